@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/go-logr/zapr"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"k8s.io/klog/v2"
+)
+
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// setLogFormat configures klog to emit logs in the given format. The text format is klog's
+// default and leaves klog untouched. The json format sends every log entry, including the
+// key/value pairs of structured log calls, to w as one JSON object per line.
+func setLogFormat(format string, w io.Writer) error {
+	switch format {
+	case logFormatText:
+		return nil
+	case logFormatJSON:
+		klog.SetLogger(zapr.NewLogger(newJSONLogger(w)))
+		return nil
+	default:
+		return fmt.Errorf("unsupported log format %q, must be one of: %s, %s", format, logFormatText, logFormatJSON)
+	}
+}
+
+// newJSONLogger returns a logger that writes JSON to w. Verbosity is still filtered by klog's
+// -v flag before entries reach the logger, so every level is enabled here.
+func newJSONLogger(w io.Writer) *zap.Logger {
+	encoderConfig := zap.NewProductionEncoderConfig()
+	encoderConfig.EncodeTime = zapcore.RFC3339NanoTimeEncoder
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(encoderConfig), zapcore.Lock(zapcore.AddSync(w)), zap.LevelEnablerFunc(func(zapcore.Level) bool { return true }))
+	return zap.New(core)
+}
@@ -44,6 +44,7 @@ var (
 		resourceLockNamespace    string
 		tlsCipherSuites          []string
 		tlsMinVersion            string
+		logFormat                string
 	}
 )
 
@@ -54,12 +55,17 @@ func init() {
 	startCmd.PersistentFlags().StringVar(&startOpts.promMetricsListenAddress, "metrics-listen-address", "127.0.0.1:8797", "Listen address for prometheus metrics listener")
 	startCmd.PersistentFlags().StringSliceVar(&startOpts.tlsCipherSuites, "tls-cipher-suites", nil, "Comma-separated list of cipher suites for the metrics server")
 	startCmd.PersistentFlags().StringVar(&startOpts.tlsMinVersion, "tls-min-version", "VersionTLS12", "Minimum TLS version supported for the metrics server")
+	startCmd.PersistentFlags().StringVar(&startOpts.logFormat, "log-format", logFormatText, "Log output format, one of: text, json")
 }
 
 func runStartCmd(_ *cobra.Command, _ []string) {
 	flag.Set("logtostderr", "true")
 	flag.Parse()
 
+	if err := setLogFormat(startOpts.logFormat, os.Stderr); err != nil {
+		klog.Fatalf("invalid --log-format: %v", err)
+	}
+
 	// This is 'main' context that we thread through the controller context and
 	// the leader elections. Cancelling this is "stop everything, we are shutting down".
 	runContext, runCancel := context.WithCancel(context.Background())
@@ -162,13 +168,25 @@ func runStartCmd(_ *cobra.Command, _ []string) {
 				ctrlctx.ClientBuilder.MachineClientOrDie("machine-set-boot-image-controller"),
 				ctrlctx.KubeNamespacedInformerFactory.Core().V1().ConfigMaps(),
 				ctrlctx.MachineInformerFactory.Machine().V1beta1().MachineSets(),
+				ctrlctx.MachineInformerFactory.Machine().V1beta1().Machines(),
 				ctrlctx.MachineInformerFactory.Machine().V1().ControlPlaneMachineSets(),
 				ctrlctx.ConfigInformerFactory.Config().V1().Infrastructures(),
 				ctrlctx.ClientBuilder.OperatorClientOrDie(componentName),
 				ctrlctx.OperatorInformerFactory.Operator().V1().MachineConfigurations(),
 				ctrlctx.ConfigInformerFactory.Config().V1().ClusterVersions(),
+				ctrlctx.KubeMAOSharedInformer.Core().V1().Secrets(),
+				ctrlctx.KubeInformerFactory.Core().V1().Nodes(),
 				ctrlctx.FeatureGatesHandler,
+				bootimagecontroller.StreamConfigMapKey,
+				bootimagecontroller.DefaultAnnotationKeyPrefix,
+				bootimagecontroller.DefaultEventComponentName,
 			)
+			ctrlcommon.RegisterDebugHandler(bootimagecontroller.BootImagePlanPath, bootImageController.PlanHandler())
+			ctrlcommon.RegisterDebugHandler(bootimagecontroller.BootImageEffectiveConfigPath, bootImageController.EffectiveConfigHandler())
+			ctrlcommon.RegisterDebugHandler(bootimagecontroller.BootImageSyncHistoryPath, bootImageController.SyncHistoryHandler())
+			ctrlcommon.RegisterDebugHandler(bootimagecontroller.BootImageLastChangesPath, bootImageController.LastChangesHandler())
+			ctrlcommon.RegisterDebugHandler(bootimagecontroller.BootImageFailingMachineSetsPath, bootImageController.FailingMachineSetsHandler())
+			ctrlcommon.RegisterDebugHandler(bootimagecontroller.BootImageProviderSpecCodecsPath, bootImageController.ProviderSpecCodecsHandler())
 			go bootImageController.Run(ctrlctx.Stop)
 			// start the informers again to enable feature gated types.
 			// see comments in SharedInformerFactory interface.
@@ -176,6 +194,7 @@ func runStartCmd(_ *cobra.Command, _ []string) {
 			ctrlctx.MachineInformerFactory.Start(ctrlctx.Stop)
 			ctrlctx.ConfigInformerFactory.Start(ctrlctx.Stop)
 			ctrlctx.OperatorInformerFactory.Start(ctrlctx.Stop)
+			ctrlctx.KubeMAOSharedInformer.Start(ctrlctx.Stop)
 		}
 
 		for _, c := range controllers {
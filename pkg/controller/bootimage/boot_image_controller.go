@@ -2,9 +2,17 @@ package bootimage
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
 	"reflect"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	osconfigv1 "github.com/openshift/api/config/v1"
@@ -13,8 +21,13 @@ import (
 	configinformersv1 "github.com/openshift/client-go/config/informers/externalversions/config/v1"
 	configlistersv1 "github.com/openshift/client-go/config/listers/config/v1"
 	mcopclientset "github.com/openshift/client-go/operator/clientset/versioned"
+	"golang.org/x/time/rate"
 	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	kubeErrs "k8s.io/apimachinery/pkg/util/errors"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	k8sversion "k8s.io/apimachinery/pkg/util/version"
 	"k8s.io/apimachinery/pkg/util/wait"
@@ -28,6 +41,7 @@ import (
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
 	"k8s.io/kubectl/pkg/scheme"
+	"k8s.io/utils/clock"
 
 	machinev1 "github.com/openshift/api/machine/v1"
 	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
@@ -55,20 +69,32 @@ type Controller struct {
 
 	mcoCmLister          corelisterv1.ConfigMapLister
 	mapiMachineSetLister machinelistersv1beta1.MachineSetLister
+	mapiMachineLister    machinelistersv1beta1.MachineLister
 	cpmsLister           machinelistersv1.ControlPlaneMachineSetLister
 	infraLister          configlistersv1.InfrastructureLister
 	mcopLister           mcoplistersv1.MachineConfigurationLister
 	clusterVersionLister configlistersv1.ClusterVersionLister
+	mapiSecretLister     corelisterv1.SecretLister
+	nodeLister           corelisterv1.NodeLister
 
 	mcoCmListerSynced          cache.InformerSynced
 	mapiMachineSetListerSynced cache.InformerSynced
+	mapiMachineListerSynced    cache.InformerSynced
 	cpmsListerSynced           cache.InformerSynced
 	infraListerSynced          cache.InformerSynced
 	mcopListerSynced           cache.InformerSynced
 	clusterVersionListerSynced cache.InformerSynced
+	mapiSecretListerSynced     cache.InformerSynced
+	nodeListerSynced           cache.InformerSynced
 
 	queue workqueue.TypedRateLimitingInterface[string]
 
+	// Key holding the stream data in the boot images configmap
+	streamConfigMapKey string
+
+	// Prefix of the annotations the controller reads and writes, in place of DefaultAnnotationKeyPrefix
+	annotationKeyPrefix string
+
 	mapiStats                  MachineResourceStats
 	cpmsStats                  MachineResourceStats
 	capiMachineSetStats        MachineResourceStats
@@ -76,15 +102,182 @@ type Controller struct {
 	mapiBootImageState         map[string]BootImageState
 	cpmsBootImageState         map[string]BootImageState
 
+	// Errors from the most recent sync of each machine resource type, aggregated into
+	// the Degraded condition so that one resource type's sync does not mask another's.
+	mapiSyncErrors []error
+	cpmsSyncErrors []error
+
+	// knobs are refreshed from the MachineConfiguration at the start of every sync
+	knobs bootImageKnobs
+
+	// The last reconcile summary event emitted and the time at which it was emitted
+	lastSyncSummary          string
+	lastSyncSummaryEventTime time.Time
+
+	// Summaries of the most recent syncs, oldest first, bounded by the configured history size. The
+	// history is guarded by syncHistoryLock as it is read by the debug endpoint.
+	syncHistory     []syncSummary
+	syncHistoryLock sync.Mutex
+
+	// Set while the cluster does not serve the MachineConfiguration API, in which case syncs are skipped.
+	// It is updated by checkMachineConfigurationAPI.
+	dormant atomic.Bool
+
+	// Progress of the current rollout, read by the stuck rollout watchdog
+	rollout     rolloutProgress
+	rolloutLock sync.Mutex
+
+	// The value of ResyncNonceAnnotationKey as of the last full sync; a manual resync of this nonce is
+	// not repeated
+	lastResyncNonce string
+
+	// Set once a sync pass has reconciled the machine resources; until then, the default boot image
+	// conditions are not populated, so the status doesn't report a rollout as done before one ran
+	syncPassCompleted bool
+
+	// The MAPI machinesets last reported as carrying orphaned annotations, as a sorted, comma-separated list
+	lastOrphanedMachineSets string
+
+	// Number of MAPI MachineSets that may be updated in the current pass, 0 if unlimited, whether
+	// updates are held off this pass due to in-flight machine replacements, and whether any MachineSet
+	// that needed an update was held back by either of these, by the soak interval or by throttling.
+	mapiReconcileBudget      int
+	mapiReplacementsInFlight bool
+	mapiUpdatesHeld          bool
+
+	// Whether a ControlPlaneMachineSet update was held back by a conflicting write in the current pass
+	cpmsUpdatesHeld bool
+
+	// Conditions computed over the current pass, rather than per machine resource. They are written
+	// together at the end of the pass by writePassConditions.
+	passConditions []metav1.Condition
+
+	// Whether the machines of a MAPI MachineSet updated while auto-rollback is enabled may still fail to boot
+	mapiAwaitingBoots bool
+
+	// The last MAPI machineset updated by a budget-limited rollout, and the cursor last persisted in the
+	// rollout state configmap, which is read once, by the first pass that needs it
+	mapiRolloutCursor      string
+	persistedRolloutCursor string
+	rolloutCursorLoaded    bool
+
+	// Describes the node readiness that holds off MAPI MachineSet updates in the current pass, empty if
+	// updates are not held off
+	lowNodeReadiness string
+
+	// The OS and release of the boot images configmap, reported by the Progressing condition; empty
+	// if unknown
+	targetOSVersion string
+
+	// Whether any MAPI MachineSet failed to sync with a transient error in the current pass
+	mapiTransientErrors bool
+
+	// Expiry timestamps of the boot image pins last reported as expired, by MAPI MachineSet, so that an
+	// expired pin is only reported once
+	reportedPinExpirations map[string]string
+
+	// Boot images last reported as ahead of the stream, by MAPI MachineSet, so that a machineset ahead of
+	// the stream is only reported once per image
+	reportedAheadOfStream map[string]string
+
+	// Consecutive failed syncs of each failing MAPI MachineSet, and whether any MAPI MachineSet failure
+	// in the current pass was within the configured error grace count
+	mapiFailures        map[string]machineSetFailures
+	mapiFailuresInGrace bool
+
+	// Wall-clock duration of the last completed MAPI MachineSet sync pass, 0 if none completed yet
+	mapiSyncDuration time.Duration
+
+	// Time of the most recent MAPI MachineSet boot image update, used to enforce the soak interval
+	mapiLastUpdateTime time.Time
+
+	// Token buckets limiting the rate of machineset reconciles per platform, created on first use
+	platformRateLimiters map[osconfigv1.PlatformType]*rate.Limiter
+
+	// Boot image updates held back by advisory-only mode in the current MAPI pass, and the plan of the
+	// last completed pass as served by PlanHandler. The published plan is guarded by publishedPlanLock
+	// as it is read by the debug endpoint.
+	mapiPlan          []plannedBootImageUpdate
+	publishedPlan     *bootImagePlan
+	publishedPlanLock sync.Mutex
+
+	// Boot images last reported by an event as planned for each MAPI MachineSet in advisory-only mode, so
+	// that a held back update is only reported once per target image
+	reportedPlannedUpdates map[string]string
+
+	// MAPI machinesets patched in the current pass, and those of the last completed pass as served by
+	// LastChangesHandler. The published changes are guarded by publishedChangesLock.
+	mapiChanged          []string
+	publishedChanges     *machineSetChanges
+	publishedChangesLock sync.Mutex
+
+	// MAPI machinesets failing as of the last completed pass, as served by FailingMachineSetsHandler.
+	// They are guarded by publishedFailuresLock.
+	publishedFailures     *failingMachineSets
+	publishedFailuresLock sync.Mutex
+
+	// Providerspec codecs of the MAPI machinesets synced in the current pass, and those of the last
+	// completed pass as served by ProviderSpecCodecsHandler. The published codecs are guarded by
+	// publishedCodecsLock.
+	mapiProviderSpecCodecs map[string]providerSpecCodec
+	publishedCodecs        *providerSpecCodecs
+	publishedCodecsLock    sync.Mutex
+
+	// Outcomes of the machinesets synced in the current MAPI pass, and the queue of completed pass
+	// states drained by stateExporter
+	mapiOutcomes []MachineSetReconcileOutcome
+	// Source each MAPI machineset's boot image was resolved from in the current pass
+	mapiImageSources map[string]BootImageSource
+	stateExportQueue chan ReconcileState
+	stateExporter    StateExporter
+
+	// Verifiers of stream images by platform, and whether the stream images looked up in the current
+	// MAPI pass exist
+	imageVerifiers     map[osconfigv1.PlatformType]ImageVerifier
+	mapiVerifiedImages map[string]bool
+
+	// Boot image resolutions of MAPI machinesets reused within ImageResolutionCacheTTLAnnotationKey
+	imageResolutionCache *imageResolutionCache
+
+	// dial is used to probe image resolution dependencies before machine resources are synced
+	dial dialFunc
+
+	// clock is the source of the current time and of the tickers and sleeps used for scheduling;
+	// see WithClock.
+	clock clock.WithTicker
+
+	// jitter randomizes retry and resync intervals; see WithRandSource.
+	jitter func(duration time.Duration, maxFactor float64) time.Duration
+
+	// Set when an approval was acted upon but ApprovedAnnotationKey could not be removed; until it is,
+	// the approval is not acted upon again
+	approvalConsumed bool
+
+	// The effective configuration of the last sync, as served by EffectiveConfigHandler, guarded by
+	// publishedEffectiveConfigLock
+	publishedEffectiveConfig     string
+	publishedEffectiveConfigLock sync.Mutex
+	// webhookClient is used to call the pre-update and post-update webhooks
+	webhookClient *http.Client
+
 	fgHandler ctrlcommon.FeatureGatesHandler
 }
 
 // Stats structure for local bookkeeping of machine resources
 type MachineResourceStats struct {
-	inProgress   int
-	skippedCount int
-	erroredCount int
-	totalCount   int
+	inProgress     int
+	skippedCount   int
+	erroredCount   int
+	totalCount     int
+	outOfDateCount int
+	deferredCount  int
+	throttledCount int
+	updatedCount   int
+	// Resources that were skipped without being compared to the stream, so their drift is unknown
+	unevaluatedCount int
+	// Enrolled resources left out by opt-in mode, the managed platforms or the opted out groups; these are
+	// not included in totalCount
+	unmanagedCount int
 }
 
 // State structure uses for detecting hot loops. Reset when cluster is opted
@@ -93,6 +286,9 @@ type MachineResourceStats struct {
 type BootImageState struct {
 	value        []byte
 	hotLoopCount int
+	// hotLoopLimit is the limit of MAPI machinesets as of the last recorded update; unset for
+	// ControlPlaneMachineSets, which are limited to HotLoopLimit
+	hotLoopLimit int
 }
 
 // isFinished checks if all resources have been evaluated
@@ -101,29 +297,172 @@ func (mrs MachineResourceStats) isFinished() bool {
 }
 
 func (mrs MachineResourceStats) getProgressingStatusMessage(name string) string {
+	var message string
 	if mrs.skippedCount > 0 {
-		return fmt.Sprintf("Reconciled %d of %d %s (%d skipped)", mrs.inProgress-mrs.skippedCount, mrs.totalCount, name, mrs.skippedCount)
+		message = fmt.Sprintf("Reconciled %d of %d %s (%d skipped)", mrs.inProgress-mrs.skippedCount, mrs.totalCount, name, mrs.skippedCount)
+	} else {
+		message = fmt.Sprintf("Reconciled %d of %d %s", mrs.inProgress, mrs.totalCount, name)
+	}
+	// Only populated in advisory-only mode, where out of date resources are reported but not updated
+	if mrs.outOfDateCount > 0 {
+		message = fmt.Sprintf("%s (%d out of date)", message, mrs.outOfDateCount)
+	}
+	// Only populated when updates are held back or reconciliation is restricted to specific zones
+	if mrs.deferredCount > 0 {
+		message = fmt.Sprintf("%s (%d deferred)", message, mrs.deferredCount)
+	}
+	// Throttled resources are also counted as deferred
+	if mrs.throttledCount > 0 {
+		message = fmt.Sprintf("%s (%d throttled)", message, mrs.throttledCount)
 	}
-	return fmt.Sprintf("Reconciled %d of %d %s", mrs.inProgress, mrs.totalCount, name)
+	if mrs.unevaluatedCount > 0 {
+		message = fmt.Sprintf("%s (%d not evaluated)", message, mrs.unevaluatedCount)
+	}
+	// Only populated in opt-in mode or when the managed platforms are restricted
+	if mrs.unmanagedCount > 0 {
+		message = fmt.Sprintf("%s (%d unmanaged)", message, mrs.unmanagedCount)
+	}
+	return message
 }
 
 func (mrs MachineResourceStats) getDegradedStatusMessage(name string) string {
 	return fmt.Sprintf("%d Degraded %s", mrs.erroredCount, name)
 }
 
+// behindCount returns the number of resources that are not on the latest boot images; this includes
+// resources that were not yet evaluated, errored, skipped, deferred or left out of date.
+func (mrs MachineResourceStats) behindCount() int {
+	return mrs.totalCount - mrs.inProgress + mrs.skippedCount + mrs.deferredCount + mrs.outOfDateCount
+}
+
+func (mrs MachineResourceStats) getSummaryMessage(name string) string {
+	return fmt.Sprintf("%s: %d updated, %d skipped, %d errored", name, mrs.updatedCount, mrs.skippedCount, mrs.erroredCount)
+}
+
+// setMachineSetMetrics publishes the MAPI MachineSet counts the conditions report.
+func (mrs MachineResourceStats) setMachineSetMetrics() {
+	ctrlcommon.MCOBootImageMachineSetsTotal.Set(float64(mrs.totalCount))
+	ctrlcommon.MCOBootImageMachineSetsInProgress.Set(float64(mrs.inProgress))
+	ctrlcommon.MCOBootImageMachineSetsErrored.Set(float64(mrs.erroredCount))
+}
+
 const (
 	// Name of machine api namespace
 	MachineAPINamespace = "openshift-machine-api"
 
-	// Key to access stream data from the boot images configmap
+	// Default key to access stream data from the boot images configmap
 	StreamConfigMapKey = "stream"
 
+	// Default prefix of the annotations read and written by the controller. The annotation key constants
+	// of this package use this prefix; a controller configured with another prefix substitutes it.
+	DefaultAnnotationKeyPrefix = "machineconfiguration.openshift.io"
+
+	// Default source component of the events emitted by the controller
+	DefaultEventComponentName = "machineconfigcontroller-machinesetbootimagecontroller"
+
 	// Labels and Annotations required for determining architecture of a machineset
 	MachineSetArchAnnotationKey = "capacity.cluster-autoscaler.kubernetes.io/labels"
 
-	ArchLabelKey     = "kubernetes.io/arch="
-	OSLabelKey       = "machine.openshift.io/os-id"
-	OSStreamLabelKey = "machineconfiguration.openshift.io/osstream"
+	ArchLabelKey        = "kubernetes.io/arch="
+	MachineRoleLabelKey = "machine.openshift.io/cluster-api-machine-role"
+	OSLabelKey          = "machine.openshift.io/os-id"
+	OSStreamLabelKey    = "machineconfiguration.openshift.io/osstream"
+
+	// Label on a machineset claiming it for the MCO instance of a single cluster, identified by the
+	// infrastructure name of that cluster. Machinesets claimed by another instance are left untouched,
+	// while unclaimed machinesets are reconciled by every instance that enrolls them.
+	BootImageOwnerLabelKey = "machineconfiguration.openshift.io/boot-image-owner"
+
+	// Label on a machineset naming the group it belongs to, e.g. the team that manages it. All machinesets
+	// of a group listed by OptedOutGroupsAnnotationKey are left unmanaged.
+	BootImageGroupLabelKey = "machineconfiguration.openshift.io/boot-image-group"
+
+	// Annotation on a machineset naming a Secret in the machine API namespace that holds the
+	// boot image to apply, used in place of the image resolved from the boot images configmap
+	BootImageSecretRefAnnotationKey = "machineconfiguration.openshift.io/boot-image-secret-ref"
+
+	// Annotation on a machineset holding an RFC 3339 timestamp, e.g. "2025-01-31T00:00:00Z", at which the
+	// boot image pinned by BootImageSecretRefAnnotationKey expires. The machineset then resumes using the
+	// cluster-wide image or the stream. A malformed timestamp is ignored, and the pin stays in effect.
+	BootImageSecretRefExpiresAtAnnotationKey = "machineconfiguration.openshift.io/boot-image-secret-ref-expires-at"
+
+	// Key to access the boot image reference from a secret referenced by a machineset or cluster-wide
+	BootImageSecretKey = "bootImage"
+
+	// Optional key of a boot image secret naming a user data secret in the machine API namespace that is
+	// paired with its boot image, e.g. as the image requires a newer ignition stub. The user data secret
+	// reference in the providerspec is updated alongside the boot image.
+	BootImageUserDataSecretKey = "userDataSecret"
+
+	// Condition on the MachineConfiguration reporting the number of machine resources that have
+	// not caught up to the latest boot images configmap. True while any resource is behind.
+	BootImageUpdateBehindConditionType = "BootImageUpdateBehind"
+
+	// Condition on the MachineConfiguration reporting whether the boot images configmap is missing or
+	// invalid. This distinguishes a bad source of truth from failures of individual machine resources,
+	// which are reported by the Degraded condition.
+	BootImageConfigMapInvalidConditionType = "BootImageConfigMapInvalid"
+
+	// Condition on the MachineConfiguration reporting whether the managed boot images scope in its status
+	// contradicts itself or the cluster, e.g. a resource that is declared twice or a selector that matches
+	// no machinesets. Machine resources are still reconciled according to the first declaration.
+	BootImageManagedScopeInconsistentConditionType = "BootImageManagedScopeInconsistent"
+
+	// Condition on the MachineConfiguration reporting whether the boot images configmap advertises
+	// different OS versions for different architectures. Boot image updates are deferred while it is
+	// True if RequireArchConsistencyAnnotationKey is set.
+	BootImageArchVersionSkewConditionType = "BootImageArchVersionSkew"
+
+	// Name of the break-glass ConfigMap in the MCO namespace. While it exists, the controller makes no
+	// changes to machine resources; deleting it resumes boot image updates. Its contents are ignored.
+	BootImageKillSwitchConfigMapName = "machine-config-boot-image-kill-switch"
+
+	// Condition on the MachineConfiguration reporting whether boot image updates are halted by the
+	// kill switch ConfigMap.
+	BootImageUpdateHaltedConditionType = "BootImageUpdateHalted"
+
+	// Reason of the Progressing condition while MAPI MachineSet updates are held off because too few
+	// nodes are Ready.
+	ReasonPausedLowNodeReadiness = "PausedLowNodeReadiness"
+
+	// Reason of the Degraded condition while the boot image update of a machine resource is refused, as
+	// its update was reverted HotLoopLimit times. The condition message names the machine resource.
+	ReasonHotLoopDetected = "HotLoopDetected"
+
+	// Reason of the Degraded condition while the boot image updates of a MAPI MachineSet are frozen, as it
+	// was rolled back after repeated boot failures. The condition message names the MachineSet.
+	ReasonBootImageRolledBack = "BootImageRolledBack"
+
+	// Reason of the Degraded condition while the boot images configmap cannot be acted on, as it is
+	// intended for a different cluster or lacks the architectures of enrolled machinesets.
+	ReasonInvalidBootImagesConfigMap = "InvalidBootImagesConfigMap"
+
+	// Optional annotations on the boot images configmap declaring the infrastructure name and the
+	// platform of the cluster it is intended for. The controller refuses to act on a configmap whose
+	// declared identifiers do not match the cluster's Infrastructure object.
+	BootImagesInfrastructureNameAnnotationKey = "machineconfiguration.openshift.io/boot-images-infrastructure-name"
+	BootImagesPlatformAnnotationKey           = "machineconfiguration.openshift.io/boot-images-platform"
+
+	// Annotation written on a machine resource recording the version of the controller that last
+	// updated its boot image. A value older than the running controller means the resource has not
+	// had its boot image updated since the upgrade.
+	BootImageUpdatedByVersionAnnotationKey = "machineconfiguration.openshift.io/boot-image-updated-by-version"
+
+	// Annotation written on a MAPI machineset recording, in RFC 3339 format, when its boot image was last
+	// updated by the controller
+	BootImageUpdatedAtAnnotationKey = "machineconfiguration.openshift.io/boot-image-updated-at"
+
+	// Annotation on a MAPI machineset; "true" opts the machineset in to boot image updates while the
+	// MachineConfiguration enables opt-in mode with OptInAnnotationKey
+	BootImageManagedAnnotationKey = "machineconfiguration.openshift.io/bootimage-managed"
+
+	// Annotation on a MAPI machineset; "true" opts the machineset out of boot image updates, e.g. to pin
+	// an older boot image for validation. The machineset remains enrolled and is counted as skipped, and
+	// is reconciled again once the annotation is removed.
+	BootImageOptOutAnnotationKey = "machineconfiguration.openshift.io/boot-image-opt-out"
+
+	// Annotation on a machineset that overrides HotLoopLimit for that machineset only
+	HotLoopLimitAnnotationKey = "machineconfiguration.openshift.io/boot-image-hot-loop-limit"
 
 	// Stream currently supported by the MCO's boot image controller
 	// Note: This should be updated along with supportedOSStream in test/extended-priv/util/clusters.go
@@ -134,50 +473,120 @@ const (
 
 	// maxRetries is the number of times a sync will be retried before it is dropped out of the queue.
 	maxRetries = 15
+
+	// syncSummaryEventInterval is the minimum time between two identical reconcile summary events,
+	// so that rapid syncs do not flood the event stream.
+	syncSummaryEventInterval = 5 * time.Minute
+
+	// maxConditionErrors is the number of individual errors included in the Degraded condition
+	// message; any further errors are summarized by count.
+	maxConditionErrors = 10
+
+	// heldUpdatesRequeueInterval is the delay before the next pass when machineset updates were held
+	// back by the reconcile budget, by in-flight machine replacements or by the soak interval.
+	heldUpdatesRequeueInterval = 1 * time.Minute
+
+	// metricLabelUnknown is the metric label value used when a platform or architecture cannot be determined
+	metricLabelUnknown = "unknown"
+
+	// metricLabelUnspecifiedRole is the metric label value used for machinesets without a role label
+	metricLabelUnspecifiedRole = "unspecified"
 )
 
+// Option customizes a controller returned by New. The options are the injection points for the
+// controller's timing, so that tests can control scheduling without sleeping.
+type Option func(*Controller)
+
+// WithClock sets the clock used for the current time, for sleeps between retries and for the tickers
+// that drive periodic work. Production uses the real clock; tests can pass a fake clock and step it.
+func WithClock(c clock.WithTicker) Option {
+	return func(ctrl *Controller) {
+		ctrl.clock = c
+	}
+}
+
+// WithRandSource sets the source of randomness used to jitter retry and resync intervals.
+// Production uses the global random source; tests can pass a seeded or fixed source to make the
+// jittered intervals deterministic.
+func WithRandSource(src rand.Source) Option {
+	return func(ctrl *Controller) {
+		r := rand.New(src)
+		ctrl.jitter = func(duration time.Duration, maxFactor float64) time.Duration {
+			if maxFactor <= 0.0 {
+				maxFactor = 1.0
+			}
+			return duration + time.Duration(r.Float64()*maxFactor*float64(duration))
+		}
+	}
+}
+
 // New returns a new machine-set-boot-image controller.
 func New(
 	kubeClient clientset.Interface,
 	machineClient machineclientset.Interface,
 	mcoCmInfomer coreinformersv1.ConfigMapInformer,
 	mapiMachineSetInformer mapimachineinformersv1beta1.MachineSetInformer,
+	mapiMachineInformer mapimachineinformersv1beta1.MachineInformer,
 	cpmsInformer mapimachineinformersv1.ControlPlaneMachineSetInformer,
 	infraInformer configinformersv1.InfrastructureInformer,
 	mcopClient mcopclientset.Interface,
 	mcopInformer mcopinformersv1.MachineConfigurationInformer,
 	clusterVersionInformer configinformersv1.ClusterVersionInformer,
+	mapiSecretInformer coreinformersv1.SecretInformer,
+	nodeInformer coreinformersv1.NodeInformer,
 	fgHandler ctrlcommon.FeatureGatesHandler,
+	streamConfigMapKey string,
+	annotationKeyPrefix string,
+	eventComponentName string,
+	opts ...Option,
 ) *Controller {
-	eventBroadcaster := record.NewBroadcaster()
-	eventBroadcaster.StartLogging(klog.Infof)
-	eventBroadcaster.StartRecordingToSink(&corev1client.EventSinkImpl{Interface: kubeClient.CoreV1().Events("")})
-
 	ctrl := &Controller{
 		kubeClient:    kubeClient,
 		machineClient: machineClient,
 		mcopClient:    mcopClient,
-		eventRecorder: ctrlcommon.NamespacedEventRecorder(eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "machineconfigcontroller-machinesetbootimagecontroller"})),
+		eventRecorder: newEventRecorder(kubeClient, eventComponentName),
 		queue: workqueue.NewTypedRateLimitingQueueWithConfig(
 			workqueue.DefaultTypedControllerRateLimiter[string](),
 			workqueue.TypedRateLimitingQueueConfig[string]{Name: "machineconfigcontroller-machinesetbootimagecontroller"}),
+		dial:                net.DialTimeout,
+		webhookClient:       &http.Client{Timeout: updateWebhookTimeout},
+		streamConfigMapKey:  streamConfigMapKey,
+		annotationKeyPrefix: annotationKeyPrefix,
+		stateExportQueue:    make(chan ReconcileState, stateExportQueueLength),
+		stateExporter:       noopStateExporter{},
+		clock:               clock.RealClock{},
+		jitter:              wait.Jitter,
+	}
+	for _, opt := range opts {
+		opt(ctrl)
 	}
 
 	ctrl.syncHandler = ctrl.syncAll
 
+	// Events are emitted on the MachineConfiguration object, so its type must be known to the scheme
+	if err := opv1.AddToScheme(scheme.Scheme); err != nil {
+		klog.Errorf("Could not modify scheme: %v", err)
+	}
+
 	ctrl.mcoCmLister = mcoCmInfomer.Lister()
 	ctrl.mapiMachineSetLister = mapiMachineSetInformer.Lister()
+	ctrl.mapiMachineLister = mapiMachineInformer.Lister()
 	ctrl.cpmsLister = cpmsInformer.Lister()
 	ctrl.infraLister = infraInformer.Lister()
 	ctrl.mcopLister = mcopInformer.Lister()
 	ctrl.clusterVersionLister = clusterVersionInformer.Lister()
+	ctrl.mapiSecretLister = mapiSecretInformer.Lister()
+	ctrl.nodeLister = nodeInformer.Lister()
 
 	ctrl.mcoCmListerSynced = mcoCmInfomer.Informer().HasSynced
 	ctrl.mapiMachineSetListerSynced = mapiMachineSetInformer.Informer().HasSynced
+	ctrl.mapiMachineListerSynced = mapiMachineInformer.Informer().HasSynced
 	ctrl.cpmsListerSynced = cpmsInformer.Informer().HasSynced
 	ctrl.infraListerSynced = infraInformer.Informer().HasSynced
 	ctrl.mcopListerSynced = mcopInformer.Informer().HasSynced
 	ctrl.clusterVersionListerSynced = clusterVersionInformer.Informer().HasSynced
+	ctrl.mapiSecretListerSynced = mapiSecretInformer.Informer().HasSynced
+	ctrl.nodeListerSynced = nodeInformer.Informer().HasSynced
 
 	mapiMachineSetInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc:    ctrl.addMAPIMachineSet,
@@ -185,6 +594,10 @@ func New(
 		DeleteFunc: ctrl.deleteMAPIMachineSet,
 	})
 
+	mapiMachineInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: ctrl.updateMAPIMachine,
+	})
+
 	if fgHandler.Enabled(features.FeatureGateManagedBootImagesCPMS) {
 		klog.V(4).Infof("ManagedBootImagesCPMS feature gate is enabled, adding CPMS event handlers")
 		cpmsInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
@@ -210,29 +623,68 @@ func New(
 		UpdateFunc: ctrl.updateClusterVersion,
 	})
 
+	mapiSecretInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: ctrl.updateBootImageSecret,
+	})
+
 	ctrl.fgHandler = fgHandler
 
 	ctrl.mapiBootImageState = map[string]BootImageState{}
 	ctrl.cpmsBootImageState = map[string]BootImageState{}
+	ctrl.mapiImageSources = map[string]BootImageSource{}
+	ctrl.imageResolutionCache = newImageResolutionCache()
+	ctrl.mapiFailures = map[string]machineSetFailures{}
+	ctrl.reportedPinExpirations = map[string]string{}
+	ctrl.reportedAheadOfStream = map[string]string{}
+	ctrl.mapiVerifiedImages = map[string]bool{}
+	ctrl.mapiProviderSpecCodecs = map[string]providerSpecCodec{}
+	ctrl.reportedPlannedUpdates = map[string]string{}
 
 	return ctrl
 }
 
+// newEventRecorder returns a recorder emitting events with the given source component, or
+// DefaultEventComponentName if it is empty.
+func newEventRecorder(kubeClient clientset.Interface, component string) record.EventRecorder {
+	if component == "" {
+		component = DefaultEventComponentName
+	}
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartLogging(klog.Infof)
+	eventBroadcaster.StartRecordingToSink(&corev1client.EventSinkImpl{Interface: kubeClient.CoreV1().Events("")})
+	return ctrlcommon.NamespacedEventRecorder(eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: component}))
+}
+
 // Run executes the machine-set-boot-image controller.
 func (ctrl *Controller) Run(stopCh <-chan struct{}) {
 	defer utilruntime.HandleCrash()
 	defer ctrl.queue.ShutDown()
 
-	if !cache.WaitForCacheSync(stopCh, ctrl.mcoCmListerSynced, ctrl.mapiMachineSetListerSynced, ctrl.infraListerSynced, ctrl.mcopListerSynced, ctrl.clusterVersionListerSynced) {
+	if ctrl.streamConfigMapKey == "" {
+		klog.Errorf("Not starting MachineConfigController-MachineSetBootImageController, the boot images configmap stream data key is empty")
+		return
+	}
+
+	// Enter dormant mode ahead of the first sync if the MachineConfiguration API is not served
+	ctrl.checkMachineConfigurationAPI()
+	go wait.Until(ctrl.checkMachineConfigurationAPI, machineConfigurationAPICheckInterval, stopCh)
+
+	if !cache.WaitForCacheSync(stopCh, ctrl.mcoCmListerSynced, ctrl.mapiMachineSetListerSynced, ctrl.mapiMachineListerSynced, ctrl.infraListerSynced, ctrl.mcopListerSynced, ctrl.clusterVersionListerSynced, ctrl.mapiSecretListerSynced, ctrl.nodeListerSynced) {
 		return
 	}
 
 	klog.Info("Starting MachineConfigController-MachineSetBootImageController")
 	defer klog.Info("Shutting down MachineConfigController-MachineSetBootImageController")
 
+	// Persisted state is validated before the first sync; invalid state is discarded rather than
+	// failing startup
+	ctrl.loadRolloutCursor()
+
 	// This controller needs to run in single thread mode, as the work unit per sync are
 	// the same and shouldn't overlap each other.
 	go wait.Until(ctrl.worker, time.Second, stopCh)
+	go ctrl.runStateExporter(stopCh)
+	go ctrl.runStuckRolloutWatchdog(stopCh)
 
 	<-stopCh
 }
@@ -384,18 +836,40 @@ func (ctrl *Controller) deleteControlPlaneMachineSet(deletedCPMS interface{}) {
 	ctrl.enqueueEvent("ControlPlaneMachineSetDeleted")
 }
 
+// isMCOConfigMap returns true if the configmap is the named configmap in the MCO namespace. A configmap
+// of the same name in another namespace is a look-alike, e.g. a misplaced copy, which is ignored with a
+// warning so that boot images are only ever taken from the configmap published by the MCO.
+func isMCOConfigMap(configMap *corev1.ConfigMap, name string) bool {
+	if configMap.Name != name {
+		return false
+	}
+	if configMap.Namespace != ctrlcommon.MCONamespace {
+		klog.Warningf("Ignoring configmap %s/%s, only %s/%s is used for boot image updates", configMap.Namespace, configMap.Name, ctrlcommon.MCONamespace, name)
+		return false
+	}
+	return true
+}
+
 // addConfigMap handles the addition of the boot images ConfigMap by triggering
 // a reconciliation of all enrolled machine resources.
 func (ctrl *Controller) addConfigMap(obj interface{}) {
 
 	configMap := obj.(*corev1.ConfigMap)
 
+	if isMCOConfigMap(configMap, BootImageKillSwitchConfigMapName) {
+		klog.Infof("Kill switch configMap %s added, halting boot image updates", configMap.Name)
+		ctrl.enqueueEvent("BootImageKillSwitchAdded")
+		return
+	}
+
 	// Take no action if this isn't the "golden" config map
-	if configMap.Name != ctrlcommon.BootImagesConfigMapName {
+	if !isMCOConfigMap(configMap, ctrlcommon.BootImagesConfigMapName) {
 		return
 	}
 
 	klog.Infof("configMap %s added, reconciling enrolled machine resources", configMap.Name)
+	ctrl.imageResolutionCache.invalidate()
+	ctrl.trackConfigMapRollout()
 
 	// Update all machinesets since the "golden" configmap has been added
 	ctrl.enqueueEvent("BootImageConfigMapAdded")
@@ -408,17 +882,19 @@ func (ctrl *Controller) updateConfigMap(oldCM, newCM interface{}) {
 	oldConfigMap := oldCM.(*corev1.ConfigMap)
 	newConfigMap := newCM.(*corev1.ConfigMap)
 
-	// Take no action if this isn't the "golden" config map
-	if oldConfigMap.Name != ctrlcommon.BootImagesConfigMapName {
+	// Only take action if the there is an actual change in the configMap Object
+	if oldConfigMap.ResourceVersion == newConfigMap.ResourceVersion {
 		return
 	}
 
-	// Only take action if the there is an actual change in the configMap Object
-	if oldConfigMap.ResourceVersion == newConfigMap.ResourceVersion {
+	// Take no action if this isn't the "golden" config map
+	if !isMCOConfigMap(newConfigMap, ctrlcommon.BootImagesConfigMapName) {
 		return
 	}
 
 	klog.Infof("configMap %s updated, reconciling enrolled machine resources", oldConfigMap.Name)
+	ctrl.imageResolutionCache.invalidate()
+	ctrl.trackConfigMapRollout()
 
 	// Update all machinesets since the "golden" configmap has been updated
 	ctrl.enqueueEvent("BootImageConfigMapUpdated")
@@ -428,14 +904,35 @@ func (ctrl *Controller) updateConfigMap(oldCM, newCM interface{}) {
 // a reconciliation of all enrolled machine resources.
 func (ctrl *Controller) deleteConfigMap(obj interface{}) {
 
-	configMap := obj.(*corev1.ConfigMap)
+	// The delete may only be observed on a relist, e.g. when the configmap was deleted and recreated
+	// while the watch was down, in which case the final state of the object is wrapped in a tombstone
+	configMap, ok := obj.(*corev1.ConfigMap)
+	if !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			utilruntime.HandleError(fmt.Errorf("couldn't get object from tombstone %#v", obj))
+			return
+		}
+		configMap, ok = tombstone.Obj.(*corev1.ConfigMap)
+		if !ok {
+			utilruntime.HandleError(fmt.Errorf("tombstone contained object that is not a ConfigMap %#v", obj))
+			return
+		}
+	}
+
+	if isMCOConfigMap(configMap, BootImageKillSwitchConfigMapName) {
+		klog.Infof("Kill switch configMap %s deleted, resuming boot image updates", configMap.Name)
+		ctrl.enqueueEvent("BootImageKillSwitchDeleted")
+		return
+	}
 
 	// Take no action if this isn't the "golden" config map
-	if configMap.Name != ctrlcommon.BootImagesConfigMapName {
+	if !isMCOConfigMap(configMap, ctrlcommon.BootImagesConfigMapName) {
 		return
 	}
 
 	klog.Infof("configMap %s deleted, reconciling enrolled machine resources", configMap.Name)
+	ctrl.imageResolutionCache.invalidate()
 
 	// Update all machinesets since the "golden" configmap has been deleted
 	ctrl.enqueueEvent("BootImageConfigMapDeleted")
@@ -472,9 +969,18 @@ func (ctrl *Controller) updateMachineConfiguration(oldMC, newMC interface{}) {
 		return
 	}
 
-	// Skip reconciliation if neither ManagedBootImagesStatus nor BootImageSkewEnforcementStatus has changed.
+	// A new resync nonce requests a full resync; it is enqueued separately so that it is not merged with
+	// the event of other changes
+	nonceKey := ctrl.annotationKey(ResyncNonceAnnotationKey)
+	if nonce := newMachineConfiguration.Annotations[nonceKey]; nonce != "" && nonce != oldMachineConfiguration.Annotations[nonceKey] {
+		klog.Infof("Boot image resync nonce changed to %q, resyncing all machine resources", nonce)
+		ctrl.enqueueEvent("ManualResync")
+	}
+
+	// Skip reconciliation if neither ManagedBootImagesStatus, the boot image knobs nor BootImageSkewEnforcementStatus has changed.
 	// BootImageSkewEnforcementStatus is only checked when the BootImageSkewEnforcement feature gate is enabled.
 	if reflect.DeepEqual(oldMachineConfiguration.Status.ManagedBootImagesStatus, newMachineConfiguration.Status.ManagedBootImagesStatus) &&
+		!bootImageKnobsChanged(oldMachineConfiguration, newMachineConfiguration, ctrl.annotationKeyPrefix) &&
 		(!ctrl.fgHandler.Enabled(features.FeatureGateBootImageSkewEnforcement) ||
 			reflect.DeepEqual(oldMachineConfiguration.Status.BootImageSkewEnforcementStatus, newMachineConfiguration.Status.BootImageSkewEnforcementStatus)) {
 		return
@@ -524,19 +1030,52 @@ func (ctrl *Controller) updateClusterVersion(oldCV, newCV interface{}) {
 	}
 }
 
+// updateBootImageSecret handles updates to Secrets in the machine API namespace. It triggers
+// a reconciliation only if the Secret holds a boot image reference and its data changed, so that
+// machinesets referencing it via BootImageSecretRefAnnotationKey pick up the new image.
+func (ctrl *Controller) updateBootImageSecret(oldS, newS interface{}) {
+	oldSecret := oldS.(*corev1.Secret)
+	newSecret := newS.(*corev1.Secret)
+
+	if _, ok := newSecret.Data[BootImageSecretKey]; !ok {
+		return
+	}
+
+	if reflect.DeepEqual(oldSecret.Data, newSecret.Data) {
+		return
+	}
+
+	klog.Infof("Boot image secret %s updated, reconciling enrolled machine resources", newSecret.Name)
+	ctrl.enqueueEvent("BootImageSecretUpdated")
+}
+
 // updateConditions updates the boot image update conditions on the MachineConfiguration status
 // based on the current state of machine resource reconciliation.
 func (ctrl *Controller) updateConditions(newReason string, syncError error, targetConditionType string) {
 
+	// Publish the stats along with every condition update, so the metrics never drift from the conditions
+	ctrl.mapiStats.setMachineSetMetrics()
+
 	mcop, err := ctrl.mcopClient.OperatorV1().MachineConfigurations().Get(context.TODO(), ctrlcommon.MCOOperatorKnobsObjectName, metav1.GetOptions{})
 	if err != nil {
 		klog.Errorf("error updating progressing condition: %s", err)
 		return
 	}
+	// Other controllers may set conditions on the same status, so conditions are always looked up by type
 	newConditions := mcop.Status.DeepCopy().Conditions
-	// If no conditions exist, populate some sane defaults
-	if newConditions == nil {
-		newConditions = getDefaultConditions()
+	// Once a sync pass has completed, populate sane defaults for any boot image condition that is
+	// missing, e.g. if the status was partially initialized by another controller
+	if ctrl.syncPassCompleted {
+		for _, condition := range getDefaultConditions() {
+			if meta.FindStatusCondition(newConditions, condition.Type) == nil {
+				newConditions = append(newConditions, condition)
+			}
+		}
+	}
+
+	// Conditions not in the defaults are added the first time they are set
+	if meta.FindStatusCondition(newConditions, targetConditionType) == nil {
+		newConditions = append(newConditions, metav1.Condition{Type: targetConditionType, LastTransitionTime: metav1.Now()})
 	}
 
 	for i, condition := range newConditions {
@@ -549,13 +1088,36 @@ func (ctrl *Controller) updateConditions(newReason string, syncError error, targ
 					ctrl.capiMachineDeploymentStats.getProgressingStatusMessage("CAPI MachineDeployments"),
 				}
 				newConditions[i].Message = strings.Join(messages, " | ")
+				if ctrl.knobs.reportSyncDuration && ctrl.mapiSyncDuration > 0 {
+					newConditions[i].Message = fmt.Sprintf("%s | Last MAPI MachineSets sync took %v", newConditions[i].Message, ctrl.mapiSyncDuration)
+				}
+				if ctrl.targetOSVersion != "" {
+					newConditions[i].Message = fmt.Sprintf("Converging to %s | %s", ctrl.targetOSVersion, newConditions[i].Message)
+				}
+				if ctrl.lowNodeReadiness != "" {
+					newConditions[i].Message = fmt.Sprintf("Paused on low node readiness, %s | %s", ctrl.lowNodeReadiness, newConditions[i].Message)
+				}
+				if len(ctrl.knobs.pausedPlatforms) > 0 {
+					pausedPlatforms := []string{}
+					for _, platform := range ctrl.knobs.pausedPlatforms {
+						pausedPlatforms = append(pausedPlatforms, string(platform))
+					}
+					newConditions[i].Message = fmt.Sprintf("Paused on platform(s) %s | %s", strings.Join(pausedPlatforms, ", "), newConditions[i].Message)
+				}
+				if ctrl.knobs.awaitingApproval {
+					newConditions[i].Message = fmt.Sprintf("Awaiting approval via annotation %s, no machine resources will be updated | %s", ctrl.annotationKey(ApprovedAnnotationKey), newConditions[i].Message)
+				} else if ctrl.knobs.advisoryOnly {
+					newConditions[i].Message = "Advisory-only mode, no machine resources will be updated | " + newConditions[i].Message
+				}
 				newConditions[i].Reason = newReason
 				// If all machine resources have been processed, then the controller is no longer progressing.
-				if ctrl.mapiStats.isFinished() && ctrl.cpmsStats.isFinished() && ctrl.capiMachineSetStats.isFinished() && ctrl.capiMachineDeploymentStats.isFinished() {
+				finished := ctrl.mapiStats.isFinished() && ctrl.cpmsStats.isFinished() && ctrl.capiMachineSetStats.isFinished() && ctrl.capiMachineDeploymentStats.isFinished()
+				if finished {
 					newConditions[i].Status = metav1.ConditionFalse
 				} else {
 					newConditions[i].Status = metav1.ConditionTrue
 				}
+				ctrl.trackRolloutProgress(finished)
 			} else if condition.Type == opv1.MachineConfigurationBootImageUpdateDegraded {
 				messages := []string{
 					ctrl.mapiStats.getDegradedStatusMessage("MAPI MachineSets"),
@@ -563,32 +1125,188 @@ func (ctrl *Controller) updateConditions(newReason string, syncError error, targ
 					ctrl.capiMachineSetStats.getDegradedStatusMessage("CAPI MachineSets"),
 					ctrl.capiMachineDeploymentStats.getDegradedStatusMessage("CAPI MachineDeployments"),
 				}
-				if syncError == nil {
-					newConditions[i].Message = strings.Join(messages, " | ")
-				} else {
-					newConditions[i].Message = fmt.Sprintf("%s | Error(s): %s", strings.Join(messages, " | "), syncError.Error())
-				}
+				newConditions[i].Message = ctrl.getDegradedMessage(strings.Join(messages, " | "), syncError)
 				newConditions[i].Reason = newReason
-				if syncError != nil {
+				if syncError != nil && ctrl.knobs.degradedSuppressed(ctrl.clock.Now()) {
+					newConditions[i].Message = fmt.Sprintf("Degraded suppressed until %s via annotation %s | %s",
+						ctrl.knobs.suppressDegradedUntil.Format(time.RFC3339), ctrl.annotationKey(SuppressDegradedUntilAnnotationKey), newConditions[i].Message)
+					newConditions[i].Status = metav1.ConditionFalse
+				} else if syncError != nil {
 					newConditions[i].Status = metav1.ConditionTrue
 				} else {
 					newConditions[i].Status = metav1.ConditionFalse
 				}
 			}
-			// Check if there is a change in the condition before updating LastTransitionTime
-			if len(mcop.Status.Conditions) == 0 || !reflect.DeepEqual(newConditions[i], mcop.Status.Conditions[i]) {
+			newConditions[i].Message = truncateConditionMessage(newConditions[i].Message, ctrl.knobs.conditionMessageMaxLength())
+			// LastTransitionTime only moves when the condition transitions from one status to another.
+			// The previous condition is looked up by type, as conditions may have been added above.
+			if oldCondition := meta.FindStatusCondition(mcop.Status.Conditions, targetConditionType); oldCondition == nil || oldCondition.Status != newConditions[i].Status {
 				newConditions[i].LastTransitionTime = metav1.Now()
 			}
 			break
 		}
 	}
-	// Only make an API call if there is an update to the Conditions field
+	// Only make an API call if there is an update to the Conditions field. Only the target condition and
+	// any added defaults are written, so that conditions changed since they were read are left untouched.
 	if !reflect.DeepEqual(newConditions, mcop.Status.Conditions) {
-		mcop.Status.Conditions = newConditions
-		ctrl.updateMachineConfigurationStatus(mcop.Status)
+		updated := []metav1.Condition{}
+		for _, condition := range newConditions {
+			if condition.Type == targetConditionType || meta.FindStatusCondition(mcop.Status.Conditions, condition.Type) == nil {
+				updated = append(updated, condition)
+			}
+		}
+		ctrl.updateMachineConfigurationStatus(func(status *opv1.MachineConfigurationStatus) {
+			status.Conditions = mergeConditions(status.Conditions, updated)
+		})
+	}
+}
+
+// setPassCondition records a condition computed over the current pass. Each state of a pass condition
+// has a fixed reason, so that a pass that observes the same state as the last one makes no status update.
+func (ctrl *Controller) setPassCondition(conditionType string, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&ctrl.passConditions, metav1.Condition{
+		Type:    conditionType,
+		Status:  status,
+		Reason:  reason,
+		Message: truncateConditionMessage(message, ctrl.knobs.conditionMessageMaxLength()),
+	})
+}
+
+// setBehindCondition records the number of machine resources that have not caught up to the latest
+// boot images configmap.
+func (ctrl *Controller) setBehindCondition() {
+	messages := []string{
+		fmt.Sprintf("%d MAPI MachineSets", ctrl.mapiStats.behindCount()),
+		fmt.Sprintf("%d ControlPlaneMachineSets", ctrl.cpmsStats.behindCount()),
+		fmt.Sprintf("%d CAPI MachineSets", ctrl.capiMachineSetStats.behindCount()),
+		fmt.Sprintf("%d CAPI MachineDeployments", ctrl.capiMachineDeploymentStats.behindCount()),
+	}
+	behind := ctrl.mapiStats.behindCount() + ctrl.cpmsStats.behindCount() + ctrl.capiMachineSetStats.behindCount() + ctrl.capiMachineDeploymentStats.behindCount()
+	message := fmt.Sprintf("%d machine resources behind the boot images configmap | %s", behind, strings.Join(messages, " | "))
+	if behind > 0 {
+		ctrl.setPassCondition(BootImageUpdateBehindConditionType, metav1.ConditionTrue, "MachineResourcesBehind", message)
+	} else {
+		ctrl.setPassCondition(BootImageUpdateBehindConditionType, metav1.ConditionFalse, "MachineResourcesUpToDate", message)
 	}
 }
 
+// writePassConditions merges the conditions recorded over the current pass into the MachineConfiguration
+// status, in a single update. No update is made if none of them changed.
+func (ctrl *Controller) writePassConditions() {
+	if len(ctrl.passConditions) == 0 {
+		return
+	}
+	mcop, err := ctrl.mcopClient.OperatorV1().MachineConfigurations().Get(context.TODO(), ctrlcommon.MCOOperatorKnobsObjectName, metav1.GetOptions{})
+	if err != nil {
+		klog.Errorf("error updating boot image conditions: %s", err)
+		return
+	}
+	newConditions := mcop.Status.DeepCopy().Conditions
+	// As in updateConditions, missing defaults are only populated once a sync pass has completed
+	if ctrl.syncPassCompleted {
+		for _, condition := range getDefaultConditions() {
+			if meta.FindStatusCondition(newConditions, condition.Type) == nil {
+				newConditions = append(newConditions, condition)
+			}
+		}
+	}
+	// LastTransitionTime only moves when a condition transitions from one status to another
+	for _, condition := range ctrl.passConditions {
+		meta.SetStatusCondition(&newConditions, condition)
+	}
+	// Only the pass conditions and any added defaults are written, so that conditions changed since they
+	// were read are left untouched
+	if !reflect.DeepEqual(newConditions, mcop.Status.Conditions) {
+		updated := []metav1.Condition{}
+		for _, condition := range newConditions {
+			if meta.FindStatusCondition(ctrl.passConditions, condition.Type) != nil || meta.FindStatusCondition(mcop.Status.Conditions, condition.Type) == nil {
+				updated = append(updated, condition)
+			}
+		}
+		ctrl.updateMachineConfigurationStatus(func(status *opv1.MachineConfigurationStatus) {
+			status.Conditions = mergeConditions(status.Conditions, updated)
+		})
+	}
+}
+
+// mergeConditions returns the conditions with each updated condition replacing the condition of the same
+// type, or appended if there is none. All other conditions, including those set by other controllers,
+// keep their content and order.
+func mergeConditions(conditions, updated []metav1.Condition) []metav1.Condition {
+	merged := slices.Clone(conditions)
+	for _, condition := range updated {
+		if i := slices.IndexFunc(merged, func(c metav1.Condition) bool { return c.Type == condition.Type }); i >= 0 {
+			merged[i] = condition
+		} else {
+			merged = append(merged, condition)
+		}
+	}
+	return merged
+}
+
+// aggregateSyncErrors combines the errors from the most recent sync of every machine resource type
+// into a single error for the Degraded condition. Returns nil if there were no errors.
+func (ctrl *Controller) aggregateSyncErrors() error {
+	var errs []error
+	errs = append(errs, ctrl.cpmsSyncErrors...)
+	errs = append(errs, ctrl.mapiSyncErrors...)
+	if len(errs) > maxConditionErrors {
+		remaining := len(errs) - maxConditionErrors
+		errs = append(errs[:maxConditionErrors:maxConditionErrors], omittedErrors(remaining))
+	}
+	return kubeErrs.NewAggregate(errs)
+}
+
+// omittedErrors stands in for the given number of errors left out of a condition message.
+type omittedErrors int
+
+func (n omittedErrors) Error() string {
+	return fmt.Sprintf("and %d more error(s)", int(n))
+}
+
+// getDegradedMessage returns the Degraded condition message for the per resource type counts and the
+// sync error, if any. A message longer than the configured maximum keeps the counts and as many whole
+// errors as fit, followed by the number of errors left out.
+func (ctrl *Controller) getDegradedMessage(counts string, syncError error) string {
+	if syncError == nil {
+		return counts
+	}
+	maxLength := ctrl.knobs.conditionMessageMaxLength()
+	message := fmt.Sprintf("%s | Error(s): %s", counts, syncError.Error())
+	if len(message) <= maxLength {
+		return message
+	}
+
+	errs := []error{syncError}
+	var aggregate kubeErrs.Aggregate
+	if errors.As(syncError, &aggregate) {
+		errs = aggregate.Errors()
+	}
+	omitted := 0
+	var summary omittedErrors
+	if len(errs) > 0 && errors.As(errs[len(errs)-1], &summary) {
+		omitted = int(summary)
+		errs = errs[:len(errs)-1]
+	}
+	for kept := len(errs) - 1; kept >= 0; kept-- {
+		truncated := append(append([]error{}, errs[:kept]...), omittedErrors(omitted+len(errs)-kept))
+		message = fmt.Sprintf("%s | Error(s): %s", counts, kubeErrs.NewAggregate(truncated).Error())
+		if len(message) <= maxLength {
+			return message
+		}
+	}
+	return truncateConditionMessage(message, maxLength)
+}
+
+// truncateConditionMessage cuts the message down to maxLength bytes, marking the cut with an ellipsis.
+func truncateConditionMessage(message string, maxLength int) string {
+	const ellipsis = "..."
+	if len(message) <= maxLength {
+		return message
+	}
+	return strings.ToValidUTF8(message[:maxLength-len(ellipsis)], "") + ellipsis
+}
+
 // updateClusterBootImage updates the cluster boot image record if the skew enforcement is set to Automatic mode.
 func (ctrl *Controller) updateClusterBootImage() {
 
@@ -630,8 +1348,9 @@ func (ctrl *Controller) updateClusterBootImage() {
 
 	// Only make an API call if there is an update to the skew enforcement status
 	if !reflect.DeepEqual(mcop.Status.BootImageSkewEnforcementStatus, *newBootImageSkewEnforcementStatus) {
-		mcop.Status.BootImageSkewEnforcementStatus = *newBootImageSkewEnforcementStatus
-		ctrl.updateMachineConfigurationStatus(mcop.Status)
+		ctrl.updateMachineConfigurationStatus(func(status *opv1.MachineConfigurationStatus) {
+			status.BootImageSkewEnforcementStatus = *newBootImageSkewEnforcementStatus
+		})
 	}
 }
 
@@ -665,22 +1384,25 @@ func (ctrl *Controller) resetClusterBootImage() {
 	}
 	if !reflect.DeepEqual(mcop.Status.BootImageSkewEnforcementStatus, *newBootImageSkewEnforcementStatus) {
 		klog.Infof("Resetting cluster boot image record to install version %s due to reconcileSkipped MachineSets", ocpVersion)
-		mcop.Status.BootImageSkewEnforcementStatus = *newBootImageSkewEnforcementStatus
-		ctrl.updateMachineConfigurationStatus(mcop.Status)
+		ctrl.updateMachineConfigurationStatus(func(status *opv1.MachineConfigurationStatus) {
+			status.BootImageSkewEnforcementStatus = *newBootImageSkewEnforcementStatus
+		})
 	}
 }
 
 // updateMachineConfigurationStatus updates the MachineConfiguration status using retry logic to handle concurrent updates.
-func (ctrl *Controller) updateMachineConfigurationStatus(mcopStatus opv1.MachineConfigurationStatus) {
+// The update is applied to the latest status on every attempt, so that fields and conditions written by others
+// since the status was read are preserved.
+func (ctrl *Controller) updateMachineConfigurationStatus(update func(*opv1.MachineConfigurationStatus)) {
 	// Using a retry here as there may be concurrent reconiliation loops updating conditions for multiple
 	// resources at the same time and their local stores may be out of date
-	klog.V(4).Infof("MachineConfiguration status update: %v", mcopStatus)
 	if err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
 		mcop, err := ctrl.mcopClient.OperatorV1().MachineConfigurations().Get(context.TODO(), ctrlcommon.MCOOperatorKnobsObjectName, metav1.GetOptions{})
 		if err != nil {
 			return err
 		}
-		mcop.Status = mcopStatus
+		update(&mcop.Status)
+		klog.V(4).Infof("MachineConfiguration status update: %v", mcop.Status)
 		_, err = ctrl.mcopClient.OperatorV1().MachineConfigurations().UpdateStatus(context.TODO(), mcop, metav1.UpdateOptions{})
 		if err != nil {
 			return err
@@ -718,12 +1440,35 @@ func getDefaultConditions() []metav1.Condition {
 func (ctrl *Controller) syncAll(event string) error {
 	klog.V(4).Infof("Syncing boot image controller for event: %s", event)
 
+	// Without the MachineConfiguration API, no conditions can be reported
+	if ctrl.dormant.Load() {
+		klog.V(4).Infof("Boot image controller is dormant, skipping sync for event: %s", event)
+		return nil
+	}
+
 	// Wait for MachineConfiguration/cluster to be ready before syncing any machine resources
 	if err := ctrl.waitForMachineConfigurationReady(); err != nil {
 		ctrl.updateConditions(event, fmt.Errorf("MachineConfiguration was not ready: %w", err), opv1.MachineConfigurationBootImageUpdateDegraded)
 		return err
 	}
 
+	// Conditions computed over this pass are written once it is done, whichever way it ends
+	ctrl.passConditions = nil
+	defer ctrl.writePassConditions()
+
+	// The kill switch halts all changes to machine resources, regardless of the MachineConfiguration
+	halted, err := ctrl.isKillSwitchEngaged()
+	if err != nil {
+		return err
+	}
+	if halted {
+		klog.Infof("Kill switch configmap %s is present, boot image updates are halted", BootImageKillSwitchConfigMapName)
+		ctrl.setPassCondition(BootImageUpdateHaltedConditionType, metav1.ConditionTrue, "KillSwitchPresent",
+			fmt.Sprintf("Boot image updates are halted: kill switch configmap %s/%s is present, delete it to resume boot image updates", ctrlcommon.MCONamespace, BootImageKillSwitchConfigMapName))
+		return nil
+	}
+	ctrl.setPassCondition(BootImageUpdateHaltedConditionType, metav1.ConditionFalse, "NotHalted", "Boot image updates are not halted")
+
 	// Skip reconciliation while the cluster is installing or upgrading.
 	// External services may not yet be reachable during these transitions
 	// (e.g. vCenter on vSphere), and boot image updates are only meaningful
@@ -737,7 +1482,249 @@ func (ctrl *Controller) syncAll(event string) error {
 		return nil
 	}
 
+	// Refresh the boot image knobs for this sync
+	mcop, err := ctrl.mcopLister.Get(ctrlcommon.MCOOperatorKnobsObjectName)
+	if err != nil {
+		return fmt.Errorf("failed to get MachineConfiguration: %w", err)
+	}
+	ctrl.knobs = getBootImageKnobs(mcop, ctrl.annotationKeyPrefix, ctrl.imageVerifiers)
+
+	// A manual resync is redundant if a completed sync already picked up its nonce, as every sync is a
+	// full sync
+	nonce := mcop.Annotations[ctrl.annotationKey(ResyncNonceAnnotationKey)]
+	if event == "ManualResync" && nonce == ctrl.lastResyncNonce {
+		klog.Infof("Boot image resync nonce %q was already processed, skipping manual resync", nonce)
+		return nil
+	}
+
+	// An approval that was already acted upon, but could not be cleared, fails closed: no updates are
+	// applied until it is removed
+	if ctrl.approvalConsumed && ctrl.knobs.approved {
+		if err := ctrl.clearBootImageApproval(mcop); err != nil {
+			klog.Errorf("Boot image approval was already acted upon, not applying updates until it is cleared: %v", err)
+		} else {
+			ctrl.approvalConsumed = false
+		}
+		ctrl.knobs = ctrl.knobs.withdrawApproval()
+	} else if !ctrl.knobs.approved {
+		ctrl.approvalConsumed = false
+	}
+
+	switch {
+	case ctrl.knobs.awaitingApproval:
+		klog.Infof("Boot image updates are awaiting approval, machine resources will not be updated")
+	case ctrl.knobs.advisoryOnly:
+		klog.Infof("Boot image controller is in advisory-only mode, machine resources will not be updated")
+	case ctrl.knobs.approved:
+		klog.Infof("Boot image updates were approved, applying pending updates")
+	}
+	ctrl.publishEffectiveConfig()
+
+	// Contradictions in the managed scope are reported, but do not stop the machine resources that are
+	// unambiguously enrolled from being reconciled
+	scopeErr := ctrl.validateManagedBootImagesStatus(mcop.Status.ManagedBootImagesStatus)
+	if scopeErr != nil {
+		klog.Warningf("Managed boot images scope is inconsistent: %v", scopeErr)
+	}
+	if scopeErr != nil {
+		ctrl.setPassCondition(BootImageManagedScopeInconsistentConditionType, metav1.ConditionTrue, "ManagedScopeInconsistent",
+			fmt.Sprintf("Managed boot images scope is inconsistent: %s", scopeErr.Error()))
+	} else {
+		ctrl.setPassCondition(BootImageManagedScopeInconsistentConditionType, metav1.ConditionFalse, "ManagedScopeConsistent", "Managed boot images scope is consistent")
+	}
+
+	// Confirm that image resolution dependencies (e.g. vCenter on vSphere) are reachable before
+	// iterating machine resources, so that a network blip surfaces as a single transient error
+	// rather than an error for every machine resource. Returning the error backs off the event.
+	// Advisory-only mode does not evaluate the platforms that have such dependencies.
+	if len(mcop.Status.ManagedBootImagesStatus.MachineManagers) > 0 && !ctrl.knobs.advisoryOnly {
+		infra, err := ctrl.infraLister.Get("cluster")
+		if err != nil {
+			return fmt.Errorf("failed to fetch infra object: %w", err)
+		}
+		if err := ctrl.checkImageResolutionDependency(infra); err != nil {
+			klog.Warningf("Deferring boot image reconciliation: %v", err)
+			ctrl.updateConditions(event, fmt.Errorf("transient error, will retry: %w", err), opv1.MachineConfigurationBootImageUpdateDegraded)
+			return err
+		}
+	}
+
+	// Make sure the golden configmap in the cache is the one on the API server; after a rapid
+	// delete and recreate the cache may briefly still hold the old object.
+	if len(mcop.Status.ManagedBootImagesStatus.MachineManagers) > 0 {
+		if err := ctrl.verifyBootImagesConfigMap(); err != nil {
+			klog.Warningf("Deferring boot image reconciliation: %v", err)
+			return err
+		}
+	}
+
+	// Report a missing or invalid configmap separately from the errors of individual machine resources
+	var configMapErr error
+	if len(mcop.Status.ManagedBootImagesStatus.MachineManagers) > 0 {
+		configMapErr = ctrl.validateBootImagesConfigMap()
+		if configMapErr != nil {
+			klog.Errorf("Boot images configmap is invalid: %v", configMapErr)
+		}
+	}
+	if configMapErr != nil {
+		ctrl.setPassCondition(BootImageConfigMapInvalidConditionType, metav1.ConditionTrue, "ConfigMapInvalid",
+			fmt.Sprintf("Boot images configmap %s is invalid: %s", ctrlcommon.BootImagesConfigMapName, configMapErr.Error()))
+	} else {
+		ctrl.setPassCondition(BootImageConfigMapInvalidConditionType, metav1.ConditionFalse, "ConfigMapValid",
+			fmt.Sprintf("Boot images configmap %s is valid", ctrlcommon.BootImagesConfigMapName))
+	}
+	if configMapErr != nil {
+		// A configmap meant for another cluster, or missing the architectures of enrolled machinesets, is
+		// also reported as degraded once, rather than as an error for every affected machine resource
+		if errors.Is(configMapErr, errBootImagesConfigMapClusterMismatch) || errors.Is(configMapErr, errBootImagesConfigMapMissingArchitectures) {
+			ctrl.updateConditions(ReasonInvalidBootImagesConfigMap, configMapErr, opv1.MachineConfigurationBootImageUpdateDegraded)
+		}
+		// Nothing can be reconciled against a bad source of truth; an update to the configmap triggers a new sync
+		return nil
+	}
+
+	// A multi-arch stream that is only partially published would roll out a half-published update
+	var archSkewErr error
+	if len(mcop.Status.ManagedBootImagesStatus.MachineManagers) > 0 {
+		archSkewErr = ctrl.checkArchVersionSkew()
+		if archSkewErr != nil {
+			klog.Warningf("Boot images configmap is inconsistent across architectures: %v", archSkewErr)
+		}
+	}
+	switch {
+	case archSkewErr != nil && ctrl.knobs.requireArchConsistency:
+		ctrl.setPassCondition(BootImageArchVersionSkewConditionType, metav1.ConditionTrue, "ArchVersionSkewDeferred",
+			fmt.Sprintf("Boot image updates are deferred until the stream data is consistent across architectures: %s", archSkewErr.Error()))
+	case archSkewErr != nil:
+		ctrl.setPassCondition(BootImageArchVersionSkewConditionType, metav1.ConditionTrue, "ArchVersionSkew",
+			fmt.Sprintf("Stream data is inconsistent across architectures: %s", archSkewErr.Error()))
+	default:
+		ctrl.setPassCondition(BootImageArchVersionSkewConditionType, metav1.ConditionFalse, "ArchVersionsConsistent", "Stream data is consistent across architectures")
+	}
+	if archSkewErr != nil && ctrl.knobs.requireArchConsistency {
+		// The remaining architectures are published by an update to the configmap, which triggers a new sync
+		klog.Infof("Deferring boot image reconciliation until the stream data is consistent across architectures")
+		return nil
+	}
+	ctrl.targetOSVersion = ctrl.getTargetOSVersion()
+
 	ctrl.syncControlPlaneMachineSets(event)
 	ctrl.syncMAPIMachineSets(event)
+	ctrl.syncPassCompleted = true
+	ctrl.setBehindCondition()
+	ctrl.recordRolloutConvergence(mcop)
+	ctrl.emitSyncSummaryEvent(mcop)
+	ctrl.recordSyncSummary(event)
+	ctrl.lastResyncNonce = nonce
+
+	// Machine resources held back by the reconcile budget, in-flight replacements, the soak interval or a
+	// conflicting write are picked up by a later pass
+	if ctrl.mapiUpdatesHeld || ctrl.cpmsUpdatesHeld {
+		klog.Infof("Boot image updates were held back, requeueing in %v", heldUpdatesRequeueInterval)
+		ctrl.queue.AddAfter(event, heldUpdatesRequeueInterval)
+	}
+
+	// Machines created since a boot image update that are still booting count as boot failures once the
+	// boot timeout has passed, which no event signals
+	if ctrl.mapiAwaitingBoots {
+		delay := ctrl.knobs.autoRollbackTimeout()
+		klog.V(4).Infof("Machines of updated MAPI machinesets are booting, requeueing in %v to check for boot failures", delay)
+		ctrl.queue.AddAfter(event, delay)
+	}
+
+	// Machinesets that failed with a transient error, or whose failure is within the error grace count,
+	// are retried after the configured delay, as the failure does not back off the event
+	if ctrl.mapiTransientErrors || ctrl.mapiFailuresInGrace {
+		delay := ctrl.knobs.transientRequeueDelay()
+		klog.Infof("MAPI machinesets failed to sync with transient errors or within the error grace count, requeueing in %v", delay)
+		ctrl.queue.AddAfter(event, delay)
+	}
+
+	// Errors held back from the Degraded condition are reported once the suppression window ends
+	if ctrl.aggregateSyncErrors() != nil && ctrl.knobs.degradedSuppressed(ctrl.clock.Now()) {
+		delay := ctrl.knobs.suppressDegradedUntil.Sub(ctrl.clock.Now())
+		klog.Warningf("Degraded condition is suppressed despite sync errors, requeueing in %v", delay)
+		ctrl.queue.AddAfter(event, delay)
+	}
+
+	// An approval is good for a single pass, after which the controller returns to reporting. A pass
+	// that held back updates keeps the approval until the remaining machinesets are updated.
+	if ctrl.knobs.approved && !ctrl.mapiUpdatesHeld && !ctrl.cpmsUpdatesHeld {
+		if err := ctrl.clearBootImageApproval(mcop); err != nil {
+			ctrl.approvalConsumed = true
+			return err
+		}
+	}
 	return nil
 }
+
+// verifyBootImagesConfigMap snapshots the golden configmap from the lister and re-verifies it against the
+// API server. An error is returned if the two disagree on whether the configmap exists, or on its UID or
+// resource version, as the lister has not yet caught up with a delete or recreate. The sync is then
+// retried, so that machine resources are only ever reconciled against the latest configmap content.
+// A configmap that is absent from both is not an error here; the machine resource syncs report it.
+func (ctrl *Controller) verifyBootImagesConfigMap() error {
+	cached, err := ctrl.mcoCmLister.ConfigMaps(ctrlcommon.MCONamespace).Get(ctrlcommon.BootImagesConfigMapName)
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return fmt.Errorf("failed to fetch coreos-bootimages config map: %w", err)
+	}
+	cachedFound := err == nil
+
+	live, err := ctrl.kubeClient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Get(context.TODO(), ctrlcommon.BootImagesConfigMapName, metav1.GetOptions{})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return fmt.Errorf("failed to verify coreos-bootimages config map: %w", err)
+	}
+	liveFound := err == nil
+
+	switch {
+	case cachedFound && !liveFound:
+		return fmt.Errorf("coreos-bootimages config map was deleted, waiting for the cache to observe the deletion")
+	case !cachedFound && liveFound:
+		return fmt.Errorf("coreos-bootimages config map was recreated, waiting for the cache to observe it")
+	case cachedFound && (cached.UID != live.UID || cached.ResourceVersion != live.ResourceVersion):
+		return fmt.Errorf("cached coreos-bootimages config map (uid %s, resourceVersion %s) is stale, waiting for the cache to observe uid %s, resourceVersion %s",
+			cached.UID, cached.ResourceVersion, live.UID, live.ResourceVersion)
+	}
+	return nil
+}
+
+// clearBootImageApproval removes the approval annotation from the MachineConfiguration. The removal
+// is conditional on the annotation still holding the value that was acted upon, so that an approval
+// that was changed mid-sync is not lost.
+func (ctrl *Controller) clearBootImageApproval(mcop *opv1.MachineConfiguration) error {
+	approvedKey := ctrl.annotationKey(ApprovedAnnotationKey)
+	path := "/metadata/annotations/" + strings.ReplaceAll(strings.ReplaceAll(approvedKey, "~", "~0"), "/", "~1")
+	patch, err := json.Marshal([]map[string]interface{}{
+		{"op": "test", "path": path, "value": mcop.Annotations[approvedKey]},
+		{"op": "remove", "path": path},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create patch clearing boot image approval: %w", err)
+	}
+	if _, err := ctrl.mcopClient.OperatorV1().MachineConfigurations().Patch(context.TODO(), mcop.Name, types.JSONPatchType, patch, metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("failed to clear boot image approval: %w", err)
+	}
+	klog.Infof("Applied approved boot image updates, cleared annotation %s", approvedKey)
+	return nil
+}
+
+// emitSyncSummaryEvent emits a single event on the MachineConfiguration object summarizing the
+// outcome of the sync for each machine resource type. A summary that differs from the previous one
+// is always emitted, while an identical summary is emitted at most once per syncSummaryEventInterval;
+// the event recorder coalesces the identical events into one with a count.
+func (ctrl *Controller) emitSyncSummaryEvent(mcop *opv1.MachineConfiguration) {
+	messages := []string{
+		ctrl.mapiStats.getSummaryMessage("MAPI MachineSets"),
+		ctrl.cpmsStats.getSummaryMessage("ControlPlaneMachineSets"),
+		ctrl.capiMachineSetStats.getSummaryMessage("CAPI MachineSets"),
+		ctrl.capiMachineDeploymentStats.getSummaryMessage("CAPI MachineDeployments"),
+	}
+	summary := strings.Join(messages, " | ")
+	if summary == ctrl.lastSyncSummary && ctrl.clock.Since(ctrl.lastSyncSummaryEventTime) < syncSummaryEventInterval {
+		klog.V(4).Infof("Skipping unchanged reconcile summary event, last one was emitted at %v", ctrl.lastSyncSummaryEventTime)
+		return
+	}
+	ctrl.eventRecorder.Eventf(mcop, corev1.EventTypeNormal, "BootImageReconcileComplete", "Boot image reconciliation complete | %s", summary)
+	ctrl.lastSyncSummary = summary
+	ctrl.lastSyncSummaryEventTime = ctrl.clock.Now()
+}
@@ -2,7 +2,15 @@ package bootimage
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/coreos/stream-metadata-go/stream"
 	"github.com/coreos/stream-metadata-go/stream/rhcos"
@@ -10,16 +18,31 @@ import (
 	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
 	opv1 "github.com/openshift/api/operator/v1"
 	configlistersv1 "github.com/openshift/client-go/config/listers/config/v1"
+	fakemachineclient "github.com/openshift/client-go/machine/clientset/versioned/fake"
+	machinelistersv1beta1 "github.com/openshift/client-go/machine/listers/machine/v1beta1"
 	fakemcopclient "github.com/openshift/client-go/operator/clientset/versioned/fake"
+	mcoplistersv1 "github.com/openshift/client-go/operator/listers/operator/v1"
+	"github.com/openshift/library-go/pkg/operator/resource/resourceread"
+	"github.com/openshift/machine-config-operator/manifests"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	kubeErrs "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	fakediscovery "k8s.io/client-go/discovery/fake"
 	"k8s.io/client-go/kubernetes/fake"
+	corelisterv1 "k8s.io/client-go/listers/core/v1"
+	clienttesting "k8s.io/client-go/testing"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
-	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"k8s.io/utils/clock"
 )
 
 func TestIsClusterStable(t *testing.T) {
@@ -177,6 +200,27 @@ func TestHotLoop(t *testing.T) {
 			updateCount:           HotLoopLimit + 1,
 			expectHotLoop:         false,
 		},
+		{
+			name:                  "Hot loop not detected past the default threshold due to a raised per-machineset limit",
+			machineset:            withAnnotation(getMachineSet("machine-set-1", "boot-image-1"), HotLoopLimitAnnotationKey, "6"),
+			generateBootImageFunc: func(s string) string { return s },
+			updateCount:           6,
+			expectHotLoop:         false,
+		},
+		{
+			name:                  "Hot loop detected when a raised per-machineset limit is exceeded",
+			machineset:            withAnnotation(getMachineSet("machine-set-1", "boot-image-1"), HotLoopLimitAnnotationKey, "6"),
+			generateBootImageFunc: func(s string) string { return s },
+			updateCount:           7,
+			expectHotLoop:         true,
+		},
+		{
+			name:                  "Hot loop detected at the default threshold due to an invalid per-machineset limit",
+			machineset:            withAnnotation(getMachineSet("machine-set-1", "boot-image-1"), HotLoopLimitAnnotationKey, "0"),
+			generateBootImageFunc: func(s string) string { return s },
+			updateCount:           HotLoopLimit + 1,
+			expectHotLoop:         true,
+		},
 	}
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
@@ -310,6 +354,65 @@ func TestGetArchFromMachineSet(t *testing.T) {
 			expectedArch:   "ppc64le",
 			expectError:    false,
 		},
+		{
+			name: "Whitespace around key and value",
+			annotations: map[string]string{
+				MachineSetArchAnnotationKey: "node.kubernetes.io/instance-type = m6g.large,  kubernetes.io/arch = arm64 ",
+			},
+			clusterVersion: multiArchCV,
+			expectedArch:   "aarch64",
+			expectError:    false,
+		},
+		{
+			name: "Autoscaler labels with empty entries and a similarly named label",
+			annotations: map[string]string{
+				MachineSetArchAnnotationKey: "kubernetes.io/os=linux,,node.kubernetes.io/arch-hint=arm64,kubernetes.io/arch=amd64,",
+			},
+			clusterVersion: multiArchCV,
+			expectedArch:   "x86_64",
+			expectError:    false,
+		},
+		{
+			name: "Architecture label repeated with the same value",
+			annotations: map[string]string{
+				MachineSetArchAnnotationKey: "kubernetes.io/arch=s390x,topology.kubernetes.io/zone=z1,kubernetes.io/arch= s390x",
+			},
+			clusterVersion: singleArchCV,
+			expectedArch:   "s390x",
+			expectError:    false,
+		},
+		{
+			name: "Architecture label repeated with conflicting values",
+			annotations: map[string]string{
+				MachineSetArchAnnotationKey: "kubernetes.io/arch=amd64,kubernetes.io/arch=arm64",
+			},
+			clusterVersion: singleArchCV,
+			expectError:    true,
+		},
+		{
+			name: "Architecture label with an empty value",
+			annotations: map[string]string{
+				MachineSetArchAnnotationKey: "kubernetes.io/arch=,topology.kubernetes.io/zone=z1",
+			},
+			clusterVersion: singleArchCV,
+			expectError:    true,
+		},
+		{
+			name: "Empty annotation in single-arch cluster defaults to control plane arch",
+			annotations: map[string]string{
+				MachineSetArchAnnotationKey: " ",
+			},
+			clusterVersion: singleArchCV,
+			expectError:    false, // Should default to control plane arch
+		},
+		{
+			name: "Empty annotation in multi-arch cluster returns error",
+			annotations: map[string]string{
+				MachineSetArchAnnotationKey: "",
+			},
+			clusterVersion: multiArchCV,
+			expectError:    true,
+		},
 		{
 			name: "Invalid architecture",
 			annotations: map[string]string{
@@ -1076,3 +1179,1482 @@ func TestResetClusterBootImage(t *testing.T) {
 		})
 	}
 }
+
+const (
+	// Boot image advertised by the test GCP stream
+	testGCPStreamImage = "projects/rhcos-cloud/global/images/rhcos-9-6-new"
+	// An older boot image known to the MCO, eligible for update
+	testGCPOldImage = "projects/rhcos-cloud/global/images/rhcos-9-6-old"
+)
+
+// Returns a GCP machineset with a single boot disk using the given image. The machineset
+// is annotated as amd64 so that arch detection is independent of the test host.
+func getGCPMachineSet(name, bootImage string) *machinev1beta1.MachineSet {
+	providerSpec := machinev1beta1.GCPMachineProviderSpec{
+		Disks: []*machinev1beta1.GCPDisk{
+			{Boot: true, Image: bootImage},
+		},
+		UserDataSecret: &corev1.LocalObjectReference{Name: "test-secret"},
+	}
+	raw, err := json.Marshal(providerSpec)
+	if err != nil {
+		panic(err)
+	}
+	return &machinev1beta1.MachineSet{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      name,
+			Namespace: MachineAPINamespace,
+			Annotations: map[string]string{
+				MachineSetArchAnnotationKey: "kubernetes.io/arch=amd64",
+			},
+		},
+		Spec: machinev1beta1.MachineSetSpec{
+			Template: machinev1beta1.MachineTemplateSpec{
+				Spec: machinev1beta1.MachineSpec{
+					ProviderSpec: machinev1beta1.ProviderSpec{
+						Value: &runtime.RawExtension{Raw: raw},
+					},
+				},
+			},
+		},
+	}
+}
+
+// Sets an annotation on a machineset
+func withAnnotation(machineSet *machinev1beta1.MachineSet, key, value string) *machinev1beta1.MachineSet {
+	if machineSet.Annotations == nil {
+		machineSet.Annotations = map[string]string{}
+	}
+	machineSet.Annotations[key] = value
+	return machineSet
+}
+
+// Sets the zone targeted by a GCP machineset
+func setGCPMachineSetZone(t *testing.T, machineSet *machinev1beta1.MachineSet, zone string) *machinev1beta1.MachineSet {
+	t.Helper()
+	providerSpec := new(machinev1beta1.GCPMachineProviderSpec)
+	require.NoError(t, unmarshalProviderSpec(machineSet, providerSpec))
+	providerSpec.Zone = zone
+	require.NoError(t, marshalProviderSpec(machineSet, providerSpec))
+	return machineSet
+}
+
+// Returns the boot disk image of a GCP machineset
+func getGCPMachineSetBootImage(t *testing.T, machineSet *machinev1beta1.MachineSet) string {
+	t.Helper()
+	providerSpec := new(machinev1beta1.GCPMachineProviderSpec)
+	require.NoError(t, unmarshalProviderSpec(machineSet, providerSpec))
+	for _, disk := range providerSpec.Disks {
+		if disk.Boot {
+			return disk.Image
+		}
+	}
+	return ""
+}
+
+// Returns a boot images configmap advertising testGCPStreamImage for x86_64
+func getGCPBootImagesConfigMap() *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      ctrlcommon.BootImagesConfigMapName,
+			Namespace: ctrlcommon.MCONamespace,
+		},
+		Data: map[string]string{
+			StreamConfigMapKey: `{"stream":"rhcos-9.6","architectures":{"x86_64":{"images":{"gcp":{"project":"rhcos-cloud","name":"rhcos-9-6-new"}}}}}`,
+		},
+	}
+}
+
+// testController bundles a Controller wired up with fake clients and listers, for exercising the
+// sync paths end to end.
+type testController struct {
+	*Controller
+	machineClient  *fakemachineclient.Clientset
+	mcopClient     *fakemcopclient.Clientset
+	kubeClient     *fake.Clientset
+	mcopIndexer    cache.Indexer
+	infraIndexer   cache.Indexer
+	cmIndexer      cache.Indexer
+	msIndexer      cache.Indexer
+	machineIndexer cache.Indexer
+	nodeIndexer    cache.Indexer
+	eventRecorder  *record.FakeRecorder
+}
+
+// newTestController returns a controller on the given platform with the MAPI machinesets, secrets in
+// the machine API namespace and the golden configmap populated in its listers. All MAPI machinesets
+// are enrolled for boot image updates.
+func newTestController(t *testing.T, platform osconfigv1.PlatformType, machineSets []*machinev1beta1.MachineSet, secrets []*corev1.Secret) *testController {
+	t.Helper()
+
+	cvIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	require.NoError(t, cvIndexer.Add(&osconfigv1.ClusterVersion{
+		ObjectMeta: v1.ObjectMeta{Name: "version"},
+		Status: osconfigv1.ClusterVersionStatus{
+			History: []osconfigv1.UpdateHistory{{State: osconfigv1.CompletedUpdate, Version: "4.20.0"}},
+		},
+	}))
+
+	infraIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	require.NoError(t, infraIndexer.Add(&osconfigv1.Infrastructure{
+		ObjectMeta: v1.ObjectMeta{Name: "cluster"},
+		Status: osconfigv1.InfrastructureStatus{
+			InfrastructureName: "test-cluster-abcde",
+			PlatformStatus:     &osconfigv1.PlatformStatus{Type: platform},
+		},
+	}))
+
+	cmIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	require.NoError(t, cmIndexer.Add(getGCPBootImagesConfigMap()))
+
+	// The user data secret is always present so ignition stub checks succeed
+	userDataSecret := &corev1.Secret{
+		ObjectMeta: v1.ObjectMeta{Name: "test-secret", Namespace: MachineAPINamespace},
+		Data: map[string][]byte{
+			ctrlcommon.UserDataKey: []byte(`{"ignition":{"version":"3.4.0"}}`),
+		},
+	}
+	secretIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	kubeObjects := []runtime.Object{userDataSecret, getGCPBootImagesConfigMap()}
+	for _, secret := range secrets {
+		require.NoError(t, secretIndexer.Add(secret))
+		kubeObjects = append(kubeObjects, secret)
+	}
+
+	msIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	machineObjects := []runtime.Object{}
+	for _, ms := range machineSets {
+		require.NoError(t, msIndexer.Add(ms))
+		machineObjects = append(machineObjects, ms)
+	}
+
+	mcop := &opv1.MachineConfiguration{
+		ObjectMeta: v1.ObjectMeta{Name: ctrlcommon.MCOOperatorKnobsObjectName},
+		Status: opv1.MachineConfigurationStatus{
+			ManagedBootImagesStatus: opv1.ManagedBootImages{
+				MachineManagers: []opv1.MachineManager{
+					{
+						Resource: opv1.MachineSets,
+						APIGroup: opv1.MachineAPI,
+						Selection: opv1.MachineManagerSelector{
+							Mode: opv1.All,
+						},
+					},
+				},
+			},
+		},
+	}
+	mcopIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	require.NoError(t, mcopIndexer.Add(mcop))
+
+	tc := &testController{
+		machineClient:  fakemachineclient.NewClientset(machineObjects...),
+		mcopClient:     fakemcopclient.NewClientset(mcop),
+		kubeClient:     fake.NewClientset(kubeObjects...),
+		mcopIndexer:    mcopIndexer,
+		infraIndexer:   infraIndexer,
+		cmIndexer:      cmIndexer,
+		msIndexer:      msIndexer,
+		machineIndexer: cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}),
+		nodeIndexer:    cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}),
+		eventRecorder:  record.NewFakeRecorder(10),
+	}
+	tc.Controller = &Controller{
+		eventRecorder:        tc.eventRecorder,
+		kubeClient:           tc.kubeClient,
+		machineClient:        tc.machineClient,
+		mcopClient:           tc.mcopClient,
+		mcoCmLister:          corelisterv1.NewConfigMapLister(cmIndexer),
+		mapiMachineSetLister: machinelistersv1beta1.NewMachineSetLister(msIndexer),
+		mapiMachineLister:    machinelistersv1beta1.NewMachineLister(tc.machineIndexer),
+		infraLister:          configlistersv1.NewInfrastructureLister(infraIndexer),
+		mcopLister:           mcoplistersv1.NewMachineConfigurationLister(mcopIndexer),
+		clusterVersionLister: configlistersv1.NewClusterVersionLister(cvIndexer),
+		mapiSecretLister:     corelisterv1.NewSecretLister(secretIndexer),
+		nodeLister:           corelisterv1.NewNodeLister(tc.nodeIndexer),
+		mapiBootImageState:   map[string]BootImageState{},
+		cpmsBootImageState:   map[string]BootImageState{},
+		mapiImageSources:     map[string]BootImageSource{},
+		imageResolutionCache: newImageResolutionCache(),
+		fgHandler:            ctrlcommon.NewFeatureGatesHardcodedHandler(nil, nil),
+		queue:                workqueue.NewTypedRateLimitingQueue(workqueue.DefaultTypedControllerRateLimiter[string]()),
+		streamConfigMapKey:   StreamConfigMapKey,
+		annotationKeyPrefix:  DefaultAnnotationKeyPrefix,
+		stateExportQueue:     make(chan ReconcileState, stateExportQueueLength),
+		stateExporter:        noopStateExporter{},
+		dial: func(_, address string, _ time.Duration) (net.Conn, error) {
+			return nil, fmt.Errorf("unexpected dial to %s", address)
+		},
+		clock:                  clock.RealClock{},
+		jitter:                 wait.Jitter,
+		webhookClient:          &http.Client{Timeout: updateWebhookTimeout},
+		mapiFailures:           map[string]machineSetFailures{},
+		reportedPinExpirations: map[string]string{},
+		reportedAheadOfStream:  map[string]string{},
+		mapiVerifiedImages:     map[string]bool{},
+		mapiProviderSpecCodecs: map[string]providerSpecCodec{},
+		reportedPlannedUpdates: map[string]string{},
+	}
+	return tc
+}
+
+// Sets the boot image knob annotations on the MachineConfiguration, in both the lister and the client
+func (tc *testController) setKnobs(t *testing.T, annotations map[string]string) {
+	t.Helper()
+	mcop, err := tc.mcopClient.OperatorV1().MachineConfigurations().Get(context.TODO(), ctrlcommon.MCOOperatorKnobsObjectName, v1.GetOptions{})
+	require.NoError(t, err)
+	mcop.Annotations = annotations
+	mcop, err = tc.mcopClient.OperatorV1().MachineConfigurations().Update(context.TODO(), mcop, v1.UpdateOptions{})
+	require.NoError(t, err)
+	require.NoError(t, tc.mcopIndexer.Update(mcop))
+}
+
+// Makes the fake MachineConfiguration client refuse, while enforce is set, any request the MCC
+// ClusterRole does not grant, as the API server would. The fake clients have no RBAC of their own.
+func (tc *testController) enforceClusterRole(t *testing.T, enforce *bool) {
+	t.Helper()
+	manifest, err := manifests.ReadFile("manifests/machineconfigcontroller/clusterrole.yaml")
+	require.NoError(t, err)
+	clusterRole := resourceread.ReadClusterRoleV1OrDie(manifest)
+	tc.mcopClient.PrependReactor("*", "*", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		if !*enforce {
+			return false, nil, nil
+		}
+		resource := action.GetResource().Resource
+		if action.GetSubresource() != "" {
+			resource += "/" + action.GetSubresource()
+		}
+		for _, rule := range clusterRole.Rules {
+			if slices.Contains(rule.APIGroups, action.GetResource().Group) && slices.Contains(rule.Resources, resource) && slices.Contains(rule.Verbs, action.GetVerb()) {
+				return false, nil, nil
+			}
+		}
+		return true, nil, k8serrors.NewForbidden(action.GetResource().GroupResource(), ctrlcommon.MCOOperatorKnobsObjectName,
+			fmt.Errorf("the machine-config-controller ClusterRole does not grant %s on %s", action.GetVerb(), resource))
+	})
+}
+
+// Returns the condition of the given type from the MachineConfiguration status
+func (tc *testController) getCondition(t *testing.T, conditionType string) v1.Condition {
+	t.Helper()
+	mcop, err := tc.mcopClient.OperatorV1().MachineConfigurations().Get(context.TODO(), ctrlcommon.MCOOperatorKnobsObjectName, v1.GetOptions{})
+	require.NoError(t, err)
+	for _, condition := range mcop.Status.Conditions {
+		if condition.Type == conditionType {
+			return condition
+		}
+	}
+	require.Failf(t, "condition not found", "condition %s not found", conditionType)
+	return v1.Condition{}
+}
+
+// Returns the number of boot image patch actions issued against MAPI machinesets
+func (tc *testController) countMachineSetPatches() int {
+	count := 0
+	for _, action := range tc.machineClient.Actions() {
+		if action.GetVerb() != "patch" || action.GetResource().Resource != "machinesets" {
+			continue
+		}
+		// Patches that only record a skip reason do not touch the spec
+		if strings.Contains(string(action.(clienttesting.PatchAction).GetPatch()), `"spec"`) {
+			count++
+		}
+	}
+	return count
+}
+
+// Returns the current state of a MAPI machineset from the fake machine client
+func (tc *testController) getMachineSet(t *testing.T, name string) *machinev1beta1.MachineSet {
+	t.Helper()
+	ms, err := tc.machineClient.MachineV1beta1().MachineSets(MachineAPINamespace).Get(context.TODO(), name, v1.GetOptions{})
+	require.NoError(t, err)
+	return ms
+}
+
+// Returns the current state of the cluster MachineConfiguration from the fake client
+func (tc *testController) getMachineConfiguration(t *testing.T) *opv1.MachineConfiguration {
+	t.Helper()
+	mcop, err := tc.mcopClient.OperatorV1().MachineConfigurations().Get(context.TODO(), ctrlcommon.MCOOperatorKnobsObjectName, v1.GetOptions{})
+	require.NoError(t, err)
+	return mcop
+}
+
+func TestDegradedConditionAggregatesErrors(t *testing.T) {
+	cases := []struct {
+		name             string
+		failingCount     int
+		expectedNames    int
+		expectTruncation string
+	}{
+		{
+			name:          "all failing machinesets are represented",
+			failingCount:  3,
+			expectedNames: 3,
+		},
+		{
+			name:             "errors beyond the limit are summarized",
+			failingCount:     maxConditionErrors + 2,
+			expectedNames:    maxConditionErrors - 1,
+			expectTruncation: "and 3 more error(s)",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			machineSets := []*machinev1beta1.MachineSet{}
+			for i := range tc.failingCount {
+				ms := getGCPMachineSet(fmt.Sprintf("failing-machineset-%02d", i), testGCPOldImage)
+				ms.Annotations[BootImageSecretRefAnnotationKey] = "missing-secret"
+				machineSets = append(machineSets, ms)
+			}
+			machineSets = append(machineSets, getGCPMachineSet("healthy-machineset", testGCPOldImage))
+			ctrl := newTestController(t, osconfigv1.GCPPlatformType, machineSets, nil)
+			// Simulate a failure in the preceding ControlPlaneMachineSet sync, which must not be masked
+			ctrl.cpmsSyncErrors = []error{fmt.Errorf("error syncing ControlPlaneMachineSet cluster")}
+
+			ctrl.syncMAPIMachineSets("test")
+
+			degraded := ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateDegraded)
+			assert.Equal(t, v1.ConditionTrue, degraded.Status)
+			assert.Contains(t, degraded.Message, fmt.Sprintf("%d Degraded MAPI MachineSets", tc.failingCount))
+			assert.Contains(t, degraded.Message, "error syncing ControlPlaneMachineSet cluster")
+			assert.NotContains(t, degraded.Message, "healthy-machineset")
+			assert.Equal(t, tc.expectedNames, strings.Count(degraded.Message, "error syncing MAPI MachineSet"))
+			if tc.expectTruncation != "" {
+				assert.Contains(t, degraded.Message, tc.expectTruncation)
+			} else {
+				for i := range tc.failingCount {
+					assert.Contains(t, degraded.Message, fmt.Sprintf("failing-machineset-%02d", i))
+				}
+			}
+			assert.Equal(t, 1, ctrl.countMachineSetPatches())
+		})
+	}
+}
+
+func TestSyncSummaryEvent(t *testing.T) {
+	skippedMachineSet := getGCPMachineSet("machineset-c", testGCPOldImage)
+	skippedMachineSet.OwnerReferences = []v1.OwnerReference{{Kind: "MachineDeployment", Name: "owner"}}
+	machineSets := []*machinev1beta1.MachineSet{
+		getGCPMachineSet("machineset-a", testGCPStreamImage),
+		getGCPMachineSet("machineset-b", testGCPStreamImage),
+		skippedMachineSet,
+	}
+	ctrl := newTestController(t, osconfigv1.GCPPlatformType, machineSets, nil)
+
+	require.NoError(t, ctrl.syncAll("test"))
+	require.Len(t, ctrl.eventRecorder.Events, 1)
+	event := <-ctrl.eventRecorder.Events
+	assert.Contains(t, event, "Normal BootImageReconcileComplete")
+	assert.Contains(t, event, "MAPI MachineSets: 0 updated, 1 skipped, 0 errored")
+	assert.Contains(t, event, "ControlPlaneMachineSets: 0 updated, 0 skipped, 0 errored")
+
+	// Rapid follow-up syncs with an identical, already up to date, outcome are coalesced
+	for range 3 {
+		require.NoError(t, ctrl.syncAll("test"))
+	}
+	assert.Empty(t, ctrl.eventRecorder.Events)
+
+	// Once the interval has passed, the identical summary is emitted again
+	ctrl.lastSyncSummaryEventTime = time.Now().Add(-syncSummaryEventInterval)
+	require.NoError(t, ctrl.syncAll("test"))
+	require.Len(t, ctrl.eventRecorder.Events, 1)
+	assert.Equal(t, event, <-ctrl.eventRecorder.Events)
+
+	// A new error is a meaningful transition, and is emitted right away
+	msIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	require.NoError(t, msIndexer.Add(getGCPMachineSet("machineset-a", testGCPOldImage)))
+	require.NoError(t, msIndexer.Add(getGCPMachineSet("machineset-b", testGCPOldImage)))
+	require.NoError(t, msIndexer.Add(withAnnotation(skippedMachineSet, BootImageSkipReasonAnnotationKey, string(SkipReasonOwnerReference))))
+	ctrl.mapiMachineSetLister = machinelistersv1beta1.NewMachineSetLister(msIndexer)
+	ctrl.machineClient.PrependReactor("patch", "machinesets", func(_ clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("patch failed")
+	})
+	require.NoError(t, ctrl.syncAll("test"))
+	require.Len(t, ctrl.eventRecorder.Events, 1)
+	assert.Contains(t, <-ctrl.eventRecorder.Events, "MAPI MachineSets: 0 updated, 1 skipped, 2 errored")
+}
+
+func TestDeletingMachineSetIsDeferred(t *testing.T) {
+	deletingMachineSet := getGCPMachineSet("deleting-machineset", testGCPOldImage)
+	deletionTimestamp := v1.Now()
+	deletingMachineSet.DeletionTimestamp = &deletionTimestamp
+	deletingMachineSet.Finalizers = []string{"machine.openshift.io/test"}
+	machineSets := []*machinev1beta1.MachineSet{
+		deletingMachineSet,
+		getGCPMachineSet("test-machineset", testGCPOldImage),
+	}
+	ctrl := newTestController(t, osconfigv1.GCPPlatformType, machineSets, nil)
+
+	require.NoError(t, ctrl.syncAll("test"))
+
+	// Only the machineset that isn't being deleted is patched
+	assert.Equal(t, 1, ctrl.countMachineSetPatches())
+	for _, action := range ctrl.machineClient.Actions() {
+		if patch, ok := action.(clienttesting.PatchAction); ok {
+			assert.NotEqual(t, "deleting-machineset", patch.GetName())
+		}
+	}
+	assert.Equal(t, testGCPOldImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, "deleting-machineset")))
+	assert.Equal(t, 1, ctrl.mapiStats.deferredCount)
+	assert.Equal(t, 0, ctrl.mapiStats.erroredCount)
+
+	progressing := ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateProgressing)
+	assert.Equal(t, v1.ConditionFalse, progressing.Status)
+	assert.Contains(t, progressing.Message, "(1 deferred)")
+	degraded := ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateDegraded)
+	assert.Equal(t, v1.ConditionFalse, degraded.Status)
+}
+
+func TestBehindCondition(t *testing.T) {
+	cases := []struct {
+		name           string
+		platform       osconfigv1.PlatformType
+		annotations    map[string]string
+		failingSync    bool
+		expectedBehind int
+		expectedStatus v1.ConditionStatus
+	}{
+		{
+			name:           "converged fleet reports no machinesets behind",
+			expectedBehind: 0,
+			expectedStatus: v1.ConditionFalse,
+		},
+		{
+			name:           "mid-rollout reports deferred and errored machinesets as behind",
+			annotations:    map[string]string{ZonesAnnotationKey: "us-central1-a"},
+			failingSync:    true,
+			expectedBehind: 3,
+			expectedStatus: v1.ConditionTrue,
+		},
+		{
+			name:           "advisory-only mode reports out of date machinesets as behind",
+			annotations:    map[string]string{AdvisoryOnlyAnnotationKey: "true"},
+			expectedBehind: 2,
+			expectedStatus: v1.ConditionTrue,
+		},
+		{
+			name:           "advisory-only mode reports machinesets it cannot evaluate as behind",
+			platform:       osconfigv1.VSpherePlatformType,
+			annotations:    map[string]string{AdvisoryOnlyAnnotationKey: "true"},
+			expectedBehind: 3,
+			expectedStatus: v1.ConditionTrue,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			machineSets := []*machinev1beta1.MachineSet{
+				setGCPMachineSetZone(t, getGCPMachineSet("machineset-a", testGCPOldImage), "us-central1-a"),
+				setGCPMachineSetZone(t, getGCPMachineSet("machineset-b", testGCPOldImage), "us-central1-b"),
+				setGCPMachineSetZone(t, getGCPMachineSet("machineset-c", testGCPStreamImage), "us-central1-c"),
+			}
+			if tc.failingSync {
+				failing := setGCPMachineSetZone(t, getGCPMachineSet("machineset-d", testGCPOldImage), "us-central1-a")
+				failing.Annotations[BootImageSecretRefAnnotationKey] = "missing-secret"
+				machineSets = append(machineSets, failing)
+			}
+			if tc.platform == "" {
+				tc.platform = osconfigv1.GCPPlatformType
+			}
+			ctrl := newTestController(t, tc.platform, machineSets, nil)
+			ctrl.setKnobs(t, tc.annotations)
+
+			require.NoError(t, ctrl.syncAll("test"))
+
+			behind := ctrl.getCondition(t, BootImageUpdateBehindConditionType)
+			assert.Equal(t, tc.expectedStatus, behind.Status)
+			assert.True(t, strings.HasPrefix(behind.Message, fmt.Sprintf("%d machine resources behind the boot images configmap", tc.expectedBehind)), behind.Message)
+			assert.Contains(t, behind.Message, fmt.Sprintf("%d MAPI MachineSets", tc.expectedBehind))
+		})
+	}
+}
+
+func TestApprovalClearedWithClusterRole(t *testing.T) {
+	ctrl := newTestController(t, osconfigv1.GCPPlatformType, []*machinev1beta1.MachineSet{getGCPMachineSet("machineset-a", testGCPOldImage)}, nil)
+	ctrl.setKnobs(t, map[string]string{ApprovalRequiredAnnotationKey: "true", ApprovedAnnotationKey: "true"})
+	enforce := true
+	ctrl.enforceClusterRole(t, &enforce)
+
+	require.NoError(t, ctrl.syncAll("test"))
+	enforce = false
+	assert.Equal(t, testGCPStreamImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, "machineset-a")))
+	mcop, err := ctrl.mcopClient.OperatorV1().MachineConfigurations().Get(context.TODO(), ctrlcommon.MCOOperatorKnobsObjectName, v1.GetOptions{})
+	require.NoError(t, err)
+	assert.NotContains(t, mcop.Annotations, ApprovedAnnotationKey)
+}
+
+func TestClearBootImageApprovalIsConditional(t *testing.T) {
+	ctrl := newTestController(t, osconfigv1.GCPPlatformType, nil, nil)
+	ctrl.setKnobs(t, map[string]string{ApprovalRequiredAnnotationKey: "true", ApprovedAnnotationKey: "1"})
+	observed, err := ctrl.mcopLister.Get(ctrlcommon.MCOOperatorKnobsObjectName)
+	require.NoError(t, err)
+
+	// The approval changed after it was observed, so it must not be cleared
+	ctrl.setKnobs(t, map[string]string{ApprovalRequiredAnnotationKey: "true", ApprovedAnnotationKey: "true"})
+	require.Error(t, ctrl.clearBootImageApproval(observed))
+	mcop, err := ctrl.mcopClient.OperatorV1().MachineConfigurations().Get(context.TODO(), ctrlcommon.MCOOperatorKnobsObjectName, v1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "true", mcop.Annotations[ApprovedAnnotationKey])
+}
+
+func TestStreamConfigMapKey(t *testing.T) {
+	const customKey = "stream-v2"
+
+	cases := []struct {
+		name        string
+		streamKey   string
+		expectImage string
+		expectError string
+	}{
+		{
+			name:        "stream data is read from the configured key",
+			streamKey:   customKey,
+			expectImage: testGCPStreamImage,
+		},
+		{
+			name:        "default key is not used when another key is configured",
+			streamKey:   StreamConfigMapKey,
+			expectImage: testGCPOldImage,
+			expectError: fmt.Sprintf("stream data key %q not found", StreamConfigMapKey),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ms := getGCPMachineSet("machineset-a", testGCPOldImage)
+			ctrl := newTestController(t, osconfigv1.GCPPlatformType, []*machinev1beta1.MachineSet{ms}, nil)
+			ctrl.streamConfigMapKey = tc.streamKey
+
+			configMap := getGCPBootImagesConfigMap()
+			configMap.Data[customKey] = configMap.Data[StreamConfigMapKey]
+			delete(configMap.Data, StreamConfigMapKey)
+			cmIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+			require.NoError(t, cmIndexer.Add(configMap))
+			ctrl.mcoCmLister = corelisterv1.NewConfigMapLister(cmIndexer)
+
+			ctrl.syncMAPIMachineSets("test")
+
+			assert.Equal(t, tc.expectImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, ms.Name)))
+			degraded := ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateDegraded)
+			if tc.expectError != "" {
+				assert.Equal(t, v1.ConditionTrue, degraded.Status)
+				assert.Contains(t, degraded.Message, tc.expectError)
+			} else {
+				assert.Equal(t, v1.ConditionFalse, degraded.Status)
+			}
+		})
+	}
+
+	t.Run("empty key prevents the controller from starting", func(t *testing.T) {
+		ctrl := newTestController(t, osconfigv1.GCPPlatformType, nil, nil)
+		ctrl.streamConfigMapKey = ""
+		stopCh := make(chan struct{})
+		defer close(stopCh)
+
+		done := make(chan struct{})
+		go func() {
+			ctrl.Run(stopCh)
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(wait.ForeverTestTimeout):
+			t.Fatal("controller started with an empty stream data key")
+		}
+	})
+}
+
+// Returns a MAPI machine owned by the given machineset, in the given phase
+func getMachine(name, machineSetName, phase string) *machinev1beta1.Machine {
+	machine := &machinev1beta1.Machine{
+		ObjectMeta: v1.ObjectMeta{
+			Name:            name,
+			Namespace:       MachineAPINamespace,
+			OwnerReferences: []v1.OwnerReference{{Kind: "MachineSet", Name: machineSetName}},
+		},
+	}
+	if phase != "" {
+		machine.Status.Phase = &phase
+	}
+	return machine
+}
+
+func TestUpdateConditionsTransitions(t *testing.T) {
+	oldTime := v1.NewTime(time.Now().Add(-time.Hour).Truncate(time.Second))
+	finished := MachineResourceStats{totalCount: 2, inProgress: 2}
+	running := MachineResourceStats{totalCount: 2, inProgress: 1}
+	failed := MachineResourceStats{totalCount: 2, inProgress: 1, erroredCount: 1}
+
+	type update struct {
+		conditionType string
+		syncError     error
+	}
+	type expectation struct {
+		status          v1.ConditionStatus
+		message         string
+		transitionMoved bool
+	}
+
+	cases := []struct {
+		name     string
+		initial  []v1.Condition
+		stats    MachineResourceStats
+		updates  []update
+		expected map[string]expectation
+	}{
+		{
+			name: "progressing true to false",
+			initial: []v1.Condition{
+				{Type: opv1.MachineConfigurationBootImageUpdateProgressing, Status: v1.ConditionTrue, Reason: "old", Message: "Reconciled 1 of 2 MAPI MachineSets"},
+				{Type: opv1.MachineConfigurationBootImageUpdateDegraded, Status: v1.ConditionFalse, Reason: "old"},
+			},
+			stats:   finished,
+			updates: []update{{conditionType: opv1.MachineConfigurationBootImageUpdateProgressing}},
+			expected: map[string]expectation{
+				opv1.MachineConfigurationBootImageUpdateProgressing: {status: v1.ConditionFalse, message: "Reconciled 2 of 2 MAPI MachineSets", transitionMoved: true},
+				opv1.MachineConfigurationBootImageUpdateDegraded:    {status: v1.ConditionFalse},
+			},
+		},
+		{
+			name: "degraded false to true",
+			initial: []v1.Condition{
+				{Type: opv1.MachineConfigurationBootImageUpdateProgressing, Status: v1.ConditionFalse, Reason: "old"},
+				{Type: opv1.MachineConfigurationBootImageUpdateDegraded, Status: v1.ConditionFalse, Reason: "old", Message: "0 Degraded MAPI MachineSets"},
+			},
+			stats:   failed,
+			updates: []update{{conditionType: opv1.MachineConfigurationBootImageUpdateDegraded, syncError: fmt.Errorf("boom")}},
+			expected: map[string]expectation{
+				opv1.MachineConfigurationBootImageUpdateProgressing: {status: v1.ConditionFalse},
+				opv1.MachineConfigurationBootImageUpdateDegraded:    {status: v1.ConditionTrue, message: "1 Degraded MAPI MachineSets", transitionMoved: true},
+			},
+		},
+		{
+			name: "progressing and degraded transition together",
+			initial: []v1.Condition{
+				{Type: opv1.MachineConfigurationBootImageUpdateProgressing, Status: v1.ConditionFalse, Reason: "old"},
+				{Type: opv1.MachineConfigurationBootImageUpdateDegraded, Status: v1.ConditionTrue, Reason: "old", Message: "1 Degraded MAPI MachineSets | Error(s): boom"},
+			},
+			stats: running,
+			updates: []update{
+				{conditionType: opv1.MachineConfigurationBootImageUpdateProgressing},
+				{conditionType: opv1.MachineConfigurationBootImageUpdateDegraded},
+			},
+			expected: map[string]expectation{
+				opv1.MachineConfigurationBootImageUpdateProgressing: {status: v1.ConditionTrue, message: "Reconciled 1 of 2 MAPI MachineSets", transitionMoved: true},
+				opv1.MachineConfigurationBootImageUpdateDegraded:    {status: v1.ConditionFalse, message: "0 Degraded MAPI MachineSets", transitionMoved: true},
+			},
+		},
+		{
+			name: "message change without a status change keeps the transition time",
+			initial: []v1.Condition{
+				{Type: opv1.MachineConfigurationBootImageUpdateProgressing, Status: v1.ConditionTrue, Reason: "old", Message: "Reconciled 0 of 2 MAPI MachineSets"},
+				{Type: opv1.MachineConfigurationBootImageUpdateDegraded, Status: v1.ConditionTrue, Reason: "old", Message: "1 Degraded MAPI MachineSets | Error(s): boom"},
+			},
+			stats: MachineResourceStats{totalCount: 3, inProgress: 1, erroredCount: 1},
+			updates: []update{
+				{conditionType: opv1.MachineConfigurationBootImageUpdateProgressing},
+				{conditionType: opv1.MachineConfigurationBootImageUpdateDegraded, syncError: fmt.Errorf("bang")},
+			},
+			expected: map[string]expectation{
+				opv1.MachineConfigurationBootImageUpdateProgressing: {status: v1.ConditionTrue, message: "Reconciled 1 of 3 MAPI MachineSets"},
+				opv1.MachineConfigurationBootImageUpdateDegraded:    {status: v1.ConditionTrue, message: "Error(s): bang"},
+			},
+		},
+		{
+			name: "no change",
+			initial: []v1.Condition{
+				{Type: opv1.MachineConfigurationBootImageUpdateProgressing, Status: v1.ConditionFalse, Reason: "test", Message: "Reconciled 2 of 2 MAPI MachineSets | Reconciled 0 of 0 ControlPlaneMachineSets | Reconciled 0 of 0 CAPI MachineSets | Reconciled 0 of 0 CAPI MachineDeployments"},
+				{Type: opv1.MachineConfigurationBootImageUpdateDegraded, Status: v1.ConditionFalse, Reason: "test", Message: "0 Degraded MAPI MachineSets | 0 Degraded ControlPlaneMachineSets | 0 Degraded CAPI MachineSets | 0 Degraded CAPI MachineDeployments"},
+			},
+			stats: finished,
+			updates: []update{
+				{conditionType: opv1.MachineConfigurationBootImageUpdateProgressing},
+				{conditionType: opv1.MachineConfigurationBootImageUpdateDegraded},
+			},
+			expected: map[string]expectation{
+				opv1.MachineConfigurationBootImageUpdateProgressing: {status: v1.ConditionFalse, message: "Reconciled 2 of 2 MAPI MachineSets"},
+				opv1.MachineConfigurationBootImageUpdateDegraded:    {status: v1.ConditionFalse, message: "0 Degraded MAPI MachineSets"},
+			},
+		},
+		{
+			name: "a condition added to existing ones starts with a fresh transition time",
+			initial: []v1.Condition{
+				{Type: opv1.MachineConfigurationBootImageUpdateDegraded, Status: v1.ConditionFalse, Reason: "old"},
+			},
+			stats:   running,
+			updates: []update{{conditionType: opv1.MachineConfigurationBootImageUpdateProgressing}},
+			expected: map[string]expectation{
+				opv1.MachineConfigurationBootImageUpdateProgressing: {status: v1.ConditionTrue, message: "Reconciled 1 of 2 MAPI MachineSets", transitionMoved: true},
+				opv1.MachineConfigurationBootImageUpdateDegraded:    {status: v1.ConditionFalse},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := newTestController(t, osconfigv1.GCPPlatformType, nil, nil)
+			mcop, err := ctrl.mcopClient.OperatorV1().MachineConfigurations().Get(context.TODO(), ctrlcommon.MCOOperatorKnobsObjectName, v1.GetOptions{})
+			require.NoError(t, err)
+			for _, condition := range tc.initial {
+				condition.LastTransitionTime = oldTime
+				mcop.Status.Conditions = append(mcop.Status.Conditions, condition)
+			}
+			_, err = ctrl.mcopClient.OperatorV1().MachineConfigurations().UpdateStatus(context.TODO(), mcop, v1.UpdateOptions{})
+			require.NoError(t, err)
+			ctrl.mapiStats = tc.stats
+
+			for _, u := range tc.updates {
+				ctrl.updateConditions("test", u.syncError, u.conditionType)
+			}
+
+			for conditionType, expected := range tc.expected {
+				condition := ctrl.getCondition(t, conditionType)
+				assert.Equal(t, expected.status, condition.Status, "status of %s", conditionType)
+				assert.Contains(t, condition.Message, expected.message, "message of %s", conditionType)
+				if expected.transitionMoved {
+					assert.True(t, condition.LastTransitionTime.After(oldTime.Time), "transition time of %s should move", conditionType)
+				} else {
+					assert.True(t, condition.LastTransitionTime.Equal(&oldTime), "transition time of %s should not move", conditionType)
+				}
+				updated := slices.ContainsFunc(tc.updates, func(u update) bool { return u.conditionType == conditionType })
+				if updated {
+					assert.Equal(t, "test", condition.Reason, "reason of %s", conditionType)
+				} else {
+					assert.Equal(t, "old", condition.Reason, "reason of %s", conditionType)
+				}
+			}
+		})
+	}
+}
+
+func TestBootImagesConfigMapRecreated(t *testing.T) {
+	const recreatedImage = "projects/rhcos-cloud/global/images/rhcos-9-6-newer"
+	ms := getGCPMachineSet("machineset-a", testGCPOldImage)
+	ctrl := newTestController(t, osconfigv1.GCPPlatformType, []*machinev1beta1.MachineSet{ms}, nil)
+	original, err := ctrl.kubeClient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Get(context.TODO(), ctrlcommon.BootImagesConfigMapName, v1.GetOptions{})
+	require.NoError(t, err)
+
+	// Delete and recreate the golden configmap with new content, before the cache has observed either event
+	require.NoError(t, ctrl.kubeClient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Delete(context.TODO(), ctrlcommon.BootImagesConfigMapName, v1.DeleteOptions{}))
+	recreated := getGCPBootImagesConfigMap()
+	recreated.UID = "recreated-uid"
+	recreated.ResourceVersion = "2"
+	recreated.Data[StreamConfigMapKey] = `{"stream":"rhcos-9.6","architectures":{"x86_64":{"images":{"gcp":{"project":"rhcos-cloud","name":"rhcos-9-6-newer"}}}}}`
+	_, err = ctrl.kubeClient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Create(context.TODO(), recreated, v1.CreateOptions{})
+	require.NoError(t, err)
+
+	// The stale cached configmap must not be acted upon
+	require.Error(t, ctrl.syncAll("test"))
+	assert.Equal(t, 0, ctrl.countMachineSetPatches())
+
+	// The cache observes the delete, delivered as a tombstone on relist
+	require.NoError(t, ctrl.cmIndexer.Delete(original))
+	ctrl.deleteConfigMap(cache.DeletedFinalStateUnknown{Key: ctrlcommon.MCONamespace + "/" + ctrlcommon.BootImagesConfigMapName, Obj: original})
+	assert.Equal(t, 1, ctrl.queue.Len())
+	require.Error(t, ctrl.syncAll("test"))
+	assert.Equal(t, 0, ctrl.countMachineSetPatches())
+
+	// Once the cache observes the recreated configmap, the sync converges on its content
+	require.NoError(t, ctrl.cmIndexer.Add(recreated))
+	require.NoError(t, ctrl.syncAll("test"))
+	assert.Equal(t, 1, ctrl.countMachineSetPatches())
+	assert.Equal(t, recreatedImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, ms.Name)))
+	assert.Equal(t, v1.ConditionFalse, ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateDegraded).Status)
+}
+
+func TestSetPatchResourceVersion(t *testing.T) {
+	patch, err := setPatchResourceVersion([]byte(`{"spec":{"replicas":1}}`), "42")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"metadata":{"resourceVersion":"42"},"spec":{"replicas":1}}`, string(patch))
+
+	patch, err = setPatchResourceVersion([]byte(`{"spec":{"replicas":1}}`), "")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"spec":{"replicas":1}}`, string(patch))
+}
+
+func TestBootImagesConfigMapInvalidCondition(t *testing.T) {
+	ctrl := newTestController(t, osconfigv1.GCPPlatformType, []*machinev1beta1.MachineSet{getGCPMachineSet("machineset-a", testGCPOldImage)}, nil)
+
+	// Replaces the golden configmap in both the cache and the API server
+	setConfigMapData := func(t *testing.T, data string) {
+		t.Helper()
+		configMap := getGCPBootImagesConfigMap()
+		configMap.Data[StreamConfigMapKey] = data
+		require.NoError(t, ctrl.cmIndexer.Update(configMap))
+		_, err := ctrl.kubeClient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Update(context.TODO(), configMap, v1.UpdateOptions{})
+		require.NoError(t, err)
+	}
+
+	cases := []struct {
+		name          string
+		data          string
+		expectInvalid string
+	}{
+		{
+			name:          "unparseable stream data",
+			data:          `{"stream":`,
+			expectInvalid: "failed to parse CoreOS stream metadata",
+		},
+		{
+			name:          "stream data without architectures",
+			data:          `{"stream":"rhcos-9.6","architectures":{}}`,
+			expectInvalid: "does not list any architectures",
+		},
+		{
+			name: "valid stream data clears the condition",
+			data: getGCPBootImagesConfigMap().Data[StreamConfigMapKey],
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			setConfigMapData(t, tc.data)
+			require.NoError(t, ctrl.syncAll("test"))
+
+			invalid := ctrl.getCondition(t, BootImageConfigMapInvalidConditionType)
+			if tc.expectInvalid != "" {
+				assert.Equal(t, v1.ConditionTrue, invalid.Status)
+				assert.Contains(t, invalid.Message, tc.expectInvalid)
+				assert.Equal(t, testGCPOldImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, "machineset-a")))
+			} else {
+				assert.Equal(t, v1.ConditionFalse, invalid.Status)
+				assert.Equal(t, testGCPStreamImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, "machineset-a")))
+				assert.Equal(t, v1.ConditionFalse, ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateDegraded).Status)
+			}
+		})
+	}
+}
+
+func TestBootImagesConfigMapForAnotherCluster(t *testing.T) {
+	ctrl := newTestController(t, osconfigv1.GCPPlatformType, []*machinev1beta1.MachineSet{getGCPMachineSet("machineset-a", testGCPOldImage)}, nil)
+
+	// Replaces the golden configmap in both the cache and the API server
+	setConfigMapAnnotations := func(t *testing.T, annotations map[string]string) {
+		t.Helper()
+		configMap := getGCPBootImagesConfigMap()
+		configMap.Annotations = annotations
+		require.NoError(t, ctrl.cmIndexer.Update(configMap))
+		_, err := ctrl.kubeClient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Update(context.TODO(), configMap, v1.UpdateOptions{})
+		require.NoError(t, err)
+	}
+
+	cases := []struct {
+		name           string
+		annotations    map[string]string
+		expectMismatch string
+	}{
+		{
+			name:           "mismatched infrastructure name",
+			annotations:    map[string]string{BootImagesInfrastructureNameAnnotationKey: "other-cluster-fghij"},
+			expectMismatch: `declares infrastructure "other-cluster-fghij", but this cluster's infrastructure is "test-cluster-abcde"`,
+		},
+		{
+			name:           "mismatched platform",
+			annotations:    map[string]string{BootImagesPlatformAnnotationKey: string(osconfigv1.AWSPlatformType)},
+			expectMismatch: `declares platform "AWS", but this cluster's platform is "GCP"`,
+		},
+		{
+			name: "matching identifiers",
+			annotations: map[string]string{
+				BootImagesInfrastructureNameAnnotationKey: "test-cluster-abcde",
+				BootImagesPlatformAnnotationKey:           string(osconfigv1.GCPPlatformType),
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			setConfigMapAnnotations(t, tc.annotations)
+			require.NoError(t, ctrl.syncAll("test"))
+
+			invalid := ctrl.getCondition(t, BootImageConfigMapInvalidConditionType)
+			degraded := ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateDegraded)
+			if tc.expectMismatch != "" {
+				assert.Equal(t, v1.ConditionTrue, invalid.Status)
+				assert.Contains(t, invalid.Message, tc.expectMismatch)
+				assert.Equal(t, v1.ConditionTrue, degraded.Status)
+				assert.Contains(t, degraded.Message, tc.expectMismatch)
+				assert.Equal(t, 0, ctrl.countMachineSetPatches())
+				assert.Equal(t, testGCPOldImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, "machineset-a")))
+			} else {
+				assert.Equal(t, v1.ConditionFalse, invalid.Status)
+				assert.Equal(t, v1.ConditionFalse, degraded.Status)
+				assert.Equal(t, testGCPStreamImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, "machineset-a")))
+			}
+		})
+	}
+}
+
+func TestKillSwitchConfigMap(t *testing.T) {
+	ctrl := newTestController(t, osconfigv1.GCPPlatformType, []*machinev1beta1.MachineSet{getGCPMachineSet("machineset-a", testGCPOldImage)}, nil)
+	killSwitch := &corev1.ConfigMap{ObjectMeta: v1.ObjectMeta{Name: BootImageKillSwitchConfigMapName, Namespace: ctrlcommon.MCONamespace}}
+
+	t.Run("presence halts updates", func(t *testing.T) {
+		require.NoError(t, ctrl.cmIndexer.Add(killSwitch))
+		require.NoError(t, ctrl.syncAll("BootImageKillSwitchAdded"))
+
+		halted := ctrl.getCondition(t, BootImageUpdateHaltedConditionType)
+		assert.Equal(t, v1.ConditionTrue, halted.Status)
+		assert.Contains(t, halted.Message, BootImageKillSwitchConfigMapName)
+		assert.Equal(t, 0, ctrl.countMachineSetPatches())
+		assert.Equal(t, testGCPOldImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, "machineset-a")))
+	})
+
+	t.Run("unchanged state makes no status update", func(t *testing.T) {
+		ctrl.mcopClient.ClearActions()
+		require.NoError(t, ctrl.syncAll("BootImageKillSwitchUpdated"))
+
+		for _, action := range ctrl.mcopClient.Actions() {
+			assert.NotEqual(t, "update", action.GetVerb(), "status should not be updated while the pass conditions are unchanged")
+		}
+		assert.Equal(t, "KillSwitchPresent", ctrl.getCondition(t, BootImageUpdateHaltedConditionType).Reason)
+	})
+
+	t.Run("deletion resumes updates", func(t *testing.T) {
+		require.NoError(t, ctrl.cmIndexer.Delete(killSwitch))
+		require.NoError(t, ctrl.syncAll("BootImageKillSwitchDeleted"))
+
+		assert.Equal(t, v1.ConditionFalse, ctrl.getCondition(t, BootImageUpdateHaltedConditionType).Status)
+		assert.Equal(t, testGCPStreamImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, "machineset-a")))
+	})
+}
+
+func TestEventRecorderComponentName(t *testing.T) {
+	cases := []struct {
+		name              string
+		component         string
+		expectedComponent string
+	}{
+		{
+			name:              "default component",
+			expectedComponent: DefaultEventComponentName,
+		},
+		{
+			name:              "configured component",
+			component:         "downstream-bootimagecontroller",
+			expectedComponent: "downstream-bootimagecontroller",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			kubeClient := fake.NewClientset()
+			recorder := newEventRecorder(kubeClient, tc.component)
+			recorder.Event(getGCPBootImagesConfigMap(), corev1.EventTypeNormal, "BootImageUpdated", "test event")
+
+			var events *corev1.EventList
+			require.NoError(t, wait.PollUntilContextTimeout(context.TODO(), 10*time.Millisecond, wait.ForeverTestTimeout, true, func(ctx context.Context) (bool, error) {
+				var err error
+				events, err = kubeClient.CoreV1().Events("").List(ctx, v1.ListOptions{})
+				return err == nil && len(events.Items) > 0, err
+			}))
+			require.Len(t, events.Items, 1)
+			assert.Equal(t, tc.expectedComponent, events.Items[0].Source.Component)
+		})
+	}
+}
+
+func TestConditionMessageTruncation(t *testing.T) {
+	const failingCount = 8
+	const maxLength = 512
+	machineSets := []*machinev1beta1.MachineSet{}
+	for i := range failingCount {
+		ms := getGCPMachineSet(fmt.Sprintf("failing-machineset-%02d", i), testGCPOldImage)
+		ms.Annotations[BootImageSecretRefAnnotationKey] = "missing-secret"
+		machineSets = append(machineSets, ms)
+	}
+	ctrl := newTestController(t, osconfigv1.GCPPlatformType, machineSets, nil)
+	ctrl.knobs = getBootImageKnobs(&opv1.MachineConfiguration{ObjectMeta: v1.ObjectMeta{
+		Annotations: map[string]string{ConditionMessageMaxLengthAnnotationKey: strconv.Itoa(maxLength)},
+	}}, DefaultAnnotationKeyPrefix, nil)
+
+	ctrl.syncMAPIMachineSets("test")
+
+	// The counts and the first errors are kept, the remaining errors are counted
+	message := ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateDegraded).Message
+	assert.LessOrEqual(t, len(message), maxLength)
+	assert.Contains(t, message, fmt.Sprintf("%d Degraded MAPI MachineSets", failingCount))
+	kept := strings.Count(message, "error syncing MAPI MachineSet")
+	assert.Greater(t, kept, 0)
+	assert.Less(t, kept, failingCount)
+	assert.Contains(t, message, fmt.Sprintf("and %d more error(s)", failingCount-kept))
+	assert.True(t, strings.HasSuffix(message, "more error(s)]"), message)
+
+	// Errors already summarized by count are included in the count of left out errors
+	errs := []error{}
+	for i := range maxConditionErrors {
+		errs = append(errs, fmt.Errorf("error %02d: %s", i, strings.Repeat("x", 100)))
+	}
+	errs = append(errs, omittedErrors(5))
+	message = ctrl.getDegradedMessage("counts", kubeErrs.NewAggregate(errs))
+	assert.LessOrEqual(t, len(message), maxLength)
+	kept = strings.Count(message, "error ")
+	assert.Contains(t, message, fmt.Sprintf("and %d more error(s)", maxConditionErrors+5-kept))
+
+	// A message that does not fit even without errors is cut
+	message = ctrl.getDegradedMessage(strings.Repeat("c", maxLength), kubeErrs.NewAggregate(errs))
+	assert.Len(t, message, maxLength)
+	assert.True(t, strings.HasSuffix(message, "..."))
+
+	// Short messages are left untouched
+	assert.Equal(t, "counts | Error(s): error", ctrl.getDegradedMessage("counts", fmt.Errorf("error")))
+}
+
+// delayRecordingQueue records the delays of the items added with AddAfter
+type delayRecordingQueue struct {
+	workqueue.TypedRateLimitingInterface[string]
+	delays []time.Duration
+}
+
+func (q *delayRecordingQueue) AddAfter(item string, duration time.Duration) {
+	q.delays = append(q.delays, duration)
+	q.TypedRateLimitingInterface.AddAfter(item, duration)
+}
+
+func TestTransientErrorRequeueDelay(t *testing.T) {
+	cases := []struct {
+		name           string
+		knobs          map[string]string
+		patchErr       error
+		expectedDelays []time.Duration
+	}{
+		{
+			name:           "transient error is requeued after the default delay",
+			patchErr:       k8serrors.NewServerTimeout(machinev1beta1.Resource("machinesets"), "patch", 1),
+			expectedDelays: []time.Duration{DefaultTransientErrorRequeueDelay},
+		},
+		{
+			name:           "transient error is requeued after the configured delay",
+			knobs:          map[string]string{TransientErrorRequeueDelayAnnotationKey: "45s"},
+			patchErr:       k8serrors.NewTooManyRequests("slow down", 1),
+			expectedDelays: []time.Duration{45 * time.Second},
+		},
+		{
+			name:     "other errors are not requeued",
+			knobs:    map[string]string{TransientErrorRequeueDelayAnnotationKey: "45s"},
+			patchErr: fmt.Errorf("patch failed"),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := newTestController(t, osconfigv1.GCPPlatformType, []*machinev1beta1.MachineSet{getGCPMachineSet("machineset-a", testGCPOldImage)}, nil)
+			queue := &delayRecordingQueue{TypedRateLimitingInterface: ctrl.queue}
+			ctrl.queue = queue
+			ctrl.setKnobs(t, tc.knobs)
+			ctrl.machineClient.PrependReactor("patch", "machinesets", func(_ clienttesting.Action) (bool, runtime.Object, error) {
+				return true, nil, tc.patchErr
+			})
+
+			require.NoError(t, ctrl.syncAll("test"))
+
+			assert.Equal(t, 1, ctrl.mapiStats.erroredCount)
+			assert.Equal(t, tc.expectedDelays, queue.delays)
+		})
+	}
+}
+
+func TestProgressingReportsTargetOSVersion(t *testing.T) {
+	t.Run("release is reported when the stream lists one", func(t *testing.T) {
+		ctrl := newTestController(t, osconfigv1.GCPPlatformType, []*machinev1beta1.MachineSet{getGCPMachineSet("machineset-a", testGCPOldImage)}, nil)
+		configMap := getGCPBootImagesConfigMap()
+		configMap.Data[StreamConfigMapKey] = `{"stream":"rhcos-9.6","architectures":{"x86_64":{"artifacts":{"gcp":{"release":"9.6.20250402-0","formats":{}}},"images":{"gcp":{"project":"rhcos-cloud","name":"rhcos-9-6-new"}}}}}`
+		require.NoError(t, ctrl.cmIndexer.Update(configMap))
+
+		require.NoError(t, ctrl.syncAll("test"))
+
+		progressing := ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateProgressing)
+		assert.Contains(t, progressing.Message, "Converging to RHCOS 9.6.20250402-0 | ")
+	})
+
+	t.Run("release is omitted when the stream lists none", func(t *testing.T) {
+		ctrl := newTestController(t, osconfigv1.GCPPlatformType, []*machinev1beta1.MachineSet{getGCPMachineSet("machineset-a", testGCPOldImage)}, nil)
+
+		require.NoError(t, ctrl.syncAll("test"))
+
+		progressing := ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateProgressing)
+		assert.NotContains(t, progressing.Message, "Converging to")
+	})
+}
+
+func TestMAPISyncDuration(t *testing.T) {
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(ctrlcommon.MCOBootImageSyncDuration)
+	// Returns the number of durations observed by the histogram
+	getSampleCount := func(t *testing.T) uint64 {
+		t.Helper()
+		families, err := registry.Gather()
+		require.NoError(t, err)
+		require.Len(t, families, 1)
+		require.Equal(t, "mco_boot_image_sync_duration_seconds", families[0].GetName())
+		return families[0].GetMetric()[0].GetHistogram().GetSampleCount()
+	}
+
+	ctrl := newTestController(t, osconfigv1.GCPPlatformType, []*machinev1beta1.MachineSet{getGCPMachineSet("machineset-a", testGCPOldImage)}, nil)
+	initial := getSampleCount(t)
+
+	t.Run("every sync is observed", func(t *testing.T) {
+		require.NoError(t, ctrl.syncAll("test"))
+		require.NoError(t, ctrl.syncAll("test"))
+
+		assert.Equal(t, initial+2, getSampleCount(t))
+		assert.Positive(t, ctrl.mapiSyncDuration)
+		assert.NotContains(t, ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateProgressing).Message, "sync took")
+	})
+
+	t.Run("duration is reported in the Progressing condition when enabled", func(t *testing.T) {
+		ctrl.setKnobs(t, map[string]string{ReportSyncDurationAnnotationKey: "true"})
+		require.NoError(t, ctrl.syncAll("test"))
+
+		assert.Equal(t, initial+3, getSampleCount(t))
+		progressing := ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateProgressing)
+		assert.Contains(t, progressing.Message, fmt.Sprintf("Last MAPI MachineSets sync took %v", ctrl.mapiSyncDuration))
+	})
+}
+
+func TestManagedScopeConsistency(t *testing.T) {
+	partial := func(matchLabels map[string]string) opv1.MachineManagerSelector {
+		return opv1.MachineManagerSelector{
+			Mode:    opv1.Partial,
+			Partial: &opv1.PartialSelector{MachineResourceSelector: &v1.LabelSelector{MatchLabels: matchLabels}},
+		}
+	}
+	mapiManager := func(selection opv1.MachineManagerSelector) opv1.MachineManager {
+		return opv1.MachineManager{Resource: opv1.MachineSets, APIGroup: opv1.MachineAPI, Selection: selection}
+	}
+
+	cases := []struct {
+		name             string
+		machineManagers  []opv1.MachineManager
+		expectedErrors   []string
+		expectedUpdated  bool
+		expectedDegraded bool
+	}{
+		{
+			name:            "all machinesets",
+			machineManagers: []opv1.MachineManager{mapiManager(opv1.MachineManagerSelector{Mode: opv1.All})},
+			expectedUpdated: true,
+		},
+		{
+			name: "partial selector matching a machineset, along with control plane machinesets",
+			machineManagers: []opv1.MachineManager{
+				mapiManager(partial(map[string]string{"boot-images": "managed"})),
+				{Resource: opv1.ControlPlaneMachineSets, APIGroup: opv1.MachineAPI, Selection: opv1.MachineManagerSelector{Mode: opv1.None}},
+			},
+			expectedUpdated: true,
+		},
+		{
+			name:            "partial selector matching no machineset",
+			machineManagers: []opv1.MachineManager{mapiManager(partial(map[string]string{"boot-images": "missing"}))},
+			expectedErrors:  []string{`machinesets.machine.openshift.io selector "boot-images=missing" matches no machinesets`},
+		},
+		{
+			name: "resource declared twice",
+			machineManagers: []opv1.MachineManager{
+				mapiManager(opv1.MachineManagerSelector{Mode: opv1.All}),
+				mapiManager(opv1.MachineManagerSelector{Mode: opv1.None}),
+			},
+			expectedErrors:  []string{"machinesets.machine.openshift.io is declared by more than one machine manager, only the first is used"},
+			expectedUpdated: true,
+		},
+		{
+			name:             "partial mode without a selector",
+			machineManagers:  []opv1.MachineManager{mapiManager(opv1.MachineManagerSelector{Mode: opv1.Partial})},
+			expectedErrors:   []string{"machinesets.machine.openshift.io is in Partial mode without a selector"},
+			expectedDegraded: true,
+		},
+		{
+			name: "selector in all mode and unsupported resource",
+			machineManagers: []opv1.MachineManager{
+				mapiManager(opv1.MachineManagerSelector{Mode: opv1.All, Partial: &opv1.PartialSelector{}}),
+				{Resource: opv1.MachineSets, APIGroup: "cluster.x-k8s.io", Selection: opv1.MachineManagerSelector{Mode: opv1.All}},
+			},
+			expectedErrors: []string{
+				"machinesets.machine.openshift.io has a partial selector, which is ignored in All mode",
+				"machinesets.cluster.x-k8s.io is not a machine resource supported for boot image updates",
+			},
+			expectedUpdated: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			machineSet := getGCPMachineSet("machineset-a", testGCPOldImage)
+			machineSet.Labels = map[string]string{"boot-images": "managed"}
+			ctrl := newTestController(t, osconfigv1.GCPPlatformType, []*machinev1beta1.MachineSet{machineSet}, nil)
+			mcop := ctrl.getMachineConfiguration(t)
+			mcop.Status.ManagedBootImagesStatus.MachineManagers = tc.machineManagers
+			mcop, err := ctrl.mcopClient.OperatorV1().MachineConfigurations().UpdateStatus(context.TODO(), mcop, v1.UpdateOptions{})
+			require.NoError(t, err)
+			require.NoError(t, ctrl.mcopIndexer.Update(mcop))
+
+			require.NoError(t, ctrl.syncAll("test"))
+
+			inconsistent := ctrl.getCondition(t, BootImageManagedScopeInconsistentConditionType)
+			if len(tc.expectedErrors) == 0 {
+				assert.Equal(t, v1.ConditionFalse, inconsistent.Status)
+			} else {
+				assert.Equal(t, v1.ConditionTrue, inconsistent.Status)
+				for _, expected := range tc.expectedErrors {
+					assert.Contains(t, inconsistent.Message, expected)
+				}
+			}
+			expectedImage := testGCPOldImage
+			if tc.expectedUpdated {
+				expectedImage = testGCPStreamImage
+			}
+			assert.Equal(t, expectedImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, "machineset-a")))
+			expectedDegraded := v1.ConditionFalse
+			if tc.expectedDegraded {
+				expectedDegraded = v1.ConditionTrue
+			}
+			assert.Equal(t, expectedDegraded, ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateDegraded).Status)
+		})
+	}
+}
+
+func TestLookAlikeConfigMapsIgnored(t *testing.T) {
+	ctrl := newTestController(t, osconfigv1.GCPPlatformType, []*machinev1beta1.MachineSet{getGCPMachineSet("machineset-a", testGCPOldImage)}, nil)
+	lookAlike := getGCPBootImagesConfigMap()
+	lookAlike.Namespace = "openshift-config"
+	lookAlike.Data[StreamConfigMapKey] = `{"stream":"rhcos-9.6","architectures":{"x86_64":{"images":{"gcp":{"project":"rhcos-cloud","name":"rhcos-9-6-look-alike"}}}}}`
+	killSwitchLookAlike := &corev1.ConfigMap{ObjectMeta: v1.ObjectMeta{Name: BootImageKillSwitchConfigMapName, Namespace: "openshift-config"}}
+	require.NoError(t, ctrl.cmIndexer.Add(lookAlike))
+	require.NoError(t, ctrl.cmIndexer.Add(killSwitchLookAlike))
+
+	// Events for the look-alikes do not trigger a sync
+	ctrl.addConfigMap(lookAlike)
+	ctrl.addConfigMap(killSwitchLookAlike)
+	updated := lookAlike.DeepCopy()
+	updated.ResourceVersion = "2"
+	ctrl.updateConfigMap(lookAlike, updated)
+	ctrl.deleteConfigMap(lookAlike)
+	ctrl.deleteConfigMap(killSwitchLookAlike)
+	assert.Equal(t, 0, ctrl.queue.Len())
+
+	// The sync only uses the configmaps in the MCO namespace
+	require.NoError(t, ctrl.syncAll("test"))
+	assert.Equal(t, v1.ConditionFalse, ctrl.getCondition(t, BootImageUpdateHaltedConditionType).Status)
+	assert.Equal(t, testGCPStreamImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, "machineset-a")))
+}
+
+func TestRolloutConvergence(t *testing.T) {
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(ctrlcommon.MCCBootImageRolloutConvergenceDuration)
+	// Returns the number of convergence durations observed by the histogram
+	getSampleCount := func(t *testing.T) uint64 {
+		t.Helper()
+		families, err := registry.Gather()
+		require.NoError(t, err)
+		require.Len(t, families, 1)
+		return families[0].GetMetric()[0].GetHistogram().GetSampleCount()
+	}
+	getConvergedEvents := func(ctrl *testController) []string {
+		events := []string{}
+		for len(ctrl.eventRecorder.Events) > 0 {
+			if event := <-ctrl.eventRecorder.Events; strings.Contains(event, "BootImageRolloutConverged") {
+				events = append(events, event)
+			}
+		}
+		return events
+	}
+	updateConfigMap := func(ctrl *testController) {
+		oldConfigMap := getGCPBootImagesConfigMap()
+		newConfigMap := oldConfigMap.DeepCopy()
+		newConfigMap.ResourceVersion = "2"
+		ctrl.updateConfigMap(oldConfigMap, newConfigMap)
+	}
+	initial := getSampleCount(t)
+
+	t.Run("convergence on a configmap change is observed once", func(t *testing.T) {
+		ctrl := newTestController(t, osconfigv1.GCPPlatformType, []*machinev1beta1.MachineSet{getGCPMachineSet("machineset-a", testGCPOldImage)}, nil)
+		updateConfigMap(ctrl)
+		require.NoError(t, ctrl.syncAll("test"))
+
+		assert.Equal(t, testGCPStreamImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, "machineset-a")))
+		assert.Equal(t, initial+1, getSampleCount(t))
+		assert.Len(t, getConvergedEvents(ctrl), 1)
+
+		// Syncs without a configmap change are not rollouts
+		require.NoError(t, ctrl.syncAll("test"))
+		assert.Equal(t, initial+1, getSampleCount(t))
+		assert.Empty(t, getConvergedEvents(ctrl))
+	})
+
+	t.Run("a rollout that does not converge is not observed", func(t *testing.T) {
+		// The AWS providerspec does not match the cluster platform, so the machineset errors on every sync
+		ctrl := newTestController(t, osconfigv1.GCPPlatformType, []*machinev1beta1.MachineSet{getMachineSet("machineset-a", "ami-old")}, nil)
+		updateConfigMap(ctrl)
+		require.NoError(t, ctrl.syncAll("test"))
+		require.NoError(t, ctrl.syncAll("test"))
+
+		assert.Equal(t, 1, ctrl.mapiStats.erroredCount)
+		assert.Equal(t, initial+1, getSampleCount(t))
+		assert.Empty(t, getConvergedEvents(ctrl))
+	})
+}
+
+// Makes the fake discovery client serve the MachineConfiguration API
+func (ctrl *testController) serveMachineConfigurationAPI() {
+	ctrl.kubeClient.Discovery().(*fakediscovery.FakeDiscovery).Resources = []*v1.APIResourceList{{
+		GroupVersion: opv1.GroupVersion.String(),
+		APIResources: []v1.APIResource{{Name: "machineconfigurations", Kind: "MachineConfiguration"}},
+	}}
+}
+
+func TestUpdateConditionsPreservesUnrelatedConditions(t *testing.T) {
+	ctrl := newTestController(t, osconfigv1.GCPPlatformType, []*machinev1beta1.MachineSet{getGCPMachineSet("machineset-a", testGCPOldImage)}, nil)
+	thirdPartyConditions := []v1.Condition{
+		{Type: "ThirdPartyReady", Status: v1.ConditionTrue, Reason: "AllGood", Message: "set by another controller", LastTransitionTime: v1.NewTime(time.Unix(1700000000, 0))},
+		{Type: "ThirdPartyDegraded", Status: v1.ConditionFalse, Reason: "AsExpected", LastTransitionTime: v1.NewTime(time.Unix(1700000000, 0))},
+	}
+	getThirdPartyConditions := func(t *testing.T) []v1.Condition {
+		t.Helper()
+		mcop, err := ctrl.mcopClient.OperatorV1().MachineConfigurations().Get(context.TODO(), ctrlcommon.MCOOperatorKnobsObjectName, v1.GetOptions{})
+		require.NoError(t, err)
+		conditions := []v1.Condition{}
+		for _, condition := range mcop.Status.Conditions {
+			if strings.HasPrefix(condition.Type, "ThirdParty") {
+				conditions = append(conditions, condition)
+			}
+		}
+		return conditions
+	}
+
+	// Seed the status with only third party conditions, ahead of any boot image condition
+	mcop, err := ctrl.mcopClient.OperatorV1().MachineConfigurations().Get(context.TODO(), ctrlcommon.MCOOperatorKnobsObjectName, v1.GetOptions{})
+	require.NoError(t, err)
+	mcop.Status.Conditions = append([]v1.Condition{}, thirdPartyConditions...)
+	_, err = ctrl.mcopClient.OperatorV1().MachineConfigurations().UpdateStatus(context.TODO(), mcop, v1.UpdateOptions{})
+	require.NoError(t, err)
+
+	require.NoError(t, ctrl.syncAll("test"))
+	assert.Equal(t, thirdPartyConditions, getThirdPartyConditions(t))
+	assert.Equal(t, v1.ConditionFalse, ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateProgressing).Status)
+	assert.Equal(t, v1.ConditionFalse, ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateDegraded).Status)
+
+	// A third party condition added while the boot image conditions are being written is preserved as well
+	addedCondition := v1.Condition{Type: "ThirdPartyAdded", Status: v1.ConditionTrue, Reason: "Added", LastTransitionTime: v1.NewTime(time.Unix(1700000000, 0))}
+	injected := false
+	ctrl.mcopClient.PrependReactor("update", "machineconfigurations", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		if injected || action.GetSubresource() != "status" {
+			return false, nil, nil
+		}
+		injected = true
+		// Mimic another controller winning the race to update the status
+		obj, err := ctrl.mcopClient.Tracker().Get(opv1.GroupVersion.WithResource("machineconfigurations"), "", ctrlcommon.MCOOperatorKnobsObjectName)
+		require.NoError(t, err)
+		current := obj.(*opv1.MachineConfiguration)
+		current.Status.Conditions = append(current.Status.Conditions, addedCondition)
+		current.ResourceVersion = "raced"
+		require.NoError(t, ctrl.mcopClient.Tracker().Update(opv1.GroupVersion.WithResource("machineconfigurations"), current, ""))
+		return true, nil, k8serrors.NewConflict(opv1.Resource("machineconfigurations"), ctrlcommon.MCOOperatorKnobsObjectName, fmt.Errorf("the object has been modified"))
+	})
+	ctrl.updateConditions("test", fmt.Errorf("injected failure"), opv1.MachineConfigurationBootImageUpdateDegraded)
+	require.True(t, injected)
+	assert.Equal(t, append(append([]v1.Condition{}, thirdPartyConditions...), addedCondition), getThirdPartyConditions(t))
+	degraded := ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateDegraded)
+	assert.Equal(t, v1.ConditionTrue, degraded.Status)
+	assert.Contains(t, degraded.Message, "injected failure")
+}
+
+func TestBootImagesConfigMapMissingEnrolledArchitectures(t *testing.T) {
+	ctrl := newTestController(t, osconfigv1.GCPPlatformType, []*machinev1beta1.MachineSet{
+		withAnnotation(getGCPMachineSet("machineset-a", testGCPOldImage), MachineSetArchAnnotationKey, "kubernetes.io/arch=amd64"),
+		withAnnotation(getGCPMachineSet("machineset-b", testGCPOldImage), MachineSetArchAnnotationKey, "kubernetes.io/arch=arm64"),
+	}, nil)
+
+	// The stream data has no aarch64 entries, so no machineset is updated and Degraded is set once
+	require.NoError(t, ctrl.syncAll("test"))
+	invalid := ctrl.getCondition(t, BootImageConfigMapInvalidConditionType)
+	degraded := ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateDegraded)
+	assert.Equal(t, v1.ConditionTrue, invalid.Status)
+	assert.Contains(t, invalid.Message, "aarch64 (machinesets machineset-b)")
+	assert.Equal(t, v1.ConditionTrue, degraded.Status)
+	assert.Equal(t, ReasonInvalidBootImagesConfigMap, degraded.Reason)
+	assert.Contains(t, degraded.Message, "aarch64 (machinesets machineset-b)")
+	assert.NotContains(t, degraded.Message, "error syncing MAPI MachineSet")
+	assert.Equal(t, 0, ctrl.countMachineSetPatches())
+
+	// Once the machineset targets an architecture of the stream, the configmap is valid again
+	machineSet := withAnnotation(ctrl.getMachineSet(t, "machineset-b"), MachineSetArchAnnotationKey, "kubernetes.io/arch=amd64")
+	require.NoError(t, ctrl.msIndexer.Update(machineSet))
+	_, err := ctrl.machineClient.MachineV1beta1().MachineSets(MachineAPINamespace).Update(context.TODO(), machineSet, v1.UpdateOptions{})
+	require.NoError(t, err)
+	require.NoError(t, ctrl.syncAll("test"))
+	assert.Equal(t, v1.ConditionFalse, ctrl.getCondition(t, BootImageConfigMapInvalidConditionType).Status)
+	assert.Equal(t, v1.ConditionFalse, ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateDegraded).Status)
+	for _, name := range []string{"machineset-a", "machineset-b"} {
+		assert.Equal(t, testGCPStreamImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, name)))
+	}
+}
+
+func TestDefaultConditionsOnlyAddedAfterSyncPass(t *testing.T) {
+	ctrl := newTestController(t, osconfigv1.GCPPlatformType, []*machinev1beta1.MachineSet{getGCPMachineSet("machineset-a", testGCPOldImage)}, nil)
+	hasCondition := func(t *testing.T, conditionType string) bool {
+		t.Helper()
+		return meta.FindStatusCondition(ctrl.getMachineConfiguration(t).Status.Conditions, conditionType) != nil
+	}
+	killSwitch := &corev1.ConfigMap{ObjectMeta: v1.ObjectMeta{Name: BootImageKillSwitchConfigMapName, Namespace: ctrlcommon.MCONamespace}}
+
+	t.Run("first write before a sync pass", func(t *testing.T) {
+		require.NoError(t, ctrl.cmIndexer.Add(killSwitch))
+		require.NoError(t, ctrl.syncAll("BootImageKillSwitchAdded"))
+
+		// Only the Halted condition is written; a Progressing=False default would read as a completed rollout
+		assert.Equal(t, v1.ConditionTrue, ctrl.getCondition(t, BootImageUpdateHaltedConditionType).Status)
+		assert.False(t, hasCondition(t, opv1.MachineConfigurationBootImageUpdateProgressing))
+		assert.False(t, hasCondition(t, opv1.MachineConfigurationBootImageUpdateDegraded))
+	})
+
+	t.Run("completed sync pass", func(t *testing.T) {
+		require.NoError(t, ctrl.cmIndexer.Delete(killSwitch))
+		require.NoError(t, ctrl.syncAll("BootImageKillSwitchDeleted"))
+
+		progressing := ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateProgressing)
+		assert.Equal(t, v1.ConditionFalse, progressing.Status)
+		assert.Contains(t, progressing.Message, "Reconciled 1 of 1 MAPI MachineSets")
+		assert.Equal(t, v1.ConditionFalse, ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateDegraded).Status)
+	})
+}
+
+func TestUpdateConditionsAddsMissingBootImageConditions(t *testing.T) {
+	ctrl := newTestController(t, osconfigv1.GCPPlatformType, nil, nil)
+	thirdPartyCondition := v1.Condition{Type: "ThirdPartyReady", Status: v1.ConditionTrue, Reason: "AllGood", LastTransitionTime: v1.NewTime(time.Unix(1700000000, 0))}
+	degradedCondition := v1.Condition{Type: opv1.MachineConfigurationBootImageUpdateDegraded, Status: v1.ConditionFalse, Reason: "NA", Message: "set before", LastTransitionTime: v1.NewTime(time.Unix(1700000000, 0))}
+
+	// Seed a partially initialized status, with a boot image condition but without Progressing
+	mcop, err := ctrl.mcopClient.OperatorV1().MachineConfigurations().Get(context.TODO(), ctrlcommon.MCOOperatorKnobsObjectName, v1.GetOptions{})
+	require.NoError(t, err)
+	mcop.Status.Conditions = []v1.Condition{thirdPartyCondition, degradedCondition}
+	_, err = ctrl.mcopClient.OperatorV1().MachineConfigurations().UpdateStatus(context.TODO(), mcop, v1.UpdateOptions{})
+	require.NoError(t, err)
+
+	// Once a sync pass has completed, updating another condition adds the missing Progressing condition
+	// from the defaults
+	ctrl.syncPassCompleted = true
+	ctrl.updateConditions("test", fmt.Errorf("injected failure"), opv1.MachineConfigurationBootImageUpdateDegraded)
+	progressing := ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateProgressing)
+	assert.Equal(t, v1.ConditionFalse, progressing.Status)
+	assert.Equal(t, "NA", progressing.Reason)
+	assert.False(t, progressing.LastTransitionTime.IsZero())
+	degraded := ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateDegraded)
+	assert.Equal(t, v1.ConditionTrue, degraded.Status)
+	assert.Contains(t, degraded.Message, "injected failure")
+	assert.Equal(t, thirdPartyCondition, ctrl.getCondition(t, "ThirdPartyReady"))
+
+	// The added condition is then updated like any other
+	ctrl.mapiStats = MachineResourceStats{totalCount: 2, inProgress: 1}
+	ctrl.updateConditions("test", nil, opv1.MachineConfigurationBootImageUpdateProgressing)
+	progressing = ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateProgressing)
+	assert.Equal(t, v1.ConditionTrue, progressing.Status)
+	assert.Contains(t, progressing.Message, "Reconciled 1 of 2 MAPI MachineSets")
+
+	// A condition outside of the defaults is added with a transition time the first time it is set
+	ctrl.setPassCondition(BootImageConfigMapInvalidConditionType, v1.ConditionFalse, "ConfigMapValid", "")
+	ctrl.writePassConditions()
+	invalid := ctrl.getCondition(t, BootImageConfigMapInvalidConditionType)
+	assert.Equal(t, v1.ConditionFalse, invalid.Status)
+	assert.False(t, invalid.LastTransitionTime.IsZero())
+}
@@ -0,0 +1,55 @@
+package bootimage
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// Path of the debug endpoint, served by the metrics listener, that returns the MAPI machinesets whose
+// boot image was updated by the last completed sync
+const BootImageLastChangesPath = "/debug/bootimage/last-changes"
+
+// machineSetChanges is the JSON document served on BootImageLastChangesPath.
+type machineSetChanges struct {
+	SyncedAt string `json:"syncedAt"`
+	Reason   string `json:"reason"`
+	// MachineSets holds the sorted names of the MAPI machinesets patched by the sync
+	MachineSets []string `json:"machineSets"`
+}
+
+// publishMachineSetChanges replaces the changes served on BootImageLastChangesPath with the machinesets
+// updated by the completed pass.
+func (ctrl *Controller) publishMachineSetChanges(reason string) {
+	changes := &machineSetChanges{SyncedAt: ctrl.clock.Now().UTC().Format(time.RFC3339), Reason: reason, MachineSets: []string{}}
+	changes.MachineSets = append(changes.MachineSets, ctrl.mapiChanged...)
+	slices.Sort(changes.MachineSets)
+	ctrl.publishedChangesLock.Lock()
+	defer ctrl.publishedChangesLock.Unlock()
+	ctrl.publishedChanges = changes
+}
+
+// LastChangesHandler returns the read-only handler for BootImageLastChangesPath. It responds with 404
+// until the controller has completed a pass.
+func (ctrl *Controller) LastChangesHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+			return
+		}
+		ctrl.publishedChangesLock.Lock()
+		changes := ctrl.publishedChanges
+		ctrl.publishedChangesLock.Unlock()
+		if changes == nil {
+			http.Error(w, "no MAPI machineset sync has completed yet", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(changes); err != nil {
+			klog.Errorf("Failed to write boot image changes: %v", err)
+		}
+	})
+}
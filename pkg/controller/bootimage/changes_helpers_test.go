@@ -0,0 +1,60 @@
+package bootimage
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	osconfigv1 "github.com/openshift/api/config/v1"
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLastChanges(t *testing.T) {
+	failing := getGCPMachineSet("machineset-failing", testGCPOldImage)
+	failing.Annotations[BootImageSecretRefAnnotationKey] = "missing-secret"
+	machineSets := []*machinev1beta1.MachineSet{
+		getGCPMachineSet("machineset-b", testGCPOldImage),
+		getGCPMachineSet("machineset-a", testGCPOldImage),
+		getGCPMachineSet("machineset-current", testGCPStreamImage),
+		failing,
+	}
+	ctrl := newTestController(t, osconfigv1.GCPPlatformType, machineSets, nil)
+	getChanges := func(t *testing.T, method string) (*httptest.ResponseRecorder, machineSetChanges) {
+		t.Helper()
+		recorder := httptest.NewRecorder()
+		ctrl.LastChangesHandler().ServeHTTP(recorder, httptest.NewRequest(method, BootImageLastChangesPath, nil))
+		changes := machineSetChanges{}
+		if recorder.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &changes))
+		}
+		return recorder, changes
+	}
+
+	// Nothing is served before a pass has completed
+	response, _ := getChanges(t, http.MethodGet)
+	assert.Equal(t, http.StatusNotFound, response.Code)
+
+	// Only the machinesets that were patched are listed, not those up to date or failing
+	require.NoError(t, ctrl.syncAll("first"))
+	response, changes := getChanges(t, http.MethodGet)
+	require.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "application/json", response.Header().Get("Content-Type"))
+	assert.Equal(t, "first", changes.Reason)
+	assert.NotEmpty(t, changes.SyncedAt)
+	assert.Equal(t, []string{"machineset-a", "machineset-b"}, changes.MachineSets)
+	response, _ = getChanges(t, http.MethodPost)
+	assert.Equal(t, http.StatusMethodNotAllowed, response.Code)
+
+	// The next pass replaces the changes, even when it changed nothing
+	for _, name := range changes.MachineSets {
+		require.NoError(t, ctrl.msIndexer.Update(ctrl.getMachineSet(t, name)))
+	}
+	require.NoError(t, ctrl.syncAll("second"))
+	_, changes = getChanges(t, http.MethodGet)
+	assert.Equal(t, "second", changes.Reason)
+	assert.Empty(t, changes.MachineSets)
+	assert.NotNil(t, changes.MachineSets)
+}
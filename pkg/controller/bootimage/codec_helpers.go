@@ -0,0 +1,104 @@
+package bootimage
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	osconfigv1 "github.com/openshift/api/config/v1"
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// Path of the debug endpoint, served by the metrics listener, that returns the providerspec apiVersion
+// each MAPI machineset was decoded from and re-encoded with during the last pass
+const BootImageProviderSpecCodecsPath = "/debug/bootimage/providerspec-codecs"
+
+// providerSpecCodec records how the providerspec of a MAPI machineset was handled by its last sync.
+type providerSpecCodec struct {
+	MachineSet string `json:"machineSet"`
+	Platform   string `json:"platform"`
+	// DecodedAPIVersion is the apiVersion declared by the providerspec; empty if it declares none
+	DecodedAPIVersion string `json:"decodedAPIVersion"`
+	// DecodedAs is the group, version and kind of the type the providerspec was decoded into; empty on
+	// platforms that are not natively supported, whose providerspecs are not decoded
+	DecodedAs string `json:"decodedAs"`
+	// EncodedAPIVersion is the apiVersion of the providerspec written back; empty if it was not re-encoded
+	EncodedAPIVersion string `json:"encodedAPIVersion"`
+}
+
+// providerSpecCodecs is the JSON document served on BootImageProviderSpecCodecsPath.
+type providerSpecCodecs struct {
+	SyncedAt string `json:"syncedAt"`
+	// MachineSets holds the codecs of the synced MAPI machinesets, sorted by name
+	MachineSets []providerSpecCodec `json:"machineSets"`
+}
+
+// getProviderSpecAPIVersion returns the apiVersion declared by the machineset's providerspec, or an
+// empty string if it declares none or cannot be read.
+func getProviderSpecAPIVersion(machineSet *machinev1beta1.MachineSet) string {
+	typeMeta := metav1.TypeMeta{}
+	if err := unmarshalProviderSpec(machineSet, &typeMeta); err != nil {
+		return ""
+	}
+	return typeMeta.APIVersion
+}
+
+// recordProviderSpecCodec records the providerspec apiVersion the machineset was decoded from, the type
+// it was decoded into, and, if the sync computed an update, the apiVersion it is re-encoded with.
+func (ctrl *Controller) recordProviderSpecCodec(infra *osconfigv1.Infrastructure, machineSet, newMachineSet *machinev1beta1.MachineSet) {
+	platform := infra.Status.PlatformStatus.Type
+	codec := providerSpecCodec{
+		MachineSet:        machineSet.Name,
+		Platform:          string(platform),
+		DecodedAPIVersion: getProviderSpecAPIVersion(machineSet),
+	}
+	if kind, ok := providerSpecKinds[platform]; ok {
+		codec.DecodedAs = machinev1beta1.GroupVersion.WithKind(kind).String()
+	}
+	if newMachineSet != nil {
+		codec.EncodedAPIVersion = getProviderSpecAPIVersion(newMachineSet)
+	}
+	klog.V(4).Infof("Providerspec of MAPI machineset %s on platform %s: decoded from apiVersion %q as %q, re-encoded with apiVersion %q",
+		machineSet.Name, codec.Platform, codec.DecodedAPIVersion, codec.DecodedAs, codec.EncodedAPIVersion)
+	ctrl.mapiProviderSpecCodecs[machineSet.Name] = codec
+}
+
+// publishProviderSpecCodecs replaces the codecs served on BootImageProviderSpecCodecsPath with those
+// recorded during the completed pass, and starts recording the next pass.
+func (ctrl *Controller) publishProviderSpecCodecs() {
+	codecs := &providerSpecCodecs{SyncedAt: time.Now().UTC().Format(time.RFC3339), MachineSets: []providerSpecCodec{}}
+	for _, codec := range ctrl.mapiProviderSpecCodecs {
+		codecs.MachineSets = append(codecs.MachineSets, codec)
+	}
+	slices.SortFunc(codecs.MachineSets, func(a, b providerSpecCodec) int { return strings.Compare(a.MachineSet, b.MachineSet) })
+	ctrl.mapiProviderSpecCodecs = map[string]providerSpecCodec{}
+	ctrl.publishedCodecsLock.Lock()
+	defer ctrl.publishedCodecsLock.Unlock()
+	ctrl.publishedCodecs = codecs
+}
+
+// ProviderSpecCodecsHandler returns the read-only handler for BootImageProviderSpecCodecsPath. It
+// responds with 404 until the controller has completed a pass.
+func (ctrl *Controller) ProviderSpecCodecsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+			return
+		}
+		ctrl.publishedCodecsLock.Lock()
+		codecs := ctrl.publishedCodecs
+		ctrl.publishedCodecsLock.Unlock()
+		if codecs == nil {
+			http.Error(w, "no MAPI machineset sync has completed yet", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(codecs); err != nil {
+			klog.Errorf("Failed to write boot image providerspec codecs: %v", err)
+		}
+	})
+}
@@ -0,0 +1,149 @@
+package bootimage
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	osconfigv1 "github.com/openshift/api/config/v1"
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	opv1 "github.com/openshift/api/operator/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestMixedProviderSpecAPIVersions(t *testing.T) {
+	// Returns a GCP machineset whose providerspec declares the given apiVersion
+	getMachineSetWithAPIVersion := func(t *testing.T, name, apiVersion string) *machinev1beta1.MachineSet {
+		t.Helper()
+		machineSet := getGCPMachineSet(name, testGCPOldImage)
+		providerSpec := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal(machineSet.Spec.Template.Spec.ProviderSpec.Value.Raw, &providerSpec))
+		providerSpec["apiVersion"] = apiVersion
+		providerSpec["kind"] = "GCPMachineProviderSpec"
+		raw, err := json.Marshal(providerSpec)
+		require.NoError(t, err)
+		machineSet.Spec.Template.Spec.ProviderSpec.Value.Raw = raw
+		return machineSet
+	}
+	getAPIVersion := func(t *testing.T, machineSet *machinev1beta1.MachineSet) string {
+		t.Helper()
+		typeMeta := v1.TypeMeta{}
+		require.NoError(t, unmarshalProviderSpec(machineSet, &typeMeta))
+		return typeMeta.APIVersion
+	}
+
+	cases := []struct {
+		name              string
+		knobs             map[string]string
+		expectedUpdated   []string
+		expectedErrorText string
+	}{
+		{
+			name:              "recognized apiVersions are reconciled and unrecognized ones errored",
+			expectedUpdated:   []string{"machineset-machine-api", "machineset-legacy"},
+			expectedErrorText: "machineset machineset-future: its providerspec apiVersion gcpprovider.openshift.io/v2 is not recognized on platform GCP, expected one of machine.openshift.io/v1beta1, gcpprovider.openshift.io/v1beta1",
+		},
+		{
+			name:            "extra apiVersions are accepted",
+			knobs:           map[string]string{ExtraProviderSpecAPIVersionsAnnotationKey: "gcpprovider.openshift.io/v2, example.com/v1"},
+			expectedUpdated: []string{"machineset-machine-api", "machineset-legacy", "machineset-future"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			apiVersions := map[string]string{
+				"machineset-machine-api": "machine.openshift.io/v1beta1",
+				"machineset-legacy":      "gcpprovider.openshift.io/v1beta1",
+				"machineset-future":      "gcpprovider.openshift.io/v2",
+			}
+			machineSets := []*machinev1beta1.MachineSet{}
+			for name, apiVersion := range apiVersions {
+				machineSets = append(machineSets, getMachineSetWithAPIVersion(t, name, apiVersion))
+			}
+			ctrl := newTestController(t, osconfigv1.GCPPlatformType, machineSets, nil)
+			ctrl.setKnobs(t, tc.knobs)
+
+			require.NoError(t, ctrl.syncAll("test"))
+
+			for name, apiVersion := range apiVersions {
+				machineSet := ctrl.getMachineSet(t, name)
+				// Each machineset keeps the apiVersion it declared
+				assert.Equal(t, apiVersion, getAPIVersion(t, machineSet), name)
+				if slices.Contains(tc.expectedUpdated, name) {
+					assert.Equal(t, testGCPStreamImage, getGCPMachineSetBootImage(t, machineSet), name)
+				} else {
+					assert.Equal(t, testGCPOldImage, getGCPMachineSetBootImage(t, machineSet), name)
+				}
+			}
+			assert.Equal(t, len(tc.expectedUpdated), ctrl.mapiStats.updatedCount)
+			degraded := ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateDegraded)
+			if tc.expectedErrorText != "" {
+				assert.Equal(t, 1, ctrl.mapiStats.erroredCount)
+				assert.Contains(t, degraded.Message, tc.expectedErrorText)
+			} else {
+				assert.Equal(t, v1.ConditionFalse, degraded.Status)
+			}
+		})
+	}
+}
+
+func TestProviderSpecCodecsEndpoint(t *testing.T) {
+	apiVersions := map[string]string{
+		"machineset-machine-api": "machine.openshift.io/v1beta1",
+		"machineset-legacy":      "gcpprovider.openshift.io/v1beta1",
+		"machineset-undeclared":  "",
+	}
+	machineSets := []*machinev1beta1.MachineSet{}
+	for name, apiVersion := range apiVersions {
+		machineSet := getGCPMachineSet(name, testGCPOldImage)
+		providerSpec := new(machinev1beta1.GCPMachineProviderSpec)
+		require.NoError(t, unmarshalProviderSpec(machineSet, providerSpec))
+		providerSpec.APIVersion = apiVersion
+		require.NoError(t, marshalProviderSpec(machineSet, providerSpec))
+		machineSets = append(machineSets, machineSet)
+	}
+	// An up to date machineset is decoded, but not re-encoded
+	machineSets = append(machineSets, getGCPMachineSet("machineset-up-to-date", testGCPStreamImage))
+	apiVersions["machineset-up-to-date"] = ""
+	ctrl := newTestController(t, osconfigv1.GCPPlatformType, machineSets, nil)
+	getCodecs := func(t *testing.T, method string) (*httptest.ResponseRecorder, providerSpecCodecs) {
+		t.Helper()
+		recorder := httptest.NewRecorder()
+		ctrl.ProviderSpecCodecsHandler().ServeHTTP(recorder, httptest.NewRequest(method, BootImageProviderSpecCodecsPath, nil))
+		codecs := providerSpecCodecs{}
+		if recorder.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &codecs))
+		}
+		return recorder, codecs
+	}
+
+	// Nothing is served before a pass has completed
+	response, _ := getCodecs(t, http.MethodGet)
+	assert.Equal(t, http.StatusNotFound, response.Code)
+
+	require.NoError(t, ctrl.syncAll("test"))
+	response, codecs := getCodecs(t, http.MethodGet)
+	require.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "application/json", response.Header().Get("Content-Type"))
+	require.Len(t, codecs.MachineSets, len(apiVersions))
+	for _, codec := range codecs.MachineSets {
+		assert.Equal(t, apiVersions[codec.MachineSet], codec.DecodedAPIVersion, codec.MachineSet)
+		assert.Equal(t, "GCP", codec.Platform, codec.MachineSet)
+		assert.Equal(t, "machine.openshift.io/v1beta1, Kind=GCPMachineProviderSpec", codec.DecodedAs, codec.MachineSet)
+		if codec.MachineSet == "machineset-up-to-date" {
+			assert.Empty(t, codec.EncodedAPIVersion)
+			continue
+		}
+		// Updated providerspecs keep their apiVersion
+		assert.Equal(t, codec.DecodedAPIVersion, codec.EncodedAPIVersion, codec.MachineSet)
+		assert.Equal(t, codec.DecodedAPIVersion, getProviderSpecAPIVersion(ctrl.getMachineSet(t, codec.MachineSet)), codec.MachineSet)
+	}
+	assert.True(t, slices.IsSortedFunc(codecs.MachineSets, func(a, b providerSpecCodec) int { return strings.Compare(a.MachineSet, b.MachineSet) }))
+	response, _ = getCodecs(t, http.MethodPost)
+	assert.Equal(t, http.StatusMethodNotAllowed, response.Code)
+}
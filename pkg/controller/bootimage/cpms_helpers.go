@@ -14,13 +14,14 @@ import (
 	machinev1 "github.com/openshift/api/machine/v1"
 	opv1 "github.com/openshift/api/operator/v1"
 	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/version"
 	"sigs.k8s.io/yaml"
 
 	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	kruntime "k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
-	kubeErrs "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/apimachinery/pkg/util/jsonmergepatch"
 	clientset "k8s.io/client-go/kubernetes"
 	"k8s.io/klog/v2"
@@ -35,6 +36,7 @@ func (ctrl *Controller) syncControlPlaneMachineSets(reason string) {
 	// Check if CPMS feature gate is enabled
 	if !ctrl.fgHandler.Enabled(features.FeatureGateManagedBootImagesCPMS) {
 		klog.V(4).Infof("ManagedBootImagesCPMS feature gate is not enabled, skipping CPMS sync")
+		ctrl.cpmsSyncErrors = nil
 		return
 	}
 
@@ -42,14 +44,16 @@ func (ctrl *Controller) syncControlPlaneMachineSets(reason string) {
 	mcop, err := ctrl.mcopLister.Get(ctrlcommon.MCOOperatorKnobsObjectName)
 	if err != nil {
 		klog.Errorf("Failed to get MachineConfiguration: %v", err)
-		ctrl.updateConditions(reason, fmt.Errorf("failed to get MachineConfiguration while enqueueing ControlPlaneMachineSet: %w", err), opv1.MachineConfigurationBootImageUpdateDegraded)
+		ctrl.cpmsSyncErrors = []error{fmt.Errorf("failed to get MachineConfiguration while enqueueing ControlPlaneMachineSet: %w", err)}
+		ctrl.updateConditions(reason, ctrl.aggregateSyncErrors(), opv1.MachineConfigurationBootImageUpdateDegraded)
 		return
 	}
 
 	machineManagerFound, machineResourceSelector, err := getMachineResourceSelectorFromMachineManagers(mcop.Status.ManagedBootImagesStatus.MachineManagers, opv1.MachineAPI, opv1.ControlPlaneMachineSets)
 	if err != nil {
 		klog.Errorf("failed to create a machineset selector while enqueueing controlplanemachineset %v", err)
-		ctrl.updateConditions(reason, fmt.Errorf("failed to create a machineset selector while enqueueing ControlPlaneMachineSet %w", err), opv1.MachineConfigurationBootImageUpdateDegraded)
+		ctrl.cpmsSyncErrors = []error{fmt.Errorf("failed to create a machineset selector while enqueueing ControlPlaneMachineSet %w", err)}
+		ctrl.updateConditions(reason, ctrl.aggregateSyncErrors(), opv1.MachineConfigurationBootImageUpdateDegraded)
 		return
 	}
 	if !machineManagerFound {
@@ -58,12 +62,14 @@ func (ctrl *Controller) syncControlPlaneMachineSets(reason string) {
 		for k := range ctrl.cpmsBootImageState {
 			delete(ctrl.cpmsBootImageState, k)
 		}
+		ctrl.updateHotLoopStateMetrics()
 	}
 
 	controlPlaneMachineSets, err := ctrl.cpmsLister.List(machineResourceSelector)
 	if err != nil {
 		klog.Errorf("failed to fetch ControlPlaneMachineSet list while enqueueing ControlPlaneMachineSet %v", err)
-		ctrl.updateConditions(reason, fmt.Errorf("failed to fetch ControlPlaneMachineSet list while enqueueing ControlPlaneMachineSet %w", err), opv1.MachineConfigurationBootImageUpdateDegraded)
+		ctrl.cpmsSyncErrors = []error{fmt.Errorf("failed to fetch ControlPlaneMachineSet list while enqueueing ControlPlaneMachineSet %w", err)}
+		ctrl.updateConditions(reason, ctrl.aggregateSyncErrors(), opv1.MachineConfigurationBootImageUpdateDegraded)
 		return
 	}
 
@@ -74,12 +80,17 @@ func (ctrl *Controller) syncControlPlaneMachineSets(reason string) {
 		for k := range ctrl.cpmsBootImageState {
 			delete(ctrl.cpmsBootImageState, k)
 		}
+		ctrl.updateHotLoopStateMetrics()
 	}
 
 	// Reset stats before initiating reconciliation loop
 	ctrl.cpmsStats.inProgress = 0
 	ctrl.cpmsStats.totalCount = len(controlPlaneMachineSets)
 	ctrl.cpmsStats.erroredCount = 0
+	ctrl.cpmsStats.outOfDateCount = 0
+	ctrl.cpmsStats.updatedCount = 0
+	ctrl.cpmsStats.deferredCount = 0
+	ctrl.cpmsUpdatesHeld = false
 
 	// Signal start of reconciliation process, by setting progressing to true
 	var syncErrors []error
@@ -97,8 +108,10 @@ func (ctrl *Controller) syncControlPlaneMachineSets(reason string) {
 		// Update progressing conditions every step of the loop
 		ctrl.updateConditions(reason, nil, opv1.MachineConfigurationBootImageUpdateProgressing)
 	}
-	// Update/Clear degrade conditions based on errors from this loop
-	ctrl.updateConditions(reason, kubeErrs.NewAggregate(syncErrors), opv1.MachineConfigurationBootImageUpdateDegraded)
+	// Update/Clear degrade conditions based on errors from this loop, along with those of
+	// the other machine resource types
+	ctrl.cpmsSyncErrors = syncErrors
+	ctrl.updateConditions(ctrl.getDegradedReason(reason), ctrl.aggregateSyncErrors(), opv1.MachineConfigurationBootImageUpdateDegraded)
 }
 
 // syncControlPlaneMachineSet will attempt to reconcile the provided ControlPlaneMachineSet
@@ -149,20 +162,43 @@ func (ctrl *Controller) syncControlPlaneMachineSet(controlPlaneMachineSet *machi
 		return fmt.Errorf("failed to fetch coreos-bootimages config map during ControlPlaneMachineSet sync: %w", err)
 	}
 
+	// In advisory-only mode, evaluate without a client so that no writes take place
+	secretClient := ctrl.kubeClient
+	if ctrl.knobs.advisoryOnly {
+		secretClient = nil
+	}
+
 	// Check if the this ControlPlaneMachineSet requires an update
-	patchRequired, newControlPlaneMachineSet, err := checkControlPlaneMachineSet(infra, controlPlaneMachineSet, configMap, arch, ctrl.kubeClient)
+	patchRequired, newControlPlaneMachineSet, err := checkControlPlaneMachineSet(infra, controlPlaneMachineSet, configMap, ctrl.streamConfigMapKey, arch, secretClient)
 	if err != nil {
 		return fmt.Errorf("failed to reconcile ControlPlaneMachineSet %s, err: %w", controlPlaneMachineSet.Name, err)
 	}
 
+	if patchRequired && ctrl.knobs.advisoryOnly {
+		klog.Infof("Advisory-only mode, ControlPlaneMachineSet %s is out of date but will not be patched", controlPlaneMachineSet.Name)
+		ctrl.cpmsStats.outOfDateCount++
+		return nil
+	}
+
 	// Patch the machineset if required
 	if patchRequired {
 		// First, check if we're hot looping
 		if ctrl.checkControlPlaneMachineSetHotLoop(newControlPlaneMachineSet) {
-			return fmt.Errorf("refusing to reconcile ControlPlaneMachineSet %s, hot loop detected. Please opt-out of boot image updates, adjust your machine provisioning workflow to prevent hot loops and opt back in to resume boot image updates", controlPlaneMachineSet.Name)
+			return newHotLoopError("ControlPlaneMachineSet", controlPlaneMachineSet.Name)
 		}
 		klog.Infof("Patching ControlPlaneMachineSet %s", controlPlaneMachineSet.Name)
-		return ctrl.patchControlPlaneMachineSet(controlPlaneMachineSet, newControlPlaneMachineSet)
+		metav1.SetMetaDataAnnotation(&newControlPlaneMachineSet.ObjectMeta, ctrl.annotationKey(BootImageUpdatedByVersionAnnotationKey), version.Hash)
+		if err := ctrl.patchControlPlaneMachineSet(controlPlaneMachineSet, newControlPlaneMachineSet); err != nil {
+			if k8serrors.IsConflict(err) {
+				klog.Infof("ControlPlaneMachineSet %s was modified concurrently, deferring its boot image update", controlPlaneMachineSet.Name)
+				ctrl.cpmsStats.deferredCount++
+				ctrl.cpmsUpdatesHeld = true
+				return nil
+			}
+			return err
+		}
+		ctrl.cpmsStats.updatedCount++
+		return nil
 	}
 	klog.Infof("No patching required for ControlPlaneMachineSet %s", controlPlaneMachineSet.Name)
 	return nil
@@ -177,6 +213,7 @@ func (ctrl *Controller) checkControlPlaneMachineSetHotLoop(machineSet *machinev1
 			value:        machineSet.Spec.Template.OpenShiftMachineV1Beta1Machine.Spec.ProviderSpec.Value.Raw,
 			hotLoopCount: 1,
 		}
+		ctrl.updateHotLoopStateMetrics()
 	} else {
 		hotLoopCount := 1
 		// If the controller is updating to a value that was previously updated to, increase the hot loop counter
@@ -191,6 +228,7 @@ func (ctrl *Controller) checkControlPlaneMachineSetHotLoop(machineSet *machinev1
 			value:        machineSet.Spec.Template.OpenShiftMachineV1Beta1Machine.Spec.ProviderSpec.Value.Raw,
 			hotLoopCount: hotLoopCount,
 		}
+		ctrl.updateHotLoopStateMetrics()
 	}
 	return false
 }
@@ -210,7 +248,15 @@ func (ctrl *Controller) patchControlPlaneMachineSet(oldControlPlaneMachineSet, n
 	if err != nil {
 		return fmt.Errorf("unable to create patch for new ControlPlaneMachineSet: %w", err)
 	}
+	// As with MAPI machinesets, the patch is guarded by the resource version it was computed against
+	patchBytes, err = setPatchResourceVersion(patchBytes, oldControlPlaneMachineSet.ResourceVersion)
+	if err != nil {
+		return err
+	}
 	_, err = ctrl.machineClient.MachineV1().ControlPlaneMachineSets(MachineAPINamespace).Patch(context.TODO(), oldControlPlaneMachineSet.Name, types.MergePatchType, patchBytes, metav1.PatchOptions{})
+	if k8serrors.IsConflict(err) {
+		ctrlcommon.MCCBootImagePatchConflicts.WithLabelValues("controlplanemachineset").Inc()
+	}
 	if err != nil {
 		return fmt.Errorf("unable to patch new ControlPlaneMachineSet: %w", err)
 	}
@@ -221,14 +267,14 @@ func (ctrl *Controller) patchControlPlaneMachineSet(oldControlPlaneMachineSet, n
 // This function calls the appropriate reconcile function based on the infra type
 // On success, it will return a bool indicating if a patch is required, and an updated
 // machineset object if any. It will return an error if any of the above steps fail.
-func checkControlPlaneMachineSet(infra *osconfigv1.Infrastructure, machineSet *machinev1.ControlPlaneMachineSet, configMap *corev1.ConfigMap, arch string, secretClient clientset.Interface) (bool, *machinev1.ControlPlaneMachineSet, error) {
+func checkControlPlaneMachineSet(infra *osconfigv1.Infrastructure, machineSet *machinev1.ControlPlaneMachineSet, configMap *corev1.ConfigMap, streamKey, arch string, secretClient clientset.Interface) (bool, *machinev1.ControlPlaneMachineSet, error) {
 	switch infra.Status.PlatformStatus.Type {
 	case osconfigv1.AWSPlatformType:
-		return reconcilePlatformCPMS(machineSet, infra, configMap, arch, secretClient, reconcileAWSProviderSpec)
+		return reconcilePlatformCPMS(machineSet, infra, configMap, streamKey, arch, secretClient, reconcileAWSProviderSpec)
 	case osconfigv1.AzurePlatformType:
-		return reconcilePlatformCPMS(machineSet, infra, configMap, arch, secretClient, reconcileAzureProviderSpec)
+		return reconcilePlatformCPMS(machineSet, infra, configMap, streamKey, arch, secretClient, reconcileAzureProviderSpec)
 	case osconfigv1.GCPPlatformType:
-		return reconcilePlatformCPMS(machineSet, infra, configMap, arch, secretClient, reconcileGCPProviderSpec)
+		return reconcilePlatformCPMS(machineSet, infra, configMap, streamKey, arch, secretClient, reconcileGCPProviderSpec)
 	// TODO: vsphere CPMS template seems to be empty in CI runs, and will need further investigation
 	default:
 		klog.Infof("Skipping controlplanemachineset %s, unsupported platform %s", machineSet.Name, infra.Status.PlatformStatus.Type)
@@ -242,6 +288,7 @@ func reconcilePlatformCPMS[T any](
 	cpms *machinev1.ControlPlaneMachineSet,
 	infra *osconfigv1.Infrastructure,
 	configMap *corev1.ConfigMap,
+	streamKey string,
 	arch string,
 	secretClient clientset.Interface,
 	reconcileProviderSpec func(*stream.Stream, string, *osconfigv1.Infrastructure, *T, string, clientset.Interface) (bool, bool, *T, error),
@@ -256,9 +303,12 @@ func reconcilePlatformCPMS[T any](
 
 	// Unmarshal the configmap into a stream object
 	streamData := new(stream.Stream)
-	if err := unmarshalStreamDataConfigMap(configMap, streamData); err != nil {
+	if err := unmarshalStreamDataConfigMap(configMap, streamKey, streamData); err != nil {
 		return false, nil, err
 	}
+	if _, ok := streamData.Architectures[arch]; !ok {
+		return false, nil, fmt.Errorf("architecture %s not found in stream data", arch)
+	}
 
 	// Reconcile the provider spec
 	patchRequired, _, newProviderSpec, err := reconcileProviderSpec(streamData, arch, infra, providerSpec, cpms.Name, secretClient)
@@ -271,11 +321,14 @@ func reconcilePlatformCPMS[T any](
 		return false, nil, nil
 	}
 
-	// If patch is required, marshal the new providerspec into the controlplanemachineset
+	// If patch is required, merge only the changed fields into the controlplanemachineset's providerspec so
+	// that fields unknown to the typed providerspec are preserved
 	newCPMS = cpms.DeepCopy()
-	if err := marshalProviderSpecCPMS(newCPMS, newProviderSpec); err != nil {
+	mergedProviderSpec, err := mergeProviderSpecChanges(cpms.Spec.Template.OpenShiftMachineV1Beta1Machine.Spec.ProviderSpec.Value.Raw, providerSpec, newProviderSpec)
+	if err != nil {
 		return false, nil, err
 	}
+	newCPMS.Spec.Template.OpenShiftMachineV1Beta1Machine.Spec.ProviderSpec.Value = mergedProviderSpec
 	return patchRequired, newCPMS, nil
 }
 
@@ -0,0 +1,78 @@
+package bootimage
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	osconfigv1 "github.com/openshift/api/config/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// Timeout for a single reachability probe of an image resolution dependency
+	dependencyDialTimeout = 5 * time.Second
+
+	// Port used to reach vCenter when the infrastructure object does not specify one
+	defaultVCenterPort = 443
+)
+
+// dependencyCheckBackoff is the retry schedule used when probing image resolution dependencies,
+// so that a brief network blip does not fail the whole sync.
+var dependencyCheckBackoff = wait.Backoff{
+	Steps:    3,
+	Duration: 1 * time.Second,
+	Factor:   2.0,
+	Jitter:   0.1,
+}
+
+// dialFunc opens a network connection; it matches the signature of net.DialTimeout.
+type dialFunc func(network, address string, timeout time.Duration) (net.Conn, error)
+
+// getImageResolutionEndpoints returns the network endpoints that must be reachable for boot image
+// resolution on this platform. Platforms that resolve images solely from the boot images configmap
+// return no endpoints.
+func getImageResolutionEndpoints(infra *osconfigv1.Infrastructure) []string {
+	if infra.Status.PlatformStatus == nil || infra.Status.PlatformStatus.Type != osconfigv1.VSpherePlatformType {
+		return nil
+	}
+	if infra.Spec.PlatformSpec.VSphere == nil {
+		return nil
+	}
+	endpoints := []string{}
+	for _, vcenter := range infra.Spec.PlatformSpec.VSphere.VCenters {
+		port := int(vcenter.Port)
+		if port == 0 {
+			port = defaultVCenterPort
+		}
+		endpoints = append(endpoints, net.JoinHostPort(vcenter.Server, strconv.Itoa(port)))
+	}
+	return endpoints
+}
+
+// checkImageResolutionDependency confirms that every endpoint needed for boot image resolution is
+// reachable, retrying with a short backoff. An error is returned if any endpoint remains unreachable.
+// Retries sleep on the controller's clock and are jittered with the controller's jitter function, so
+// that tests can run the backoff without sleeping.
+func (ctrl *Controller) checkImageResolutionDependency(infra *osconfigv1.Infrastructure) error {
+	for _, endpoint := range getImageResolutionEndpoints(infra) {
+		backoff := dependencyCheckBackoff
+		for {
+			conn, err := ctrl.dial("tcp", endpoint, dependencyDialTimeout)
+			if err == nil {
+				conn.Close()
+				break
+			}
+			backoff.Steps--
+			if backoff.Steps <= 0 {
+				return fmt.Errorf("image resolution dependency %s is not reachable: %w", endpoint, err)
+			}
+			klog.V(4).Infof("Image resolution dependency %s is not reachable, retrying: %v", endpoint, err)
+			ctrl.clock.Sleep(ctrl.jitter(backoff.Duration, backoff.Jitter))
+			backoff.Duration = time.Duration(float64(backoff.Duration) * backoff.Factor)
+		}
+	}
+	return nil
+}
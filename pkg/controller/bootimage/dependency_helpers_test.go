@@ -0,0 +1,115 @@
+package bootimage
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	osconfigv1 "github.com/openshift/api/config/v1"
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	opv1 "github.com/openshift/api/operator/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+// fixedSource is a rand.Source that always yields the same value, making jitter deterministic.
+type fixedSource int64
+
+func (s fixedSource) Int63() int64 { return int64(s) }
+
+func (s fixedSource) Seed(int64) {}
+
+func TestImageResolutionDependencyCheck(t *testing.T) {
+	cases := []struct {
+		name            string
+		failedDials     int
+		expectError     bool
+		expectDialCount int
+		// Expected time slept between retries, with every retry jittered by half of the maximum
+		expectBackoff time.Duration
+	}{
+		{
+			name:            "reachable dependency",
+			failedDials:     0,
+			expectError:     false,
+			expectDialCount: 1,
+			expectBackoff:   0,
+		},
+		{
+			name:            "brief network blip is retried",
+			failedDials:     2,
+			expectError:     false,
+			expectDialCount: 3,
+			expectBackoff:   1050*time.Millisecond + 2100*time.Millisecond,
+		},
+		{
+			name:            "unreachable dependency degrades and backs off",
+			failedDials:     5,
+			expectError:     true,
+			expectDialCount: 3,
+			expectBackoff:   1050*time.Millisecond + 2100*time.Millisecond,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			// A machineset is only enrolled when the check is expected to fail, as syncing it
+			// would otherwise require a real vCenter
+			ms := getGCPMachineSet("test-machineset", testGCPOldImage)
+			machineSets := []*machinev1beta1.MachineSet{}
+			if tc.expectError {
+				machineSets = append(machineSets, ms)
+			}
+			ctrl := newTestController(t, osconfigv1.VSpherePlatformType, machineSets, nil)
+			require.NoError(t, ctrl.infraIndexer.Update(&osconfigv1.Infrastructure{
+				ObjectMeta: v1.ObjectMeta{Name: "cluster"},
+				Spec: osconfigv1.InfrastructureSpec{
+					PlatformSpec: osconfigv1.PlatformSpec{
+						VSphere: &osconfigv1.VSpherePlatformSpec{
+							VCenters: []osconfigv1.VSpherePlatformVCenterSpec{{Server: "vcenter.example.com"}},
+						},
+					},
+				},
+				Status: osconfigv1.InfrastructureStatus{
+					PlatformStatus: &osconfigv1.PlatformStatus{Type: osconfigv1.VSpherePlatformType},
+				},
+			}))
+			// A fake clock lets the retries run without sleeping, and a fixed random source
+			// yields a jitter factor of one half
+			start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+			fakeClock := clocktesting.NewFakeClock(start)
+			for _, opt := range []Option{WithClock(fakeClock), WithRandSource(fixedSource(1 << 62))} {
+				opt(ctrl.Controller)
+			}
+			dialCount := 0
+			ctrl.dial = func(_, address string, _ time.Duration) (net.Conn, error) {
+				dialCount++
+				assert.Equal(t, "vcenter.example.com:443", address)
+				if dialCount <= tc.failedDials {
+					return nil, fmt.Errorf("connection refused")
+				}
+				client, server := net.Pipe()
+				server.Close()
+				return client, nil
+			}
+
+			err := ctrl.syncAll("test")
+			assert.Equal(t, tc.expectDialCount, dialCount)
+			assert.Equal(t, tc.expectBackoff, fakeClock.Since(start))
+			degraded := ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateDegraded)
+			if tc.expectError {
+				require.Error(t, err)
+				assert.Equal(t, v1.ConditionTrue, degraded.Status)
+				assert.Contains(t, degraded.Message, "image resolution dependency vcenter.example.com:443 is not reachable")
+				assert.NotContains(t, degraded.Message, ms.Name)
+				assert.Equal(t, 0, ctrl.countMachineSetPatches())
+			} else {
+				require.NoError(t, err)
+				assert.NotContains(t, degraded.Message, "not reachable")
+			}
+		})
+	}
+}
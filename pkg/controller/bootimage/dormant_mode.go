@@ -0,0 +1,49 @@
+package bootimage
+
+import (
+	"slices"
+	"time"
+
+	opv1 "github.com/openshift/api/operator/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// Interval at which the controller checks whether the cluster serves the MachineConfiguration API
+const machineConfigurationAPICheckInterval = 5 * time.Minute
+
+// isMachineConfigurationAPIServed uses discovery to check whether the cluster serves the
+// MachineConfiguration API, which the controller reports its conditions on.
+func (ctrl *Controller) isMachineConfigurationAPIServed() (bool, error) {
+	resources, err := ctrl.kubeClient.Discovery().ServerResourcesForGroupVersion(opv1.GroupVersion.String())
+	if k8serrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return slices.ContainsFunc(resources.APIResources, func(resource metav1.APIResource) bool {
+		return resource.Name == "machineconfigurations"
+	}), nil
+}
+
+// checkMachineConfigurationAPI puts the controller in dormant mode while the MachineConfiguration API is
+// not served, as no conditions can be reported without it, and resumes with a full resync once it is
+// installed. Only the transitions are logged, so that a dormant controller does not log continuously.
+func (ctrl *Controller) checkMachineConfigurationAPI() {
+	served, err := ctrl.isMachineConfigurationAPIServed()
+	if err != nil {
+		klog.Warningf("Failed to check whether the MachineConfiguration API is served, keeping the current mode: %v", err)
+		return
+	}
+	if wasDormant := ctrl.dormant.Swap(!served); wasDormant == !served {
+		return
+	}
+	if !served {
+		klog.Warningf("The %s MachineConfiguration API is not served, the boot image controller is dormant and checks again every %v", opv1.GroupVersion, machineConfigurationAPICheckInterval)
+		return
+	}
+	klog.Infof("The %s MachineConfiguration API is now served, resuming boot image updates", opv1.GroupVersion)
+	ctrl.enqueueEvent("MachineConfigurationAPIServed")
+}
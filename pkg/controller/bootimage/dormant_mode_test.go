@@ -0,0 +1,44 @@
+package bootimage
+
+import (
+	"testing"
+
+	osconfigv1 "github.com/openshift/api/config/v1"
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMachineConfigurationAPIAbsent(t *testing.T) {
+	ctrl := newTestController(t, osconfigv1.GCPPlatformType, []*machinev1beta1.MachineSet{getGCPMachineSet("machineset-a", testGCPOldImage)}, nil)
+	countStatusUpdates := func() int {
+		count := 0
+		for _, action := range ctrl.mcopClient.Actions() {
+			if action.GetVerb() == "update" && action.GetSubresource() == "status" {
+				count++
+			}
+		}
+		return count
+	}
+
+	// Without the API, the controller is dormant and neither updates conditions nor machine resources
+	ctrl.checkMachineConfigurationAPI()
+	assert.True(t, ctrl.dormant.Load())
+	require.NoError(t, ctrl.syncAll("test"))
+	assert.Equal(t, 0, countStatusUpdates())
+	assert.Equal(t, 0, ctrl.countMachineSetPatches())
+
+	// Re-checking without the API changes nothing
+	ctrl.checkMachineConfigurationAPI()
+	assert.True(t, ctrl.dormant.Load())
+	assert.Equal(t, 0, ctrl.queue.Len())
+
+	// Once the API is installed, the controller resumes with a resync
+	ctrl.serveMachineConfigurationAPI()
+	ctrl.checkMachineConfigurationAPI()
+	assert.False(t, ctrl.dormant.Load())
+	assert.Equal(t, 1, ctrl.queue.Len())
+	require.NoError(t, ctrl.syncAll("test"))
+	assert.Positive(t, countStatusUpdates())
+	assert.Equal(t, testGCPStreamImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, "machineset-a")))
+}
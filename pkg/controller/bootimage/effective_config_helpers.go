@@ -0,0 +1,51 @@
+package bootimage
+
+import (
+	"net/http"
+
+	"k8s.io/klog/v2"
+)
+
+// Path of the debug endpoint, served by the metrics listener, that returns the boot image knobs in
+// effect for the last sync, so that users can confirm their settings were picked up
+const BootImageEffectiveConfigPath = "/debug/bootimage/effective-config"
+
+// publishEffectiveConfig replaces the document served on BootImageEffectiveConfigPath with the knobs in
+// effect for this sync. It is only logged when it changes. This is informational, so a failure is
+// logged rather than failing the sync.
+func (ctrl *Controller) publishEffectiveConfig() {
+	config, err := ctrl.knobs.effectiveConfig(ctrl.streamConfigMapKey)
+	if err != nil {
+		klog.Errorf("Failed to marshal effective boot image configuration: %v", err)
+		return
+	}
+	ctrl.publishedEffectiveConfigLock.Lock()
+	defer ctrl.publishedEffectiveConfigLock.Unlock()
+	if ctrl.publishedEffectiveConfig == config {
+		return
+	}
+	ctrl.publishedEffectiveConfig = config
+	klog.Infof("Effective boot image configuration: %s", config)
+}
+
+// EffectiveConfigHandler returns the read-only handler for BootImageEffectiveConfigPath. It responds with
+// 404 until the controller has started a sync.
+func (ctrl *Controller) EffectiveConfigHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+			return
+		}
+		ctrl.publishedEffectiveConfigLock.Lock()
+		config := ctrl.publishedEffectiveConfig
+		ctrl.publishedEffectiveConfigLock.Unlock()
+		if config == "" {
+			http.Error(w, "no boot image sync has started yet", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := w.Write([]byte(config)); err != nil {
+			klog.Errorf("Failed to write effective boot image configuration: %v", err)
+		}
+	})
+}
@@ -0,0 +1,67 @@
+package bootimage
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	osconfigv1 "github.com/openshift/api/config/v1"
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEffectiveConfigPublished(t *testing.T) {
+	getEffectiveConfig := func(t *testing.T, ctrl *testController) map[string]interface{} {
+		t.Helper()
+		recorder := httptest.NewRecorder()
+		ctrl.EffectiveConfigHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, BootImageEffectiveConfigPath, nil))
+		require.Equal(t, http.StatusOK, recorder.Code)
+		config := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &config))
+		return config
+	}
+
+	ctrl := newTestController(t, osconfigv1.GCPPlatformType, []*machinev1beta1.MachineSet{getGCPMachineSet("machineset-a", testGCPStreamImage)}, nil)
+
+	// Nothing is served before a sync has started
+	recorder := httptest.NewRecorder()
+	ctrl.EffectiveConfigHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, BootImageEffectiveConfigPath, nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+
+	// Defaults are reported when no knobs are set
+	require.NoError(t, ctrl.syncAll("test"))
+	config := getEffectiveConfig(t, ctrl)
+	assert.Equal(t, StreamConfigMapKey, config["streamConfigMapKey"])
+	assert.Equal(t, false, config["advisoryOnly"])
+	assert.Equal(t, []interface{}{}, config["zones"])
+	assert.Equal(t, float64(0), config["reconcileBudgetPercent"])
+
+	// Valid knobs are reflected, and malformed ones fall back to their defaults
+	ctrl.setKnobs(t, map[string]string{
+		AdvisoryOnlyAnnotationKey:            "true",
+		ZonesAnnotationKey:                   "us-central1-a, us-central1-b",
+		PausedPlatformsAnnotationKey:         "AWS,AWS",
+		ReconcileBudgetPercentAnnotationKey:  "250",
+		MaxInFlightReplacementsAnnotationKey: "2",
+	})
+	ctrl.mcopClient.ClearActions()
+	require.NoError(t, ctrl.syncAll("test"))
+	config = getEffectiveConfig(t, ctrl)
+	assert.Equal(t, true, config["advisoryOnly"])
+	assert.Equal(t, []interface{}{"us-central1-a", "us-central1-b"}, config["zones"])
+	assert.Equal(t, []interface{}{"AWS"}, config["pausedPlatforms"])
+	assert.Equal(t, float64(0), config["reconcileBudgetPercent"])
+	assert.Equal(t, float64(2), config["maxInFlightReplacements"])
+
+	// Publishing does not write to the user-owned MachineConfiguration object
+	for _, action := range ctrl.mcopClient.Actions() {
+		assert.NotEqual(t, "patch", action.GetVerb(), "effective config should not be written to the MachineConfiguration")
+	}
+
+	// Only GET is supported
+	recorder = httptest.NewRecorder()
+	ctrl.EffectiveConfigHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, BootImageEffectiveConfigPath, nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+}
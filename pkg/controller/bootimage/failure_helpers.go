@@ -0,0 +1,104 @@
+package bootimage
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// Path of the debug endpoint, served by the metrics listener, that returns the MAPI machinesets whose
+// last sync failed, along with when they started and last failed
+const BootImageFailingMachineSetsPath = "/debug/bootimage/failing-machinesets"
+
+// machineSetFailures tracks the consecutive failed syncs of a MAPI machineset. It is forgotten once the
+// machineset syncs successfully.
+type machineSetFailures struct {
+	consecutiveFailures int
+	firstErrorTime      time.Time
+	lastErrorTime       time.Time
+	lastError           string
+}
+
+// failingMachineSet describes a failing MAPI machineset in the document served on
+// BootImageFailingMachineSetsPath.
+type failingMachineSet struct {
+	MachineSet          string `json:"machineSet"`
+	ConsecutiveFailures int    `json:"consecutiveFailures"`
+	FirstErrorTime      string `json:"firstErrorTime"`
+	LastErrorTime       string `json:"lastErrorTime"`
+	// FailingFor is the time between the first and the last failed sync
+	FailingFor string `json:"failingFor"`
+	LastError  string `json:"lastError"`
+}
+
+// failingMachineSets is the JSON document served on BootImageFailingMachineSetsPath.
+type failingMachineSets struct {
+	SyncedAt string `json:"syncedAt"`
+	// MachineSets holds the failing MAPI machinesets, sorted by name
+	MachineSets []failingMachineSet `json:"machineSets"`
+}
+
+// recordMachineSetSyncResult updates the failures tracked for a MAPI machineset with the result of its
+// sync, and returns its number of consecutive failed syncs.
+func (ctrl *Controller) recordMachineSetSyncResult(machineSetName string, err error) int {
+	if err == nil {
+		delete(ctrl.mapiFailures, machineSetName)
+		return 0
+	}
+	now := ctrl.clock.Now()
+	failures, ok := ctrl.mapiFailures[machineSetName]
+	if !ok {
+		failures.firstErrorTime = now
+	}
+	failures.consecutiveFailures++
+	failures.lastErrorTime = now
+	failures.lastError = err.Error()
+	ctrl.mapiFailures[machineSetName] = failures
+	return failures.consecutiveFailures
+}
+
+// publishFailingMachineSets replaces the failing machinesets served on BootImageFailingMachineSetsPath
+// with those tracked after the completed pass.
+func (ctrl *Controller) publishFailingMachineSets() {
+	failing := &failingMachineSets{SyncedAt: ctrl.clock.Now().UTC().Format(time.RFC3339), MachineSets: []failingMachineSet{}}
+	for name, failures := range ctrl.mapiFailures {
+		failing.MachineSets = append(failing.MachineSets, failingMachineSet{
+			MachineSet:          name,
+			ConsecutiveFailures: failures.consecutiveFailures,
+			FirstErrorTime:      failures.firstErrorTime.UTC().Format(time.RFC3339),
+			LastErrorTime:       failures.lastErrorTime.UTC().Format(time.RFC3339),
+			FailingFor:          failures.lastErrorTime.Sub(failures.firstErrorTime).Round(time.Second).String(),
+			LastError:           failures.lastError,
+		})
+	}
+	slices.SortFunc(failing.MachineSets, func(a, b failingMachineSet) int { return strings.Compare(a.MachineSet, b.MachineSet) })
+	ctrl.publishedFailuresLock.Lock()
+	defer ctrl.publishedFailuresLock.Unlock()
+	ctrl.publishedFailures = failing
+}
+
+// FailingMachineSetsHandler returns the read-only handler for BootImageFailingMachineSetsPath. It
+// responds with 404 until the controller has completed a pass.
+func (ctrl *Controller) FailingMachineSetsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+			return
+		}
+		ctrl.publishedFailuresLock.Lock()
+		failing := ctrl.publishedFailures
+		ctrl.publishedFailuresLock.Unlock()
+		if failing == nil {
+			http.Error(w, "no MAPI machineset sync has completed yet", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(failing); err != nil {
+			klog.Errorf("Failed to write failing boot image machinesets: %v", err)
+		}
+	})
+}
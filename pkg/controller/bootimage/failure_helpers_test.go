@@ -0,0 +1,116 @@
+package bootimage
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	osconfigv1 "github.com/openshift/api/config/v1"
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	opv1 "github.com/openshift/api/operator/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestErrorGraceCount(t *testing.T) {
+	ctrl := newTestController(t, osconfigv1.GCPPlatformType, []*machinev1beta1.MachineSet{getGCPMachineSet("machineset-a", testGCPOldImage)}, nil)
+	queue := &delayRecordingQueue{TypedRateLimitingInterface: ctrl.queue}
+	ctrl.queue = queue
+	ctrl.setKnobs(t, map[string]string{ErrorGraceCountAnnotationKey: "2"})
+	failPatches := true
+	ctrl.machineClient.PrependReactor("patch", "machinesets", func(_ clienttesting.Action) (bool, runtime.Object, error) {
+		if failPatches {
+			return true, nil, fmt.Errorf("patch failed")
+		}
+		return false, nil, nil
+	})
+
+	// Failures within the grace count are retried, but not reported as errored or degraded
+	for sync := 1; sync <= 2; sync++ {
+		require.NoError(t, ctrl.syncAll("test"))
+		assert.Equal(t, 0, ctrl.mapiStats.erroredCount, "sync %d", sync)
+		assert.Equal(t, v1.ConditionFalse, ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateDegraded).Status, "sync %d", sync)
+		assert.Equal(t, v1.ConditionFalse, ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateProgressing).Status, "sync %d", sync)
+		assert.Len(t, queue.delays, sync)
+	}
+
+	// The failure beyond the grace count degrades the controller
+	require.NoError(t, ctrl.syncAll("test"))
+	assert.Equal(t, 1, ctrl.mapiStats.erroredCount)
+	degraded := ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateDegraded)
+	assert.Equal(t, v1.ConditionTrue, degraded.Status)
+	assert.Contains(t, degraded.Message, "patch failed")
+
+	// A successful sync clears the degradation and resets the consecutive failures
+	failPatches = false
+	require.NoError(t, ctrl.syncAll("test"))
+	assert.Equal(t, 0, ctrl.mapiStats.erroredCount)
+	assert.Equal(t, v1.ConditionFalse, ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateDegraded).Status)
+	assert.Empty(t, ctrl.mapiFailures)
+}
+
+func TestFailingMachineSetsEndpoint(t *testing.T) {
+	ctrl := newTestController(t, osconfigv1.GCPPlatformType, []*machinev1beta1.MachineSet{getGCPMachineSet("machineset-a", testGCPOldImage)}, nil)
+	failPatches := true
+	ctrl.machineClient.PrependReactor("patch", "machinesets", func(_ clienttesting.Action) (bool, runtime.Object, error) {
+		if failPatches {
+			return true, nil, fmt.Errorf("patch failed")
+		}
+		return false, nil, nil
+	})
+	getFailing := func(t *testing.T, method string) (*httptest.ResponseRecorder, failingMachineSets) {
+		t.Helper()
+		recorder := httptest.NewRecorder()
+		ctrl.FailingMachineSetsHandler().ServeHTTP(recorder, httptest.NewRequest(method, BootImageFailingMachineSetsPath, nil))
+		failing := failingMachineSets{}
+		if recorder.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &failing))
+		}
+		return recorder, failing
+	}
+
+	// Nothing is served before a pass has completed
+	response, _ := getFailing(t, http.MethodGet)
+	assert.Equal(t, http.StatusNotFound, response.Code)
+
+	// The first failure sets both timestamps
+	require.NoError(t, ctrl.syncAll("test"))
+	first := ctrl.mapiFailures["machineset-a"]
+	assert.Equal(t, 1, first.consecutiveFailures)
+	assert.False(t, first.firstErrorTime.IsZero())
+	assert.Equal(t, first.firstErrorTime, first.lastErrorTime)
+
+	// Further failures only advance the last error time
+	require.NoError(t, ctrl.syncAll("test"))
+	second := ctrl.mapiFailures["machineset-a"]
+	assert.Equal(t, 2, second.consecutiveFailures)
+	assert.Equal(t, first.firstErrorTime, second.firstErrorTime)
+	assert.True(t, second.lastErrorTime.After(first.lastErrorTime))
+
+	response, failing := getFailing(t, http.MethodGet)
+	require.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "application/json", response.Header().Get("Content-Type"))
+	require.Len(t, failing.MachineSets, 1)
+	assert.Equal(t, "machineset-a", failing.MachineSets[0].MachineSet)
+	assert.Equal(t, 2, failing.MachineSets[0].ConsecutiveFailures)
+	assert.Equal(t, second.firstErrorTime.UTC().Format(time.RFC3339), failing.MachineSets[0].FirstErrorTime)
+	assert.Equal(t, second.lastErrorTime.UTC().Format(time.RFC3339), failing.MachineSets[0].LastErrorTime)
+	assert.NotEmpty(t, failing.MachineSets[0].FailingFor)
+	assert.Contains(t, failing.MachineSets[0].LastError, "patch failed")
+	response, _ = getFailing(t, http.MethodPost)
+	assert.Equal(t, http.StatusMethodNotAllowed, response.Code)
+
+	// A successful sync clears the timestamps
+	failPatches = false
+	require.NoError(t, ctrl.syncAll("test"))
+	assert.Empty(t, ctrl.mapiFailures)
+	_, failing = getFailing(t, http.MethodGet)
+	assert.Empty(t, failing.MachineSets)
+	assert.NotNil(t, failing.MachineSets)
+}
@@ -3,19 +3,28 @@ package bootimage
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
+	"net"
+	"reflect"
+	"slices"
 	"strings"
 	"time"
 
+	"github.com/coreos/stream-metadata-go/stream"
 	osconfigv1 "github.com/openshift/api/config/v1"
 	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
 	opv1 "github.com/openshift/api/operator/v1"
 
 	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/labels"
 	kruntime "k8s.io/apimachinery/pkg/runtime"
+	kubeErrs "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/util/wait"
 	clientset "k8s.io/client-go/kubernetes"
 	"k8s.io/klog/v2"
@@ -57,15 +66,368 @@ func marshalProviderSpec(ms *machinev1beta1.MachineSet, providerSpec interface{}
 	return nil
 }
 
+// This function applies only the fields that changed between the original and updated ProviderSpec objects
+// to the raw provider spec. Fields that the typed ProviderSpec does not know about, such as user-added
+// tags or fields from newer API versions, would otherwise be dropped by a full re-marshal.
+// Returns the merged raw provider spec, or an error if any marshal or unmarshal fails.
+func mergeProviderSpecChanges(raw []byte, original, updated interface{}) (*kruntime.RawExtension, error) {
+	var current, originalValue, updatedValue interface{}
+	if err := yaml.Unmarshal(raw, &current); err != nil {
+		return nil, fmt.Errorf("unmarshal into providerSpec failed %w", err)
+	}
+	if err := toUnstructuredValue(original, &originalValue); err != nil {
+		return nil, err
+	}
+	if err := toUnstructuredValue(updated, &updatedValue); err != nil {
+		return nil, err
+	}
+	rawBytes, err := json.Marshal(mergeChangedFields(current, originalValue, updatedValue))
+	if err != nil {
+		return nil, fmt.Errorf("marshal into machineset failed: %w", err)
+	}
+	return &kruntime.RawExtension{Raw: rawBytes}, nil
+}
+
+// toUnstructuredValue converts a typed object into its generic JSON representation.
+func toUnstructuredValue(obj, out interface{}) error {
+	objBytes, err := json.Marshal(obj)
+	if err != nil {
+		return fmt.Errorf("marshal of providerSpec failed: %w", err)
+	}
+	if err := json.Unmarshal(objBytes, out); err != nil {
+		return fmt.Errorf("unmarshal of providerSpec failed: %w", err)
+	}
+	return nil
+}
+
+// mergeChangedFields returns current with the differences between original and updated applied to it.
+// Objects, and lists whose length is unchanged, are merged element by element so that any field of
+// current that is absent from both original and updated is left untouched.
+func mergeChangedFields(current, original, updated interface{}) interface{} {
+	if reflect.DeepEqual(original, updated) {
+		return current
+	}
+	switch updatedValue := updated.(type) {
+	case map[string]interface{}:
+		currentMap, currentOK := current.(map[string]interface{})
+		originalMap, originalOK := original.(map[string]interface{})
+		if !currentOK || !originalOK {
+			return updated
+		}
+		for key := range originalMap {
+			if _, ok := updatedValue[key]; !ok {
+				delete(currentMap, key)
+			}
+		}
+		for key, value := range updatedValue {
+			// Unchanged fields are left as they are, including defaults the raw providerspec omits
+			if reflect.DeepEqual(originalMap[key], value) {
+				continue
+			}
+			currentMap[key] = mergeChangedFields(currentMap[key], originalMap[key], value)
+		}
+		return currentMap
+	case []interface{}:
+		currentList, currentOK := current.([]interface{})
+		originalList, originalOK := original.([]interface{})
+		if !currentOK || !originalOK || len(currentList) != len(updatedValue) || len(originalList) != len(updatedValue) {
+			return updated
+		}
+		for idx := range updatedValue {
+			currentList[idx] = mergeChangedFields(currentList[idx], originalList[idx], updatedValue[idx])
+		}
+		return currentList
+	default:
+		return updated
+	}
+}
+
+// setPatchResourceVersion returns the merge patch with metadata.resourceVersion set, so that the API
+// server rejects it with a conflict if the object has changed since. The patch is returned unchanged
+// if resourceVersion is empty.
+func setPatchResourceVersion(patchBytes []byte, resourceVersion string) ([]byte, error) {
+	if resourceVersion == "" {
+		return patchBytes, nil
+	}
+	patch := map[string]interface{}{}
+	if err := json.Unmarshal(patchBytes, &patch); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal patch: %w", err)
+	}
+	if err := unstructured.SetNestedField(patch, resourceVersion, "metadata", "resourceVersion"); err != nil {
+		return nil, fmt.Errorf("unable to set resource version on patch: %w", err)
+	}
+	return json.Marshal(patch)
+}
+
+// annotationKey returns key, one of the annotation key constants of this package, with its
+// DefaultAnnotationKeyPrefix replaced by prefix. The key is returned unchanged if prefix is empty.
+func annotationKey(prefix, key string) string {
+	name, ok := strings.CutPrefix(key, DefaultAnnotationKeyPrefix+"/")
+	if !ok || prefix == "" {
+		return key
+	}
+	return prefix + "/" + name
+}
+
+// annotationKey returns key under the annotation key prefix the controller is configured with.
+func (ctrl *Controller) annotationKey(key string) string {
+	return annotationKey(ctrl.annotationKeyPrefix, key)
+}
+
 // This function unmarshals the golden stream configmap into a coreos
 // stream object. Returns an error if the unmarshal fails.
-func unmarshalStreamDataConfigMap(cm *corev1.ConfigMap, st interface{}) error {
-	if err := json.Unmarshal([]byte(cm.Data[StreamConfigMapKey]), &st); err != nil {
+func unmarshalStreamDataConfigMap(cm *corev1.ConfigMap, streamKey string, st interface{}) error {
+	streamData, ok := cm.Data[streamKey]
+	if !ok {
+		return fmt.Errorf("stream data key %q not found in configmap %s", streamKey, cm.Name)
+	}
+	if err := json.Unmarshal([]byte(streamData), &st); err != nil {
 		return fmt.Errorf("failed to parse CoreOS stream metadata: %w", err)
 	}
 	return nil
 }
 
+// getTargetOSVersion returns the OS and release that the boot images configmap points machine resources
+// to, e.g. "RHCOS 9.6.20250402-0", or an empty string if the stream data does not list any release.
+// Releases that differ between architectures or platforms are all listed.
+func (ctrl *Controller) getTargetOSVersion() string {
+	configMap, err := ctrl.mcoCmLister.ConfigMaps(ctrlcommon.MCONamespace).Get(ctrlcommon.BootImagesConfigMapName)
+	if err != nil {
+		return ""
+	}
+	streamData := new(stream.Stream)
+	if err := unmarshalStreamDataConfigMap(configMap, ctrl.streamConfigMapKey, streamData); err != nil {
+		return ""
+	}
+	releases := sets.New[string]()
+	for _, arch := range streamData.Architectures {
+		for _, artifacts := range arch.Artifacts {
+			if artifacts.Release != "" {
+				releases.Insert(artifacts.Release)
+			}
+		}
+	}
+	if releases.Len() == 0 {
+		return ""
+	}
+	osName := strings.ToUpper(getStreamOSVariant(streamData.Stream))
+	if osName == "" {
+		osName = streamData.Stream
+	}
+	return strings.TrimSpace(fmt.Sprintf("%s %s", osName, strings.Join(sets.List(releases), ", ")))
+}
+
+// checkArchVersionSkew returns an error listing the releases advertised for each architecture if the
+// stream data in the boot images configmap does not advertise the same releases for every architecture.
+// Architectures that do not list any release are not compared.
+func (ctrl *Controller) checkArchVersionSkew() error {
+	configMap, err := ctrl.mcoCmLister.ConfigMaps(ctrlcommon.MCONamespace).Get(ctrlcommon.BootImagesConfigMapName)
+	if err != nil {
+		return fmt.Errorf("failed to fetch coreos-bootimages config map: %w", err)
+	}
+	streamData := new(stream.Stream)
+	if err := unmarshalStreamDataConfigMap(configMap, ctrl.streamConfigMapKey, streamData); err != nil {
+		return err
+	}
+	archVersions := map[string]string{}
+	for archName, arch := range streamData.Architectures {
+		releases := sets.New[string]()
+		for _, artifacts := range arch.Artifacts {
+			if artifacts.Release != "" {
+				releases.Insert(artifacts.Release)
+			}
+		}
+		if releases.Len() > 0 {
+			archVersions[archName] = strings.Join(sets.List(releases), ", ")
+		}
+	}
+	versions := sets.New[string]()
+	for _, version := range archVersions {
+		versions.Insert(version)
+	}
+	if versions.Len() <= 1 {
+		return nil
+	}
+	mismatch := []string{}
+	for _, archName := range sets.List(sets.KeySet(archVersions)) {
+		mismatch = append(mismatch, fmt.Sprintf("%s: %s", archName, archVersions[archName]))
+	}
+	return fmt.Errorf("stream data advertises different OS versions per architecture (%s)", strings.Join(mismatch, "; "))
+}
+
+// isTransientError returns true if the error is likely to clear up without intervention, such as an API
+// server timeout, throttling or conflict, or a network error.
+func isTransientError(err error) bool {
+	if k8serrors.IsServerTimeout(err) || k8serrors.IsTimeout(err) || k8serrors.IsTooManyRequests(err) ||
+		k8serrors.IsServiceUnavailable(err) || k8serrors.IsInternalError(err) || k8serrors.IsConflict(err) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// hotLoopError is returned by the sync of a machine resource whose boot image update is refused, as the
+// update was reverted as many times as the hot loop limit allows.
+type hotLoopError struct {
+	// resource is the kind of the machine resource, e.g. "machineset"
+	resource string
+	name     string
+}
+
+func (e *hotLoopError) Error() string {
+	return fmt.Sprintf("refusing to reconcile %s %s, hot loop detected. Please opt-out of boot image updates, adjust your machine provisioning workflow to prevent hot loops and opt back in to resume boot image updates", e.resource, e.name)
+}
+
+// newHotLoopError returns the error of a detected hot loop of the named machine resource.
+func newHotLoopError(resource, name string) error {
+	return &hotLoopError{resource: resource, name: name}
+}
+
+// getDegradedReason returns ReasonHotLoopDetected if the last sync of any machine resource type detected a
+// hot loop, ReasonBootImageRolledBack if a MAPI machineset is frozen after a rollback, and the given reason
+// otherwise. The reason reverts once no machine resource is hot looping or frozen.
+func (ctrl *Controller) getDegradedReason(reason string) string {
+	var hotLoop *hotLoopError
+	var rollbackFrozen *rollbackFrozenError
+	errs := append(append([]error{}, ctrl.cpmsSyncErrors...), ctrl.mapiSyncErrors...)
+	for _, err := range errs {
+		if errors.As(err, &hotLoop) {
+			return ReasonHotLoopDetected
+		}
+	}
+	for _, err := range errs {
+		if errors.As(err, &rollbackFrozen) {
+			return ReasonBootImageRolledBack
+		}
+	}
+	return reason
+}
+
+// isKillSwitchEngaged returns true if the kill switch configmap exists in the MCO namespace.
+func (ctrl *Controller) isKillSwitchEngaged() (bool, error) {
+	_, err := ctrl.mcoCmLister.ConfigMaps(ctrlcommon.MCONamespace).Get(BootImageKillSwitchConfigMapName)
+	if k8serrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check for kill switch configmap %s: %w", BootImageKillSwitchConfigMapName, err)
+	}
+	return true, nil
+}
+
+// errBootImagesConfigMapClusterMismatch is wrapped by the error returned when the golden configmap
+// declares that it is intended for a different cluster.
+var errBootImagesConfigMapClusterMismatch = errors.New("boot images configmap is intended for a different cluster")
+
+// errBootImagesConfigMapMissingArchitectures is wrapped by the error returned when the stream data of the
+// golden configmap has no entries for the architecture of an enrolled machineset.
+var errBootImagesConfigMapMissingArchitectures = errors.New("boot images configmap is missing architectures of enrolled machinesets")
+
+// validateBootImagesConfigMap checks that the golden configmap exists, is intended for this cluster and
+// holds parseable stream data under the configured stream key, with entries for at least the
+// architectures of the enrolled MAPI machinesets.
+func (ctrl *Controller) validateBootImagesConfigMap() error {
+	configMap, err := ctrl.mcoCmLister.ConfigMaps(ctrlcommon.MCONamespace).Get(ctrlcommon.BootImagesConfigMapName)
+	if err != nil {
+		return fmt.Errorf("failed to fetch coreos-bootimages config map: %w", err)
+	}
+	if err := ctrl.checkBootImagesConfigMapCluster(configMap); err != nil {
+		return err
+	}
+	streamData := new(stream.Stream)
+	if err := unmarshalStreamDataConfigMap(configMap, ctrl.streamConfigMapKey, streamData); err != nil {
+		return err
+	}
+	if streamData.Stream == "" {
+		return fmt.Errorf("stream data under key %q does not name a stream", ctrl.streamConfigMapKey)
+	}
+	if len(streamData.Architectures) == 0 {
+		return fmt.Errorf("stream data under key %q does not list any architectures", ctrl.streamConfigMapKey)
+	}
+	return ctrl.checkEnrolledArchitectures(streamData)
+}
+
+// checkEnrolledArchitectures checks that the stream data has entries for the architecture of every
+// enrolled MAPI machineset. Machinesets whose architecture cannot be determined are left to their own
+// sync, which reports them individually.
+func (ctrl *Controller) checkEnrolledArchitectures(streamData *stream.Stream) error {
+	mcop, err := ctrl.mcopLister.Get(ctrlcommon.MCOOperatorKnobsObjectName)
+	if err != nil {
+		return fmt.Errorf("failed to get MachineConfiguration to verify the boot images configmap: %w", err)
+	}
+	machineManagerFound, machineResourceSelector, err := getMachineResourceSelectorFromMachineManagers(mcop.Status.ManagedBootImagesStatus.MachineManagers, opv1.MachineAPI, opv1.MachineSets)
+	if err != nil || !machineManagerFound {
+		return nil
+	}
+	machineSets, err := ctrl.mapiMachineSetLister.List(machineResourceSelector)
+	if err != nil {
+		return fmt.Errorf("failed to list MAPI machinesets to verify the boot images configmap: %w", err)
+	}
+	machineSets, _ = ctrl.filterOptedInMachineSets(machineSets)
+	machineSets, _ = ctrl.filterManagedPlatformMachineSets(machineSets)
+	machineSets, _ = ctrl.filterOptedOutGroupMachineSets(machineSets)
+	if len(machineSets) == 0 {
+		return nil
+	}
+	clusterVersion, err := ctrl.clusterVersionLister.Get("version")
+	if err != nil {
+		return fmt.Errorf("failed to fetch clusterversion to verify the boot images configmap: %w", err)
+	}
+
+	missing := map[string][]string{}
+	for _, machineSet := range machineSets {
+		arch, err := ctrl.getMachineSetArch(machineSet, clusterVersion)
+		if err != nil {
+			continue
+		}
+		if _, ok := streamData.Architectures[arch]; !ok {
+			missing[arch] = append(missing[arch], machineSet.Name)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	details := []string{}
+	for _, arch := range slices.Sorted(maps.Keys(missing)) {
+		slices.Sort(missing[arch])
+		details = append(details, fmt.Sprintf("%s (machinesets %s)", arch, strings.Join(missing[arch], ", ")))
+	}
+	return fmt.Errorf("%w: stream data under key %q has no entries for architecture(s) %s",
+		errBootImagesConfigMapMissingArchitectures, ctrl.streamConfigMapKey, strings.Join(details, "; "))
+}
+
+// checkBootImagesConfigMapCluster cross-checks the cluster identifiers declared on the golden configmap,
+// if any, against the cluster's Infrastructure object. Boot images intended for a different cluster
+// would break the provisioning of every machine resource, so a mismatch is an error.
+func (ctrl *Controller) checkBootImagesConfigMapCluster(configMap *corev1.ConfigMap) error {
+	infraNameKey := ctrl.annotationKey(BootImagesInfrastructureNameAnnotationKey)
+	platformKey := ctrl.annotationKey(BootImagesPlatformAnnotationKey)
+	declaredInfraName, declaresInfraName := configMap.Annotations[infraNameKey]
+	declaredPlatform, declaresPlatform := configMap.Annotations[platformKey]
+	if !declaresInfraName && !declaresPlatform {
+		return nil
+	}
+	infra, err := ctrl.infraLister.Get("cluster")
+	if err != nil {
+		return fmt.Errorf("failed to fetch infra object to verify the boot images configmap: %w", err)
+	}
+	if declaresInfraName && declaredInfraName != infra.Status.InfrastructureName {
+		return fmt.Errorf("%w: annotation %s declares infrastructure %q, but this cluster's infrastructure is %q",
+			errBootImagesConfigMapClusterMismatch, infraNameKey, declaredInfraName, infra.Status.InfrastructureName)
+	}
+	if declaresPlatform {
+		var platform osconfigv1.PlatformType
+		if infra.Status.PlatformStatus != nil {
+			platform = infra.Status.PlatformStatus.Type
+		}
+		if osconfigv1.PlatformType(declaredPlatform) != platform {
+			return fmt.Errorf("%w: annotation %s declares platform %q, but this cluster's platform is %q",
+				errBootImagesConfigMapClusterMismatch, platformKey, declaredPlatform, platform)
+		}
+	}
+	return nil
+}
+
 // This function checks if an array of machineManagers contains the target apigroup/resource and returns
 // a bool(success/fail), a label selector to filter the target resource and an error, if any.
 func getMachineResourceSelectorFromMachineManagers(machineManagers []opv1.MachineManager, apiGroup opv1.MachineManagerMachineSetsAPIGroupType, resource opv1.MachineManagerMachineSetsResourceType) (bool, labels.Selector, error) {
@@ -78,6 +440,9 @@ func getMachineResourceSelectorFromMachineManagers(machineManagers []opv1.Machin
 		if machineManager.APIGroup == apiGroup && machineManager.Resource == resource {
 			switch machineManager.Selection.Mode {
 			case opv1.Partial:
+				if machineManager.Selection.Partial == nil {
+					return true, labels.Nothing(), fmt.Errorf("machine manager for %s.%s has a Partial selection without a selector", resource, apiGroup)
+				}
 				selector, err := metav1.LabelSelectorAsSelector(machineManager.Selection.Partial.MachineResourceSelector)
 				return true, selector, err
 			case opv1.All:
@@ -90,8 +455,63 @@ func getMachineResourceSelectorFromMachineManagers(machineManagers []opv1.Machin
 	return false, labels.Nothing(), nil
 }
 
-// Upgrades the Ignition stub enclosed in referenced secret if required
+// validateManagedBootImagesStatus checks that the machine managers of the managed boot images status each
+// declare a distinct, supported machine resource with a selection that is well formed, and that a Partial
+// selection of MAPI machinesets matches at least one machineset. Returns nil if the status is consistent.
+func (ctrl *Controller) validateManagedBootImagesStatus(status opv1.ManagedBootImages) error {
+	var errs []error
+	declared := sets.New[string]()
+	for _, machineManager := range status.MachineManagers {
+		name := fmt.Sprintf("%s.%s", machineManager.Resource, machineManager.APIGroup)
+		if declared.Has(name) {
+			errs = append(errs, fmt.Errorf("%s is declared by more than one machine manager, only the first is used", name))
+			continue
+		}
+		declared.Insert(name)
+
+		if machineManager.APIGroup != opv1.MachineAPI || (machineManager.Resource != opv1.MachineSets && machineManager.Resource != opv1.ControlPlaneMachineSets) {
+			errs = append(errs, fmt.Errorf("%s is not a machine resource supported for boot image updates", name))
+			continue
+		}
+
+		switch machineManager.Selection.Mode {
+		case opv1.All, opv1.None:
+			if machineManager.Selection.Partial != nil {
+				errs = append(errs, fmt.Errorf("%s has a partial selector, which is ignored in %s mode", name, machineManager.Selection.Mode))
+			}
+		case opv1.Partial:
+			if machineManager.Selection.Partial == nil || machineManager.Selection.Partial.MachineResourceSelector == nil {
+				errs = append(errs, fmt.Errorf("%s is in Partial mode without a selector", name))
+				continue
+			}
+			selector, err := metav1.LabelSelectorAsSelector(machineManager.Selection.Partial.MachineResourceSelector)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s has an invalid selector: %w", name, err))
+				continue
+			}
+			if machineManager.Resource != opv1.MachineSets {
+				continue
+			}
+			machineSets, err := ctrl.mapiMachineSetLister.List(selector)
+			if err != nil {
+				return fmt.Errorf("failed to fetch MachineSet list while validating the managed boot images scope: %w", err)
+			}
+			if len(machineSets) == 0 {
+				errs = append(errs, fmt.Errorf("%s selector %q matches no machinesets", name, selector.String()))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("%s has unknown selection mode %q", name, machineManager.Selection.Mode))
+		}
+	}
+	return kubeErrs.NewAggregate(errs)
+}
+
+// Upgrades the Ignition stub enclosed in referenced secret if required. A nil secretClient
+// indicates a read-only evaluation (advisory-only mode), in which case no upgrade is attempted.
 func upgradeStubIgnitionIfRequired(secretName string, secretClient clientset.Interface) error {
+	if secretClient == nil {
+		return nil
+	}
 	secret, err := secretClient.CoreV1().Secrets(ctrlcommon.MachineAPINamespace).Get(context.TODO(), secretName, metav1.GetOptions{})
 	if err != nil {
 		return fmt.Errorf("error grabbing user data secret referenced in machineset: %w", err)
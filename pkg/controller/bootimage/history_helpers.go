@@ -0,0 +1,91 @@
+package bootimage
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// Path of the debug endpoint, served by the metrics listener, that returns the summaries of the most
+// recent syncs, oldest first
+const BootImageSyncHistoryPath = "/debug/bootimage/history"
+
+// syncCounts are the per machine resource type counts of a sync summary.
+type syncCounts struct {
+	Total     int `json:"total"`
+	Updated   int `json:"updated"`
+	Skipped   int `json:"skipped"`
+	Errored   int `json:"errored"`
+	Deferred  int `json:"deferred"`
+	Throttled int `json:"throttled"`
+	OutOfDate int `json:"outOfDate"`
+}
+
+// syncSummary describes the outcome of a single sync of all machine resources.
+type syncSummary struct {
+	Time                    string     `json:"time"`
+	Reason                  string     `json:"reason"`
+	MAPIMachineSets         syncCounts `json:"mapiMachineSets"`
+	ControlPlaneMachineSets syncCounts `json:"controlPlaneMachineSets"`
+	// Errors holds at most maxConditionErrors errors, followed by a count of the remaining ones
+	Errors []string `json:"errors"`
+}
+
+func newSyncCounts(stats MachineResourceStats) syncCounts {
+	return syncCounts{
+		Total:     stats.totalCount,
+		Updated:   stats.updatedCount,
+		Skipped:   stats.skippedCount,
+		Errored:   stats.erroredCount,
+		Deferred:  stats.deferredCount,
+		Throttled: stats.throttledCount,
+		OutOfDate: stats.outOfDateCount,
+	}
+}
+
+// recordSyncSummary appends the summary of the completed sync to the sync history, evicting the oldest
+// summaries beyond the configured history size.
+func (ctrl *Controller) recordSyncSummary(reason string) {
+	summary := syncSummary{
+		Time:                    ctrl.clock.Now().UTC().Format(time.RFC3339),
+		Reason:                  reason,
+		MAPIMachineSets:         newSyncCounts(ctrl.mapiStats),
+		ControlPlaneMachineSets: newSyncCounts(ctrl.cpmsStats),
+		Errors:                  []string{},
+	}
+	errs := append(append([]error{}, ctrl.cpmsSyncErrors...), ctrl.mapiSyncErrors...)
+	for i, err := range errs {
+		if i == maxConditionErrors {
+			summary.Errors = append(summary.Errors, fmt.Sprintf("and %d more error(s)", len(errs)-maxConditionErrors))
+			break
+		}
+		summary.Errors = append(summary.Errors, err.Error())
+	}
+
+	ctrl.syncHistoryLock.Lock()
+	defer ctrl.syncHistoryLock.Unlock()
+	ctrl.syncHistory = append(ctrl.syncHistory, summary)
+	if size := ctrl.knobs.historySize(); len(ctrl.syncHistory) > size {
+		ctrl.syncHistory = append([]syncSummary{}, ctrl.syncHistory[len(ctrl.syncHistory)-size:]...)
+	}
+}
+
+// SyncHistoryHandler returns the read-only handler for BootImageSyncHistoryPath.
+func (ctrl *Controller) SyncHistoryHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+			return
+		}
+		ctrl.syncHistoryLock.Lock()
+		history := append([]syncSummary{}, ctrl.syncHistory...)
+		ctrl.syncHistoryLock.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(history); err != nil {
+			klog.Errorf("Failed to write boot image sync history: %v", err)
+		}
+	})
+}
@@ -0,0 +1,59 @@
+package bootimage
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	osconfigv1 "github.com/openshift/api/config/v1"
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncHistory(t *testing.T) {
+	ctrl := newTestController(t, osconfigv1.GCPPlatformType, []*machinev1beta1.MachineSet{getGCPMachineSet("machineset-a", testGCPOldImage)}, nil)
+	ctrl.setKnobs(t, map[string]string{SyncHistorySizeAnnotationKey: "3"})
+	getHistory := func(t *testing.T) []syncSummary {
+		t.Helper()
+		recorder := httptest.NewRecorder()
+		ctrl.SyncHistoryHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, BootImageSyncHistoryPath, nil))
+		require.Equal(t, http.StatusOK, recorder.Code)
+		history := []syncSummary{}
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &history))
+		return history
+	}
+	getReasons := func(history []syncSummary) []string {
+		reasons := []string{}
+		for _, summary := range history {
+			reasons = append(reasons, summary.Reason)
+		}
+		return reasons
+	}
+
+	assert.Empty(t, getHistory(t))
+
+	for i := 1; i <= 4; i++ {
+		require.NoError(t, ctrl.syncAll(fmt.Sprintf("sync-%d", i)))
+	}
+
+	// The oldest summary is evicted, the remaining ones are kept in order
+	history := getHistory(t)
+	assert.Equal(t, []string{"sync-2", "sync-3", "sync-4"}, getReasons(history))
+	for _, summary := range history {
+		assert.NotEmpty(t, summary.Time)
+		assert.Equal(t, 1, summary.MAPIMachineSets.Total)
+	}
+
+	// Shrinking the history evicts the oldest summaries on the next sync
+	ctrl.setKnobs(t, map[string]string{SyncHistorySizeAnnotationKey: "2"})
+	require.NoError(t, ctrl.syncAll("sync-5"))
+	assert.Equal(t, []string{"sync-4", "sync-5"}, getReasons(getHistory(t)))
+
+	// The endpoint is read-only
+	recorder := httptest.NewRecorder()
+	ctrl.SyncHistoryHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, BootImageSyncHistoryPath, nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+}
@@ -0,0 +1,54 @@
+package bootimage
+
+import (
+	"strings"
+)
+
+// The same boot image may be referenced in several equivalent forms, e.g. a GCP image as a relative
+// resource name or as a full self-link. Comparing references in their canonical form prevents the
+// controller from rewriting an image that is already current, which would churn machinesets and could
+// trip hot loop detection.
+
+// gcpImageSelfLinkPrefixes are the Compute Engine API endpoints that prefix the self-link of a GCP image.
+var gcpImageSelfLinkPrefixes = []string{
+	"https://www.googleapis.com/compute/v1/",
+	"https://www.googleapis.com/compute/beta/",
+	"https://compute.googleapis.com/compute/v1/",
+	"https://compute.googleapis.com/compute/beta/",
+}
+
+// normalizeGCPImage returns the relative resource name of a GCP image reference, e.g.
+// "projects/rhcos-cloud/global/images/rhcos-9-6", dropping the API endpoint of a self-link.
+// References without a project, which resolve against the machine's own project, are left as is.
+func normalizeGCPImage(image string) string {
+	image = strings.TrimSpace(image)
+	for _, prefix := range gcpImageSelfLinkPrefixes {
+		if len(image) > len(prefix) && strings.EqualFold(image[:len(prefix)], prefix) {
+			image = image[len(prefix):]
+			break
+		}
+	}
+	return strings.TrimPrefix(image, "/")
+}
+
+// gcpImagesEqual returns true if both GCP image references name the same image.
+func gcpImagesEqual(a, b string) bool {
+	return normalizeGCPImage(a) == normalizeGCPImage(b)
+}
+
+// normalizeAWSAMI returns the AMI ID of an AWS image reference, which may be an AMI ID or the ARN of an
+// image, e.g. "arn:aws:ec2:us-east-1::image/ami-0123". An ARN of another resource type is left as is.
+func normalizeAWSAMI(ami string) string {
+	ami = strings.ToLower(strings.TrimSpace(ami))
+	if strings.HasPrefix(ami, "arn:") {
+		if _, id, ok := strings.Cut(ami, ":image/"); ok && strings.HasPrefix(id, "ami-") {
+			return id
+		}
+	}
+	return ami
+}
+
+// awsAMIsEqual returns true if both AWS image references name the same AMI.
+func awsAMIsEqual(a, b string) bool {
+	return normalizeAWSAMI(a) == normalizeAWSAMI(b)
+}
@@ -0,0 +1,118 @@
+package bootimage
+
+import (
+	"testing"
+
+	"github.com/coreos/stream-metadata-go/stream"
+	osconfigv1 "github.com/openshift/api/config/v1"
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestEquivalentImageReferences(t *testing.T) {
+	t.Run("GCP", func(t *testing.T) {
+		cases := []struct {
+			name         string
+			currentImage string
+		}{
+			{name: "self-link", currentImage: "https://www.googleapis.com/compute/v1/" + testGCPStreamImage},
+			{name: "self-link on the compute endpoint", currentImage: "https://compute.googleapis.com/compute/v1/" + testGCPStreamImage},
+			{name: "beta API self-link", currentImage: "https://www.googleapis.com/compute/beta/" + testGCPStreamImage},
+			{name: "leading slash", currentImage: "/" + testGCPStreamImage},
+		}
+		for _, tc := range cases {
+			t.Run(tc.name, func(t *testing.T) {
+				ctrl := newTestController(t, osconfigv1.GCPPlatformType, []*machinev1beta1.MachineSet{getGCPMachineSet("machineset-a", tc.currentImage)}, nil)
+
+				require.NoError(t, ctrl.syncAll("test"))
+
+				assert.Equal(t, 0, ctrl.countMachineSetPatches())
+				assert.Equal(t, 0, ctrl.mapiStats.skippedCount)
+				assert.Equal(t, tc.currentImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, "machineset-a")))
+			})
+		}
+
+		t.Run("self-link to an old image is updated", func(t *testing.T) {
+			ctrl := newTestController(t, osconfigv1.GCPPlatformType, []*machinev1beta1.MachineSet{getGCPMachineSet("machineset-a", "https://www.googleapis.com/compute/v1/"+testGCPOldImage)}, nil)
+
+			require.NoError(t, ctrl.syncAll("test"))
+
+			assert.Equal(t, testGCPStreamImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, "machineset-a")))
+		})
+	})
+
+	t.Run("AWS", func(t *testing.T) {
+		streamData := &stream.Stream{
+			Architectures: map[string]stream.Arch{
+				"x86_64": {
+					Images: stream.Images{
+						Aws: &stream.AwsImage{Regions: map[string]stream.SingleImage{"us-east-1": {Image: "ami-0123456789abcdef0"}}},
+					},
+				},
+			},
+		}
+		fakeClient := fake.NewClientset(&corev1.Secret{
+			ObjectMeta: v1.ObjectMeta{Name: "test-secret", Namespace: MachineAPINamespace},
+			Data: map[string][]byte{
+				ctrlcommon.UserDataKey: []byte(`{"ignition":{"version":"3.4.0"}}`),
+			},
+		})
+
+		cases := []struct {
+			name string
+			id   string
+			arn  string
+		}{
+			{name: "upper case AMI ID", id: "AMI-0123456789ABCDEF0"},
+			{name: "AMI ID with surrounding whitespace", id: " ami-0123456789abcdef0 "},
+			{name: "ARN", arn: "arn:aws:ec2:us-east-1::image/ami-0123456789abcdef0"},
+			{name: "ARN in another partition", arn: "arn:aws-us-gov:ec2:us-east-1:123456789012:image/ami-0123456789abcdef0"},
+		}
+		for _, tc := range cases {
+			t.Run(tc.name, func(t *testing.T) {
+				ami := machinev1beta1.AWSResourceReference{}
+				if tc.id != "" {
+					ami.ID = &tc.id
+				}
+				if tc.arn != "" {
+					ami.ARN = &tc.arn
+				}
+				providerSpec := &machinev1beta1.AWSMachineProviderConfig{
+					AMI:            ami,
+					Placement:      machinev1beta1.Placement{Region: "us-east-1"},
+					UserDataSecret: &corev1.LocalObjectReference{Name: "test-secret"},
+				}
+
+				patchRequired, reconcileSkipped, _, err := reconcileAWSProviderSpec(streamData, "x86_64", nil, providerSpec, "test-machineset", fakeClient)
+				require.NoError(t, err)
+				assert.False(t, patchRequired)
+				assert.False(t, reconcileSkipped)
+
+				// The same holds for a boot image resolved from a Secret
+				changed, _ := setAWSBootImage(providerSpec.DeepCopy(), "ami-0123456789abcdef0", "test-machineset")
+				assert.False(t, changed)
+			})
+		}
+
+		t.Run("ARN of another AMI is not equivalent", func(t *testing.T) {
+			arn := "arn:aws:ec2:us-east-1::image/ami-0fedcba9876543210"
+			providerSpec := &machinev1beta1.AWSMachineProviderConfig{
+				AMI:            machinev1beta1.AWSResourceReference{ARN: &arn},
+				Placement:      machinev1beta1.Placement{Region: "us-east-1"},
+				UserDataSecret: &corev1.LocalObjectReference{Name: "test-secret"},
+			}
+
+			patchRequired, reconcileSkipped, _, err := reconcileAWSProviderSpec(streamData, "x86_64", nil, providerSpec, "test-machineset", fakeClient)
+			require.NoError(t, err)
+			assert.False(t, patchRequired)
+			assert.True(t, reconcileSkipped)
+			changed, _ := setAWSBootImage(providerSpec.DeepCopy(), "ami-0123456789abcdef0", "test-machineset")
+			assert.True(t, changed)
+		})
+	})
+}
@@ -0,0 +1,56 @@
+package bootimage
+
+import (
+	"fmt"
+
+	osconfigv1 "github.com/openshift/api/config/v1"
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	"k8s.io/klog/v2"
+)
+
+// ImageVerifier checks that a boot image exists on the cloud provider of a platform, e.g. with a
+// DescribeImages call on AWS. It is called from the sync goroutine before a MAPI machineset is updated
+// to a stream image, and its result is reused for the rest of the pass.
+type ImageVerifier interface {
+	// ImageExists returns whether image exists for the machineset, which carries the region or
+	// project the image is looked up in. An error is returned if the provider could not be queried.
+	ImageExists(machineSet *machinev1beta1.MachineSet, image string) (bool, error)
+}
+
+// SetImageVerifier registers the verifier used for the stream images of the given platform when
+// VerifyStreamImagesAnnotationKey is set. It must be called before Run.
+func (ctrl *Controller) SetImageVerifier(platform osconfigv1.PlatformType, verifier ImageVerifier) {
+	if ctrl.imageVerifiers == nil {
+		ctrl.imageVerifiers = map[osconfigv1.PlatformType]ImageVerifier{}
+	}
+	ctrl.imageVerifiers[platform] = verifier
+}
+
+// verifyStreamImage returns an error unless the stream image that newMachineSet would be updated to
+// exists on the provider. Images are looked up once per pass, so that a missing image refuses the
+// update of every machineset it would be applied to. Provider errors are not cached, and are retried
+// for the next machineset.
+func (ctrl *Controller) verifyStreamImage(infra *osconfigv1.Infrastructure, imagePath []string, newMachineSet *machinev1beta1.MachineSet) error {
+	platform := infra.Status.PlatformStatus.Type
+	verifier, ok := ctrl.imageVerifiers[platform]
+	if !ok {
+		return fmt.Errorf("refusing to update machineset %s, %s is set but no image verifier is available for platform %s", newMachineSet.Name, ctrl.annotationKey(VerifyStreamImagesAnnotationKey), platform)
+	}
+	image, err := getMachineSetBootImage(infra, imagePath, newMachineSet)
+	if err != nil {
+		return fmt.Errorf("failed to read the target boot image of machineset %s for verification: %w", newMachineSet.Name, err)
+	}
+	exists, ok := ctrl.mapiVerifiedImages[image]
+	if !ok {
+		exists, err = verifier.ImageExists(newMachineSet, image)
+		if err != nil {
+			return fmt.Errorf("failed to verify that boot image %s of machineset %s exists on platform %s: %w", image, newMachineSet.Name, platform, err)
+		}
+		klog.Infof("Verified boot image %s on platform %s, exists: %t", image, platform, exists)
+		ctrl.mapiVerifiedImages[image] = exists
+	}
+	if !exists {
+		return fmt.Errorf("refusing to update machineset %s, boot image %s from the boot images configmap does not exist on platform %s", newMachineSet.Name, image, platform)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package bootimage
+
+import (
+	"fmt"
+	"testing"
+
+	osconfigv1 "github.com/openshift/api/config/v1"
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	opv1 "github.com/openshift/api/operator/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// fakeImageVerifier reports the images in existing as present, and counts its lookups
+type fakeImageVerifier struct {
+	existing sets.Set[string]
+	err      error
+	lookups  int
+}
+
+func (verifier *fakeImageVerifier) ImageExists(_ *machinev1beta1.MachineSet, image string) (bool, error) {
+	verifier.lookups++
+	return verifier.existing.Has(image), verifier.err
+}
+
+func TestVerifyStreamImages(t *testing.T) {
+	cases := []struct {
+		name             string
+		knobs            map[string]string
+		verifier         *fakeImageVerifier
+		verifierPlatform osconfigv1.PlatformType
+		expectedImage    string
+		expectedLookups  int
+		expectedError    string
+	}{
+		{
+			name:          "stream images are not verified by default",
+			verifier:      &fakeImageVerifier{existing: sets.New[string]()},
+			expectedImage: testGCPStreamImage,
+		},
+		{
+			name:            "an existing image is applied, and looked up once per pass",
+			knobs:           map[string]string{VerifyStreamImagesAnnotationKey: "true"},
+			verifier:        &fakeImageVerifier{existing: sets.New(testGCPStreamImage)},
+			expectedImage:   testGCPStreamImage,
+			expectedLookups: 1,
+		},
+		{
+			name:            "a missing image is refused",
+			knobs:           map[string]string{VerifyStreamImagesAnnotationKey: "true"},
+			verifier:        &fakeImageVerifier{existing: sets.New[string]()},
+			expectedImage:   testGCPOldImage,
+			expectedLookups: 1,
+			expectedError:   "does not exist on platform GCP",
+		},
+		{
+			name:            "a provider error is refused and retried",
+			knobs:           map[string]string{VerifyStreamImagesAnnotationKey: "true"},
+			verifier:        &fakeImageVerifier{existing: sets.New[string](), err: fmt.Errorf("provider unavailable")},
+			expectedImage:   testGCPOldImage,
+			expectedLookups: 2,
+			expectedError:   "provider unavailable",
+		},
+		{
+			name:          "the knob is ignored without a registered verifier",
+			knobs:         map[string]string{VerifyStreamImagesAnnotationKey: "true"},
+			expectedImage: testGCPStreamImage,
+		},
+		{
+			name:             "updates are refused without a verifier for the platform",
+			knobs:            map[string]string{VerifyStreamImagesAnnotationKey: "true"},
+			verifier:         &fakeImageVerifier{existing: sets.New(testGCPStreamImage)},
+			verifierPlatform: osconfigv1.AWSPlatformType,
+			expectedImage:    testGCPOldImage,
+			expectedError:    "no image verifier is available for platform GCP",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			machineSets := []*machinev1beta1.MachineSet{
+				getGCPMachineSet("machineset-a", testGCPOldImage),
+				getGCPMachineSet("machineset-b", testGCPOldImage),
+			}
+			ctrl := newTestController(t, osconfigv1.GCPPlatformType, machineSets, nil)
+			ctrl.setKnobs(t, tc.knobs)
+			if tc.verifier != nil {
+				platform := osconfigv1.GCPPlatformType
+				if tc.verifierPlatform != "" {
+					platform = tc.verifierPlatform
+				}
+				ctrl.SetImageVerifier(platform, tc.verifier)
+			}
+
+			require.NoError(t, ctrl.syncAll("test"))
+			// The effective config reports whether stream images are verified
+			assert.Equal(t, tc.knobs != nil && tc.verifier != nil, ctrl.knobs.verifyStreamImages)
+			assert.Contains(t, ctrl.publishedEffectiveConfig, fmt.Sprintf(`"verifyStreamImages":%t`, ctrl.knobs.verifyStreamImages))
+			for _, machineSet := range machineSets {
+				assert.Equal(t, tc.expectedImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, machineSet.Name)))
+			}
+			if tc.verifier != nil {
+				assert.Equal(t, tc.expectedLookups, tc.verifier.lookups)
+			}
+			degraded := ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateDegraded)
+			if tc.expectedError == "" {
+				assert.Equal(t, v1.ConditionFalse, degraded.Status)
+				return
+			}
+			assert.Equal(t, v1.ConditionTrue, degraded.Status)
+			assert.Contains(t, degraded.Message, tc.expectedError)
+			assert.Equal(t, 2, ctrl.mapiStats.erroredCount)
+		})
+	}
+}
@@ -0,0 +1,841 @@
+package bootimage
+
+import (
+	"encoding/json"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	osconfigv1 "github.com/openshift/api/config/v1"
+	opv1 "github.com/openshift/api/operator/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// Annotation on the cluster-level MachineConfiguration object that, when "true", puts the controller
+	// into advisory-only mode: drift is computed and reported via conditions, but no machine resource
+	// is ever patched.
+	AdvisoryOnlyAnnotationKey = "machineconfiguration.openshift.io/boot-image-advisory-only"
+
+	// Annotation on the cluster-level MachineConfiguration object holding a comma separated list of
+	// zones. When set, only MAPI machinesets whose providerspec targets one of these zones are
+	// reconciled; all other machinesets are deferred.
+	ZonesAnnotationKey = "machineconfiguration.openshift.io/boot-image-zones"
+
+	// Annotation on the cluster-level MachineConfiguration object holding a JSON object that maps a
+	// platform type to the dot separated path of the boot image field in its machineset providerspec,
+	// e.g. {"Nutanix": "image.name"}. This allows boot images referenced via a Secret to be applied on
+	// platforms that the controller does not natively support. Natively supported platforms are ignored.
+	ProviderSpecImagePathsAnnotationKey = "machineconfiguration.openshift.io/boot-image-providerspec-paths"
+
+	// Annotation on the cluster-level MachineConfiguration object that, when "true", gates boot image
+	// updates on approval: drift is reported as in advisory-only mode until ApprovedAnnotationKey is set.
+	ApprovalRequiredAnnotationKey = "machineconfiguration.openshift.io/boot-image-approval-required"
+
+	// Annotation on the cluster-level MachineConfiguration object that, when "true", approves a single
+	// pass of boot image updates while ApprovalRequiredAnnotationKey is set. The controller removes it
+	// once the updates have been applied.
+	ApprovedAnnotationKey = "machineconfiguration.openshift.io/boot-image-approved"
+
+	// Annotation on the cluster-level MachineConfiguration object holding a comma separated list of
+	// platform types, e.g. "AWS,Azure". MAPI machinesets on a listed platform are deferred until the
+	// platform is removed from the list.
+	PausedPlatformsAnnotationKey = "machineconfiguration.openshift.io/boot-image-paused-platforms"
+
+	// Annotation on the cluster-level MachineConfiguration object holding an integer percentage, from 1
+	// to 100, of the managed MAPI machinesets that may be updated in a single pass. Machinesets beyond the
+	// budget are deferred to a later pass. A budget-limited rollout walks the machinesets in name order,
+	// and resumes after the last machineset it updated, recorded in BootImageRolloutStateConfigMapName.
+	ReconcileBudgetPercentAnnotationKey = "machineconfiguration.openshift.io/boot-image-reconcile-budget-percent"
+
+	// Annotation on the cluster-level MachineConfiguration object holding a positive integer. While the
+	// number of MAPI machines being replaced (provisioning or deleting) reaches this value, boot image
+	// updates of further MAPI machinesets are held off.
+	MaxInFlightReplacementsAnnotationKey = "machineconfiguration.openshift.io/boot-image-max-inflight-replacements"
+
+	// Annotation on the cluster-level MachineConfiguration object holding the name of a single MAPI
+	// machineset. When set, only that machineset is reconciled and all others are left untouched.
+	TargetMachineSetAnnotationKey = "machineconfiguration.openshift.io/boot-image-target-machineset"
+
+	// Annotation on the cluster-level MachineConfiguration object holding an integer percentage, from 1
+	// to 100. When the architecture of more than this percentage of the enrolled MAPI machinesets cannot
+	// be determined, the sync is aborted without updating any MAPI machineset, as this points to a
+	// systemic problem such as a changed annotation format. Defaults to DefaultArchSafeModeThresholdPercent;
+	// 100 disables the check.
+	ArchSafeModeThresholdPercentAnnotationKey = "machineconfiguration.openshift.io/boot-image-arch-safe-mode-threshold-percent"
+
+	// Default for ArchSafeModeThresholdPercentAnnotationKey
+	DefaultArchSafeModeThresholdPercent = 50
+
+	// Annotation on the cluster-level MachineConfiguration object holding an http or https URL. Before a
+	// MAPI machineset's boot image is updated, a JSON description of the change is posted to it; a
+	// non-2xx response aborts the update of that machineset.
+	PreUpdateWebhookAnnotationKey = "machineconfiguration.openshift.io/boot-image-pre-update-webhook"
+
+	// Annotation on the cluster-level MachineConfiguration object holding an http or https URL. After a
+	// MAPI machineset's boot image is updated, a JSON description of the change is posted to it. The
+	// response does not affect the update.
+	PostUpdateWebhookAnnotationKey = "machineconfiguration.openshift.io/boot-image-post-update-webhook"
+
+	// Annotation on the cluster-level MachineConfiguration object holding a duration, e.g. "2h". After a
+	// MAPI machineset's boot image is updated, no other MAPI machineset is updated until this interval
+	// has passed, spreading the rollout over time. The time of the last update is read from the
+	// BootImageUpdatedAtAnnotationKey annotations, so the soak carries over controller restarts.
+	SoakIntervalAnnotationKey = "machineconfiguration.openshift.io/boot-image-soak-interval"
+
+	// Annotation on the cluster-level MachineConfiguration object that, when "true", has the controller
+	// report the boot image status of each MAPI machineset in its BootImageStatusAnnotationKey annotation.
+	// The aggregate conditions on the MachineConfiguration are reported regardless.
+	MachineSetStatusAnnotationKey = "machineconfiguration.openshift.io/boot-image-machineset-status"
+
+	// Annotation on the cluster-level MachineConfiguration object holding a comma-separated list of
+	// <platform>=<limit> pairs, e.g. "AWS=20,GCP=40". Each limit is the number of MAPI machinesets that
+	// may be updated per minute on that platform. Throttling is opt-in: platforms that are not listed, or
+	// are listed with a limit of 0, are not throttled.
+	PlatformRateLimitsAnnotationKey = "machineconfiguration.openshift.io/boot-image-platform-rate-limits"
+
+	// Annotation on the cluster-level MachineConfiguration object which, when set to "true", has the
+	// controller remove the sync annotations it finds on MAPI machinesets that are not enrolled for boot
+	// image updates. Such orphaned annotations are reported regardless.
+	RemoveOrphanedAnnotationsAnnotationKey = "machineconfiguration.openshift.io/boot-image-remove-orphaned-annotations"
+
+	// Annotation on the cluster-level MachineConfiguration object holding the positive number of recent
+	// sync summaries kept by the controller and served on BootImageSyncHistoryPath. Defaults to
+	// DefaultSyncHistorySize.
+	SyncHistorySizeAnnotationKey = "machineconfiguration.openshift.io/boot-image-sync-history-size"
+
+	// Default for SyncHistorySizeAnnotationKey
+	DefaultSyncHistorySize = 20
+
+	// Annotation on the cluster-level MachineConfiguration object holding the maximum length, in bytes,
+	// of the messages of the conditions set by the controller, from MinConditionMessageMaxLength to
+	// DefaultConditionMessageMaxLength, the limit of the API. The Degraded condition keeps its counts and
+	// leading errors when truncated.
+	ConditionMessageMaxLengthAnnotationKey = "machineconfiguration.openshift.io/boot-image-condition-message-max-length"
+
+	// Bounds of ConditionMessageMaxLengthAnnotationKey; the maximum is also the default
+	MinConditionMessageMaxLength     = 256
+	DefaultConditionMessageMaxLength = 32768
+
+	// Annotation on the cluster-level MachineConfiguration object holding a duration, e.g. "2m". When a
+	// MAPI machineset fails to sync with a transient error, such as an API server timeout, the sync is
+	// retried after this delay. Defaults to DefaultTransientErrorRequeueDelay.
+	TransientErrorRequeueDelayAnnotationKey = "machineconfiguration.openshift.io/boot-image-transient-error-requeue-delay"
+
+	// Default for TransientErrorRequeueDelayAnnotationKey
+	DefaultTransientErrorRequeueDelay = 30 * time.Second
+
+	// Annotation on the cluster-level MachineConfiguration object holding an integer percentage, from 1
+	// to 100. While fewer than this percentage of the cluster's nodes are Ready, boot image updates of
+	// further MAPI machinesets are held off, so that an ongoing outage is not compounded by machine churn.
+	MinNodeReadinessPercentAnnotationKey = "machineconfiguration.openshift.io/boot-image-min-node-readiness-percent"
+
+	// Annotation on the cluster-level MachineConfiguration object; when "true", only the enrolled MAPI
+	// machinesets that opt in with BootImageManagedAnnotationKey are reconciled, and all others are
+	// counted as unmanaged.
+	OptInAnnotationKey = "machineconfiguration.openshift.io/boot-image-opt-in"
+
+	// Annotation on the cluster-level MachineConfiguration object; when "true", the Progressing condition
+	// reports how long the last sync of MAPI machinesets took. The duration is always exported as a metric.
+	ReportSyncDurationAnnotationKey = "machineconfiguration.openshift.io/boot-image-report-sync-duration"
+
+	// Annotation on the cluster-level MachineConfiguration object naming a Secret in the machine API
+	// namespace that holds the boot image to apply to all MAPI machinesets, under BootImageSecretKey. It
+	// takes precedence over the boot images configmap, while a machineset's own BootImageSecretRefAnnotationKey
+	// takes precedence over it.
+	ClusterBootImageSecretRefAnnotationKey = "machineconfiguration.openshift.io/boot-image-cluster-secret-ref"
+
+	// Annotation on the cluster-level MachineConfiguration object holding an RFC 3339 timestamp, e.g.
+	// "2024-06-01T18:00:00Z". Until then, sync errors are logged and counted as usual but the Degraded
+	// condition is held false, with a note, so that a known maintenance event does not raise alerts.
+	SuppressDegradedUntilAnnotationKey = "machineconfiguration.openshift.io/boot-image-suppress-degraded-until"
+
+	// Annotation on the cluster-level MachineConfiguration object holding a duration, e.g. "10m". A boot
+	// image resolution of a MAPI machineset that found nothing to update is reused by the syncs within this
+	// duration, saving external lookups such as vCenter queries. Changes to the boot images configmap
+	// invalidate all cached resolutions. Unset disables the cache.
+	ImageResolutionCacheTTLAnnotationKey = "machineconfiguration.openshift.io/boot-image-resolution-cache-ttl"
+
+	// Annotation on the cluster-level MachineConfiguration object holding a duration, e.g. "1h". When the
+	// machine resources have been progressing for longer than this, without all of them finishing, a
+	// warning event names the MAPI machinesets not yet synced, as the rollout is likely stuck.
+	StuckRolloutTimeoutAnnotationKey = "machineconfiguration.openshift.io/boot-image-stuck-rollout-timeout"
+
+	// Annotation on the cluster-level MachineConfiguration object holding a comma separated list of MAPI
+	// machine providerspec apiVersions that are accepted in addition to the recognized ones, e.g. during a
+	// migration to a new version. Their providerspecs are decoded as the machine.openshift.io/v1beta1 types
+	// of the platform, so only compatible versions should be listed.
+	ExtraProviderSpecAPIVersionsAnnotationKey = "machineconfiguration.openshift.io/boot-image-extra-providerspec-api-versions"
+
+	// Annotation on the cluster-level MachineConfiguration object that, when set to "true", defers boot
+	// image updates while the boot images configmap advertises different OS versions for different
+	// architectures, e.g. while a multi-arch stream is only partially published.
+	RequireArchConsistencyAnnotationKey = "machineconfiguration.openshift.io/boot-image-require-arch-consistency"
+
+	// Annotation on the cluster-level MachineConfiguration object holding a positive integer. A MAPI
+	// machineset that fails this many consecutive syncs or fewer is logged and retried, but is not yet
+	// counted as errored or reported by the Degraded condition, so that a single transient failure does
+	// not raise an alert.
+	ErrorGraceCountAnnotationKey = "machineconfiguration.openshift.io/boot-image-error-grace-count"
+
+	// Annotation on the cluster-level MachineConfiguration object holding a comma separated list of
+	// platform types, e.g. "AWS,GCP". Only the MAPI machinesets on a listed platform are reconciled, and
+	// all others are counted as unmanaged. All platforms are managed if the list is empty.
+	ManagedPlatformsAnnotationKey = "machineconfiguration.openshift.io/boot-image-managed-platforms"
+
+	// Annotation on the cluster-level MachineConfiguration object that, when set to "true", defaults MAPI
+	// machinesets whose architecture annotation cannot be parsed to the architecture of the cluster nodes,
+	// rather than erroring. It only applies to clusters confirmed to be single-arch, by both the
+	// ClusterVersion and all nodes sharing a single architecture; multi-arch clusters remain strict.
+	DefaultSingleArchAnnotationKey = "machineconfiguration.openshift.io/boot-image-default-single-arch"
+
+	// Annotation on the cluster-level MachineConfiguration object that, when set to "true", verifies that
+	// a stream image exists on the cloud provider before any MAPI machineset is updated to it. A missing
+	// image degrades the machinesets it would be applied to. This is opt-in as it calls the provider's
+	// APIs. It is ignored unless an image verifier is registered with SetImageVerifier, and updates on a
+	// platform without a verifier are refused while it is in effect.
+	VerifyStreamImagesAnnotationKey = "machineconfiguration.openshift.io/boot-image-verify-stream-images"
+
+	// Annotation on the cluster-level MachineConfiguration object holding a comma separated list of
+	// machineset groups, e.g. "team-a,team-b". The MAPI machinesets whose BootImageGroupLabelKey names a
+	// listed group are opted out of boot image updates together, and are counted as unmanaged.
+	OptedOutGroupsAnnotationKey = "machineconfiguration.openshift.io/boot-image-opted-out-groups"
+
+	// Annotation on the cluster-level MachineConfiguration object holding an arbitrary nonce, e.g. a
+	// timestamp. Changing it triggers a single full resync of all machine resources. It is not a knob, as
+	// it does not tune the controller, and does not trigger the resync of a knob change.
+	ResyncNonceAnnotationKey = "machineconfiguration.openshift.io/boot-image-resync-nonce"
+
+	// Annotation on the cluster-level MachineConfiguration object holding a positive integer. When set,
+	// a MAPI machineset is rolled back to the providerspec it had before its last boot image update once
+	// this many of the machines created since the update fail to boot, and its boot image updates are
+	// frozen until BootImageRolledBackAtAnnotationKey is removed from it.
+	AutoRollbackBootFailuresAnnotationKey = "machineconfiguration.openshift.io/boot-image-auto-rollback-boot-failures"
+
+	// Annotation on the cluster-level MachineConfiguration object holding a duration, e.g. "45m". A
+	// machine created since the boot image update of its machineset that has not reached the Running
+	// phase within this duration counts as a boot failure. Defaults to DefaultAutoRollbackBootTimeout.
+	AutoRollbackBootTimeoutAnnotationKey = "machineconfiguration.openshift.io/boot-image-auto-rollback-boot-timeout"
+
+	// Default for AutoRollbackBootTimeoutAnnotationKey
+	DefaultAutoRollbackBootTimeout = 30 * time.Minute
+)
+
+// bootImageKnobAnnotationKeys is the set of MachineConfiguration annotations that tune the controller.
+// A change to any of these triggers a reconciliation.
+var bootImageKnobAnnotationKeys = []string{
+	AdvisoryOnlyAnnotationKey,
+	ZonesAnnotationKey,
+	ProviderSpecImagePathsAnnotationKey,
+	ApprovalRequiredAnnotationKey,
+	ApprovedAnnotationKey,
+	PausedPlatformsAnnotationKey,
+	ReconcileBudgetPercentAnnotationKey,
+	MaxInFlightReplacementsAnnotationKey,
+	TargetMachineSetAnnotationKey,
+	ArchSafeModeThresholdPercentAnnotationKey,
+	PreUpdateWebhookAnnotationKey,
+	PostUpdateWebhookAnnotationKey,
+	SoakIntervalAnnotationKey,
+	MachineSetStatusAnnotationKey,
+	PlatformRateLimitsAnnotationKey,
+	RemoveOrphanedAnnotationsAnnotationKey,
+	SyncHistorySizeAnnotationKey,
+	ConditionMessageMaxLengthAnnotationKey,
+	TransientErrorRequeueDelayAnnotationKey,
+	MinNodeReadinessPercentAnnotationKey,
+	OptInAnnotationKey,
+	ReportSyncDurationAnnotationKey,
+	ClusterBootImageSecretRefAnnotationKey,
+	SuppressDegradedUntilAnnotationKey,
+	ImageResolutionCacheTTLAnnotationKey,
+	StuckRolloutTimeoutAnnotationKey,
+	ExtraProviderSpecAPIVersionsAnnotationKey,
+	RequireArchConsistencyAnnotationKey,
+	ErrorGraceCountAnnotationKey,
+	ManagedPlatformsAnnotationKey,
+	DefaultSingleArchAnnotationKey,
+	VerifyStreamImagesAnnotationKey,
+	OptedOutGroupsAnnotationKey,
+	AutoRollbackBootFailuresAnnotationKey,
+	AutoRollbackBootTimeoutAnnotationKey,
+}
+
+// bootImageKnobs holds controller settings read from annotations on the cluster-level
+// MachineConfiguration object. The zero value is the default behavior.
+type bootImageKnobs struct {
+	advisoryOnly bool
+	// awaitingApproval is set when updates require approval and none was given; it implies advisoryOnly
+	awaitingApproval bool
+	// approved is set when a single pass of updates has been approved
+	approved bool
+	// zones restricts reconciliation to machinesets in these zones; nil means all zones
+	zones []string
+	// providerSpecImagePaths holds the boot image field path, split into its fields, per platform
+	providerSpecImagePaths map[osconfigv1.PlatformType][]string
+	// pausedPlatforms lists the platforms on which reconciliation is paused
+	pausedPlatforms []osconfigv1.PlatformType
+	// budgetPercent is the percentage of machinesets that may be updated per pass; 0 means no limit
+	budgetPercent int
+	// maxInFlightReplacements is the number of in-flight machine replacements at which updates are held; 0 means no limit
+	maxInFlightReplacements int
+	// targetMachineSet restricts reconciliation to the named MAPI machineset; empty means all machinesets
+	targetMachineSet string
+	// archSafeModePercent overrides DefaultArchSafeModeThresholdPercent; 0 means the default is used
+	archSafeModePercent int
+	// preUpdateWebhook and postUpdateWebhook are the URLs called around machineset updates; empty means none
+	preUpdateWebhook  string
+	postUpdateWebhook string
+	// soakInterval is the minimum time between two MAPI machineset updates; 0 means no soak
+	soakInterval time.Duration
+	// reportMachineSetStatus enables the per-machineset boot image status annotation
+	reportMachineSetStatus bool
+	// platformRateLimits holds the update rate limit of each throttled platform
+	platformRateLimits map[osconfigv1.PlatformType]int
+	// removeOrphanedAnnotations enables the removal of sync annotations from unenrolled MAPI machinesets
+	removeOrphanedAnnotations bool
+	// syncHistorySize is the number of recent sync summaries kept; 0 means DefaultSyncHistorySize
+	syncHistorySize int
+	// maxConditionMessageLength bounds condition messages; 0 means DefaultConditionMessageMaxLength
+	maxConditionMessageLength int
+	// transientErrorRequeueDelay is the delay before retrying a sync after a transient machineset error;
+	// 0 means DefaultTransientErrorRequeueDelay
+	transientErrorRequeueDelay time.Duration
+	// minNodeReadinessPercent is the percentage of Ready nodes below which updates are held; 0 means no gate
+	minNodeReadinessPercent int
+	// optIn restricts reconciliation to MAPI machinesets annotated with BootImageManagedAnnotationKey
+	optIn bool
+	// reportSyncDuration enables reporting the duration of the last MAPI machineset sync in the Progressing condition
+	reportSyncDuration bool
+	// clusterBootImageSecretRef names the Secret holding the cluster-wide boot image; empty means none
+	clusterBootImageSecretRef string
+	// suppressDegradedUntil is the end of the Degraded condition suppression window; zero means no window
+	suppressDegradedUntil time.Time
+	// imageResolutionCacheTTL is how long boot image resolutions are reused; 0 disables the cache
+	imageResolutionCacheTTL time.Duration
+	// stuckRolloutTimeout is how long machine resources may be progressing before the rollout is reported as stuck; 0 means never
+	stuckRolloutTimeout time.Duration
+	// extraProviderSpecAPIVersions are accepted in addition to providerSpecAPIVersions; nil means none
+	extraProviderSpecAPIVersions []string
+	// requireArchConsistency defers updates while the stream data versions differ between architectures
+	requireArchConsistency bool
+	// errorGraceCount is the number of consecutive failed syncs of a machineset that are not reported as errors
+	errorGraceCount int
+	// managedPlatforms lists the platforms whose machinesets are reconciled; nil means all platforms
+	managedPlatforms []osconfigv1.PlatformType
+	// defaultSingleArch defaults unparseable machineset architectures to the node architecture of single-arch clusters
+	defaultSingleArch bool
+	// verifyStreamImages checks that stream images exist on the provider before they are applied
+	verifyStreamImages bool
+	// optedOutGroups lists the machineset groups that are left unmanaged; nil means none
+	optedOutGroups []string
+	// autoRollbackBootFailures is the number of boot failures that rolls back a machineset; 0 disables auto-rollback
+	autoRollbackBootFailures int
+	// autoRollbackBootTimeout is how long a new machine may take to boot; 0 means DefaultAutoRollbackBootTimeout
+	autoRollbackBootTimeout time.Duration
+}
+
+// effectiveBootImageConfig is the JSON representation of the knobs in effect, after defaults are applied
+// and malformed values are discarded, served on BootImageEffectiveConfigPath.
+type effectiveBootImageConfig struct {
+	StreamConfigMapKey           string            `json:"streamConfigMapKey"`
+	AdvisoryOnly                 bool              `json:"advisoryOnly"`
+	AwaitingApproval             bool              `json:"awaitingApproval"`
+	Approved                     bool              `json:"approved"`
+	Zones                        []string          `json:"zones"`
+	ProviderSpecImagePaths       map[string]string `json:"providerSpecImagePaths"`
+	PausedPlatforms              []string          `json:"pausedPlatforms"`
+	ReconcileBudgetPercent       int               `json:"reconcileBudgetPercent"`
+	MaxInFlightReplacements      int               `json:"maxInFlightReplacements"`
+	TargetMachineSet             string            `json:"targetMachineSet"`
+	ArchSafeModeThresholdPercent int               `json:"archSafeModeThresholdPercent"`
+	PreUpdateWebhook             string            `json:"preUpdateWebhook"`
+	PostUpdateWebhook            string            `json:"postUpdateWebhook"`
+	SoakInterval                 string            `json:"soakInterval"`
+	ReportMachineSetStatus       bool              `json:"reportMachineSetStatus"`
+	PlatformRateLimits           map[string]int    `json:"platformRateLimits"`
+	RemoveOrphanedAnnotations    bool              `json:"removeOrphanedAnnotations"`
+	SyncHistorySize              int               `json:"syncHistorySize"`
+	ConditionMessageMaxLength    int               `json:"conditionMessageMaxLength"`
+	TransientErrorRequeueDelay   string            `json:"transientErrorRequeueDelay"`
+	MinNodeReadinessPercent      int               `json:"minNodeReadinessPercent"`
+	OptIn                        bool              `json:"optIn"`
+	ReportSyncDuration           bool              `json:"reportSyncDuration"`
+	ClusterBootImageSecretRef    string            `json:"clusterBootImageSecretRef"`
+	SuppressDegradedUntil        string            `json:"suppressDegradedUntil"`
+	ImageResolutionCacheTTL      string            `json:"imageResolutionCacheTTL"`
+	StuckRolloutTimeout          string            `json:"stuckRolloutTimeout"`
+	ExtraProviderSpecAPIVersions []string          `json:"extraProviderSpecAPIVersions"`
+	RequireArchConsistency       bool              `json:"requireArchConsistency"`
+	ErrorGraceCount              int               `json:"errorGraceCount"`
+	ManagedPlatforms             []string          `json:"managedPlatforms"`
+	DefaultSingleArch            bool              `json:"defaultSingleArch"`
+	VerifyStreamImages           bool              `json:"verifyStreamImages"`
+	OptedOutGroups               []string          `json:"optedOutGroups"`
+	AutoRollbackBootFailures     int               `json:"autoRollbackBootFailures"`
+	AutoRollbackBootTimeout      string            `json:"autoRollbackBootTimeout"`
+}
+
+// effectiveConfig returns the JSON document describing these knobs, along with the stream key in use.
+// Unset list and map knobs are reported as empty rather than null.
+func (knobs bootImageKnobs) effectiveConfig(streamConfigMapKey string) (string, error) {
+	config := effectiveBootImageConfig{
+		StreamConfigMapKey:           streamConfigMapKey,
+		AdvisoryOnly:                 knobs.advisoryOnly,
+		AwaitingApproval:             knobs.awaitingApproval,
+		Approved:                     knobs.approved,
+		Zones:                        []string{},
+		ProviderSpecImagePaths:       map[string]string{},
+		PausedPlatforms:              []string{},
+		ReconcileBudgetPercent:       knobs.budgetPercent,
+		MaxInFlightReplacements:      knobs.maxInFlightReplacements,
+		TargetMachineSet:             knobs.targetMachineSet,
+		ArchSafeModeThresholdPercent: knobs.archSafeModeThreshold(),
+		PreUpdateWebhook:             knobs.preUpdateWebhook,
+		PostUpdateWebhook:            knobs.postUpdateWebhook,
+		SoakInterval:                 knobs.soakInterval.String(),
+		ReportMachineSetStatus:       knobs.reportMachineSetStatus,
+		PlatformRateLimits:           map[string]int{},
+		RemoveOrphanedAnnotations:    knobs.removeOrphanedAnnotations,
+		SyncHistorySize:              knobs.historySize(),
+		ConditionMessageMaxLength:    knobs.conditionMessageMaxLength(),
+		TransientErrorRequeueDelay:   knobs.transientRequeueDelay().String(),
+		MinNodeReadinessPercent:      knobs.minNodeReadinessPercent,
+		OptIn:                        knobs.optIn,
+		ReportSyncDuration:           knobs.reportSyncDuration,
+		ClusterBootImageSecretRef:    knobs.clusterBootImageSecretRef,
+		ImageResolutionCacheTTL:      knobs.imageResolutionCacheTTL.String(),
+		StuckRolloutTimeout:          knobs.stuckRolloutTimeout.String(),
+		ExtraProviderSpecAPIVersions: []string{},
+		RequireArchConsistency:       knobs.requireArchConsistency,
+		ErrorGraceCount:              knobs.errorGraceCount,
+		ManagedPlatforms:             []string{},
+		DefaultSingleArch:            knobs.defaultSingleArch,
+		VerifyStreamImages:           knobs.verifyStreamImages,
+		OptedOutGroups:               []string{},
+		AutoRollbackBootFailures:     knobs.autoRollbackBootFailures,
+		AutoRollbackBootTimeout:      knobs.autoRollbackTimeout().String(),
+	}
+	if !knobs.suppressDegradedUntil.IsZero() {
+		config.SuppressDegradedUntil = knobs.suppressDegradedUntil.Format(time.RFC3339)
+	}
+	config.Zones = append(config.Zones, knobs.zones...)
+	config.ExtraProviderSpecAPIVersions = append(config.ExtraProviderSpecAPIVersions, knobs.extraProviderSpecAPIVersions...)
+	config.OptedOutGroups = append(config.OptedOutGroups, knobs.optedOutGroups...)
+	for platform, fields := range knobs.providerSpecImagePaths {
+		config.ProviderSpecImagePaths[string(platform)] = strings.Join(fields, ".")
+	}
+	for _, platform := range knobs.pausedPlatforms {
+		config.PausedPlatforms = append(config.PausedPlatforms, string(platform))
+	}
+	for _, platform := range knobs.managedPlatforms {
+		config.ManagedPlatforms = append(config.ManagedPlatforms, string(platform))
+	}
+	for platform := range knobs.platformRateLimits {
+		config.PlatformRateLimits[string(platform)] = knobs.platformRateLimit(platform)
+	}
+	raw, err := json.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+	return string(raw), nil
+}
+
+// zoneAllowed returns true if machinesets in the given zone may be reconciled.
+func (knobs bootImageKnobs) zoneAllowed(zone string) bool {
+	return knobs.zones == nil || slices.Contains(knobs.zones, zone)
+}
+
+// platformPaused returns true if reconciliation is paused for machinesets on the given platform.
+func (knobs bootImageKnobs) platformPaused(platform osconfigv1.PlatformType) bool {
+	return slices.Contains(knobs.pausedPlatforms, platform)
+}
+
+// platformManaged returns true if machinesets on the given platform are reconciled.
+func (knobs bootImageKnobs) platformManaged(platform osconfigv1.PlatformType) bool {
+	return len(knobs.managedPlatforms) == 0 || slices.Contains(knobs.managedPlatforms, platform)
+}
+
+// reconcileBudget returns the number of machinesets that may be updated in a single pass, out of the
+// given number of managed machinesets. The budget is rounded down, but is always at least 1 so that
+// small fleets continue to make progress. 0 is returned if there is no budget.
+func (knobs bootImageKnobs) reconcileBudget(total int) int {
+	if knobs.budgetPercent == 0 {
+		return 0
+	}
+	return max(1, total*knobs.budgetPercent/100)
+}
+
+// archSafeModeThreshold returns the percentage of MAPI machinesets whose architecture may be unknown
+// before a sync is aborted.
+func (knobs bootImageKnobs) archSafeModeThreshold() int {
+	if knobs.archSafeModePercent == 0 {
+		return DefaultArchSafeModeThresholdPercent
+	}
+	return knobs.archSafeModePercent
+}
+
+// withdrawApproval returns the knobs with a given approval treated as if it were missing, i.e. awaiting
+// approval in advisory-only mode.
+func (knobs bootImageKnobs) withdrawApproval() bootImageKnobs {
+	knobs.approved = false
+	knobs.awaitingApproval = true
+	knobs.advisoryOnly = true
+	return knobs
+}
+
+// historySize returns the number of recent sync summaries kept.
+func (knobs bootImageKnobs) historySize() int {
+	if knobs.syncHistorySize == 0 {
+		return DefaultSyncHistorySize
+	}
+	return knobs.syncHistorySize
+}
+
+// conditionMessageMaxLength returns the maximum length of condition messages.
+func (knobs bootImageKnobs) conditionMessageMaxLength() int {
+	if knobs.maxConditionMessageLength == 0 {
+		return DefaultConditionMessageMaxLength
+	}
+	return knobs.maxConditionMessageLength
+}
+
+// transientRequeueDelay returns the delay before retrying a sync after a transient machineset error.
+func (knobs bootImageKnobs) transientRequeueDelay() time.Duration {
+	if knobs.transientErrorRequeueDelay == 0 {
+		return DefaultTransientErrorRequeueDelay
+	}
+	return knobs.transientErrorRequeueDelay
+}
+
+// autoRollbackTimeout returns how long a machine created since the boot image update of its machineset
+// may take to boot before it counts as a boot failure.
+func (knobs bootImageKnobs) autoRollbackTimeout() time.Duration {
+	if knobs.autoRollbackBootTimeout == 0 {
+		return DefaultAutoRollbackBootTimeout
+	}
+	return knobs.autoRollbackBootTimeout
+}
+
+// degradedSuppressed returns true if the Degraded condition is suppressed at the given time.
+func (knobs bootImageKnobs) degradedSuppressed(now time.Time) bool {
+	return now.Before(knobs.suppressDegradedUntil)
+}
+
+// platformRateLimit returns the number of MAPI machinesets that may be updated per minute on the
+// platform, or 0 if updates on the platform are not throttled.
+func (knobs bootImageKnobs) platformRateLimit(platform osconfigv1.PlatformType) int {
+	return knobs.platformRateLimits[platform]
+}
+
+// getBootImageKnobs parses the boot image knobs from the MachineConfiguration annotations.
+// Malformed values are logged and ignored, falling back to the default for that knob. Knobs that
+// depend on an image verifier are ignored unless one is registered in imageVerifiers.
+func getBootImageKnobs(mcop *opv1.MachineConfiguration, annotationKeyPrefix string, imageVerifiers map[osconfigv1.PlatformType]ImageVerifier) bootImageKnobs {
+	knobs := bootImageKnobs{}
+	if mcop == nil {
+		return knobs
+	}
+	annotations := mcop.GetAnnotations()
+	key := func(knobKey string) string { return annotationKey(annotationKeyPrefix, knobKey) }
+
+	knobs.advisoryOnly = parseBoolKnob(annotations, key(AdvisoryOnlyAnnotationKey))
+	if parseBoolKnob(annotations, key(ApprovalRequiredAnnotationKey)) && !knobs.advisoryOnly {
+		if parseBoolKnob(annotations, key(ApprovedAnnotationKey)) {
+			knobs.approved = true
+		} else {
+			knobs.awaitingApproval = true
+			knobs.advisoryOnly = true
+		}
+	}
+
+	if value, ok := annotations[key(ZonesAnnotationKey)]; ok {
+		zones := []string{}
+		for zone := range strings.SplitSeq(value, ",") {
+			if zone = strings.TrimSpace(zone); zone != "" {
+				zones = append(zones, zone)
+			}
+		}
+		if len(zones) == 0 {
+			klog.Warningf("Ignoring annotation %s as it does not list any zones", key(ZonesAnnotationKey))
+		} else {
+			knobs.zones = zones
+		}
+	}
+
+	if value, ok := annotations[key(ProviderSpecImagePathsAnnotationKey)]; ok {
+		knobs.providerSpecImagePaths = parseProviderSpecImagePaths(value, key(ProviderSpecImagePathsAnnotationKey))
+	}
+
+	if value, ok := annotations[key(PausedPlatformsAnnotationKey)]; ok {
+		for platform := range strings.SplitSeq(value, ",") {
+			if platform = strings.TrimSpace(platform); platform != "" && !slices.Contains(knobs.pausedPlatforms, osconfigv1.PlatformType(platform)) {
+				knobs.pausedPlatforms = append(knobs.pausedPlatforms, osconfigv1.PlatformType(platform))
+			}
+		}
+	}
+
+	if value, ok := annotations[key(ReconcileBudgetPercentAnnotationKey)]; ok {
+		percent, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || percent < 1 || percent > 100 {
+			klog.Warningf("Ignoring invalid value %q for annotation %s, expected an integer between 1 and 100", value, key(ReconcileBudgetPercentAnnotationKey))
+		} else {
+			knobs.budgetPercent = percent
+		}
+	}
+
+	if value, ok := annotations[key(MaxInFlightReplacementsAnnotationKey)]; ok {
+		limit, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || limit < 1 {
+			klog.Warningf("Ignoring invalid value %q for annotation %s, expected a positive integer", value, key(MaxInFlightReplacementsAnnotationKey))
+		} else {
+			knobs.maxInFlightReplacements = limit
+		}
+	}
+
+	knobs.targetMachineSet = strings.TrimSpace(annotations[key(TargetMachineSetAnnotationKey)])
+
+	if value, ok := annotations[key(ArchSafeModeThresholdPercentAnnotationKey)]; ok {
+		percent, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || percent < 1 || percent > 100 {
+			klog.Warningf("Ignoring invalid value %q for annotation %s, expected an integer between 1 and 100", value, key(ArchSafeModeThresholdPercentAnnotationKey))
+		} else {
+			knobs.archSafeModePercent = percent
+		}
+	}
+
+	knobs.preUpdateWebhook = parseWebhookKnob(annotations, key(PreUpdateWebhookAnnotationKey))
+	knobs.postUpdateWebhook = parseWebhookKnob(annotations, key(PostUpdateWebhookAnnotationKey))
+
+	if value, ok := annotations[key(SoakIntervalAnnotationKey)]; ok {
+		interval, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || interval <= 0 {
+			klog.Warningf("Ignoring invalid value %q for annotation %s, expected a positive duration", value, key(SoakIntervalAnnotationKey))
+		} else {
+			knobs.soakInterval = interval
+		}
+	}
+
+	knobs.reportMachineSetStatus = parseBoolKnob(annotations, key(MachineSetStatusAnnotationKey))
+
+	if value, ok := annotations[key(PlatformRateLimitsAnnotationKey)]; ok {
+		knobs.platformRateLimits = parsePlatformRateLimits(value, key(PlatformRateLimitsAnnotationKey))
+	}
+
+	knobs.removeOrphanedAnnotations = parseBoolKnob(annotations, key(RemoveOrphanedAnnotationsAnnotationKey))
+
+	if value, ok := annotations[key(SyncHistorySizeAnnotationKey)]; ok {
+		size, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || size < 1 {
+			klog.Warningf("Ignoring invalid value %q for annotation %s, expected a positive integer", value, key(SyncHistorySizeAnnotationKey))
+		} else {
+			knobs.syncHistorySize = size
+		}
+	}
+
+	if value, ok := annotations[key(ConditionMessageMaxLengthAnnotationKey)]; ok {
+		length, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || length < MinConditionMessageMaxLength || length > DefaultConditionMessageMaxLength {
+			klog.Warningf("Ignoring invalid value %q for annotation %s, expected an integer between %d and %d", value, key(ConditionMessageMaxLengthAnnotationKey), MinConditionMessageMaxLength, DefaultConditionMessageMaxLength)
+		} else {
+			knobs.maxConditionMessageLength = length
+		}
+	}
+
+	if value, ok := annotations[key(TransientErrorRequeueDelayAnnotationKey)]; ok {
+		delay, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || delay <= 0 {
+			klog.Warningf("Ignoring invalid value %q for annotation %s, expected a positive duration", value, key(TransientErrorRequeueDelayAnnotationKey))
+		} else {
+			knobs.transientErrorRequeueDelay = delay
+		}
+	}
+
+	if value, ok := annotations[key(MinNodeReadinessPercentAnnotationKey)]; ok {
+		percent, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || percent < 1 || percent > 100 {
+			klog.Warningf("Ignoring invalid value %q for annotation %s, expected an integer between 1 and 100", value, key(MinNodeReadinessPercentAnnotationKey))
+		} else {
+			knobs.minNodeReadinessPercent = percent
+		}
+	}
+
+	knobs.optIn = parseBoolKnob(annotations, key(OptInAnnotationKey))
+	knobs.reportSyncDuration = parseBoolKnob(annotations, key(ReportSyncDurationAnnotationKey))
+	knobs.clusterBootImageSecretRef = strings.TrimSpace(annotations[key(ClusterBootImageSecretRefAnnotationKey)])
+
+	if value, ok := annotations[key(SuppressDegradedUntilAnnotationKey)]; ok {
+		until, err := time.Parse(time.RFC3339, strings.TrimSpace(value))
+		if err != nil {
+			klog.Warningf("Ignoring invalid value %q for annotation %s, expected an RFC 3339 timestamp", value, key(SuppressDegradedUntilAnnotationKey))
+		} else {
+			knobs.suppressDegradedUntil = until
+		}
+	}
+
+	if value, ok := annotations[key(ImageResolutionCacheTTLAnnotationKey)]; ok {
+		ttl, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || ttl <= 0 {
+			klog.Warningf("Ignoring invalid value %q for annotation %s, expected a positive duration", value, key(ImageResolutionCacheTTLAnnotationKey))
+		} else {
+			knobs.imageResolutionCacheTTL = ttl
+		}
+	}
+
+	if value, ok := annotations[key(StuckRolloutTimeoutAnnotationKey)]; ok {
+		timeout, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || timeout <= 0 {
+			klog.Warningf("Ignoring invalid value %q for annotation %s, expected a positive duration", value, key(StuckRolloutTimeoutAnnotationKey))
+		} else {
+			knobs.stuckRolloutTimeout = timeout
+		}
+	}
+
+	if value, ok := annotations[key(ExtraProviderSpecAPIVersionsAnnotationKey)]; ok {
+		for apiVersion := range strings.SplitSeq(value, ",") {
+			if apiVersion = strings.TrimSpace(apiVersion); apiVersion != "" && !slices.Contains(knobs.extraProviderSpecAPIVersions, apiVersion) {
+				knobs.extraProviderSpecAPIVersions = append(knobs.extraProviderSpecAPIVersions, apiVersion)
+			}
+		}
+	}
+
+	knobs.requireArchConsistency = parseBoolKnob(annotations, key(RequireArchConsistencyAnnotationKey))
+
+	if value, ok := annotations[key(ErrorGraceCountAnnotationKey)]; ok {
+		count, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || count < 1 {
+			klog.Warningf("Ignoring invalid value %q for annotation %s, expected a positive integer", value, key(ErrorGraceCountAnnotationKey))
+		} else {
+			knobs.errorGraceCount = count
+		}
+	}
+
+	if value, ok := annotations[key(ManagedPlatformsAnnotationKey)]; ok {
+		for platform := range strings.SplitSeq(value, ",") {
+			if platform = strings.TrimSpace(platform); platform != "" && !slices.Contains(knobs.managedPlatforms, osconfigv1.PlatformType(platform)) {
+				knobs.managedPlatforms = append(knobs.managedPlatforms, osconfigv1.PlatformType(platform))
+			}
+		}
+	}
+
+	knobs.defaultSingleArch = parseBoolKnob(annotations, key(DefaultSingleArchAnnotationKey))
+	if parseBoolKnob(annotations, key(VerifyStreamImagesAnnotationKey)) {
+		if len(imageVerifiers) == 0 {
+			klog.Warningf("Ignoring annotation %s as no image verifier is registered", key(VerifyStreamImagesAnnotationKey))
+		} else {
+			knobs.verifyStreamImages = true
+		}
+	}
+
+	if value, ok := annotations[key(OptedOutGroupsAnnotationKey)]; ok {
+		for group := range strings.SplitSeq(value, ",") {
+			if group = strings.TrimSpace(group); group != "" && !slices.Contains(knobs.optedOutGroups, group) {
+				knobs.optedOutGroups = append(knobs.optedOutGroups, group)
+			}
+		}
+	}
+
+	if value, ok := annotations[key(AutoRollbackBootFailuresAnnotationKey)]; ok {
+		count, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || count < 1 {
+			klog.Warningf("Ignoring invalid value %q for annotation %s, expected a positive integer", value, key(AutoRollbackBootFailuresAnnotationKey))
+		} else {
+			knobs.autoRollbackBootFailures = count
+		}
+	}
+
+	if value, ok := annotations[key(AutoRollbackBootTimeoutAnnotationKey)]; ok {
+		timeout, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || timeout <= 0 {
+			klog.Warningf("Ignoring invalid value %q for annotation %s, expected a positive duration", value, key(AutoRollbackBootTimeoutAnnotationKey))
+		} else {
+			knobs.autoRollbackBootTimeout = timeout
+		}
+	}
+
+	return knobs
+}
+
+// parseBoolKnob parses a boolean knob annotation, returning false if it is unset or invalid.
+func parseBoolKnob(annotations map[string]string, key string) bool {
+	value, ok := annotations[key]
+	if !ok {
+		return false
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		klog.Warningf("Ignoring invalid value %q for annotation %s: %v", value, key, err)
+		return false
+	}
+	return parsed
+}
+
+// parseWebhookKnob parses a webhook URL knob annotation, returning an empty string if it is unset or invalid.
+func parseWebhookKnob(annotations map[string]string, key string) string {
+	value, ok := annotations[key]
+	if !ok {
+		return ""
+	}
+	webhookURL, err := parseUpdateWebhookURL(strings.TrimSpace(value))
+	if err != nil {
+		klog.Warningf("Ignoring invalid value %q for annotation %s: %v", value, key, err)
+		return ""
+	}
+	return webhookURL
+}
+
+// parseProviderSpecImagePaths parses the value of the ProviderSpecImagePathsAnnotationKey annotation,
+// named by key. Invalid entries are logged and ignored.
+func parseProviderSpecImagePaths(value, key string) map[osconfigv1.PlatformType][]string {
+	rawPaths := map[osconfigv1.PlatformType]string{}
+	if err := json.Unmarshal([]byte(value), &rawPaths); err != nil {
+		klog.Warningf("Ignoring invalid value for annotation %s: %v", key, err)
+		return nil
+	}
+	paths := map[osconfigv1.PlatformType][]string{}
+	for platform, rawPath := range rawPaths {
+		if isNativelySupportedPlatform(platform) {
+			klog.Warningf("Ignoring providerspec image path for platform %s in annotation %s, as it is natively supported", platform, key)
+			continue
+		}
+		fields := strings.Split(rawPath, ".")
+		if slices.Contains(fields, "") {
+			klog.Warningf("Ignoring invalid providerspec image path %q for platform %s in annotation %s", rawPath, platform, key)
+			continue
+		}
+		paths[platform] = fields
+	}
+	return paths
+}
+
+// parsePlatformRateLimits parses the value of the PlatformRateLimitsAnnotationKey annotation, named by
+// key. Invalid entries are logged and ignored.
+func parsePlatformRateLimits(value, key string) map[osconfigv1.PlatformType]int {
+	limits := map[osconfigv1.PlatformType]int{}
+	for entry := range strings.SplitSeq(value, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		platform, rawLimit, found := strings.Cut(entry, "=")
+		limit, err := strconv.Atoi(strings.TrimSpace(rawLimit))
+		if !found || strings.TrimSpace(platform) == "" || err != nil || limit < 0 {
+			klog.Warningf("Ignoring invalid entry %q in annotation %s, expected <platform>=<non-negative integer>", entry, key)
+			continue
+		}
+		limits[osconfigv1.PlatformType(strings.TrimSpace(platform))] = limit
+	}
+	return limits
+}
+
+// bootImageKnobsChanged returns true if any of the boot image knob annotations differ between
+// the two MachineConfiguration objects, with the knob annotations under annotationKeyPrefix.
+func bootImageKnobsChanged(oldMCOP, newMCOP *opv1.MachineConfiguration, annotationKeyPrefix string) bool {
+	for _, knobKey := range bootImageKnobAnnotationKeys {
+		key := annotationKey(annotationKeyPrefix, knobKey)
+		if oldMCOP.GetAnnotations()[key] != newMCOP.GetAnnotations()[key] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,1272 @@
+package bootimage
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	osconfigv1 "github.com/openshift/api/config/v1"
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	opv1 "github.com/openshift/api/operator/v1"
+	configlistersv1 "github.com/openshift/client-go/config/listers/config/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/version"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestAdvisoryOnlyMode(t *testing.T) {
+	cases := []struct {
+		name              string
+		platform          osconfigv1.PlatformType
+		annotations       map[string]string
+		expectPatches     int
+		expectOutOfDate   int
+		expectUnevaluated int
+	}{
+		{
+			name:            "advisory-only reports drift without patching",
+			annotations:     map[string]string{AdvisoryOnlyAnnotationKey: "true"},
+			expectPatches:   0,
+			expectOutOfDate: 2,
+		},
+		{
+			name:            "advisory-only disabled patches machinesets",
+			annotations:     map[string]string{AdvisoryOnlyAnnotationKey: "false"},
+			expectPatches:   2,
+			expectOutOfDate: 0,
+		},
+		{
+			name:            "invalid value falls back to default",
+			annotations:     map[string]string{AdvisoryOnlyAnnotationKey: "maybe"},
+			expectPatches:   2,
+			expectOutOfDate: 0,
+		},
+		{
+			// Evaluating vSphere machinesets requires importing templates into vCenter
+			name:              "advisory-only does not evaluate vSphere machinesets",
+			platform:          osconfigv1.VSpherePlatformType,
+			annotations:       map[string]string{AdvisoryOnlyAnnotationKey: "true"},
+			expectPatches:     0,
+			expectUnevaluated: 3,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			machineSets := []*machinev1beta1.MachineSet{
+				getGCPMachineSet("machineset-a", testGCPOldImage),
+				getGCPMachineSet("machineset-b", testGCPOldImage),
+				getGCPMachineSet("machineset-c", testGCPStreamImage),
+			}
+			platform := tc.platform
+			if platform == "" {
+				platform = osconfigv1.GCPPlatformType
+			}
+			ctrl := newTestController(t, platform, machineSets, nil)
+			ctrl.setKnobs(t, tc.annotations)
+
+			require.NoError(t, ctrl.syncAll("test"))
+
+			assert.Equal(t, tc.expectPatches, ctrl.countMachineSetPatches())
+			assert.Equal(t, tc.expectOutOfDate, ctrl.mapiStats.outOfDateCount)
+			assert.Equal(t, tc.expectUnevaluated, ctrl.mapiStats.unevaluatedCount)
+			assert.Equal(t, tc.expectUnevaluated, ctrl.mapiStats.skippedCount)
+			// No secret writes (e.g. ignition stub upgrades) may happen in advisory-only mode
+			if tc.expectPatches == 0 {
+				for _, action := range ctrl.kubeClient.Actions() {
+					assert.Equal(t, "get", action.GetVerb(), "unexpected %s on %s", action.GetVerb(), action.GetResource().Resource)
+				}
+			}
+
+			progressing := ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateProgressing)
+			assert.Equal(t, v1.ConditionFalse, progressing.Status)
+			if tc.expectOutOfDate > 0 {
+				assert.Contains(t, progressing.Message, "Advisory-only mode")
+				assert.Contains(t, progressing.Message, "(2 out of date)")
+			} else {
+				assert.NotContains(t, progressing.Message, "out of date")
+			}
+			if tc.expectUnevaluated > 0 {
+				assert.Contains(t, progressing.Message, "Reconciled 0 of 3 MAPI MachineSets (3 skipped) (3 not evaluated)")
+			} else {
+				assert.NotContains(t, progressing.Message, "not evaluated")
+			}
+			degraded := ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateDegraded)
+			assert.Equal(t, v1.ConditionFalse, degraded.Status)
+		})
+	}
+}
+
+func TestZoneRestriction(t *testing.T) {
+	cases := []struct {
+		name           string
+		annotations    map[string]string
+		expectUpdated  []string
+		expectDeferred []string
+	}{
+		{
+			name:          "no zone restriction reconciles all machinesets",
+			annotations:   map[string]string{},
+			expectUpdated: []string{"machineset-a", "machineset-b", "machineset-c"},
+		},
+		{
+			name:           "machinesets in excluded zones are deferred",
+			annotations:    map[string]string{ZonesAnnotationKey: "us-central1-a, us-central1-c"},
+			expectUpdated:  []string{"machineset-a", "machineset-c"},
+			expectDeferred: []string{"machineset-b"},
+		},
+		{
+			name:           "single zone rollout",
+			annotations:    map[string]string{ZonesAnnotationKey: "us-central1-b"},
+			expectUpdated:  []string{"machineset-b"},
+			expectDeferred: []string{"machineset-a", "machineset-c"},
+		},
+		{
+			name:          "zone list without zones is ignored",
+			annotations:   map[string]string{ZonesAnnotationKey: " , "},
+			expectUpdated: []string{"machineset-a", "machineset-b", "machineset-c"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			machineSets := []*machinev1beta1.MachineSet{
+				setGCPMachineSetZone(t, getGCPMachineSet("machineset-a", testGCPOldImage), "us-central1-a"),
+				setGCPMachineSetZone(t, getGCPMachineSet("machineset-b", testGCPOldImage), "us-central1-b"),
+				setGCPMachineSetZone(t, getGCPMachineSet("machineset-c", testGCPOldImage), "us-central1-c"),
+			}
+			ctrl := newTestController(t, osconfigv1.GCPPlatformType, machineSets, nil)
+			ctrl.setKnobs(t, tc.annotations)
+
+			require.NoError(t, ctrl.syncAll("test"))
+
+			assert.Equal(t, len(tc.expectUpdated), ctrl.countMachineSetPatches())
+			for _, name := range tc.expectUpdated {
+				assert.Equal(t, testGCPStreamImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, name)))
+			}
+			for _, name := range tc.expectDeferred {
+				assert.Equal(t, testGCPOldImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, name)))
+			}
+			assert.Equal(t, len(tc.expectDeferred), ctrl.mapiStats.deferredCount)
+
+			progressing := ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateProgressing)
+			assert.Equal(t, v1.ConditionFalse, progressing.Status)
+			if len(tc.expectDeferred) > 0 {
+				assert.Contains(t, progressing.Message, fmt.Sprintf("(%d deferred)", len(tc.expectDeferred)))
+			} else {
+				assert.NotContains(t, progressing.Message, "deferred")
+			}
+			degraded := ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateDegraded)
+			assert.Equal(t, v1.ConditionFalse, degraded.Status)
+		})
+	}
+}
+
+func TestProviderSpecImagePath(t *testing.T) {
+	const secretImage = "rhcos-private-new"
+	bootImageSecret := &corev1.Secret{
+		ObjectMeta: v1.ObjectMeta{Name: "boot-image-secret", Namespace: MachineAPINamespace},
+		Data: map[string][]byte{
+			BootImageSecretKey: []byte(secretImage),
+		},
+	}
+	imagePaths := `{"Nutanix": "image.name", "GCP": "disks"}`
+
+	cases := []struct {
+		name           string
+		annotations    map[string]string
+		providerSpec   string
+		expectedSpec   string
+		expectError    string
+		expectedReason MachineSetSkipReason
+	}{
+		{
+			name:         "configured path is updated",
+			annotations:  map[string]string{ProviderSpecImagePathsAnnotationKey: imagePaths},
+			providerSpec: `{"image":{"name":"rhcos-private-old","type":"name"},"vcpus":4}`,
+			expectedSpec: `{"image":{"name":"rhcos-private-new","type":"name"},"vcpus":4}`,
+		},
+		{
+			name:         "configured path already up to date",
+			annotations:  map[string]string{ProviderSpecImagePathsAnnotationKey: imagePaths},
+			providerSpec: `{"image":{"name":"rhcos-private-new","type":"name"}}`,
+			expectedSpec: `{"image":{"name":"rhcos-private-new","type":"name"}}`,
+		},
+		{
+			name:         "configured path does not exist",
+			annotations:  map[string]string{ProviderSpecImagePathsAnnotationKey: imagePaths},
+			providerSpec: `{"template":"rhcos-private-old"}`,
+			expectedSpec: `{"template":"rhcos-private-old"}`,
+			expectError:  "providerspec image path image.name not found",
+		},
+		{
+			name:         "configured path is not a string field",
+			annotations:  map[string]string{ProviderSpecImagePathsAnnotationKey: imagePaths},
+			providerSpec: `{"image":{"name":["rhcos-private-old"]}}`,
+			expectedSpec: `{"image":{"name":["rhcos-private-old"]}}`,
+			expectError:  "is not a string field",
+		},
+		{
+			name:           "no configured path leaves the platform unsupported",
+			annotations:    map[string]string{ProviderSpecImagePathsAnnotationKey: `{"GCP": "disks"}`},
+			providerSpec:   `{"image":{"name":"rhcos-private-old","type":"name"}}`,
+			expectedSpec:   `{"image":{"name":"rhcos-private-old","type":"name"}}`,
+			expectedReason: SkipReasonUnsupportedPlatform,
+		},
+		{
+			name:           "invalid configuration is ignored",
+			annotations:    map[string]string{ProviderSpecImagePathsAnnotationKey: `{"Nutanix": "image..name"}`},
+			providerSpec:   `{"image":{"name":"rhcos-private-old","type":"name"}}`,
+			expectedSpec:   `{"image":{"name":"rhcos-private-old","type":"name"}}`,
+			expectedReason: SkipReasonUnsupportedPlatform,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			machineSet := getGCPMachineSet("test-machineset", testGCPOldImage)
+			machineSet.Annotations[BootImageSecretRefAnnotationKey] = "boot-image-secret"
+			machineSet.Spec.Template.Spec.ProviderSpec.Value = &runtime.RawExtension{Raw: []byte(tc.providerSpec)}
+			ctrl := newTestController(t, osconfigv1.NutanixPlatformType, []*machinev1beta1.MachineSet{machineSet}, []*corev1.Secret{bootImageSecret})
+			ctrl.knobs = getBootImageKnobs(&opv1.MachineConfiguration{ObjectMeta: v1.ObjectMeta{Annotations: tc.annotations}}, DefaultAnnotationKeyPrefix, nil)
+
+			_, _, err := ctrl.syncMAPIMachineSet(machineSet, getGCPBootImagesConfigMap())
+			if tc.expectError != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.expectError)
+			} else {
+				require.NoError(t, err)
+			}
+			// Natively supported platforms are never configurable
+			assert.NotContains(t, ctrl.knobs.providerSpecImagePaths, osconfigv1.GCPPlatformType)
+
+			updatedMachineSet := ctrl.getMachineSet(t, machineSet.Name)
+			assert.JSONEq(t, tc.expectedSpec, string(updatedMachineSet.Spec.Template.Spec.ProviderSpec.Value.Raw))
+			assert.Equal(t, string(tc.expectedReason), updatedMachineSet.Annotations[BootImageSkipReasonAnnotationKey])
+		})
+	}
+}
+
+func TestApprovalGatedUpdates(t *testing.T) {
+	machineSets := []*machinev1beta1.MachineSet{
+		getGCPMachineSet("machineset-a", testGCPOldImage),
+		getGCPMachineSet("machineset-b", testGCPOldImage),
+	}
+	ctrl := newTestController(t, osconfigv1.GCPPlatformType, machineSets, nil)
+
+	// Drift is reported while waiting for approval
+	ctrl.setKnobs(t, map[string]string{ApprovalRequiredAnnotationKey: "true"})
+	require.NoError(t, ctrl.syncAll("test"))
+	assert.Equal(t, 0, ctrl.countMachineSetPatches())
+	assert.Equal(t, 2, ctrl.mapiStats.outOfDateCount)
+	progressing := ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateProgressing)
+	assert.Contains(t, progressing.Message, "Awaiting approval")
+	assert.Contains(t, progressing.Message, "(2 out of date)")
+
+	// Once approved, the pending updates are applied and the approval is cleared
+	ctrl.setKnobs(t, map[string]string{ApprovalRequiredAnnotationKey: "true", ApprovedAnnotationKey: "true"})
+	require.NoError(t, ctrl.syncAll("test"))
+	assert.Equal(t, 2, ctrl.countMachineSetPatches())
+	for _, ms := range machineSets {
+		assert.Equal(t, testGCPStreamImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, ms.Name)))
+	}
+	mcop, err := ctrl.mcopClient.OperatorV1().MachineConfigurations().Get(context.TODO(), ctrlcommon.MCOOperatorKnobsObjectName, v1.GetOptions{})
+	require.NoError(t, err)
+	assert.NotContains(t, mcop.Annotations, ApprovedAnnotationKey)
+	assert.Equal(t, "true", mcop.Annotations[ApprovalRequiredAnnotationKey])
+
+	// After the approved pass, the controller returns to reporting
+	require.NoError(t, ctrl.mcopIndexer.Update(mcop))
+	require.NoError(t, ctrl.syncAll("test"))
+	assert.Equal(t, 2, ctrl.countMachineSetPatches())
+	progressing = ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateProgressing)
+	assert.Contains(t, progressing.Message, "Awaiting approval")
+}
+
+func TestApprovalFailsClosedWhenNotCleared(t *testing.T) {
+	ctrl := newTestController(t, osconfigv1.GCPPlatformType, []*machinev1beta1.MachineSet{getGCPMachineSet("machineset-a", testGCPOldImage)}, nil)
+	forbidPatch := true
+	ctrl.mcopClient.PrependReactor("patch", "machineconfigurations", func(_ clienttesting.Action) (bool, runtime.Object, error) {
+		if !forbidPatch {
+			return false, nil, nil
+		}
+		return true, nil, k8serrors.NewForbidden(opv1.Resource("machineconfigurations"), ctrlcommon.MCOOperatorKnobsObjectName, fmt.Errorf("patch is not allowed"))
+	})
+	ctrl.setKnobs(t, map[string]string{ApprovalRequiredAnnotationKey: "true", ApprovedAnnotationKey: "true"})
+
+	// The approved pass applies the pending update, but the approval cannot be cleared
+	require.Error(t, ctrl.syncAll("test"))
+	assert.Equal(t, 1, ctrl.countMachineSetPatches())
+
+	// A machineset that drifts afterwards is not updated on the strength of the same approval
+	machineSet := getGCPMachineSet("machineset-b", testGCPOldImage)
+	_, err := ctrl.machineClient.MachineV1beta1().MachineSets(MachineAPINamespace).Create(context.TODO(), machineSet, v1.CreateOptions{})
+	require.NoError(t, err)
+	require.NoError(t, ctrl.msIndexer.Add(machineSet))
+	require.NoError(t, ctrl.syncAll("test"))
+	assert.Equal(t, 1, ctrl.countMachineSetPatches())
+	assert.Equal(t, testGCPOldImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, "machineset-b")))
+	assert.Contains(t, ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateProgressing).Message, "Awaiting approval")
+
+	// Once the approval can be cleared, it is, still without applying any update
+	forbidPatch = false
+	require.NoError(t, ctrl.syncAll("test"))
+	assert.Equal(t, 1, ctrl.countMachineSetPatches())
+	mcop, err := ctrl.mcopClient.OperatorV1().MachineConfigurations().Get(context.TODO(), ctrlcommon.MCOOperatorKnobsObjectName, v1.GetOptions{})
+	require.NoError(t, err)
+	assert.NotContains(t, mcop.Annotations, ApprovedAnnotationKey)
+
+	// A new approval is acted upon again
+	ctrl.setKnobs(t, map[string]string{ApprovalRequiredAnnotationKey: "true", ApprovedAnnotationKey: "true"})
+	require.NoError(t, ctrl.syncAll("test"))
+	assert.Equal(t, testGCPStreamImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, "machineset-b")))
+}
+
+func TestPausedPlatforms(t *testing.T) {
+	cases := []struct {
+		name          string
+		annotations   map[string]string
+		expectPaused  bool
+		expectMessage string
+	}{
+		{
+			name:        "no paused platforms reconciles all machinesets",
+			annotations: map[string]string{},
+		},
+		{
+			name:          "pausing another platform does not affect this one",
+			annotations:   map[string]string{PausedPlatformsAnnotationKey: "AWS"},
+			expectMessage: "Paused on platform(s) AWS",
+		},
+		{
+			name:          "machinesets on a paused platform are deferred",
+			annotations:   map[string]string{PausedPlatformsAnnotationKey: " AWS, GCP ,AWS"},
+			expectPaused:  true,
+			expectMessage: "Paused on platform(s) AWS, GCP",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			machineSets := []*machinev1beta1.MachineSet{
+				getGCPMachineSet("machineset-a", testGCPOldImage),
+				getGCPMachineSet("machineset-b", testGCPOldImage),
+			}
+			ctrl := newTestController(t, osconfigv1.GCPPlatformType, machineSets, nil)
+			ctrl.setKnobs(t, tc.annotations)
+
+			require.NoError(t, ctrl.syncAll("test"))
+
+			expectedImage := testGCPStreamImage
+			expectedDeferred := 0
+			if tc.expectPaused {
+				expectedImage = testGCPOldImage
+				expectedDeferred = len(machineSets)
+			}
+			for _, ms := range machineSets {
+				machineSet := ctrl.getMachineSet(t, ms.Name)
+				assert.Equal(t, expectedImage, getGCPMachineSetBootImage(t, machineSet))
+				if tc.expectPaused {
+					assert.Equal(t, string(SkipReasonPlatformPaused), machineSet.Annotations[BootImageSkipReasonAnnotationKey])
+				}
+			}
+			assert.Equal(t, expectedDeferred, ctrl.mapiStats.deferredCount)
+			assert.Equal(t, 0, ctrl.mapiStats.skippedCount)
+
+			progressing := ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateProgressing)
+			assert.Equal(t, v1.ConditionFalse, progressing.Status)
+			if tc.expectMessage != "" {
+				assert.Contains(t, progressing.Message, tc.expectMessage)
+			} else {
+				assert.NotContains(t, progressing.Message, "Paused")
+			}
+		})
+	}
+}
+
+func TestReconcileBudget(t *testing.T) {
+	cases := []struct {
+		total    int
+		percent  int
+		expected int
+	}{
+		{total: 10, percent: 0, expected: 0},
+		{total: 10, percent: 20, expected: 2},
+		{total: 11, percent: 20, expected: 2},
+		{total: 3, percent: 20, expected: 1},
+		{total: 1, percent: 1, expected: 1},
+		{total: 200, percent: 5, expected: 10},
+		{total: 7, percent: 100, expected: 7},
+	}
+	for _, tc := range cases {
+		knobs := bootImageKnobs{budgetPercent: tc.percent}
+		assert.Equal(t, tc.expected, knobs.reconcileBudget(tc.total), "%d%% of %d machinesets", tc.percent, tc.total)
+	}
+
+	for _, value := range []string{"0", "101", "-5", "20%", "abc"} {
+		mcop := &opv1.MachineConfiguration{ObjectMeta: v1.ObjectMeta{Annotations: map[string]string{ReconcileBudgetPercentAnnotationKey: value}}}
+		assert.Equal(t, 0, getBootImageKnobs(mcop, DefaultAnnotationKeyPrefix, nil).budgetPercent, "value %q", value)
+	}
+}
+
+func TestInFlightReplacementsHoldOffUpdates(t *testing.T) {
+	deleting := getMachine("deleting", "machineset-a", machinev1beta1.PhaseRunning)
+	deleting.DeletionTimestamp = &v1.Time{Time: time.Now()}
+	unowned := getMachine("unowned", "", machinev1beta1.PhaseProvisioning)
+	unowned.OwnerReferences = nil
+
+	cases := []struct {
+		name         string
+		annotations  map[string]string
+		machines     []*machinev1beta1.Machine
+		expectHeld   bool
+		expectErrors bool
+	}{
+		{
+			name:        "no limit ignores in-flight replacements",
+			annotations: map[string]string{},
+			machines: []*machinev1beta1.Machine{
+				getMachine("provisioning", "machineset-a", machinev1beta1.PhaseProvisioning),
+				deleting,
+			},
+		},
+		{
+			name:        "settled machines do not count as in flight",
+			annotations: map[string]string{MaxInFlightReplacementsAnnotationKey: "1"},
+			machines: []*machinev1beta1.Machine{
+				getMachine("running", "machineset-a", machinev1beta1.PhaseRunning),
+				getMachine("failed", "machineset-b", machinev1beta1.PhaseFailed),
+				unowned,
+			},
+		},
+		{
+			name:        "in-flight replacements below the limit do not hold off updates",
+			annotations: map[string]string{MaxInFlightReplacementsAnnotationKey: "3"},
+			machines: []*machinev1beta1.Machine{
+				getMachine("provisioning", "machineset-a", machinev1beta1.PhaseProvisioning),
+				deleting,
+			},
+		},
+		{
+			name:        "in-flight replacements at the limit hold off updates",
+			annotations: map[string]string{MaxInFlightReplacementsAnnotationKey: "2"},
+			machines: []*machinev1beta1.Machine{
+				getMachine("provisioned", "machineset-b", machinev1beta1.PhaseProvisioned),
+				getMachine("new", "machineset-b", ""),
+			},
+			expectHeld: true,
+		},
+		{
+			name:        "deleting machines count as in flight",
+			annotations: map[string]string{MaxInFlightReplacementsAnnotationKey: "1"},
+			machines:    []*machinev1beta1.Machine{deleting},
+			expectHeld:  true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			machineSets := []*machinev1beta1.MachineSet{
+				getGCPMachineSet("machineset-a", testGCPOldImage),
+				getGCPMachineSet("machineset-b", testGCPOldImage),
+			}
+			ctrl := newTestController(t, osconfigv1.GCPPlatformType, machineSets, nil)
+			for _, machine := range tc.machines {
+				require.NoError(t, ctrl.machineIndexer.Add(machine))
+			}
+			ctrl.setKnobs(t, tc.annotations)
+
+			require.NoError(t, ctrl.syncAll("test"))
+
+			assert.Equal(t, tc.expectHeld, ctrl.mapiUpdatesHeld)
+			for _, ms := range machineSets {
+				machineSet := ctrl.getMachineSet(t, ms.Name)
+				if tc.expectHeld {
+					assert.Equal(t, testGCPOldImage, getGCPMachineSetBootImage(t, machineSet))
+					assert.Equal(t, string(SkipReasonReplacementsInFlight), machineSet.Annotations[BootImageSkipReasonAnnotationKey])
+				} else {
+					assert.Equal(t, testGCPStreamImage, getGCPMachineSetBootImage(t, machineSet))
+				}
+			}
+			if tc.expectHeld {
+				assert.Equal(t, len(machineSets), ctrl.mapiStats.deferredCount)
+			} else {
+				assert.Equal(t, 0, ctrl.mapiStats.deferredCount)
+			}
+			degraded := ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateDegraded)
+			assert.Equal(t, v1.ConditionFalse, degraded.Status)
+		})
+	}
+}
+
+func TestTargetMachineSet(t *testing.T) {
+	cases := []struct {
+		name          string
+		target        string
+		expectUpdated []string
+		expectError   string
+	}{
+		{
+			name:          "only the target machineset is reconciled",
+			target:        "machineset-b",
+			expectUpdated: []string{"machineset-b"},
+		},
+		{
+			name:        "absent target machineset degrades",
+			target:      "machineset-missing",
+			expectError: fmt.Sprintf("target MAPI machineset machineset-missing named by annotation %s was not found", TargetMachineSetAnnotationKey),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			machineSets := []*machinev1beta1.MachineSet{
+				getGCPMachineSet("machineset-a", testGCPOldImage),
+				getGCPMachineSet("machineset-b", testGCPOldImage),
+				getGCPMachineSet("machineset-c", testGCPOldImage),
+			}
+			ctrl := newTestController(t, osconfigv1.GCPPlatformType, machineSets, nil)
+			ctrl.setKnobs(t, map[string]string{TargetMachineSetAnnotationKey: tc.target})
+
+			require.NoError(t, ctrl.syncAll("test"))
+
+			// Machinesets other than the target must not be written to at all
+			patched := []string{}
+			for _, action := range ctrl.machineClient.Actions() {
+				if action.GetVerb() == "patch" {
+					patched = append(patched, action.(clienttesting.PatchAction).GetName())
+				}
+			}
+			for _, name := range patched {
+				assert.Contains(t, tc.expectUpdated, name)
+			}
+			for _, ms := range machineSets {
+				expectedImage := testGCPOldImage
+				if slices.Contains(tc.expectUpdated, ms.Name) {
+					expectedImage = testGCPStreamImage
+				}
+				assert.Equal(t, expectedImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, ms.Name)))
+			}
+
+			degraded := ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateDegraded)
+			if tc.expectError != "" {
+				assert.Equal(t, v1.ConditionTrue, degraded.Status)
+				assert.Contains(t, degraded.Message, tc.expectError)
+				assert.Empty(t, patched)
+			} else {
+				assert.Equal(t, v1.ConditionFalse, degraded.Status)
+				assert.Equal(t, len(machineSets)-len(tc.expectUpdated), ctrl.mapiStats.deferredCount)
+				progressing := ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateProgressing)
+				assert.Equal(t, v1.ConditionFalse, progressing.Status)
+			}
+		})
+	}
+}
+
+func TestArchSafeMode(t *testing.T) {
+	cases := []struct {
+		name          string
+		knobs         map[string]string
+		badArchCount  int
+		expectAborted bool
+	}{
+		{
+			name:          "exceeding the default threshold aborts the sync",
+			badArchCount:  2,
+			expectAborted: true,
+		},
+		{
+			name:         "reaching but not exceeding the default threshold proceeds",
+			badArchCount: 1,
+		},
+		{
+			name:         "a raised threshold allows the sync to proceed",
+			knobs:        map[string]string{ArchSafeModeThresholdPercentAnnotationKey: "70"},
+			badArchCount: 2,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			machineSets := []*machinev1beta1.MachineSet{getGCPMachineSet("machineset-good", testGCPOldImage)}
+			if tc.badArchCount < 2 {
+				machineSets = append(machineSets, getGCPMachineSet("machineset-good-2", testGCPOldImage))
+			}
+			for i := range tc.badArchCount {
+				// An annotation in a format the controller does not understand
+				machineSets = append(machineSets, withAnnotation(getGCPMachineSet(fmt.Sprintf("machineset-bad-%d", i), testGCPOldImage), MachineSetArchAnnotationKey, "kubernetes.io/arch:amd64"))
+			}
+			ctrl := newTestController(t, osconfigv1.GCPPlatformType, machineSets, nil)
+			ctrl.setKnobs(t, tc.knobs)
+
+			require.NoError(t, ctrl.syncAll("test"))
+
+			degraded := ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateDegraded)
+			assert.Equal(t, v1.ConditionTrue, degraded.Status)
+			if tc.expectAborted {
+				assert.Contains(t, degraded.Message, "safe mode")
+				// Nothing at all is written to the machinesets, not even a skip reason
+				for _, action := range ctrl.machineClient.Actions() {
+					assert.NotEqual(t, "patch", action.GetVerb())
+				}
+				assert.Equal(t, testGCPOldImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, "machineset-good")))
+			} else {
+				assert.NotContains(t, degraded.Message, "safe mode")
+				assert.Equal(t, testGCPStreamImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, "machineset-good")))
+			}
+		})
+	}
+}
+
+func TestSoakIntervalSpacesUpdates(t *testing.T) {
+	const soakInterval = time.Hour
+	knobs := map[string]string{SoakIntervalAnnotationKey: soakInterval.String()}
+
+	// Returns the names of the machinesets that have been updated to the stream image
+	getUpdated := func(t *testing.T, ctrl *testController, machineSets []*machinev1beta1.MachineSet) []string {
+		t.Helper()
+		updated := []string{}
+		for _, ms := range machineSets {
+			if getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, ms.Name)) == testGCPStreamImage {
+				updated = append(updated, ms.Name)
+			}
+		}
+		return updated
+	}
+
+	t.Run("updates are spread across passes", func(t *testing.T) {
+		machineSets := []*machinev1beta1.MachineSet{
+			getGCPMachineSet("machineset-a", testGCPOldImage),
+			getGCPMachineSet("machineset-b", testGCPOldImage),
+			getGCPMachineSet("machineset-c", testGCPOldImage),
+		}
+		ctrl := newTestController(t, osconfigv1.GCPPlatformType, machineSets, nil)
+		ctrl.setKnobs(t, knobs)
+
+		// Only one machineset is updated per soak interval, the others are deferred
+		require.NoError(t, ctrl.syncAll("test"))
+		updated := getUpdated(t, ctrl, machineSets)
+		require.Len(t, updated, 1)
+		assert.True(t, ctrl.mapiUpdatesHeld)
+		first := ctrl.getMachineSet(t, updated[0])
+		updateTime, err := time.Parse(time.RFC3339, first.Annotations[BootImageUpdatedAtAnnotationKey])
+		require.NoError(t, err)
+		assert.WithinDuration(t, time.Now(), updateTime, time.Minute)
+		for _, ms := range machineSets {
+			if ms.Name != updated[0] {
+				assert.Equal(t, string(SkipReasonSoakDeferred), ctrl.getMachineSet(t, ms.Name).Annotations[BootImageSkipReasonAnnotationKey])
+			}
+		}
+
+		// While the update is soaking, a new pass does not update anything
+		require.NoError(t, ctrl.msIndexer.Update(first))
+		require.NoError(t, ctrl.syncAll("test"))
+		assert.Len(t, getUpdated(t, ctrl, machineSets), 1)
+
+		// Once the soak interval has passed, the next machineset is updated
+		first.Annotations[BootImageUpdatedAtAnnotationKey] = time.Now().Add(-soakInterval - time.Minute).UTC().Format(time.RFC3339)
+		require.NoError(t, ctrl.msIndexer.Update(first))
+		require.NoError(t, ctrl.syncAll("test"))
+		assert.Len(t, getUpdated(t, ctrl, machineSets), 2)
+	})
+
+	t.Run("soak carries over a controller restart", func(t *testing.T) {
+		recentlyUpdated := withAnnotation(getGCPMachineSet("machineset-a", testGCPStreamImage), BootImageUpdatedAtAnnotationKey, time.Now().Add(-10*time.Minute).UTC().Format(time.RFC3339))
+		machineSets := []*machinev1beta1.MachineSet{recentlyUpdated, getGCPMachineSet("machineset-b", testGCPOldImage)}
+		ctrl := newTestController(t, osconfigv1.GCPPlatformType, machineSets, nil)
+		ctrl.setKnobs(t, knobs)
+
+		require.NoError(t, ctrl.syncAll("test"))
+		assert.Equal(t, testGCPOldImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, "machineset-b")))
+		assert.Equal(t, 1, ctrl.mapiStats.deferredCount)
+	})
+}
+
+func TestCustomAnnotationKeyPrefix(t *testing.T) {
+	const prefix = "bootimages.example.com"
+
+	t.Run("keys are rewritten under the configured prefix", func(t *testing.T) {
+		assert.Equal(t, prefix+"/boot-image-skip-reason", annotationKey(prefix, BootImageSkipReasonAnnotationKey))
+		assert.Equal(t, BootImageSkipReasonAnnotationKey, annotationKey(DefaultAnnotationKeyPrefix, BootImageSkipReasonAnnotationKey))
+		assert.Equal(t, BootImageSkipReasonAnnotationKey, annotationKey("", BootImageSkipReasonAnnotationKey))
+		// Annotations owned by other components keep their own prefix
+		assert.Equal(t, MachineSetArchAnnotationKey, annotationKey(prefix, MachineSetArchAnnotationKey))
+	})
+
+	t.Run("annotations are read and written under the configured prefix", func(t *testing.T) {
+		originalHash := version.Hash
+		version.Hash = "test-controller-hash"
+		t.Cleanup(func() { version.Hash = originalHash })
+
+		machineSets := []*machinev1beta1.MachineSet{
+			getGCPMachineSet("machineset-a", testGCPOldImage),
+			getGCPMachineSet("machineset-b", testGCPOldImage),
+		}
+		ctrl := newTestController(t, osconfigv1.GCPPlatformType, machineSets, nil)
+		ctrl.annotationKeyPrefix = prefix
+		// The knob is only honored under the configured prefix
+		ctrl.setKnobs(t, map[string]string{
+			annotationKey(prefix, SoakIntervalAnnotationKey): "1h",
+			AdvisoryOnlyAnnotationKey:                        "true",
+		})
+
+		require.NoError(t, ctrl.syncAll("test"))
+		assert.Equal(t, time.Hour, ctrl.knobs.soakInterval)
+		assert.False(t, ctrl.knobs.advisoryOnly)
+
+		// One machineset is updated and stamped, the other is held by the soak interval
+		updated, held := ctrl.getMachineSet(t, "machineset-a"), ctrl.getMachineSet(t, "machineset-b")
+		if getGCPMachineSetBootImage(t, updated) == testGCPOldImage {
+			updated, held = held, updated
+		}
+		assert.Equal(t, testGCPStreamImage, getGCPMachineSetBootImage(t, updated))
+		assert.Equal(t, "test-controller-hash", updated.Annotations[annotationKey(prefix, BootImageUpdatedByVersionAnnotationKey)])
+		assert.Contains(t, updated.Annotations, annotationKey(prefix, BootImageUpdatedAtAnnotationKey))
+		assert.NotContains(t, updated.Annotations, BootImageUpdatedByVersionAnnotationKey)
+		assert.NotContains(t, updated.Annotations, BootImageUpdatedAtAnnotationKey)
+		assert.Equal(t, string(SkipReasonSoakDeferred), held.Annotations[annotationKey(prefix, BootImageSkipReasonAnnotationKey)])
+		assert.NotContains(t, held.Annotations, BootImageSkipReasonAnnotationKey)
+
+		// The update time written under the custom prefix is read back, as it is after a controller restart
+		assert.False(t, ctrl.getLastBootImageUpdateTime([]*machinev1beta1.MachineSet{updated, held}).IsZero())
+		ctrl.annotationKeyPrefix = DefaultAnnotationKeyPrefix
+		assert.True(t, ctrl.getLastBootImageUpdateTime([]*machinev1beta1.MachineSet{updated, held}).IsZero())
+	})
+}
+
+func TestNodeReadinessGate(t *testing.T) {
+	// Adds nodes to the node lister, of which the given number are Ready
+	addNodes := func(t *testing.T, ctrl *testController, total, ready int) {
+		t.Helper()
+		for i := 0; i < total; i++ {
+			status := corev1.ConditionTrue
+			if i >= ready {
+				status = corev1.ConditionFalse
+			}
+			require.NoError(t, ctrl.nodeIndexer.Add(&corev1.Node{
+				ObjectMeta: v1.ObjectMeta{Name: fmt.Sprintf("node-%d", i)},
+				Status:     corev1.NodeStatus{Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: status}}},
+			}))
+		}
+	}
+
+	cases := []struct {
+		name           string
+		knobs          map[string]string
+		readyNodes     int
+		expectedHeld   bool
+		expectedReason string
+	}{
+		{
+			name:           "low readiness is ignored without the gate",
+			readyNodes:     5,
+			expectedReason: "test",
+		},
+		{
+			name:           "readiness at the threshold allows updates",
+			knobs:          map[string]string{MinNodeReadinessPercentAnnotationKey: "80"},
+			readyNodes:     8,
+			expectedReason: "test",
+		},
+		{
+			name:           "readiness below the threshold holds updates",
+			knobs:          map[string]string{MinNodeReadinessPercentAnnotationKey: "80"},
+			readyNodes:     7,
+			expectedHeld:   true,
+			expectedReason: ReasonPausedLowNodeReadiness,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := newTestController(t, osconfigv1.GCPPlatformType, []*machinev1beta1.MachineSet{getGCPMachineSet("machineset-a", testGCPOldImage)}, nil)
+			ctrl.setKnobs(t, tc.knobs)
+			addNodes(t, ctrl, 10, tc.readyNodes)
+
+			require.NoError(t, ctrl.syncAll("test"))
+
+			progressing := ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateProgressing)
+			assert.Equal(t, tc.expectedReason, progressing.Reason)
+			machineSet := ctrl.getMachineSet(t, "machineset-a")
+			if tc.expectedHeld {
+				assert.Contains(t, progressing.Message, "Paused on low node readiness, 7 of 10 nodes are Ready")
+				assert.Equal(t, testGCPOldImage, getGCPMachineSetBootImage(t, machineSet))
+				assert.Equal(t, string(SkipReasonLowNodeReadiness), machineSet.Annotations[BootImageSkipReasonAnnotationKey])
+			} else {
+				assert.NotContains(t, progressing.Message, "node readiness")
+				assert.Equal(t, testGCPStreamImage, getGCPMachineSetBootImage(t, machineSet))
+			}
+		})
+	}
+}
+
+func TestOptInMode(t *testing.T) {
+	cases := []struct {
+		name              string
+		knobs             map[string]string
+		expectedUpdated   []string
+		expectedUnmanaged int
+	}{
+		{
+			name:            "all enrolled machinesets are managed by default",
+			expectedUpdated: []string{"opted-in", "opted-out", "unannotated"},
+		},
+		{
+			name:              "only opted in machinesets are managed in opt-in mode",
+			knobs:             map[string]string{OptInAnnotationKey: "true"},
+			expectedUpdated:   []string{"opted-in"},
+			expectedUnmanaged: 2,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			machineSets := []*machinev1beta1.MachineSet{
+				withAnnotation(getGCPMachineSet("opted-in", testGCPOldImage), BootImageManagedAnnotationKey, "true"),
+				withAnnotation(getGCPMachineSet("opted-out", testGCPOldImage), BootImageManagedAnnotationKey, "false"),
+				getGCPMachineSet("unannotated", testGCPOldImage),
+			}
+			ctrl := newTestController(t, osconfigv1.GCPPlatformType, machineSets, nil)
+			ctrl.setKnobs(t, tc.knobs)
+
+			require.NoError(t, ctrl.syncAll("test"))
+
+			updated := []string{}
+			for _, ms := range machineSets {
+				if getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, ms.Name)) == testGCPStreamImage {
+					updated = append(updated, ms.Name)
+				}
+			}
+			assert.Equal(t, tc.expectedUpdated, updated)
+			assert.Equal(t, len(tc.expectedUpdated), ctrl.mapiStats.totalCount)
+			assert.Equal(t, tc.expectedUnmanaged, ctrl.mapiStats.unmanagedCount)
+			progressing := ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateProgressing)
+			if tc.expectedUnmanaged > 0 {
+				assert.Contains(t, progressing.Message, fmt.Sprintf("(%d unmanaged)", tc.expectedUnmanaged))
+			} else {
+				assert.NotContains(t, progressing.Message, "unmanaged")
+			}
+		})
+	}
+}
+
+func TestResyncNonce(t *testing.T) {
+	ctrl := newTestController(t, osconfigv1.GCPPlatformType, []*machinev1beta1.MachineSet{getGCPMachineSet("machineset-a", testGCPOldImage)}, nil)
+	ctrl.syncHandler = ctrl.syncAll
+	require.NoError(t, ctrl.syncAll("initial"))
+
+	// Sets the annotations on the MachineConfiguration and passes the update to the event handler
+	updateAnnotations := func(t *testing.T, annotations map[string]string) {
+		t.Helper()
+		oldMCOP := ctrl.getMachineConfiguration(t)
+		ctrl.setKnobs(t, annotations)
+		ctrl.updateMachineConfiguration(oldMCOP, ctrl.getMachineConfiguration(t))
+	}
+	// Processes all queued events, returning them in order
+	drainQueue := func() []string {
+		events := []string{}
+		for ctrl.queue.Len() > 0 {
+			event, _ := ctrl.queue.Get()
+			events = append(events, event)
+			ctrl.queue.Done(event)
+			ctrl.queue.Forget(event)
+			require.NoError(t, ctrl.syncAll(event))
+		}
+		return events
+	}
+	countManualResyncs := func() int {
+		count := 0
+		for _, summary := range ctrl.syncHistory {
+			if summary.Reason == "ManualResync" {
+				count++
+			}
+		}
+		return count
+	}
+
+	t.Run("a new nonce triggers a single resync", func(t *testing.T) {
+		updateAnnotations(t, map[string]string{ResyncNonceAnnotationKey: "1"})
+		assert.Equal(t, []string{"ManualResync"}, drainQueue())
+		assert.Equal(t, 1, countManualResyncs())
+		assert.Equal(t, testGCPStreamImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, "machineset-a")))
+	})
+
+	t.Run("an unchanged nonce does not trigger a resync", func(t *testing.T) {
+		updateAnnotations(t, map[string]string{ResyncNonceAnnotationKey: "1"})
+		assert.Empty(t, drainQueue())
+
+		// A repeated resync of a processed nonce is skipped
+		ctrl.enqueueEvent("ManualResync")
+		assert.Equal(t, []string{"ManualResync"}, drainQueue())
+		assert.Equal(t, 1, countManualResyncs())
+	})
+
+	t.Run("a nonce changed along with a knob is resynced once", func(t *testing.T) {
+		updateAnnotations(t, map[string]string{ResyncNonceAnnotationKey: "2", AdvisoryOnlyAnnotationKey: "true"})
+		assert.Equal(t, []string{"ManualResync", "BootImageUpdateConfigurationUpdated"}, drainQueue())
+		// One more than the resync of the first nonce
+		assert.Equal(t, 2, countManualResyncs())
+	})
+}
+
+func TestDegradedSuppressionWindow(t *testing.T) {
+	cases := []struct {
+		name               string
+		until              time.Time
+		expectedStatus     v1.ConditionStatus
+		expectedSuppressed bool
+	}{
+		{
+			name:               "errors within the window do not degrade",
+			until:              time.Now().Add(time.Hour),
+			expectedStatus:     v1.ConditionFalse,
+			expectedSuppressed: true,
+		},
+		{
+			name:           "errors after the window degrade",
+			until:          time.Now().Add(-time.Hour),
+			expectedStatus: v1.ConditionTrue,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ms := getGCPMachineSet("machineset-a", testGCPOldImage)
+			ms.Annotations[BootImageSecretRefAnnotationKey] = "missing-secret"
+			ctrl := newTestController(t, osconfigv1.GCPPlatformType, []*machinev1beta1.MachineSet{ms}, nil)
+			queue := &delayRecordingQueue{TypedRateLimitingInterface: ctrl.queue}
+			ctrl.queue = queue
+			ctrl.setKnobs(t, map[string]string{SuppressDegradedUntilAnnotationKey: tc.until.Format(time.RFC3339)})
+
+			require.NoError(t, ctrl.syncAll("test"))
+
+			// Errors are counted regardless of the window
+			assert.Equal(t, 1, ctrl.mapiStats.erroredCount)
+			degraded := ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateDegraded)
+			assert.Equal(t, tc.expectedStatus, degraded.Status)
+			assert.Contains(t, degraded.Message, "1 Degraded MAPI MachineSets")
+			assert.Contains(t, degraded.Message, "missing-secret")
+			if tc.expectedSuppressed {
+				assert.True(t, strings.HasPrefix(degraded.Message, "Degraded suppressed until "+tc.until.Format(time.RFC3339)), degraded.Message)
+				// The sync is requeued for the end of the window, so that the errors are then reported
+				require.Len(t, queue.delays, 1)
+				assert.InDelta(t, time.Hour.Seconds(), queue.delays[0].Seconds(), 5)
+			} else {
+				assert.NotContains(t, degraded.Message, "suppressed")
+				assert.Empty(t, queue.delays)
+			}
+		})
+	}
+
+	t.Run("invalid timestamp is ignored", func(t *testing.T) {
+		knobs := getBootImageKnobs(&opv1.MachineConfiguration{ObjectMeta: v1.ObjectMeta{
+			Annotations: map[string]string{SuppressDegradedUntilAnnotationKey: "tomorrow"},
+		}}, DefaultAnnotationKeyPrefix, nil)
+		assert.True(t, knobs.suppressDegradedUntil.IsZero())
+		assert.False(t, knobs.degradedSuppressed(time.Now()))
+	})
+}
+
+func TestArchVersionSkew(t *testing.T) {
+	// Builds stream data advertising the given release for each architecture
+	getStreamData := func(releases map[string]string) string {
+		architectures := []string{}
+		for _, arch := range sets.List(sets.KeySet(releases)) {
+			architectures = append(architectures, fmt.Sprintf(`%q:{"artifacts":{"gcp":{"release":%q}},"images":{"gcp":{"project":"rhcos-cloud","name":"rhcos-9-6-new"}}}`, arch, releases[arch]))
+		}
+		return fmt.Sprintf(`{"stream":"rhcos-9.6","architectures":{%s}}`, strings.Join(architectures, ","))
+	}
+
+	cases := []struct {
+		name           string
+		knobs          map[string]string
+		releases       map[string]string
+		expectSkew     bool
+		expectDeferred bool
+	}{
+		{
+			name:           "skewed versions with consistency required",
+			knobs:          map[string]string{RequireArchConsistencyAnnotationKey: "true"},
+			releases:       map[string]string{"x86_64": "9.6.20250402-0", "aarch64": "9.6.20250301-0"},
+			expectSkew:     true,
+			expectDeferred: true,
+		},
+		{
+			name:       "skewed versions without consistency required",
+			releases:   map[string]string{"x86_64": "9.6.20250402-0", "aarch64": "9.6.20250301-0"},
+			expectSkew: true,
+		},
+		{
+			name:     "consistent versions with consistency required",
+			knobs:    map[string]string{RequireArchConsistencyAnnotationKey: "true"},
+			releases: map[string]string{"x86_64": "9.6.20250402-0", "aarch64": "9.6.20250402-0"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := newTestController(t, osconfigv1.GCPPlatformType, []*machinev1beta1.MachineSet{getGCPMachineSet("machineset-a", testGCPOldImage)}, nil)
+			ctrl.setKnobs(t, tc.knobs)
+			configMap := getGCPBootImagesConfigMap()
+			configMap.Data[StreamConfigMapKey] = getStreamData(tc.releases)
+			require.NoError(t, ctrl.cmIndexer.Update(configMap))
+			_, err := ctrl.kubeClient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Update(context.TODO(), configMap, v1.UpdateOptions{})
+			require.NoError(t, err)
+
+			require.NoError(t, ctrl.syncAll("test"))
+
+			skew := ctrl.getCondition(t, BootImageArchVersionSkewConditionType)
+			if tc.expectSkew {
+				assert.Equal(t, v1.ConditionTrue, skew.Status)
+				assert.Contains(t, skew.Message, "aarch64: 9.6.20250301-0; x86_64: 9.6.20250402-0")
+			} else {
+				assert.Equal(t, v1.ConditionFalse, skew.Status)
+			}
+			if tc.expectDeferred {
+				assert.Contains(t, skew.Message, "deferred")
+				assert.Equal(t, 0, ctrl.countMachineSetPatches())
+				assert.Equal(t, testGCPOldImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, "machineset-a")))
+			} else {
+				assert.NotContains(t, skew.Message, "deferred")
+				assert.Equal(t, testGCPStreamImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, "machineset-a")))
+			}
+		})
+	}
+}
+
+func TestManagedPlatforms(t *testing.T) {
+	cases := []struct {
+		name              string
+		knobs             map[string]string
+		expectedUpdated   []string
+		expectedUnmanaged int
+		expectedErrored   int
+	}{
+		{
+			name:            "all platforms are managed by default",
+			expectedUpdated: []string{"machineset-gcp"},
+			// The AWS providerspec does not match the cluster platform
+			expectedErrored: 1,
+		},
+		{
+			name:              "machinesets on other platforms are unmanaged",
+			knobs:             map[string]string{ManagedPlatformsAnnotationKey: "GCP"},
+			expectedUpdated:   []string{"machineset-gcp"},
+			expectedUnmanaged: 1,
+		},
+		{
+			name:              "no machinesets are managed on excluded platforms",
+			knobs:             map[string]string{ManagedPlatformsAnnotationKey: "Azure, VSphere"},
+			expectedUpdated:   []string{},
+			expectedUnmanaged: 2,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			awsMachineSet := getGCPMachineSet("machineset-aws", testGCPOldImage)
+			awsMachineSet.Spec.Template.Spec.ProviderSpec.Value.Raw = []byte(`{"apiVersion":"machine.openshift.io/v1beta1","kind":"AWSMachineProviderConfig","ami":{"id":"ami-old"}}`)
+			machineSets := []*machinev1beta1.MachineSet{getGCPMachineSet("machineset-gcp", testGCPOldImage), awsMachineSet}
+			ctrl := newTestController(t, osconfigv1.GCPPlatformType, machineSets, nil)
+			ctrl.setKnobs(t, tc.knobs)
+
+			require.NoError(t, ctrl.syncAll("test"))
+
+			updated := []string{}
+			if getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, "machineset-gcp")) == testGCPStreamImage {
+				updated = append(updated, "machineset-gcp")
+			}
+			assert.Equal(t, tc.expectedUpdated, updated)
+			assert.Equal(t, 2-tc.expectedUnmanaged, ctrl.mapiStats.totalCount)
+			assert.Equal(t, tc.expectedUnmanaged, ctrl.mapiStats.unmanagedCount)
+			assert.Equal(t, tc.expectedErrored, ctrl.mapiStats.erroredCount)
+			if tc.expectedUnmanaged > 0 {
+				progressing := ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateProgressing)
+				assert.Contains(t, progressing.Message, fmt.Sprintf("(%d unmanaged)", tc.expectedUnmanaged))
+			}
+			// The AWS machineset is never written to
+			assert.Equal(t, string(awsMachineSet.Spec.Template.Spec.ProviderSpec.Value.Raw), string(ctrl.getMachineSet(t, "machineset-aws").Spec.Template.Spec.ProviderSpec.Value.Raw))
+		})
+	}
+}
+
+func TestDefaultSingleArch(t *testing.T) {
+	cases := []struct {
+		name            string
+		knobs           map[string]string
+		nodeArchs       []string
+		multiArch       bool
+		expectedUpdated bool
+	}{
+		{
+			name:      "unparseable architectures error by default",
+			nodeArchs: []string{"amd64", "amd64"},
+		},
+		{
+			name:            "unparseable architectures default to the node architecture of a single-arch cluster",
+			knobs:           map[string]string{DefaultSingleArchAnnotationKey: "true"},
+			nodeArchs:       []string{"amd64", "amd64"},
+			expectedUpdated: true,
+		},
+		{
+			name:      "multi-arch clusters remain strict",
+			knobs:     map[string]string{DefaultSingleArchAnnotationKey: "true"},
+			nodeArchs: []string{"amd64", "amd64"},
+			multiArch: true,
+		},
+		{
+			name:      "clusters with nodes of several architectures remain strict",
+			knobs:     map[string]string{DefaultSingleArchAnnotationKey: "true"},
+			nodeArchs: []string{"amd64", "arm64"},
+		},
+		{
+			name:  "clusters without nodes remain strict",
+			knobs: map[string]string{DefaultSingleArchAnnotationKey: "true"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			machineSet := withAnnotation(getGCPMachineSet("machineset-a", testGCPOldImage), MachineSetArchAnnotationKey, "topology.kubernetes.io/zone=us-central1-a")
+			ctrl := newTestController(t, osconfigv1.GCPPlatformType, []*machinev1beta1.MachineSet{machineSet}, nil)
+			// Disable the architecture safe mode, so that the machineset itself is reported as errored
+			knobs := map[string]string{ArchSafeModeThresholdPercentAnnotationKey: "100"}
+			maps.Copy(knobs, tc.knobs)
+			ctrl.setKnobs(t, knobs)
+			for i, arch := range tc.nodeArchs {
+				require.NoError(t, ctrl.nodeIndexer.Add(&corev1.Node{
+					ObjectMeta: v1.ObjectMeta{Name: fmt.Sprintf("node-%d", i), Labels: map[string]string{corev1.LabelArchStable: arch}},
+				}))
+			}
+			if tc.multiArch {
+				cvIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+				require.NoError(t, cvIndexer.Add(&osconfigv1.ClusterVersion{
+					ObjectMeta: v1.ObjectMeta{Name: "version"},
+					Status: osconfigv1.ClusterVersionStatus{
+						Desired: osconfigv1.Release{Architecture: osconfigv1.ClusterVersionArchitectureMulti},
+						History: []osconfigv1.UpdateHistory{{State: osconfigv1.CompletedUpdate, Version: "4.20.0"}},
+					},
+				}))
+				ctrl.clusterVersionLister = configlistersv1.NewClusterVersionLister(cvIndexer)
+			}
+
+			require.NoError(t, ctrl.syncAll("test"))
+
+			if tc.expectedUpdated {
+				assert.Equal(t, 0, ctrl.mapiStats.erroredCount)
+				assert.Equal(t, testGCPStreamImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, "machineset-a")))
+			} else {
+				assert.Equal(t, 1, ctrl.mapiStats.erroredCount)
+				assert.Equal(t, testGCPOldImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, "machineset-a")))
+			}
+		})
+	}
+}
+
+func TestOptedOutGroups(t *testing.T) {
+	cases := []struct {
+		name              string
+		knobs             map[string]string
+		expectedUpdated   []string
+		expectedUnmanaged int
+	}{
+		{
+			name:            "grouped machinesets are managed by default",
+			expectedUpdated: []string{"machineset-team-a-1", "machineset-team-a-2", "machineset-team-b", "machineset-ungrouped"},
+		},
+		{
+			name:              "machinesets of an opted out group are unmanaged",
+			knobs:             map[string]string{OptedOutGroupsAnnotationKey: "team-a"},
+			expectedUpdated:   []string{"machineset-team-b", "machineset-ungrouped"},
+			expectedUnmanaged: 2,
+		},
+		{
+			name:              "several groups can be opted out",
+			knobs:             map[string]string{OptedOutGroupsAnnotationKey: "team-a, team-b"},
+			expectedUpdated:   []string{"machineset-ungrouped"},
+			expectedUnmanaged: 3,
+		},
+		{
+			name:            "unknown groups leave all machinesets managed",
+			knobs:           map[string]string{OptedOutGroupsAnnotationKey: "team-c"},
+			expectedUpdated: []string{"machineset-team-a-1", "machineset-team-a-2", "machineset-team-b", "machineset-ungrouped"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			machineSets := []*machinev1beta1.MachineSet{getGCPMachineSet("machineset-ungrouped", testGCPOldImage)}
+			for name, group := range map[string]string{"machineset-team-a-1": "team-a", "machineset-team-a-2": "team-a", "machineset-team-b": "team-b"} {
+				machineSet := getGCPMachineSet(name, testGCPOldImage)
+				machineSet.Labels = map[string]string{BootImageGroupLabelKey: group}
+				machineSets = append(machineSets, machineSet)
+			}
+			ctrl := newTestController(t, osconfigv1.GCPPlatformType, machineSets, nil)
+			ctrl.setKnobs(t, tc.knobs)
+
+			require.NoError(t, ctrl.syncAll("test"))
+
+			updated := []string{}
+			for _, machineSet := range machineSets {
+				if getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, machineSet.Name)) == testGCPStreamImage {
+					updated = append(updated, machineSet.Name)
+				}
+			}
+			assert.ElementsMatch(t, tc.expectedUpdated, updated)
+			assert.Equal(t, len(machineSets)-tc.expectedUnmanaged, ctrl.mapiStats.totalCount)
+			assert.Equal(t, tc.expectedUnmanaged, ctrl.mapiStats.unmanagedCount)
+			if tc.expectedUnmanaged > 0 {
+				progressing := ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateProgressing)
+				assert.Contains(t, progressing.Message, fmt.Sprintf("(%d unmanaged)", tc.expectedUnmanaged))
+			}
+		})
+	}
+}
+
+func TestAdvisoryOnlyPlannedUpdateEvents(t *testing.T) {
+	machineSets := []*machinev1beta1.MachineSet{
+		getGCPMachineSet("machineset-a", testGCPOldImage),
+		getGCPMachineSet("machineset-b", testGCPOldImage),
+		getGCPMachineSet("machineset-current", testGCPStreamImage),
+	}
+	ctrl := newTestController(t, osconfigv1.GCPPlatformType, machineSets, nil)
+	ctrl.setKnobs(t, map[string]string{AdvisoryOnlyAnnotationKey: "true"})
+	getPlannedEvents := func(ctrl *testController) []string {
+		events := []string{}
+		for len(ctrl.eventRecorder.Events) > 0 {
+			if event := <-ctrl.eventRecorder.Events; strings.Contains(event, "BootImageUpdatePlanned") {
+				events = append(events, event)
+			}
+		}
+		return events
+	}
+
+	// Each held back update is reported, while nothing is written
+	require.NoError(t, ctrl.syncAll("test"))
+	events := getPlannedEvents(ctrl)
+	require.Len(t, events, 2)
+	for _, name := range []string{"machineset-a", "machineset-b"} {
+		assert.True(t, slices.ContainsFunc(events, func(event string) bool {
+			return strings.Contains(event, "machineset "+name+" ") && strings.Contains(event, testGCPStreamImage)
+		}), "no planned update event for %s", name)
+		assert.Equal(t, testGCPOldImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, name)))
+	}
+	assert.Equal(t, 0, ctrl.countMachineSetPatches())
+	assert.Equal(t, 3, ctrl.mapiStats.inProgress)
+	assert.Contains(t, ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateProgressing).Message, "(2 out of date)")
+
+	// The same planned updates are not reported again
+	require.NoError(t, ctrl.syncAll("test"))
+	assert.Empty(t, getPlannedEvents(ctrl))
+}
@@ -5,6 +5,9 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"maps"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -13,12 +16,15 @@ import (
 	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
 	opv1 "github.com/openshift/api/operator/v1"
 	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/version"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
-	kubeErrs "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/apimachinery/pkg/util/jsonmergepatch"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
 
 	archtranslater "github.com/coreos/stream-metadata-go/arch"
 	"github.com/coreos/stream-metadata-go/stream"
@@ -28,19 +34,25 @@ import (
 // syncMAPIMachineSets will attempt to enqueue every machineset
 // nolint:dupl // I separated this from syncControlPlaneMachineSets for readability
 func (ctrl *Controller) syncMAPIMachineSets(reason string) {
+	startTime := time.Now()
+	defer func() {
+		ctrlcommon.MCOBootImageSyncDuration.Observe(time.Since(startTime).Seconds())
+	}()
 
 	// Get MachineConfiguration to determine which resources are enrolled
 	mcop, err := ctrl.mcopLister.Get(ctrlcommon.MCOOperatorKnobsObjectName)
 	if err != nil {
 		klog.Errorf("Failed to get MachineConfiguration: %v", err)
-		ctrl.updateConditions(reason, fmt.Errorf("failed to get MachineConfiguration while enqueueing MAPI MachineSets: %w", err), opv1.MachineConfigurationBootImageUpdateDegraded)
+		ctrl.mapiSyncErrors = []error{fmt.Errorf("failed to get MachineConfiguration while enqueueing MAPI MachineSets: %w", err)}
+		ctrl.updateConditions(reason, ctrl.aggregateSyncErrors(), opv1.MachineConfigurationBootImageUpdateDegraded)
 		return
 	}
 
 	machineManagerFound, machineResourceSelector, err := getMachineResourceSelectorFromMachineManagers(mcop.Status.ManagedBootImagesStatus.MachineManagers, opv1.MachineAPI, opv1.MachineSets)
 	if err != nil {
 		klog.Errorf("failed to create a machineset selector while enqueueing MAPI machineset %v", err)
-		ctrl.updateConditions(reason, fmt.Errorf("failed to create a machineset selector while enqueueing MAPI machineset %w", err), opv1.MachineConfigurationBootImageUpdateDegraded)
+		ctrl.mapiSyncErrors = []error{fmt.Errorf("failed to create a machineset selector while enqueueing MAPI machineset %w", err)}
+		ctrl.updateConditions(reason, ctrl.aggregateSyncErrors(), opv1.MachineConfigurationBootImageUpdateDegraded)
 		return
 	}
 	if !machineManagerFound {
@@ -49,16 +61,28 @@ func (ctrl *Controller) syncMAPIMachineSets(reason string) {
 		for k := range ctrl.mapiBootImageState {
 			delete(ctrl.mapiBootImageState, k)
 		}
+		ctrl.updateHotLoopStateMetrics()
 
 	}
 
 	mapiMachineSets, err := ctrl.mapiMachineSetLister.List(machineResourceSelector)
 	if err != nil {
 		klog.Errorf("failed to fetch MachineSet list while enqueueing MAPI MachineSets %v", err)
-		ctrl.updateConditions(reason, fmt.Errorf("failed to fetch MachineSet list while enqueueing MAPI MachineSets %w", err), opv1.MachineConfigurationBootImageUpdateDegraded)
+		ctrl.mapiSyncErrors = []error{fmt.Errorf("failed to fetch MachineSet list while enqueueing MAPI MachineSets %w", err)}
+		ctrl.updateConditions(reason, ctrl.aggregateSyncErrors(), opv1.MachineConfigurationBootImageUpdateDegraded)
 		return
 	}
 
+	// In opt-in mode, only the machinesets that opted in are managed, and only those on the managed
+	// platforms if these are restricted, outside of the opted out groups
+	mapiMachineSets, ctrl.mapiStats.unmanagedCount = ctrl.filterOptedInMachineSets(mapiMachineSets)
+	var unmanagedPlatformCount, optedOutGroupCount int
+	mapiMachineSets, unmanagedPlatformCount = ctrl.filterManagedPlatformMachineSets(mapiMachineSets)
+	mapiMachineSets, optedOutGroupCount = ctrl.filterOptedOutGroupMachineSets(mapiMachineSets)
+	ctrl.mapiStats.unmanagedCount += unmanagedPlatformCount + optedOutGroupCount
+
+	ctrl.syncOrphanedMAPIMachineSetAnnotations(mcop, mapiMachineSets)
+
 	// If no machine resources were enrolled; exit the enqueue process without errors.
 	if len(mapiMachineSets) == 0 {
 		klog.Infof("No MAPI machinesets were enrolled, so no MAPI machinesets will be enqueued.")
@@ -66,6 +90,7 @@ func (ctrl *Controller) syncMAPIMachineSets(reason string) {
 		for k := range ctrl.mapiBootImageState {
 			delete(ctrl.mapiBootImageState, k)
 		}
+		ctrl.updateHotLoopStateMetrics()
 	}
 
 	var configMap *corev1.ConfigMap
@@ -74,40 +99,183 @@ func (ctrl *Controller) syncMAPIMachineSets(reason string) {
 		configMap, err = ctrl.mcoCmLister.ConfigMaps(ctrlcommon.MCONamespace).Get(ctrlcommon.BootImagesConfigMapName)
 		if err != nil {
 			klog.Errorf("failed to fetch coreos-bootimages config map: %v", err)
-			ctrl.updateConditions(reason, fmt.Errorf("failed to fetch coreos-bootimages config map: %w", err), opv1.MachineConfigurationBootImageUpdateDegraded)
+			ctrl.mapiSyncErrors = []error{fmt.Errorf("failed to fetch coreos-bootimages config map: %w", err)}
+			ctrl.updateConditions(reason, ctrl.aggregateSyncErrors(), opv1.MachineConfigurationBootImageUpdateDegraded)
 			return
 		}
 	}
 
+	// A targeted rollout must name an enrolled machineset, otherwise nothing would be reconciled
+	if target := ctrl.knobs.targetMachineSet; target != "" && !slices.ContainsFunc(mapiMachineSets, func(ms *machinev1beta1.MachineSet) bool { return ms.Name == target }) {
+		klog.Errorf("target MAPI machineset %s was not found among the enrolled MAPI machinesets", target)
+		ctrl.mapiSyncErrors = []error{fmt.Errorf("target MAPI machineset %s named by annotation %s was not found among the enrolled MAPI machinesets", target, ctrl.annotationKey(TargetMachineSetAnnotationKey))}
+		ctrl.updateConditions(reason, ctrl.aggregateSyncErrors(), opv1.MachineConfigurationBootImageUpdateDegraded)
+		return
+	}
+
+	// Refuse to update any machineset if the architecture of too many of them cannot be determined, as
+	// the classification logic is likely stale and updates could be applied with the wrong images
+	if err := ctrl.checkArchSafeMode(mapiMachineSets); err != nil {
+		klog.Errorf("Aborting MAPI machineset sync: %v", err)
+		ctrl.mapiSyncErrors = []error{err}
+		ctrl.updateConditions(reason, ctrl.aggregateSyncErrors(), opv1.MachineConfigurationBootImageUpdateDegraded)
+		return
+	}
+
 	// Reset stats before initiating reconciliation loop
 	ctrl.mapiStats.inProgress = 0
 	ctrl.mapiStats.totalCount = len(mapiMachineSets)
 	ctrl.mapiStats.skippedCount = 0
 	ctrl.mapiStats.erroredCount = 0
+	ctrl.mapiStats.outOfDateCount = 0
+	ctrl.mapiStats.deferredCount = 0
+	ctrl.mapiStats.throttledCount = 0
+	ctrl.mapiStats.updatedCount = 0
+	ctrl.mapiStats.unevaluatedCount = 0
+	ctrl.mapiReconcileBudget = ctrl.knobs.reconcileBudget(len(mapiMachineSets))
+	ctrl.mapiReplacementsInFlight = false
+	ctrl.mapiUpdatesHeld = false
+	ctrl.mapiAwaitingBoots = false
+	ctrl.lowNodeReadiness = ""
+	ctrl.mapiTransientErrors = false
+	ctrl.mapiFailuresInGrace = false
+	ctrl.mapiLastUpdateTime = ctrl.getLastBootImageUpdateTime(mapiMachineSets)
+	ctrl.mapiPlan = nil
+	ctrl.mapiChanged = nil
+	ctrl.mapiOutcomes = nil
+	clear(ctrl.mapiImageSources)
+	clear(ctrl.mapiVerifiedImages)
+
+	// Hold off updates for this pass if too many machines are already being replaced
+	if ctrl.knobs.maxInFlightReplacements > 0 && len(mapiMachineSets) > 0 {
+		inFlight, err := ctrl.countInFlightMachineReplacements()
+		if err != nil {
+			klog.Errorf("failed to count in-flight machine replacements: %v", err)
+			ctrl.mapiSyncErrors = []error{fmt.Errorf("failed to count in-flight machine replacements: %w", err)}
+			ctrl.updateConditions(reason, ctrl.aggregateSyncErrors(), opv1.MachineConfigurationBootImageUpdateDegraded)
+			return
+		}
+		if inFlight >= ctrl.knobs.maxInFlightReplacements {
+			klog.Infof("%d MAPI machines are being replaced, holding off boot image updates until fewer than %d are in flight", inFlight, ctrl.knobs.maxInFlightReplacements)
+			ctrl.mapiReplacementsInFlight = true
+		}
+	}
+
+	// Hold off updates for this pass if too few nodes are Ready, reporting the pause as the Progressing reason
+	progressingReason := reason
+	if ctrl.knobs.minNodeReadinessPercent > 0 && len(mapiMachineSets) > 0 {
+		ready, total, err := ctrl.countReadyNodes()
+		if err != nil {
+			klog.Errorf("failed to count Ready nodes: %v", err)
+			ctrl.mapiSyncErrors = []error{fmt.Errorf("failed to count Ready nodes: %w", err)}
+			ctrl.updateConditions(reason, ctrl.aggregateSyncErrors(), opv1.MachineConfigurationBootImageUpdateDegraded)
+			return
+		}
+		if total > 0 && ready*100 < ctrl.knobs.minNodeReadinessPercent*total {
+			ctrl.lowNodeReadiness = fmt.Sprintf("%d of %d nodes are Ready, below the minimum of %d%%", ready, total, ctrl.knobs.minNodeReadinessPercent)
+			klog.Infof("%s, holding off boot image updates", ctrl.lowNodeReadiness)
+			progressingReason = ReasonPausedLowNodeReadiness
+		}
+	}
+
+	// A budget-limited rollout walks the machinesets in name order, resuming after the last machineset
+	// it updated, including across restarts of the controller
+	if ctrl.mapiReconcileBudget > 0 {
+		ctrl.loadRolloutCursor()
+		mapiMachineSets = orderBudgetedRollout(mapiMachineSets, ctrl.mapiRolloutCursor)
+	}
+
+	// Reset per platform/architecture metrics; the lister lookups here are best effort and only
+	// used for labeling, failures are surfaced by the per machineset sync below.
+	ctrlcommon.MCCBootImageMachineSetCount.Reset()
+	ctrlcommon.MCCBootImageMachineSetErrors.Reset()
+	ctrlcommon.MCCBootImageMachineSetRoleCount.Reset()
+	metricsInfra, _ := ctrl.infraLister.Get("cluster")
+	metricsClusterVersion, _ := ctrl.clusterVersionLister.Get("version")
 
 	// Signal start of reconciliation process, by setting progressing to true
 	var syncErrors []error
-	ctrl.updateConditions(reason, nil, opv1.MachineConfigurationBootImageUpdateProgressing)
+	ctrl.updateConditions(progressingReason, nil, opv1.MachineConfigurationBootImageUpdateProgressing)
 
+	pendingMachineSets := []string{}
 	for _, machineSet := range mapiMachineSets {
-		reconcileSkipped, err := ctrl.syncMAPIMachineSet(machineSet, configMap)
-		if err == nil {
+		pendingMachineSets = append(pendingMachineSets, machineSet.Name)
+	}
+	for i, machineSet := range mapiMachineSets {
+		ctrl.setPendingMAPIMachineSets(pendingMachineSets[i:])
+		platform, arch := getMachineSetMetricLabels(machineSet, metricsInfra, metricsClusterVersion)
+		ctrlcommon.MCCBootImageMachineSetCount.WithLabelValues(platform, arch).Inc()
+		// During a targeted rollout, all other machinesets are deferred without being evaluated or written to
+		if ctrl.knobs.targetMachineSet != "" && machineSet.Name != ctrl.knobs.targetMachineSet {
+			klog.V(4).Infof("machineset %s is not the target of %s, deferring boot image update", machineSet.Name, ctrl.annotationKey(TargetMachineSetAnnotationKey))
+			ctrl.mapiStats.deferredCount++
+			ctrl.mapiStats.inProgress++
+			ctrlcommon.MCCBootImageMachineSetRoleCount.WithLabelValues(getMachineSetRole(machineSet), string(MachineSetBootImageStatusManaged)).Inc()
+			ctrl.updateConditions(progressingReason, nil, opv1.MachineConfigurationBootImageUpdateProgressing)
+			continue
+		}
+		skipReason, reconcileSkipped, err := ctrl.syncMAPIMachineSet(machineSet, configMap)
+		status := ctrl.recordMachineSetOutcome(machineSet.Name, skipReason, err)
+		ctrlcommon.MCCBootImageMachineSetRoleCount.WithLabelValues(getMachineSetRole(machineSet), string(status)).Inc()
+		if failures := ctrl.recordMachineSetSyncResult(machineSet.Name, err); err != nil && failures <= ctrl.knobs.errorGraceCount {
+			klog.Warningf("MAPI MachineSet %s failed %d consecutive sync(s), within the error grace count of %d, retrying: %v", machineSet.Name, failures, ctrl.knobs.errorGraceCount, err)
+			ctrl.mapiStats.inProgress++
+			ctrl.mapiFailuresInGrace = true
+		} else if err == nil {
 			ctrl.mapiStats.inProgress++
+			if skipReason == "" {
+				skipReason = "None"
+			}
+			klog.InfoS("Synced MAPI MachineSet", "machineset", machineSet.Name, "platform", platform, "arch", arch, "reason", skipReason)
 		} else {
-			klog.Errorf("Error syncing MAPI MachineSet %v", err)
+			klog.ErrorS(err, "Error syncing MAPI MachineSet", "machineset", machineSet.Name, "platform", platform, "arch", arch)
 			syncErrors = append(syncErrors, fmt.Errorf("error syncing MAPI MachineSet %s: %w", machineSet.Name, err))
 			ctrl.mapiStats.erroredCount++
+			ctrlcommon.MCCBootImageMachineSetErrors.WithLabelValues(platform, arch).Inc()
+			if isTransientError(err) {
+				ctrl.mapiTransientErrors = true
+			}
 		}
 		if reconcileSkipped {
 			ctrl.mapiStats.skippedCount++
 		}
 		// Update progressing conditions every step of the loop
-		ctrl.updateConditions(reason, nil, opv1.MachineConfigurationBootImageUpdateProgressing)
+		ctrl.updateConditions(progressingReason, nil, opv1.MachineConfigurationBootImageUpdateProgressing)
+	}
+	ctrl.setPendingMAPIMachineSets(nil)
+	if !ctrl.knobs.advisoryOnly {
+		ctrl.loadRolloutCursor()
+		ctrl.persistRolloutCursor()
+	}
+	// Forget the failures of machinesets that are no longer enrolled
+	maps.DeleteFunc(ctrl.mapiFailures, func(name string, _ machineSetFailures) bool {
+		return !slices.ContainsFunc(mapiMachineSets, func(ms *machinev1beta1.MachineSet) bool { return ms.Name == name })
+	})
+	maps.DeleteFunc(ctrl.reportedPinExpirations, func(name, _ string) bool {
+		return !slices.ContainsFunc(mapiMachineSets, func(ms *machinev1beta1.MachineSet) bool { return ms.Name == name })
+	})
+	maps.DeleteFunc(ctrl.reportedAheadOfStream, func(name, _ string) bool {
+		return !slices.ContainsFunc(mapiMachineSets, func(ms *machinev1beta1.MachineSet) bool { return ms.Name == name })
+	})
+	ctrl.publishFailingMachineSets()
+	ctrl.publishProviderSpecCodecs()
+	// Update/Clear degrade conditions based on errors from this loop, along with those of
+	// the other machine resource types
+	ctrl.mapiSyncErrors = syncErrors
+	ctrl.updateConditions(ctrl.getDegradedReason(reason), ctrl.aggregateSyncErrors(), opv1.MachineConfigurationBootImageUpdateDegraded)
+	ctrl.mapiSyncDuration = time.Since(startTime).Round(time.Microsecond)
+	klog.V(4).Infof("Synced %d MAPI machinesets in %v", len(mapiMachineSets), ctrl.mapiSyncDuration)
+	if ctrl.knobs.reportSyncDuration {
+		ctrl.updateConditions(progressingReason, nil, opv1.MachineConfigurationBootImageUpdateProgressing)
 	}
-	// Update/Clear degrade conditions based on errors from this loop
-	ctrl.updateConditions(reason, kubeErrs.NewAggregate(syncErrors), opv1.MachineConfigurationBootImageUpdateDegraded)
+	ctrl.publishBootImagePlan()
+	ctrl.publishMachineSetChanges(reason)
+	ctrl.exportReconcileState(reason)
 	if ctrl.fgHandler.Enabled(features.FeatureGateBootImageSkewEnforcement) {
 		switch {
+		case ctrl.mapiStats.outOfDateCount > 0 || ctrl.mapiStats.deferredCount > 0 || ctrl.mapiStats.unevaluatedCount > 0:
+			// Advisory-only mode or a zone restriction left MachineSets out of date or unevaluated,
+			// so the current OCP version cannot be recorded; the existing record is left untouched.
 		case ctrl.mapiStats.skippedCount == 0 && len(syncErrors) == 0:
 			// All MachineSets reconciled cleanly — record the current OCP version.
 			ctrl.updateClusterBootImage()
@@ -123,13 +291,48 @@ func (ctrl *Controller) syncMAPIMachineSets(reason string) {
 	}
 }
 
-// syncMAPIMachineSet will attempt to reconcile the provided machineset.
-// Returns (reconcileSkipped, error): reconcileSkipped=true means something blocked the
+// syncMAPIMachineSet will attempt to reconcile the provided machineset, and records why
+// the machineset was not updated, if applicable, on the machineset.
+// Returns (skipReason, reconcileSkipped, error): skipReason records why the machineset was not
+// updated, if applicable. reconcileSkipped=true means something blocked the
 // boot image update that requires manual intervention; rather than returning an
 // error immediately, the condition is surfaced via skew enforcement.
 // reconcileSkipped=false means a patch was applied, the MachineSet was already up to
 // date, or it is out of scope for the MAPI path (e.g. migrated to CAPI authority).
-func (ctrl *Controller) syncMAPIMachineSet(machineSet *machinev1beta1.MachineSet, configMap *corev1.ConfigMap) (bool, error) {
+func (ctrl *Controller) syncMAPIMachineSet(machineSet *machinev1beta1.MachineSet, configMap *corev1.ConfigMap) (MachineSetSkipReason, bool, error) {
+	skipReason, reconcileSkipped, currentMachineSet, err := ctrl.reconcileMAPIMachineSet(machineSet, configMap)
+	// Advisory-only mode never writes to machine resources, and machinesets being deleted or managed
+	// by another workflow or claimed by another MCO instance are left alone
+	if ctrl.knobs.advisoryOnly || machineSet.DeletionTimestamp != nil || !isSkipReasonRecorded(skipReason) {
+		return skipReason, reconcileSkipped, err
+	}
+	if err != nil {
+		// The skip reason of the previous sync is kept, only the status reflects the failure
+		var status MachineSetBootImageStatus
+		if ctrl.knobs.reportMachineSetStatus {
+			status = MachineSetBootImageStatusErrored
+		}
+		previousReason := MachineSetSkipReason(machineSet.Annotations[ctrl.annotationKey(BootImageSkipReasonAnnotationKey)])
+		if statusErr := ctrl.setMAPIMachineSetSyncAnnotations(machineSet, previousReason, status); statusErr != nil {
+			klog.Errorf("Failed to record boot image status on machineset %s: %v", machineSet.Name, statusErr)
+		}
+		return "", false, err
+	}
+	var status MachineSetBootImageStatus
+	if ctrl.knobs.reportMachineSetStatus {
+		status = getMachineSetBootImageStatus(skipReason)
+	}
+	// Failing to record the skip reason and status does not fail the sync of the machineset
+	if err := ctrl.setMAPIMachineSetSyncAnnotations(currentMachineSet, skipReason, status); err != nil {
+		klog.Errorf("Failed to record boot image skip reason and status on machineset %s: %v", machineSet.Name, err)
+	}
+	return skipReason, reconcileSkipped, nil
+}
+
+// reconcileMAPIMachineSet implements syncMAPIMachineSet. Along with (reconcileSkipped, error), it
+// returns the reason the machineset was not updated, or an empty reason if the machineset was
+// updated or is already up to date, and the machineset as last written by the controller.
+func (ctrl *Controller) reconcileMAPIMachineSet(machineSet *machinev1beta1.MachineSet, configMap *corev1.ConfigMap) (MachineSetSkipReason, bool, *machinev1beta1.MachineSet, error) {
 
 	startTime := time.Now()
 	klog.V(4).Infof("Started syncing MAPI machineset %q (%v)", machineSet.Name, startTime)
@@ -137,11 +340,55 @@ func (ctrl *Controller) syncMAPIMachineSet(machineSet *machinev1beta1.MachineSet
 		klog.V(4).Infof("Finished syncing MAPI machineset %q (%v)", machineSet.Name, time.Since(startTime))
 	}()
 
+	// Skip machinesets that are being deleted; patching them is wasteful and may conflict with
+	// finalizers. These are counted as deferred, as no manual intervention is needed.
+	if machineSet.DeletionTimestamp != nil {
+		klog.Infof("machineset %s is being deleted, deferring boot image update", machineSet.Name)
+		ctrl.mapiStats.deferredCount++
+		return "", false, machineSet, nil
+	}
+
+	// Skip machinesets claimed by the MCO instance of another cluster, so that instances sharing
+	// machinesets do not fight over them. Not counted as skipped, as the other instance manages them.
+	if owner, ok := machineSet.GetLabels()[BootImageOwnerLabelKey]; ok {
+		infra, err := ctrl.infraLister.Get("cluster")
+		if err != nil {
+			return "", false, nil, fmt.Errorf("failed to fetch infra object during machineset sync: %w", err)
+		}
+		if owner != infra.Status.InfrastructureName {
+			klog.Infof("machineset %s is claimed by MCO instance %q via label %s, skipping boot image update", machineSet.Name, owner, BootImageOwnerLabelKey)
+			return SkipReasonOwnedByOtherInstance, false, machineSet, nil
+		}
+	}
+
 	// If the machineset has an owner reference, exit and log error. This means
 	// that the machineset may be managed by another workflow and should not be reconciled.
 	if len(machineSet.GetOwnerReferences()) != 0 {
 		klog.Infof("machineset %s has OwnerReference: %v, skipping boot image update", machineSet.Name, machineSet.GetOwnerReferences()[0].Kind+"/"+machineSet.GetOwnerReferences()[0].Name)
-		return true, nil
+		return SkipReasonOwnerReference, true, machineSet, nil
+	}
+
+	// Skip machinesets that opted out, e.g. to pin an older boot image. These are counted as skipped, as
+	// they are intentionally left behind the boot images configmap.
+	if parseBoolKnob(machineSet.GetAnnotations(), ctrl.annotationKey(BootImageOptOutAnnotationKey)) {
+		klog.Infof("machineset %s opted out of boot image updates via annotation %s, skipping boot image update", machineSet.Name, ctrl.annotationKey(BootImageOptOutAnnotationKey))
+		return SkipReasonOptedOut, true, machineSet, nil
+	}
+
+	// A machineset rolled back after repeated boot failures is frozen until the rollback annotation is
+	// removed. Otherwise, a machineset whose new machines repeatedly fail to boot since its last boot
+	// image update is rolled back, and frozen.
+	if rolledBackAt, ok := machineSet.Annotations[ctrl.annotationKey(BootImageRolledBackAtAnnotationKey)]; ok {
+		return "", false, nil, &rollbackFrozenError{name: machineSet.Name, rolledBackAt: rolledBackAt, annotationKey: ctrl.annotationKey(BootImageRolledBackAtAnnotationKey)}
+	}
+	if ctrl.knobs.autoRollbackBootFailures > 0 && !ctrl.knobs.advisoryOnly {
+		rolledBackAt, err := ctrl.rollbackOnBootFailures(machineSet)
+		if err != nil {
+			return "", false, nil, err
+		}
+		if rolledBackAt != "" {
+			return "", false, nil, &rollbackFrozenError{name: machineSet.Name, rolledBackAt: rolledBackAt, annotationKey: ctrl.annotationKey(BootImageRolledBackAtAnnotationKey)}
+		}
 	}
 
 	// Skip if the machineset has a label designating a non default stream. Not counted as skipped
@@ -150,7 +397,7 @@ func (ctrl *Controller) syncMAPIMachineSet(machineSet *machinev1beta1.MachineSet
 	if streamLabel, ok := machineSet.GetLabels()[OSStreamLabelKey]; ok {
 		if streamLabel != SupportedOSStream {
 			klog.Infof("machineset %s has unsupported stream: %v, skipping boot image update", machineSet.Name, streamLabel)
-			return false, nil
+			return SkipReasonUnsupportedOSStream, false, machineSet, nil
 		}
 	}
 
@@ -159,66 +406,411 @@ func (ctrl *Controller) syncMAPIMachineSet(machineSet *machinev1beta1.MachineSet
 	if os, ok := machineSet.Spec.Template.Labels[OSLabelKey]; ok {
 		if os == "Windows" {
 			klog.Infof("machineset %s has a windows os label, skipping boot image update", machineSet.Name)
-			return false, nil
+			return SkipReasonWindows, false, machineSet, nil
 		}
 	}
 
 	// Fetch the ClusterVersion to determine if this is a multi-arch cluster
 	clusterVersion, err := ctrl.clusterVersionLister.Get("version")
 	if err != nil {
-		return false, fmt.Errorf("failed to fetch clusterversion during machineset sync: %w", err)
+		return "", false, nil, fmt.Errorf("failed to fetch clusterversion during machineset sync: %w", err)
 	}
 
 	// Fetch the architecture type of this machineset
-	arch, err := getArchFromMachineSet(machineSet, clusterVersion)
+	arch, err := ctrl.getMachineSetArch(machineSet, clusterVersion)
 	if err != nil {
 		// If no architecture annotation was found, skip this machineset without erroring
 		// A later sync loop will pick it up once the annotation is added
 		if strings.Contains(err.Error(), "no architecture annotation found") {
-			return true, nil
+			return SkipReasonMissingArchitecture, true, machineSet, nil
 		}
-		return false, fmt.Errorf("failed to fetch arch during machineset sync: %w", err)
+		return "", false, nil, fmt.Errorf("failed to fetch arch during machineset sync: %w", err)
 	}
 
 	// Fetch the infra object to determine the platform type
 	infra, err := ctrl.infraLister.Get("cluster")
 	if err != nil {
-		return false, fmt.Errorf("failed to fetch infra object during machineset sync: %w", err)
+		return "", false, nil, fmt.Errorf("failed to fetch infra object during machineset sync: %w", err)
+	}
+
+	// Platforms that are not natively supported may only be reconciled through a configured providerspec image path
+	imagePath := ctrl.knobs.providerSpecImagePaths[infra.Status.PlatformStatus.Type]
+	if !isNativelySupportedPlatform(infra.Status.PlatformStatus.Type) && imagePath == nil {
+		klog.Infof("Skipping machineset %s, unsupported platform %s", machineSet.Name, infra.Status.PlatformStatus.Type)
+		return SkipReasonUnsupportedPlatform, false, machineSet, nil
+	}
+
+	// A providerspec of another platform's kind points to a misconfigured machineset; updating its
+	// boot image with an image of the cluster's platform would corrupt it. Likewise, a providerspec of an
+	// unrecognized apiVersion may not decode into the types the boot image is set on.
+	if err := checkProviderSpecType(infra.Status.PlatformStatus.Type, machineSet, ctrl.knobs.extraProviderSpecAPIVersions); err != nil {
+		return "", false, nil, err
+	}
+
+	// If the cluster admin has paused reconciliation on this platform, defer the machineset.
+	// Like zone restrictions, this is not counted as skipped.
+	if ctrl.knobs.platformPaused(infra.Status.PlatformStatus.Type) {
+		klog.Infof("machineset %s is on platform %s which is paused via %s, deferring boot image update", machineSet.Name, infra.Status.PlatformStatus.Type, ctrl.annotationKey(PausedPlatformsAnnotationKey))
+		ctrl.mapiStats.deferredCount++
+		return SkipReasonPlatformPaused, false, machineSet, nil
+	}
+
+	// If the cluster admin has restricted reconciliation to specific zones, defer machinesets
+	// targeting any other zone. These are not counted as skipped, as no manual intervention is needed.
+	if ctrl.knobs.zones != nil {
+		zone, err := getZoneFromMachineSet(infra, machineSet)
+		if err != nil {
+			return "", false, nil, fmt.Errorf("failed to fetch zone during machineset sync: %w", err)
+		}
+		if !ctrl.knobs.zoneAllowed(zone) {
+			klog.Infof("machineset %s targets zone %q which is not in %s, deferring boot image update", machineSet.Name, zone, ctrl.annotationKey(ZonesAnnotationKey))
+			ctrl.mapiStats.deferredCount++
+			return SkipReasonZoneDeferred, false, machineSet, nil
+		}
+	}
+
+	// A boot image held in a Secret, referenced by the machineset or cluster-wide, takes the place of
+	// the image from the boot images configmap. A missing Secret degrades this machineset.
+	source, secretImage, err := ctrl.resolveBootImageSource(machineSet)
+	ctrl.mapiImageSources[machineSet.Name] = source
+	if err != nil {
+		return "", false, nil, err
+	}
+	klog.V(4).Infof("Resolving the boot image of machineset %s from source %s", machineSet.Name, source)
+	usesSecretBootImage := source != BootImageSourceStream
+
+	// Refuse to apply a boot image from the configmap to a machineset labeled for a different OS
+	// variant, e.g. an RHCOS image to a RHEL worker machineset.
+	if !usesSecretBootImage {
+		if err := checkMachineSetOSMatchesStream(machineSet, configMap, ctrl.streamConfigMapKey); err != nil {
+			return "", false, nil, err
+		}
+	}
+
+	// In advisory-only mode, the MachineSet is evaluated without a client so that no writes
+	// (such as ignition stub upgrades) take place. vSphere is not evaluated as computing the
+	// target template requires importing it into vCenter; such MachineSets are skipped and
+	// counted as not evaluated, as their drift is unknown.
+	secretClient := ctrl.kubeClient
+	if ctrl.knobs.advisoryOnly {
+		if infra.Status.PlatformStatus.Type == osconfigv1.VSpherePlatformType {
+			klog.Infof("Advisory-only mode does not support evaluating vSphere machineset %s, skipping", machineSet.Name)
+			ctrl.mapiStats.unevaluatedCount++
+			return SkipReasonAdvisoryUnsupportedPlatform, true, machineSet, nil
+		}
+		secretClient = nil
+	}
+
+	// Updates are throttled per platform, as they call the provider's APIs. A token is only taken ahead of
+	// such a call, so that up to date machinesets are never throttled: ahead of resolutions that query the
+	// provider and are not cached, and otherwise ahead of the patch.
+	platform := infra.Status.PlatformStatus.Type
+	tokenTaken := false
+	if !usesSecretBootImage && resolutionQueriesProvider(platform) && !ctrl.isResolutionCached(infra, machineSet, configMap, arch) {
+		if !ctrl.allowPlatformReconcile(platform) {
+			return ctrl.deferThrottledMachineSet(platform, machineSet)
+		}
+		tokenTaken = true
 	}
 
 	// Check if the this MachineSet requires an update
-	patchRequired, reconcileSkipped, newMachineSet, err := checkMachineSet(infra, machineSet, configMap, arch, ctrl.kubeClient)
+	var patchRequired, reconcileSkipped bool
+	var newMachineSet *machinev1beta1.MachineSet
+	if usesSecretBootImage {
+		patchRequired, newMachineSet, err = checkMachineSetSecretBootImage(infra, machineSet, secretImage, imagePath, secretClient)
+	} else {
+		patchRequired, reconcileSkipped, newMachineSet, err = ctrl.checkMachineSetCached(infra, machineSet, configMap, arch, secretClient)
+	}
 	if err != nil {
-		return false, fmt.Errorf("failed to reconcile machineset %s, err: %w", machineSet.Name, err)
+		return "", false, nil, fmt.Errorf("failed to reconcile machineset %s, err: %w", machineSet.Name, err)
 	}
+	ctrl.recordProviderSpecCodec(infra, machineSet, newMachineSet)
 
 	if reconcileSkipped {
-		return true, nil
+		return SkipReasonUnrecognizedBootImage, true, machineSet, nil
+	}
+	// Updating a machineset that is ahead of the stream, e.g. after a manual update, would downgrade it
+	if patchRequired && !usesSecretBootImage {
+		ahead, err := ctrl.checkMachineSetAheadOfStream(infra, imagePath, configMap, arch, machineSet, newMachineSet)
+		if err != nil {
+			return "", false, nil, err
+		}
+		if ahead {
+			return SkipReasonAheadOfStream, true, machineSet, nil
+		}
+	}
+	if patchRequired && ctrl.knobs.advisoryOnly {
+		klog.Infof("Advisory-only mode, MAPI machineset %s is out of date but will not be patched", machineSet.Name)
+		ctrl.mapiStats.outOfDateCount++
+		ctrl.recordPlannedBootImageUpdate(infra, imagePath, machineSet, newMachineSet)
+		return "", false, machineSet, nil
+	}
+	if patchRequired && ctrl.mapiReplacementsInFlight {
+		klog.Infof("Too many MAPI machines are being replaced, deferring boot image update of MAPI machineset %s", machineSet.Name)
+		ctrl.mapiStats.deferredCount++
+		ctrl.mapiUpdatesHeld = true
+		return SkipReasonReplacementsInFlight, false, machineSet, nil
+	}
+	if patchRequired && ctrl.lowNodeReadiness != "" {
+		klog.Infof("Too few nodes are Ready, deferring boot image update of MAPI machineset %s", machineSet.Name)
+		ctrl.mapiStats.deferredCount++
+		ctrl.mapiUpdatesHeld = true
+		return SkipReasonLowNodeReadiness, false, machineSet, nil
+	}
+	if patchRequired && ctrl.mapiReconcileBudget > 0 && ctrl.mapiStats.updatedCount >= ctrl.mapiReconcileBudget {
+		klog.Infof("Reconcile budget of %d machinesets for this pass was used up, deferring boot image update of MAPI machineset %s", ctrl.mapiReconcileBudget, machineSet.Name)
+		ctrl.mapiStats.deferredCount++
+		ctrl.mapiUpdatesHeld = true
+		return SkipReasonBudgetDeferred, false, machineSet, nil
+	}
+	if patchRequired && ctrl.knobs.soakInterval > 0 && ctrl.clock.Since(ctrl.mapiLastUpdateTime) < ctrl.knobs.soakInterval {
+		klog.Infof("Soaking the boot image update applied at %s for %v, deferring boot image update of MAPI machineset %s", ctrl.mapiLastUpdateTime.Format(time.RFC3339), ctrl.knobs.soakInterval, machineSet.Name)
+		ctrl.mapiStats.deferredCount++
+		ctrl.mapiUpdatesHeld = true
+		return SkipReasonSoakDeferred, false, machineSet, nil
+	}
+	if patchRequired && !tokenTaken && !ctrl.allowPlatformReconcile(platform) {
+		return ctrl.deferThrottledMachineSet(platform, machineSet)
+	}
+	if patchRequired && !usesSecretBootImage && ctrl.knobs.verifyStreamImages {
+		if err := ctrl.verifyStreamImage(infra, imagePath, newMachineSet); err != nil {
+			return "", false, nil, err
+		}
+	}
+	if patchRequired && ctrl.knobs.preUpdateWebhook != "" {
+		// Called ahead of hot loop detection, so that a rejected update is not recorded as an attempt
+		request, err := newUpdateWebhookRequest(updateWebhookPhasePre, infra, imagePath, machineSet, newMachineSet)
+		if err != nil {
+			return "", false, nil, fmt.Errorf("failed to describe boot image update of machineset %s for the pre-update webhook: %w", machineSet.Name, err)
+		}
+		accepted, err := ctrl.callUpdateWebhook(ctrl.knobs.preUpdateWebhook, request)
+		if err != nil {
+			return "", false, nil, err
+		}
+		if !accepted {
+			klog.Infof("Pre-update webhook rejected the boot image update of MAPI machineset %s, deferring", machineSet.Name)
+			ctrl.mapiStats.deferredCount++
+			return SkipReasonPreUpdateWebhookRejected, false, machineSet, nil
+		}
 	}
 	if patchRequired {
 		if ctrl.checkMAPIMachineSetHotLoop(newMachineSet, configMap, infra, arch) {
-			return false, fmt.Errorf("refusing to reconcile machineset %s, hot loop detected. Please opt-out of boot image updates, adjust your machine provisioning workflow to prevent hot loops and opt back in to resume boot image updates", machineSet.Name)
+			ctrlcommon.MCOBootImageHotLoops.WithLabelValues(machineSet.Name).Inc()
+			return "", false, nil, newHotLoopError("machineset", machineSet.Name)
 		}
 		klog.Infof("Patching MAPI machineset %s", machineSet.Name)
+		updateTime := ctrl.clock.Now()
+		metav1.SetMetaDataAnnotation(&newMachineSet.ObjectMeta, ctrl.annotationKey(BootImageUpdatedByVersionAnnotationKey), version.Hash)
+		metav1.SetMetaDataAnnotation(&newMachineSet.ObjectMeta, ctrl.annotationKey(BootImageUpdatedAtAnnotationKey), updateTime.UTC().Format(time.RFC3339))
+		// Auto-rollback restores the providerspec the machineset had before this update
+		if ctrl.knobs.autoRollbackBootFailures > 0 && machineSet.Spec.Template.Spec.ProviderSpec.Value != nil {
+			metav1.SetMetaDataAnnotation(&newMachineSet.ObjectMeta, ctrl.annotationKey(BootImagePreviousProviderSpecAnnotationKey), string(machineSet.Spec.Template.Spec.ProviderSpec.Value.Raw))
+		}
+		// The skip reason and status are cleared and recorded in the same patch
+		var status MachineSetBootImageStatus
+		if ctrl.knobs.reportMachineSetStatus {
+			status = MachineSetBootImageStatusUpToDate
+		}
+		ctrl.applyMAPIMachineSetSyncAnnotations(newMachineSet, "", status)
 		if err := ctrl.patchMachineSet(machineSet, newMachineSet); err != nil {
-			return false, err
+			if k8serrors.IsConflict(err) {
+				// The machineset was written to after it was cached, so the patch is recomputed and checked
+				// against the latest version by the next pass
+				klog.Infof("MAPI machineset %s was modified concurrently, deferring its boot image update", machineSet.Name)
+				ctrl.mapiStats.deferredCount++
+				ctrl.mapiUpdatesHeld = true
+				return SkipReasonConflictDeferred, false, machineSet, nil
+			}
+			return "", false, nil, err
 		}
+		ctrl.mapiLastUpdateTime = updateTime
 		ctrl.recordMAPIBootImageState(newMachineSet, configMap, infra, arch)
-		return false, nil
+		ctrl.mapiStats.updatedCount++
+		ctrl.mapiRolloutCursor = machineSet.Name
+		if ctrl.knobs.autoRollbackBootFailures > 0 {
+			ctrl.mapiAwaitingBoots = true
+		}
+		ctrl.mapiChanged = append(ctrl.mapiChanged, machineSet.Name)
+		ctrl.notifyPostUpdateWebhook(infra, imagePath, machineSet, newMachineSet)
+		return "", false, newMachineSet, nil
 	}
 	klog.Infof("No patching required for MAPI machineset %s", machineSet.Name)
-	return false, nil
+	return "", false, machineSet, nil
+}
+
+// checkArchSafeMode returns an error if the architecture could not be determined for more than the
+// safe mode threshold percentage of the given machinesets. Machinesets that simply lack the architecture
+// annotation in a multi-arch cluster are not counted, as they are skipped until it is added.
+func (ctrl *Controller) checkArchSafeMode(machineSets []*machinev1beta1.MachineSet) error {
+	if len(machineSets) == 0 {
+		return nil
+	}
+	clusterVersion, err := ctrl.clusterVersionLister.Get("version")
+	if err != nil {
+		return fmt.Errorf("failed to fetch clusterversion for architecture safe mode check: %w", err)
+	}
+	unknown := 0
+	for _, machineSet := range machineSets {
+		if _, err := ctrl.getMachineSetArch(machineSet, clusterVersion); err != nil && !strings.Contains(err.Error(), "no architecture annotation found") {
+			unknown++
+		}
+	}
+	threshold := ctrl.knobs.archSafeModeThreshold()
+	if unknown*100 > len(machineSets)*threshold {
+		return fmt.Errorf("safe mode: the architecture of %d of %d MAPI machinesets could not be determined, exceeding the %d%% threshold set by %s; no MAPI machinesets will be updated",
+			unknown, len(machineSets), threshold, ctrl.annotationKey(ArchSafeModeThresholdPercentAnnotationKey))
+	}
+	return nil
+}
+
+// getLastBootImageUpdateTime returns the most recent boot image update time recorded on the given
+// machinesets, or the zero time if none is recorded. Malformed timestamps are ignored.
+func (ctrl *Controller) getLastBootImageUpdateTime(machineSets []*machinev1beta1.MachineSet) time.Time {
+	updatedAtKey := ctrl.annotationKey(BootImageUpdatedAtAnnotationKey)
+	var last time.Time
+	for _, machineSet := range machineSets {
+		value, ok := machineSet.Annotations[updatedAtKey]
+		if !ok {
+			continue
+		}
+		updateTime, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			klog.Warningf("Ignoring invalid value %q for annotation %s on machineset %s: %v", value, updatedAtKey, machineSet.Name, err)
+			continue
+		}
+		if updateTime.After(last) {
+			last = updateTime
+		}
+	}
+	return last
+}
+
+// countInFlightMachineReplacements returns the number of MAPI machines owned by a machineset that
+// are being replaced, i.e. are being deleted or have not yet reached the Running phase.
+func (ctrl *Controller) countInFlightMachineReplacements() (int, error) {
+	machines, err := ctrl.mapiMachineLister.Machines(MachineAPINamespace).List(labels.Everything())
+	if err != nil {
+		return 0, err
+	}
+	inFlight := 0
+	for _, machine := range machines {
+		if !slices.ContainsFunc(machine.GetOwnerReferences(), func(ref metav1.OwnerReference) bool { return ref.Kind == "MachineSet" }) {
+			continue
+		}
+		if machine.DeletionTimestamp != nil {
+			inFlight++
+			continue
+		}
+		switch ptr.Deref(machine.Status.Phase, "") {
+		case machinev1beta1.PhaseRunning, machinev1beta1.PhaseFailed:
+		default:
+			inFlight++
+		}
+	}
+	return inFlight, nil
+}
+
+// filterOptedInMachineSets returns the machinesets with BootImageManagedAnnotationKey set to true, along
+// with the number of machinesets left out, while opt-in mode is enabled. Otherwise all machinesets are
+// returned.
+func (ctrl *Controller) filterOptedInMachineSets(machineSets []*machinev1beta1.MachineSet) ([]*machinev1beta1.MachineSet, int) {
+	if !ctrl.knobs.optIn {
+		return machineSets, 0
+	}
+	managed := []*machinev1beta1.MachineSet{}
+	for _, machineSet := range machineSets {
+		if parseBoolKnob(machineSet.Annotations, ctrl.annotationKey(BootImageManagedAnnotationKey)) {
+			managed = append(managed, machineSet)
+			continue
+		}
+		klog.V(4).Infof("machineset %s has not opted in with %s, leaving it unmanaged", machineSet.Name, ctrl.annotationKey(BootImageManagedAnnotationKey))
+	}
+	return managed, len(machineSets) - len(managed)
+}
+
+// filterManagedPlatformMachineSets returns the machinesets on the platforms listed by
+// ManagedPlatformsAnnotationKey, along with the number of machinesets left out. All machinesets are
+// returned if the list is empty, or if the cluster platform cannot be determined; the sync of each
+// machineset then reports the failure.
+func (ctrl *Controller) filterManagedPlatformMachineSets(machineSets []*machinev1beta1.MachineSet) ([]*machinev1beta1.MachineSet, int) {
+	if len(ctrl.knobs.managedPlatforms) == 0 {
+		return machineSets, 0
+	}
+	infra, err := ctrl.infraLister.Get("cluster")
+	if err != nil || infra.Status.PlatformStatus == nil {
+		return machineSets, 0
+	}
+	managed := []*machinev1beta1.MachineSet{}
+	for _, machineSet := range machineSets {
+		platform := getMachineSetPlatform(infra, machineSet)
+		if ctrl.knobs.platformManaged(platform) {
+			managed = append(managed, machineSet)
+			continue
+		}
+		klog.V(4).Infof("machineset %s is on platform %s, which is not listed by %s, leaving it unmanaged", machineSet.Name, platform, ctrl.annotationKey(ManagedPlatformsAnnotationKey))
+	}
+	return managed, len(machineSets) - len(managed)
+}
+
+// filterOptedOutGroupMachineSets returns the machinesets that do not belong to a group listed by
+// OptedOutGroupsAnnotationKey, along with the number of machinesets left out.
+func (ctrl *Controller) filterOptedOutGroupMachineSets(machineSets []*machinev1beta1.MachineSet) ([]*machinev1beta1.MachineSet, int) {
+	if len(ctrl.knobs.optedOutGroups) == 0 {
+		return machineSets, 0
+	}
+	managed := []*machinev1beta1.MachineSet{}
+	for _, machineSet := range machineSets {
+		group, ok := machineSet.GetLabels()[BootImageGroupLabelKey]
+		if !ok || !slices.Contains(ctrl.knobs.optedOutGroups, group) {
+			managed = append(managed, machineSet)
+			continue
+		}
+		klog.V(4).Infof("machineset %s belongs to group %q, which is opted out by %s, leaving it unmanaged", machineSet.Name, group, ctrl.annotationKey(OptedOutGroupsAnnotationKey))
+	}
+	return managed, len(machineSets) - len(managed)
+}
+
+// getMachineSetPlatform returns the platform a machineset provisions machines on, as identified by the
+// kind of its providerspec. The cluster platform is returned if the kind is not one of providerSpecKinds.
+func getMachineSetPlatform(infra *osconfigv1.Infrastructure, machineSet *machinev1beta1.MachineSet) osconfigv1.PlatformType {
+	typeMeta := metav1.TypeMeta{}
+	if err := unmarshalProviderSpec(machineSet, &typeMeta); err == nil {
+		for platform, kind := range providerSpecKinds {
+			if typeMeta.Kind == kind {
+				return platform
+			}
+		}
+	}
+	return infra.Status.PlatformStatus.Type
+}
+
+// countReadyNodes returns the number of nodes whose Ready condition is True, along with the total number
+// of nodes.
+func (ctrl *Controller) countReadyNodes() (int, int, error) {
+	nodes, err := ctrl.nodeLister.List(labels.Everything())
+	if err != nil {
+		return 0, 0, err
+	}
+	ready := 0
+	for _, node := range nodes {
+		if slices.ContainsFunc(node.Status.Conditions, func(c corev1.NodeCondition) bool {
+			return c.Type == corev1.NodeReady && c.Status == corev1.ConditionTrue
+		}) {
+			ready++
+		}
+	}
+	return ready, len(nodes), nil
 }
 
 // getMAPIBootImageValue returns the value used for hot loop detection.
 // For vSphere, templates are updated in-place so providerSpec bytes never change;
 // the OVA release version is used instead.
-func getMAPIBootImageValue(machineSet *machinev1beta1.MachineSet, configMap *corev1.ConfigMap, infra *osconfigv1.Infrastructure, arch string) []byte {
+func getMAPIBootImageValue(machineSet *machinev1beta1.MachineSet, configMap *corev1.ConfigMap, streamKey string, infra *osconfigv1.Infrastructure, arch string) []byte {
 	value := machineSet.Spec.Template.Spec.ProviderSpec.Value.Raw
 	if infra != nil && infra.Status.PlatformStatus != nil && configMap != nil &&
 		infra.Status.PlatformStatus.Type == osconfigv1.VSpherePlatformType {
 		streamData := new(stream.Stream)
-		if err := unmarshalStreamDataConfigMap(configMap, streamData); err != nil {
+		if err := unmarshalStreamDataConfigMap(configMap, streamKey, streamData); err != nil {
 			klog.Warningf("Failed to unmarshal stream data for vSphere hot loop check: %v", err)
 		} else if streamArch, err := streamData.GetArchitecture(arch); err == nil {
 			if release := streamArch.Artifacts["vmware"].Release; release != "" {
@@ -232,14 +824,30 @@ func getMAPIBootImageValue(machineSet *machinev1beta1.MachineSet, configMap *cor
 // checkMAPIMachineSetHotLoop returns true if the next patch to this machineset
 // would exceed the hot loop limit. Does not modify the store.
 func (ctrl *Controller) checkMAPIMachineSetHotLoop(machineSet *machinev1beta1.MachineSet, configMap *corev1.ConfigMap, infra *osconfigv1.Infrastructure, arch string) bool {
-	value := getMAPIBootImageValue(machineSet, configMap, infra, arch)
+	value := getMAPIBootImageValue(machineSet, configMap, ctrl.streamConfigMapKey, infra, arch)
 	bis, ok := ctrl.mapiBootImageState[machineSet.Name]
-	return ok && bytes.Equal(bis.value, value) && bis.hotLoopCount >= HotLoopLimit
+	return ok && bytes.Equal(bis.value, value) && bis.hotLoopCount >= ctrl.getHotLoopLimit(machineSet)
+}
+
+// getHotLoopLimit returns the hot loop limit for a machineset, which may be overridden with the
+// HotLoopLimitAnnotationKey annotation. Values that are not positive integers are ignored.
+func (ctrl *Controller) getHotLoopLimit(machineSet *machinev1beta1.MachineSet) int {
+	hotLoopLimitKey := ctrl.annotationKey(HotLoopLimitAnnotationKey)
+	value, ok := machineSet.GetAnnotations()[hotLoopLimitKey]
+	if !ok {
+		return HotLoopLimit
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit <= 0 {
+		klog.Warningf("Ignoring invalid value %q for annotation %s on machineset %s, must be a positive integer", value, hotLoopLimitKey, machineSet.Name)
+		return HotLoopLimit
+	}
+	return limit
 }
 
 // recordMAPIBootImageState updates the local boot image store after a successful patch.
 func (ctrl *Controller) recordMAPIBootImageState(machineSet *machinev1beta1.MachineSet, configMap *corev1.ConfigMap, infra *osconfigv1.Infrastructure, arch string) {
-	value := getMAPIBootImageValue(machineSet, configMap, infra, arch)
+	value := getMAPIBootImageValue(machineSet, configMap, ctrl.streamConfigMapKey, infra, arch)
 	hotLoopCount := 1
 	if bis, ok := ctrl.mapiBootImageState[machineSet.Name]; ok && bytes.Equal(bis.value, value) {
 		hotLoopCount = bis.hotLoopCount + 1
@@ -247,12 +855,180 @@ func (ctrl *Controller) recordMAPIBootImageState(machineSet *machinev1beta1.Mach
 	ctrl.mapiBootImageState[machineSet.Name] = BootImageState{
 		value:        value,
 		hotLoopCount: hotLoopCount,
+		hotLoopLimit: ctrl.getHotLoopLimit(machineSet),
 	}
+	ctrl.updateHotLoopStateMetrics()
+}
+
+// updateHotLoopStateMetrics publishes the number of machine resources in the local boot image stores,
+// and the number of those whose hot loop count has reached their limit. Their boot image updates are
+// frozen until the store is reset, or the boot image to apply changes.
+func (ctrl *Controller) updateHotLoopStateMetrics() {
+	ctrlcommon.MCCBootImageHotLoopStateEntries.WithLabelValues("machineset").Set(float64(len(ctrl.mapiBootImageState)))
+	ctrlcommon.MCCBootImageHotLoopStateEntries.WithLabelValues("controlplanemachineset").Set(float64(len(ctrl.cpmsBootImageState)))
+
+	mapiFrozen := 0
+	for _, bis := range ctrl.mapiBootImageState {
+		if bis.hotLoopCount >= bis.hotLoopLimit {
+			mapiFrozen++
+		}
+	}
+	cpmsFrozen := 0
+	for _, bis := range ctrl.cpmsBootImageState {
+		if bis.hotLoopCount >= HotLoopLimit {
+			cpmsFrozen++
+		}
+	}
+	ctrlcommon.MCCBootImageHotLoopFrozenResources.WithLabelValues("machineset").Set(float64(mapiFrozen))
+	ctrlcommon.MCCBootImageHotLoopFrozenResources.WithLabelValues("controlplanemachineset").Set(float64(cpmsFrozen))
+}
+
+// Annotations that the controller sets on the MAPI machinesets it patches; any other change outside of
+// the boot image fields is unexpected
+var machineSetPatchAnnotationKeys = append([]string{
+	BootImageUpdatedByVersionAnnotationKey,
+	BootImageUpdatedAtAnnotationKey,
+	BootImagePreviousProviderSpecAnnotationKey,
+}, machineSetSyncAnnotationKeys...)
+
+// JSON pointer of the providerspec of a MAPI machineset
+const providerSpecValuePath = "/spec/template/spec/providerSpec/value"
+
+// validateMachineSetPatch returns an error if the update of a MAPI machineset would change anything but
+// the boot image fields of its providerspec and the controller's own annotations, such as its labels,
+// owner references, other providerspec fields or other metadata. This guards against collateral
+// changes from encoder quirks.
+func (ctrl *Controller) validateMachineSetPatch(oldMachineSet, newMachineSet *machinev1beta1.MachineSet) error {
+	return ctrl.validateMachineSetChanges(oldMachineSet, newMachineSet, ctrl.getMachineSetImageFieldPaths)
+}
+
+// validateMachineSetRollback returns an error if the rollback of a MAPI machineset would change anything
+// but its providerspec, the controller's own annotations and BootImageRolledBackAtAnnotationKey. Unlike
+// a boot image update, a rollback restores the whole providerspec recorded before the update.
+func (ctrl *Controller) validateMachineSetRollback(oldMachineSet, newMachineSet *machinev1beta1.MachineSet) error {
+	return ctrl.validateMachineSetChanges(oldMachineSet, newMachineSet, func(*machinev1beta1.MachineSet) ([]string, error) {
+		return []string{
+			providerSpecValuePath,
+			"/metadata/annotations/" + jsonPointerEscaper.Replace(ctrl.annotationKey(BootImageRolledBackAtAnnotationKey)),
+		}, nil
+	})
+}
+
+// validateMachineSetChanges returns an error if the update of a MAPI machineset would change anything but
+// the controller's own annotations and, if its providerspec changes, the paths returned by
+// getProviderSpecPaths.
+func (ctrl *Controller) validateMachineSetChanges(oldMachineSet, newMachineSet *machinev1beta1.MachineSet, getProviderSpecPaths func(*machinev1beta1.MachineSet) ([]string, error)) error {
+	fieldChanges, err := getMachineSetFieldChanges(oldMachineSet, newMachineSet)
+	if err != nil {
+		return err
+	}
+	// An added or removed object, such as the first annotation of a machineset, is diffed field by
+	// field so that its fields can be checked individually
+	changes := []plannedFieldChange{}
+	for _, change := range fieldChanges {
+		oldObject, oldIsObject := change.OldValue.(map[string]interface{})
+		newObject, newIsObject := change.NewValue.(map[string]interface{})
+		switch {
+		case oldIsObject && change.NewValue == nil:
+			diffJSONValues(change.Path, oldObject, map[string]interface{}{}, &changes)
+		case newIsObject && change.OldValue == nil:
+			diffJSONValues(change.Path, map[string]interface{}{}, newObject, &changes)
+		default:
+			changes = append(changes, change)
+		}
+	}
+	allowedPaths := []string{}
+	for _, key := range machineSetPatchAnnotationKeys {
+		allowedPaths = append(allowedPaths, "/metadata/annotations/"+jsonPointerEscaper.Replace(ctrl.annotationKey(key)))
+	}
+	// The providerspec paths are only looked up if the providerspec changes, so that the annotations of
+	// machinesets with a providerspec the controller cannot decode can still be updated
+	if slices.ContainsFunc(changes, func(change plannedFieldChange) bool { return isJSONPointerWithin(change.Path, providerSpecValuePath) }) {
+		providerSpecPaths, err := getProviderSpecPaths(oldMachineSet)
+		if err != nil {
+			return fmt.Errorf("refusing to patch machineset %s, the providerspec fields it may change could not be determined: %w", oldMachineSet.Name, err)
+		}
+		allowedPaths = append(allowedPaths, providerSpecPaths...)
+	}
+
+	paths := []string{}
+	for _, change := range changes {
+		if !slices.ContainsFunc(allowedPaths, func(allowed string) bool { return isJSONPointerWithin(change.Path, allowed) }) {
+			paths = append(paths, change.Path)
+		}
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+	slices.Sort(paths)
+	return fmt.Errorf("refusing to patch machineset %s, the update would unexpectedly change %s", oldMachineSet.Name, strings.Join(paths, ", "))
+}
+
+// getMachineSetImageFieldPaths returns the JSON pointers of the providerspec fields that a boot image
+// update of the machineset may change: the boot image fields of the platform, or the configured
+// providerspec image path, and the user data secret, which is updated along with a paired boot image.
+func (ctrl *Controller) getMachineSetImageFieldPaths(machineSet *machinev1beta1.MachineSet) ([]string, error) {
+	infra, err := ctrl.infraLister.Get("cluster")
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch infra object: %w", err)
+	}
+	paths := []string{providerSpecValuePath + "/userDataSecret"}
+	if imagePath := ctrl.knobs.providerSpecImagePaths[infra.Status.PlatformStatus.Type]; imagePath != nil {
+		fields := []string{}
+		for _, field := range imagePath {
+			fields = append(fields, jsonPointerEscaper.Replace(field))
+		}
+		paths = append(paths, providerSpecValuePath+"/"+strings.Join(fields, "/"))
+	}
+	switch infra.Status.PlatformStatus.Type {
+	case osconfigv1.AWSPlatformType:
+		paths = append(paths, providerSpecValuePath+"/ami")
+	case osconfigv1.AzurePlatformType:
+		paths = append(paths, providerSpecValuePath+"/image")
+	case osconfigv1.GCPPlatformType:
+		providerSpec := new(machinev1beta1.GCPMachineProviderSpec)
+		if err := unmarshalProviderSpec(machineSet, providerSpec); err != nil {
+			return nil, err
+		}
+		for idx, disk := range providerSpec.Disks {
+			if disk.Boot {
+				paths = append(paths, fmt.Sprintf("%s/disks/%d/image", providerSpecValuePath, idx))
+			}
+		}
+	case osconfigv1.VSpherePlatformType:
+		paths = append(paths, providerSpecValuePath+"/template")
+	}
+	return paths, nil
+}
+
+// isJSONPointerWithin returns true if the JSON pointer refers to the value at parent, or to a value
+// nested within it.
+func isJSONPointerWithin(pointer, parent string) bool {
+	return pointer == parent || strings.HasPrefix(pointer, parent+"/")
 }
 
 // This function patches the machineset object using the machineClient
-// Returns an error if marshsalling or patching fails.
+// Returns an error if marshsalling or patching fails, or if the patch would change more than the
+// boot image fields and the controller's own annotations.
 func (ctrl *Controller) patchMachineSet(oldMachineSet, newMachineSet *machinev1beta1.MachineSet) error {
+	if err := ctrl.validateMachineSetPatch(oldMachineSet, newMachineSet); err != nil {
+		return err
+	}
+	return ctrl.applyMachineSetPatch(oldMachineSet, newMachineSet)
+}
+
+// patchMachineSetRollback patches the rollback of a machineset to the providerspec recorded before its
+// last boot image update. Returns an error if marshsalling or patching fails, or if the patch would
+// change more than the providerspec and the controller's own annotations.
+func (ctrl *Controller) patchMachineSetRollback(oldMachineSet, newMachineSet *machinev1beta1.MachineSet) error {
+	if err := ctrl.validateMachineSetRollback(oldMachineSet, newMachineSet); err != nil {
+		return err
+	}
+	return ctrl.applyMachineSetPatch(oldMachineSet, newMachineSet)
+}
+
+// applyMachineSetPatch sends the merge patch from the old to the new machineset, once it was validated.
+func (ctrl *Controller) applyMachineSetPatch(oldMachineSet, newMachineSet *machinev1beta1.MachineSet) error {
 	machineSetMarshal, err := json.Marshal(oldMachineSet)
 	if err != nil {
 		return fmt.Errorf("unable to marshal old machineset: %w", err)
@@ -265,7 +1041,16 @@ func (ctrl *Controller) patchMachineSet(oldMachineSet, newMachineSet *machinev1b
 	if err != nil {
 		return fmt.Errorf("unable to create patch for new machineset: %w", err)
 	}
+	// The patch is guarded by the resource version it was computed against, so that a concurrent write is
+	// detected rather than overwritten. A conflicting patch is not resent; see syncMAPIMachineSet.
+	patchBytes, err = setPatchResourceVersion(patchBytes, oldMachineSet.ResourceVersion)
+	if err != nil {
+		return err
+	}
 	_, err = ctrl.machineClient.MachineV1beta1().MachineSets(MachineAPINamespace).Patch(context.TODO(), oldMachineSet.Name, types.MergePatchType, patchBytes, metav1.PatchOptions{})
+	if k8serrors.IsConflict(err) {
+		ctrlcommon.MCCBootImagePatchConflicts.WithLabelValues("machineset").Inc()
+	}
 	if err != nil {
 		return fmt.Errorf("unable to patch new machineset: %w", err)
 	}
@@ -273,6 +1058,140 @@ func (ctrl *Controller) patchMachineSet(oldMachineSet, newMachineSet *machinev1b
 	return nil
 }
 
+// getZoneFromMachineSet returns the zone targeted by the machineset's providerspec. An empty zone is
+// returned for platforms whose providerspec does not carry a zone.
+func getZoneFromMachineSet(infra *osconfigv1.Infrastructure, machineSet *machinev1beta1.MachineSet) (string, error) {
+	switch infra.Status.PlatformStatus.Type {
+	case osconfigv1.AWSPlatformType:
+		providerSpec := new(machinev1beta1.AWSMachineProviderConfig)
+		if err := unmarshalProviderSpec(machineSet, providerSpec); err != nil {
+			return "", err
+		}
+		return providerSpec.Placement.AvailabilityZone, nil
+	case osconfigv1.AzurePlatformType:
+		providerSpec := new(machinev1beta1.AzureMachineProviderSpec)
+		if err := unmarshalProviderSpec(machineSet, providerSpec); err != nil {
+			return "", err
+		}
+		return providerSpec.Zone, nil
+	case osconfigv1.GCPPlatformType:
+		providerSpec := new(machinev1beta1.GCPMachineProviderSpec)
+		if err := unmarshalProviderSpec(machineSet, providerSpec); err != nil {
+			return "", err
+		}
+		return providerSpec.Zone, nil
+	default:
+		return "", nil
+	}
+}
+
+// getMachineSetMetricLabels returns the platform and architecture labels used for the boot image
+// machineset metrics. Both are drawn from fixed enumerations, with "unknown" used when the value
+// cannot be determined, so that metric cardinality stays bounded.
+func getMachineSetMetricLabels(machineSet *machinev1beta1.MachineSet, infra *osconfigv1.Infrastructure, clusterVersion *osconfigv1.ClusterVersion) (platform, arch string) {
+	platform, arch = metricLabelUnknown, metricLabelUnknown
+	if infra != nil && infra.Status.PlatformStatus != nil && infra.Status.PlatformStatus.Type != "" {
+		platform = string(infra.Status.PlatformStatus.Type)
+	}
+	if clusterVersion != nil {
+		if machineSetArch, err := getArchFromMachineSet(machineSet, clusterVersion); err == nil {
+			arch = machineSetArch
+		}
+	}
+	return platform, arch
+}
+
+// getMachineSetRole returns the machine role of a machineset, e.g. "worker" or "infra", as labeled on its
+// machine template or, failing that, on the machineset itself. "unspecified" is returned if it has none.
+func getMachineSetRole(machineSet *machinev1beta1.MachineSet) string {
+	if role := machineSet.Spec.Template.Labels[MachineRoleLabelKey]; role != "" {
+		return role
+	}
+	if role := machineSet.Labels[MachineRoleLabelKey]; role != "" {
+		return role
+	}
+	return metricLabelUnspecifiedRole
+}
+
+// osVariantAliases maps the identifiers used in machineset OSLabelKey labels and boot image stream
+// names to the OS variant they denote. Keys are lowercase.
+var osVariantAliases = map[string]string{
+	"rhcos":                "rhcos",
+	"rhel-coreos":          "rhcos",
+	"rhel":                 "rhel",
+	"scos":                 "scos",
+	"centos-stream-coreos": "scos",
+	"fcos":                 "fcos",
+	"fedora-coreos":        "fcos",
+}
+
+// getStreamOSVariant returns the OS variant of a boot image stream, based on the prefix of its name
+// (e.g. "rhcos-9.6"). An empty string is returned if the variant cannot be determined.
+func getStreamOSVariant(streamName string) string {
+	streamName = strings.ToLower(streamName)
+	variant, longestMatch := "", 0
+	for alias, aliasVariant := range osVariantAliases {
+		if len(alias) <= longestMatch {
+			continue
+		}
+		if rest, found := strings.CutPrefix(streamName, alias); found && (rest == "" || strings.HasPrefix(rest, "-")) {
+			variant, longestMatch = aliasVariant, len(alias)
+		}
+	}
+	return variant
+}
+
+// checkProviderSpecType returns an error if the kind of the machineset's providerspec is not the kind
+// used on the natively supported platform, or if its apiVersion is neither one of providerSpecAPIVersions
+// of the platform nor one of extraAPIVersions. Providerspecs that do not declare a kind or apiVersion,
+// and platforms that are not natively supported, are not checked.
+func checkProviderSpecType(platform osconfigv1.PlatformType, machineSet *machinev1beta1.MachineSet, extraAPIVersions []string) error {
+	expectedKind, ok := providerSpecKinds[platform]
+	if !ok {
+		return nil
+	}
+	typeMeta := metav1.TypeMeta{}
+	if err := unmarshalProviderSpec(machineSet, &typeMeta); err != nil {
+		return err
+	}
+	if typeMeta.Kind != "" && typeMeta.Kind != expectedKind {
+		return fmt.Errorf("refusing to reconcile machineset %s: its providerspec kind %s does not match the %s kind %s of the cluster platform", machineSet.Name, typeMeta.Kind, platform, expectedKind)
+	}
+	apiVersions := append(append([]string{}, providerSpecAPIVersions[platform]...), extraAPIVersions...)
+	if typeMeta.APIVersion != "" && !slices.Contains(apiVersions, typeMeta.APIVersion) {
+		return fmt.Errorf("refusing to reconcile machineset %s: its providerspec apiVersion %s is not recognized on platform %s, expected one of %s", machineSet.Name, typeMeta.APIVersion, platform, strings.Join(apiVersions, ", "))
+	}
+	return nil
+}
+
+// checkMachineSetOSMatchesStream returns an error if the machineset's OSLabelKey label names a different
+// OS variant than the boot image stream in the configmap. Machinesets without the label, or with a value
+// that does not name a known variant, are not checked.
+func checkMachineSetOSMatchesStream(machineSet *machinev1beta1.MachineSet, configMap *corev1.ConfigMap, streamKey string) error {
+	osLabel, ok := machineSet.Spec.Template.Labels[OSLabelKey]
+	if !ok {
+		return nil
+	}
+	machineSetVariant, ok := osVariantAliases[strings.ToLower(strings.TrimSpace(osLabel))]
+	if !ok {
+		klog.V(4).Infof("machineset %s has an unrecognized %s label %q, skipping OS variant check", machineSet.Name, OSLabelKey, osLabel)
+		return nil
+	}
+	streamData := new(stream.Stream)
+	if err := unmarshalStreamDataConfigMap(configMap, streamKey, streamData); err != nil {
+		return err
+	}
+	streamVariant := getStreamOSVariant(streamData.Stream)
+	if streamVariant == "" {
+		klog.V(4).Infof("unable to determine the OS variant of stream %q, skipping OS variant check for machineset %s", streamData.Stream, machineSet.Name)
+		return nil
+	}
+	if streamVariant != machineSetVariant {
+		return fmt.Errorf("refusing to reconcile machineset %s: its %s label %q does not match the OS of boot image stream %q", machineSet.Name, OSLabelKey, osLabel, streamData.Stream)
+	}
+	return nil
+}
+
 // Returns architecture type for a given machineset
 func getArchFromMachineSet(machineset *machinev1beta1.MachineSet, clusterVersion *osconfigv1.ClusterVersion) (arch string, err error) {
 
@@ -281,7 +1200,8 @@ func getArchFromMachineSet(machineset *machinev1beta1.MachineSet, clusterVersion
 	// Check if the annotation enclosing arch label is present on this machineset
 	archLabel, archLabelMatch := machineset.Annotations[MachineSetArchAnnotationKey]
 
-	if !archLabelMatch {
+	// An empty annotation carries no labels at all, so treat it the same as a missing one
+	if !archLabelMatch || strings.TrimSpace(archLabel) == "" {
 		// Check if this is a multi-arch cluster
 		// clusterVersion should never be nil as it's validated by the caller
 		if clusterVersion.Status.Desired.Architecture == osconfigv1.ClusterVersionArchitectureMulti {
@@ -294,17 +1214,68 @@ func getArchFromMachineSet(machineset *machinev1beta1.MachineSet, clusterVersion
 		return archtranslater.CurrentRpmArch(), nil
 	}
 
-	// Parse the annotation value which may contain multiple comma-separated labels
+	// Parse the annotation value which may contain multiple comma-separated labels, in any order
+	// and with arbitrary whitespace around each label, key and value.
 	// Example: kubernetes.io/arch=amd64,topology.ebs.csi.aws.com/zone=eu-central-1a
+	archLabelName := strings.TrimSuffix(ArchLabelKey, "=")
+	archLabelValue := ""
 	for label := range strings.SplitSeq(archLabel, ",") {
-		label = strings.TrimSpace(label)
-		if archLabelValue, found := strings.CutPrefix(label, ArchLabelKey); found {
-			// Extract just the architecture value after "kubernetes.io/arch="
-			if validArchSet.Has(archLabelValue) {
-				return archtranslater.RpmArch(archLabelValue), nil
-			}
-			return "", fmt.Errorf("invalid architecture value found in annotation: %s", archLabelValue)
+		key, value, found := strings.Cut(label, "=")
+		if !found || strings.TrimSpace(key) != archLabelName {
+			continue
 		}
+		value = strings.TrimSpace(value)
+		if archLabelValue != "" && archLabelValue != value {
+			return "", fmt.Errorf("conflicting architecture values found in annotation: %s", archLabel)
+		}
+		archLabelValue = value
+	}
+	if archLabelValue != "" {
+		if validArchSet.Has(archLabelValue) {
+			return archtranslater.RpmArch(archLabelValue), nil
+		}
+		return "", fmt.Errorf("invalid architecture value found in annotation: %s", archLabelValue)
 	}
 	return "", fmt.Errorf("kubernetes.io/arch label not found in annotation: %s", archLabel)
 }
+
+// getMachineSetArch returns the architecture of a machineset as getArchFromMachineSet does. If
+// DefaultSingleArchAnnotationKey is set, a machineset whose architecture annotation cannot be parsed
+// defaults to the node architecture of a single-arch cluster instead of erroring.
+func (ctrl *Controller) getMachineSetArch(machineSet *machinev1beta1.MachineSet, clusterVersion *osconfigv1.ClusterVersion) (string, error) {
+	arch, err := getArchFromMachineSet(machineSet, clusterVersion)
+	if err == nil || !ctrl.knobs.defaultSingleArch || strings.Contains(err.Error(), "no architecture annotation found") {
+		return arch, err
+	}
+	clusterArch, singleArchErr := ctrl.getSingleClusterArch(clusterVersion)
+	if singleArchErr != nil {
+		klog.Warningf("Not defaulting the architecture of machineset %s: %v", machineSet.Name, singleArchErr)
+		return "", err
+	}
+	klog.Warningf("Defaulting machineset %s to the %s architecture of this single-arch cluster: %v", machineSet.Name, clusterArch, err)
+	return clusterArch, nil
+}
+
+// getSingleClusterArch returns the architecture of a cluster that is confirmed to be single-arch, both by
+// the ClusterVersion and by all nodes sharing a single, valid architecture label.
+func (ctrl *Controller) getSingleClusterArch(clusterVersion *osconfigv1.ClusterVersion) (string, error) {
+	if clusterVersion.Status.Desired.Architecture == osconfigv1.ClusterVersionArchitectureMulti {
+		return "", fmt.Errorf("the cluster is multi-arch")
+	}
+	nodes, err := ctrl.nodeLister.List(labels.Everything())
+	if err != nil {
+		return "", fmt.Errorf("failed to list nodes: %w", err)
+	}
+	nodeArchs := sets.New[string]()
+	for _, node := range nodes {
+		nodeArchs.Insert(node.Labels[corev1.LabelArchStable])
+	}
+	if nodeArchs.Len() != 1 {
+		return "", fmt.Errorf("the nodes do not share a single architecture: %s", strings.Join(sets.List(nodeArchs), ", "))
+	}
+	nodeArch := nodeArchs.UnsortedList()[0]
+	if !sets.New("arm64", "s390x", "amd64", "ppc64le").Has(nodeArch) {
+		return "", fmt.Errorf("invalid node architecture %q", nodeArch)
+	}
+	return archtranslater.RpmArch(nodeArch), nil
+}
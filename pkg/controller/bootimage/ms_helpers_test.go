@@ -0,0 +1,627 @@
+package bootimage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/zapr"
+	osconfigv1 "github.com/openshift/api/config/v1"
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	opv1 "github.com/openshift/api/operator/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/openshift/machine-config-operator/pkg/version"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+)
+
+func TestMachineSetMetrics(t *testing.T) {
+	failingAMD64 := getGCPMachineSet("failing-amd64", testGCPOldImage)
+	failingAMD64.Annotations[BootImageSecretRefAnnotationKey] = "missing-secret"
+	failingARM64 := withAnnotation(getGCPMachineSet("failing-arm64", testGCPOldImage), MachineSetArchAnnotationKey, "kubernetes.io/arch=arm64")
+	failingARM64.Annotations[BootImageSecretRefAnnotationKey] = "missing-secret"
+	unknownArch := withAnnotation(getGCPMachineSet("unknown-arch", testGCPOldImage), MachineSetArchAnnotationKey, "kubernetes.io/arch=riscv64")
+
+	machineSets := []*machinev1beta1.MachineSet{
+		getGCPMachineSet("healthy-amd64", testGCPOldImage),
+		failingAMD64,
+		failingARM64,
+		unknownArch,
+	}
+	ctrl := newTestController(t, osconfigv1.GCPPlatformType, machineSets, nil)
+
+	// Seed a stale series to confirm that each sync starts from a clean slate
+	ctrlcommon.MCCBootImageMachineSetCount.WithLabelValues("AWS", "x86_64").Set(5)
+
+	ctrl.syncMAPIMachineSets("test")
+
+	gcp := string(osconfigv1.GCPPlatformType)
+	assert.Equal(t, 2.0, testutil.ToFloat64(ctrlcommon.MCCBootImageMachineSetCount.WithLabelValues(gcp, "x86_64")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(ctrlcommon.MCCBootImageMachineSetErrors.WithLabelValues(gcp, "x86_64")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(ctrlcommon.MCCBootImageMachineSetCount.WithLabelValues(gcp, "aarch64")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(ctrlcommon.MCCBootImageMachineSetErrors.WithLabelValues(gcp, "aarch64")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(ctrlcommon.MCCBootImageMachineSetCount.WithLabelValues(gcp, metricLabelUnknown)))
+	assert.Equal(t, 3, testutil.CollectAndCount(ctrlcommon.MCCBootImageMachineSetCount))
+}
+
+func TestMachineSetRoleMetrics(t *testing.T) {
+	// Sets the machine role label on the machine template of the machineset
+	withRole := func(ms *machinev1beta1.MachineSet, role string) *machinev1beta1.MachineSet {
+		ms.Spec.Template.Labels = map[string]string{MachineRoleLabelKey: role}
+		return ms
+	}
+	failingWorker := withRole(getGCPMachineSet("failing-worker", testGCPOldImage), "worker")
+	failingWorker.Annotations[BootImageSecretRefAnnotationKey] = "missing-secret"
+	// A machineset labeled only on its own metadata
+	labeledInfra := getGCPMachineSet("labeled-infra", testGCPOldImage)
+	labeledInfra.Labels = map[string]string{MachineRoleLabelKey: "infra"}
+
+	machineSets := []*machinev1beta1.MachineSet{
+		withRole(getGCPMachineSet("worker-a", testGCPOldImage), "worker"),
+		withRole(getGCPMachineSet("worker-b", testGCPStreamImage), "worker"),
+		failingWorker,
+		withRole(getGCPMachineSet("infra-a", testGCPOldImage), "infra"),
+		labeledInfra,
+		getGCPMachineSet("no-role", testGCPOldImage),
+	}
+	ctrl := newTestController(t, osconfigv1.GCPPlatformType, machineSets, nil)
+
+	// Seed a stale series to confirm that each sync starts from a clean slate
+	ctrlcommon.MCCBootImageMachineSetRoleCount.WithLabelValues("edge", string(MachineSetBootImageStatusFrozen)).Set(5)
+
+	ctrl.syncMAPIMachineSets("test")
+
+	upToDate := string(MachineSetBootImageStatusUpToDate)
+	assert.Equal(t, 2.0, testutil.ToFloat64(ctrlcommon.MCCBootImageMachineSetRoleCount.WithLabelValues("worker", upToDate)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(ctrlcommon.MCCBootImageMachineSetRoleCount.WithLabelValues("worker", string(MachineSetBootImageStatusErrored))))
+	assert.Equal(t, 2.0, testutil.ToFloat64(ctrlcommon.MCCBootImageMachineSetRoleCount.WithLabelValues("infra", upToDate)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(ctrlcommon.MCCBootImageMachineSetRoleCount.WithLabelValues(metricLabelUnspecifiedRole, upToDate)))
+	assert.Equal(t, 4, testutil.CollectAndCount(ctrlcommon.MCCBootImageMachineSetRoleCount))
+}
+
+func TestMachineSetOSMatchesStream(t *testing.T) {
+	cases := []struct {
+		name        string
+		osLabel     string
+		expectError bool
+	}{
+		{
+			name: "machineset without an OS label is reconciled",
+		},
+		{
+			name:    "machineset labeled with the stream OS is reconciled",
+			osLabel: "RHCOS",
+		},
+		{
+			name:    "machineset labeled with an unrecognized OS is reconciled",
+			osLabel: "Linux",
+		},
+		{
+			name:        "machineset labeled with a different OS is refused",
+			osLabel:     "rhel",
+			expectError: true,
+		},
+		{
+			name:        "machineset labeled with a different CoreOS variant is refused",
+			osLabel:     "fedora-coreos",
+			expectError: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ms := getGCPMachineSet("machineset-a", testGCPOldImage)
+			if tc.osLabel != "" {
+				ms.Spec.Template.Labels = map[string]string{OSLabelKey: tc.osLabel}
+			}
+			ctrl := newTestController(t, osconfigv1.GCPPlatformType, []*machinev1beta1.MachineSet{ms}, nil)
+
+			ctrl.syncMAPIMachineSets("test")
+
+			degraded := ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateDegraded)
+			if tc.expectError {
+				assert.Equal(t, v1.ConditionTrue, degraded.Status)
+				assert.Contains(t, degraded.Message, fmt.Sprintf("does not match the OS of boot image stream %q", "rhcos-9.6"))
+				assert.Equal(t, 0, ctrl.countMachineSetPatches())
+				assert.Equal(t, testGCPOldImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, ms.Name)))
+			} else {
+				assert.Equal(t, v1.ConditionFalse, degraded.Status)
+				assert.Equal(t, testGCPStreamImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, ms.Name)))
+			}
+		})
+	}
+}
+
+func TestGetStreamOSVariant(t *testing.T) {
+	cases := map[string]string{
+		"rhcos-9.6":         "rhcos",
+		"rhel-coreos-9":     "rhcos",
+		"rhel-9.6":          "rhel",
+		"scos-10.0":         "scos",
+		"fedora-coreos":     "fcos",
+		"stable":            "",
+		"rhcosextra-9.6":    "",
+		"centos-stream-9.6": "",
+	}
+	for streamName, expected := range cases {
+		assert.Equal(t, expected, getStreamOSVariant(streamName), "stream %q", streamName)
+	}
+}
+
+func TestSyncLogsMachineSetContext(t *testing.T) {
+	var buf bytes.Buffer
+	encoderConfig := zap.NewProductionEncoderConfig()
+	logger := zap.New(zapcore.NewCore(zapcore.NewJSONEncoder(encoderConfig), zapcore.AddSync(&buf), zapcore.DebugLevel))
+	klog.SetLogger(zapr.NewLogger(logger))
+	defer klog.ClearLogger()
+
+	unknownArch := withAnnotation(getGCPMachineSet("unknown-arch", testGCPOldImage), MachineSetArchAnnotationKey, "kubernetes.io/arch=riscv64")
+	failing := getGCPMachineSet("failing", testGCPOldImage)
+	failing.Annotations[BootImageSecretRefAnnotationKey] = "missing-secret"
+	windows := getGCPMachineSet("windows", testGCPOldImage)
+	windows.Spec.Template.Labels = map[string]string{OSLabelKey: "Windows"}
+	ctrl := newTestController(t, osconfigv1.GCPPlatformType, []*machinev1beta1.MachineSet{
+		getGCPMachineSet("healthy", testGCPOldImage), unknownArch, failing, windows,
+	}, nil)
+
+	ctrl.syncMAPIMachineSets("test")
+	klog.Flush()
+
+	entries := map[string]map[string]interface{}{}
+	for line := range strings.SplitSeq(strings.TrimSpace(buf.String()), "\n") {
+		entry := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal([]byte(line), &entry), "log line is not JSON: %s", line)
+		if name, ok := entry["machineset"].(string); ok {
+			entries[name] = entry
+		}
+	}
+
+	gcp := string(osconfigv1.GCPPlatformType)
+	for name, expected := range map[string]map[string]interface{}{
+		"healthy":      {"platform": gcp, "arch": "x86_64", "reason": "None"},
+		"unknown-arch": {"platform": gcp, "arch": metricLabelUnknown},
+		"windows":      {"platform": gcp, "arch": "x86_64", "reason": string(SkipReasonWindows)},
+		"failing":      {"platform": gcp, "arch": "x86_64"},
+	} {
+		entry, ok := entries[name]
+		if !assert.True(t, ok, "no structured log entry for machineset %s", name) {
+			continue
+		}
+		for key, value := range expected {
+			assert.Equal(t, value, entry[key], "key %s for machineset %s", key, name)
+		}
+	}
+	assert.Contains(t, entries["failing"], "error")
+}
+
+func TestPreserveUnknownProviderSpecFields(t *testing.T) {
+	machineSet := getGCPMachineSet("machineset-a", testGCPOldImage)
+	// Add user-added labels and tags, along with fields unknown to the typed providerspec
+	machineSet.Spec.Template.Spec.ProviderSpec.Value.Raw = []byte(fmt.Sprintf(`{
+		"customField": {"owner": "team-a"},
+		"disks": [{"boot": true, "image": %q, "futureDiskField": "keep-me"}],
+		"labels": {"cost-center": "1234"},
+		"tags": ["user-tag"],
+		"userDataSecret": {"name": "test-secret"}
+	}`, testGCPOldImage))
+
+	ctrl := newTestController(t, osconfigv1.GCPPlatformType, []*machinev1beta1.MachineSet{machineSet}, nil)
+	require.NoError(t, ctrl.syncAll("test"))
+	require.Equal(t, 1, ctrl.countMachineSetPatches())
+
+	for _, action := range ctrl.machineClient.Actions() {
+		if action.GetVerb() == "patch" {
+			assert.NotContains(t, string(action.(clienttesting.PatchAction).GetPatch()), "null", "patch must not remove any providerspec fields")
+		}
+	}
+
+	updated := ctrl.getMachineSet(t, machineSet.Name)
+	assert.Equal(t, testGCPStreamImage, getGCPMachineSetBootImage(t, updated))
+
+	providerSpec := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(updated.Spec.Template.Spec.ProviderSpec.Value.Raw, &providerSpec))
+	assert.Equal(t, map[string]interface{}{"owner": "team-a"}, providerSpec["customField"])
+	assert.Equal(t, map[string]interface{}{"cost-center": "1234"}, providerSpec["labels"])
+	assert.Equal(t, []interface{}{"user-tag"}, providerSpec["tags"])
+	disks := providerSpec["disks"].([]interface{})
+	require.Len(t, disks, 1)
+	assert.Equal(t, "keep-me", disks[0].(map[string]interface{})["futureDiskField"])
+}
+
+func TestMergeProviderSpecChanges(t *testing.T) {
+	type spec struct {
+		Image string   `json:"image"`
+		Zones []string `json:"zones,omitempty"`
+		Count int      `json:"count,omitempty"`
+	}
+	cases := []struct {
+		name     string
+		raw      string
+		original spec
+		updated  spec
+		expected string
+	}{
+		{
+			name:     "changed field is updated and unknown fields are kept",
+			raw:      `{"image":"old","unknown":"value"}`,
+			original: spec{Image: "old"},
+			updated:  spec{Image: "new"},
+			expected: `{"image":"new","unknown":"value"}`,
+		},
+		{
+			name:     "unchanged defaults absent from the raw providerspec are not added",
+			raw:      `{"image":"old"}`,
+			original: spec{Image: "old", Count: 0},
+			updated:  spec{Image: "new", Count: 0},
+			expected: `{"image":"new"}`,
+		},
+		{
+			name:     "field dropped by the update is removed",
+			raw:      `{"image":"old","count":2,"unknown":"value"}`,
+			original: spec{Image: "old", Count: 2},
+			updated:  spec{Image: "old"},
+			expected: `{"image":"old","unknown":"value"}`,
+		},
+		{
+			name:     "list that changes length is replaced",
+			raw:      `{"image":"old","zones":["a"]}`,
+			original: spec{Image: "old", Zones: []string{"a"}},
+			updated:  spec{Image: "old", Zones: []string{"a", "b"}},
+			expected: `{"image":"old","zones":["a","b"]}`,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			merged, err := mergeProviderSpecChanges([]byte(tc.raw), tc.original, tc.updated)
+			require.NoError(t, err)
+			assert.JSONEq(t, tc.expected, string(merged.Raw))
+		})
+	}
+}
+
+func TestMachineSetPatchConflictCounted(t *testing.T) {
+	ms := getGCPMachineSet("machineset-a", testGCPOldImage)
+	ms.ResourceVersion = "1"
+	ctrl := newTestController(t, osconfigv1.GCPPlatformType, []*machinev1beta1.MachineSet{ms}, nil)
+
+	// Simulate another writer updating the machineset after it was cached, so the first patch conflicts
+	conflicts := 0
+	ctrl.machineClient.PrependReactor("patch", "machinesets", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		assert.Contains(t, string(action.(clienttesting.PatchAction).GetPatch()), `"resourceVersion":"1"`, "patch should be guarded by the resource version")
+		if conflicts == 0 {
+			conflicts++
+			return true, nil, k8serrors.NewConflict(machinev1beta1.Resource("machinesets"), ms.Name, fmt.Errorf("the object has been modified"))
+		}
+		return false, nil, nil
+	})
+
+	// The conflict is counted, the stale patch is not resent, and the update is deferred to a later pass
+	before := testutil.ToFloat64(ctrlcommon.MCCBootImagePatchConflicts.WithLabelValues("machineset"))
+	require.NoError(t, ctrl.syncAll("test"))
+	assert.Equal(t, before+1, testutil.ToFloat64(ctrlcommon.MCCBootImagePatchConflicts.WithLabelValues("machineset")))
+	assert.Equal(t, 1, conflicts)
+	assert.Len(t, ctrl.machineClient.Actions(), 1, "no skip reason should be recorded on the out of date machineset")
+	assert.Equal(t, testGCPOldImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, ms.Name)))
+	assert.Equal(t, 1, ctrl.mapiStats.deferredCount)
+	assert.True(t, ctrl.mapiUpdatesHeld)
+	assert.Equal(t, v1.ConditionFalse, ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateDegraded).Status)
+
+	// The next pass recomputes the patch and applies it
+	require.NoError(t, ctrl.syncAll("test"))
+	assert.Equal(t, before+1, testutil.ToFloat64(ctrlcommon.MCCBootImagePatchConflicts.WithLabelValues("machineset")))
+	assert.Equal(t, testGCPStreamImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, ms.Name)))
+	assert.False(t, ctrl.mapiUpdatesHeld)
+	assert.Equal(t, v1.ConditionFalse, ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateDegraded).Status)
+}
+
+func TestUpdatedByVersionAnnotation(t *testing.T) {
+	originalHash := version.Hash
+	version.Hash = "test-controller-hash"
+	t.Cleanup(func() { version.Hash = originalHash })
+
+	machineSets := []*machinev1beta1.MachineSet{
+		getGCPMachineSet("machineset-outdated", testGCPOldImage),
+		getGCPMachineSet("machineset-current", testGCPStreamImage),
+	}
+	ctrl := newTestController(t, osconfigv1.GCPPlatformType, machineSets, nil)
+	require.NoError(t, ctrl.syncAll("test"))
+
+	// Only machinesets whose boot image was changed are stamped with the controller version
+	assert.Equal(t, "test-controller-hash", ctrl.getMachineSet(t, "machineset-outdated").Annotations[BootImageUpdatedByVersionAnnotationKey])
+	assert.NotContains(t, ctrl.getMachineSet(t, "machineset-current").Annotations, BootImageUpdatedByVersionAnnotationKey)
+}
+
+func TestProviderSpecKindMatchesPlatform(t *testing.T) {
+	// Returns a GCP machineset whose providerspec declares the given kind
+	getMachineSetWithKind := func(t *testing.T, name, kind string) *machinev1beta1.MachineSet {
+		t.Helper()
+		machineSet := getGCPMachineSet(name, testGCPOldImage)
+		providerSpec := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal(machineSet.Spec.Template.Spec.ProviderSpec.Value.Raw, &providerSpec))
+		if kind != "" {
+			providerSpec["kind"] = kind
+		}
+		raw, err := json.Marshal(providerSpec)
+		require.NoError(t, err)
+		machineSet.Spec.Template.Spec.ProviderSpec.Value.Raw = raw
+		return machineSet
+	}
+
+	cases := []struct {
+		name        string
+		platform    osconfigv1.PlatformType
+		kind        string
+		expectError bool
+	}{
+		{name: "GCP kind on GCP", platform: osconfigv1.GCPPlatformType, kind: "GCPMachineProviderSpec"},
+		{name: "AWS kind on AWS", platform: osconfigv1.AWSPlatformType, kind: "AWSMachineProviderConfig"},
+		{name: "Azure kind on Azure", platform: osconfigv1.AzurePlatformType, kind: "AzureMachineProviderSpec"},
+		{name: "vSphere kind on vSphere", platform: osconfigv1.VSpherePlatformType, kind: "VSphereMachineProviderSpec"},
+		{name: "no kind is not checked", platform: osconfigv1.GCPPlatformType},
+		{name: "unsupported platform is not checked", platform: osconfigv1.NutanixPlatformType, kind: "NutanixMachineProviderConfig"},
+		{name: "AWS kind on GCP", platform: osconfigv1.GCPPlatformType, kind: "AWSMachineProviderConfig", expectError: true},
+		{name: "GCP kind on Azure", platform: osconfigv1.AzurePlatformType, kind: "GCPMachineProviderSpec", expectError: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkProviderSpecType(tc.platform, getMachineSetWithKind(t, "machineset-a", tc.kind), nil)
+			if tc.expectError {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), fmt.Sprintf("providerspec kind %s does not match", tc.kind))
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	// A mismatched machineset is errored and left untouched, while the others are reconciled
+	machineSets := []*machinev1beta1.MachineSet{
+		getMachineSetWithKind(t, "machineset-mismatched", "AWSMachineProviderConfig"),
+		getMachineSetWithKind(t, "machineset-matching", "GCPMachineProviderSpec"),
+	}
+	ctrl := newTestController(t, osconfigv1.GCPPlatformType, machineSets, nil)
+	require.NoError(t, ctrl.syncAll("test"))
+
+	assert.Equal(t, testGCPOldImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, "machineset-mismatched")))
+	assert.Equal(t, testGCPStreamImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, "machineset-matching")))
+	degraded := ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateDegraded)
+	assert.Equal(t, v1.ConditionTrue, degraded.Status)
+	assert.Contains(t, degraded.Message, "machineset machineset-mismatched: its providerspec kind AWSMachineProviderConfig does not match")
+}
+
+func TestHotLoopStateMetric(t *testing.T) {
+	machineSets := []*machinev1beta1.MachineSet{
+		getGCPMachineSet("machineset-a", testGCPOldImage),
+		getGCPMachineSet("machineset-b", testGCPOldImage),
+		getGCPMachineSet("machineset-current", testGCPStreamImage),
+	}
+	ctrl := newTestController(t, osconfigv1.GCPPlatformType, machineSets, nil)
+	getEntries := func() float64 {
+		return testutil.ToFloat64(ctrlcommon.MCCBootImageHotLoopStateEntries.WithLabelValues("machineset"))
+	}
+
+	// Only updated machinesets are tracked
+	ctrl.syncMAPIMachineSets("test")
+	assert.Len(t, ctrl.mapiBootImageState, 2)
+	assert.Equal(t, float64(2), getEntries())
+
+	// Unenrolling the machinesets prunes their entries
+	mcop := ctrl.getMachineConfiguration(t)
+	mcop.Status.ManagedBootImagesStatus.MachineManagers = nil
+	require.NoError(t, ctrl.mcopIndexer.Update(mcop))
+	ctrl.syncMAPIMachineSets("test")
+	assert.Empty(t, ctrl.mapiBootImageState)
+	assert.Equal(t, float64(0), getEntries())
+}
+
+func TestValidateMachineSetPatch(t *testing.T) {
+	cases := []struct {
+		name          string
+		mutate        func(*testing.T, *machinev1beta1.MachineSet)
+		expectedError string
+	}{
+		{
+			name: "the boot image and the controller's own annotations may change",
+			mutate: func(_ *testing.T, machineSet *machinev1beta1.MachineSet) {
+				machineSet.Annotations[BootImageUpdatedByVersionAnnotationKey] = "version"
+				machineSet.Annotations[BootImageUpdatedAtAnnotationKey] = "2025-01-01T00:00:00Z"
+				machineSet.Annotations[BootImageStatusAnnotationKey] = string(MachineSetBootImageStatusUpToDate)
+			},
+		},
+		{
+			name: "labels must not change",
+			mutate: func(_ *testing.T, machineSet *machinev1beta1.MachineSet) {
+				machineSet.Labels = map[string]string{"example.com/added": "value"}
+			},
+			expectedError: "unexpectedly change /metadata/labels",
+		},
+		{
+			name: "other annotations must not change",
+			mutate: func(_ *testing.T, machineSet *machinev1beta1.MachineSet) {
+				machineSet.Annotations["example.com/added"] = "value"
+			},
+			expectedError: "unexpectedly change /metadata/annotations/example.com~1added",
+		},
+		{
+			name: "owner references must not change",
+			mutate: func(_ *testing.T, machineSet *machinev1beta1.MachineSet) {
+				machineSet.OwnerReferences = []v1.OwnerReference{{APIVersion: "v1", Kind: "ConfigMap", Name: "owner", UID: "uid"}}
+			},
+			expectedError: "unexpectedly change /metadata/ownerReferences",
+		},
+		{
+			name: "the rest of the spec must not change",
+			mutate: func(_ *testing.T, machineSet *machinev1beta1.MachineSet) {
+				machineSet.Spec.Replicas = ptr.To[int32](5)
+			},
+			expectedError: "unexpectedly change /spec/replicas",
+		},
+		{
+			name: "other providerspec fields must not change",
+			mutate: func(t *testing.T, machineSet *machinev1beta1.MachineSet) {
+				providerSpec := new(machinev1beta1.GCPMachineProviderSpec)
+				require.NoError(t, unmarshalProviderSpec(machineSet, providerSpec))
+				providerSpec.MachineType = "n2-standard-8"
+				require.NoError(t, marshalProviderSpec(machineSet, providerSpec))
+			},
+			expectedError: "unexpectedly change /spec/template/spec/providerSpec/value/machineType",
+		},
+		{
+			name: "the paired user data secret may change",
+			mutate: func(t *testing.T, machineSet *machinev1beta1.MachineSet) {
+				providerSpec := new(machinev1beta1.GCPMachineProviderSpec)
+				require.NoError(t, unmarshalProviderSpec(machineSet, providerSpec))
+				providerSpec.UserDataSecret = &corev1.LocalObjectReference{Name: "paired-secret"}
+				require.NoError(t, marshalProviderSpec(machineSet, providerSpec))
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := newTestController(t, osconfigv1.GCPPlatformType, nil, nil)
+			machineSet := getGCPMachineSet("machineset-a", testGCPOldImage)
+			newMachineSet := getGCPMachineSet("machineset-a", testGCPStreamImage)
+			tc.mutate(t, newMachineSet)
+
+			err := ctrl.validateMachineSetPatch(machineSet, newMachineSet)
+			if tc.expectedError == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tc.expectedError)
+			// Unexpected changes are never sent
+			assert.ErrorContains(t, ctrl.patchMachineSet(machineSet, newMachineSet), tc.expectedError)
+			assert.Equal(t, 0, ctrl.countMachineSetPatches())
+		})
+	}
+
+	// A rollback restores the whole recorded providerspec along with its rollback annotation, but nothing else
+	ctrl := newTestController(t, osconfigv1.GCPPlatformType, nil, nil)
+	machineSet := getGCPMachineSet("machineset-a", testGCPStreamImage)
+	rolledBack := setGCPMachineSetZone(t, getGCPMachineSet("machineset-a", testGCPOldImage), "us-central1-c")
+	rolledBack.Annotations[BootImageRolledBackAtAnnotationKey] = "2025-01-01T00:00:00Z"
+	assert.ErrorContains(t, ctrl.validateMachineSetPatch(machineSet, rolledBack), "unexpectedly change /metadata/annotations/"+jsonPointerEscaper.Replace(BootImageRolledBackAtAnnotationKey)+", /spec/template/spec/providerSpec/value/zone")
+	assert.NoError(t, ctrl.validateMachineSetRollback(machineSet, rolledBack))
+	rolledBack.Labels = map[string]string{"example.com/added": "value"}
+	assert.ErrorContains(t, ctrl.validateMachineSetRollback(machineSet, rolledBack), "unexpectedly change /metadata/labels")
+}
+
+func TestHotLoopFrozenMetric(t *testing.T) {
+	frozen := withAnnotation(getGCPMachineSet("machineset-frozen", testGCPOldImage), HotLoopLimitAnnotationKey, "2")
+	machineSets := []*machinev1beta1.MachineSet{
+		frozen,
+		getGCPMachineSet("machineset-a", testGCPOldImage),
+	}
+	ctrl := newTestController(t, osconfigv1.GCPPlatformType, machineSets, nil)
+	getFrozen := func() float64 {
+		return testutil.ToFloat64(ctrlcommon.MCCBootImageHotLoopFrozenResources.WithLabelValues("machineset"))
+	}
+
+	// A first update of each machineset is below the hot loop limit
+	for _, machineSet := range machineSets {
+		ctrl.recordMAPIBootImageState(machineSet, nil, nil, "")
+	}
+	assert.Equal(t, float64(0), getFrozen())
+
+	// Reaching the per-machineset limit freezes the machineset, while the other remains below the default limit
+	for _, machineSet := range machineSets {
+		ctrl.recordMAPIBootImageState(machineSet, nil, nil, "")
+	}
+	assert.Equal(t, float64(1), getFrozen())
+	assert.True(t, ctrl.checkMAPIMachineSetHotLoop(frozen, nil, nil, ""))
+
+	// Unenrolling the machinesets resets their hot loop state
+	mcop := ctrl.getMachineConfiguration(t)
+	mcop.Status.ManagedBootImagesStatus.MachineManagers = nil
+	require.NoError(t, ctrl.mcopIndexer.Update(mcop))
+	ctrl.syncMAPIMachineSets("test")
+	assert.Equal(t, float64(0), getFrozen())
+}
+
+func TestHotLoopDegradedReason(t *testing.T) {
+	machineSet := withAnnotation(getGCPMachineSet("machineset-looping", testGCPOldImage), HotLoopLimitAnnotationKey, "1")
+	ctrl := newTestController(t, osconfigv1.GCPPlatformType, []*machinev1beta1.MachineSet{machineSet}, nil)
+	getHotLoops := func() float64 {
+		return testutil.ToFloat64(ctrlcommon.MCOBootImageHotLoops.WithLabelValues("machineset-looping"))
+	}
+	// Mimics an external actor setting the boot image of the machineset
+	setBootImage := func(t *testing.T, image string) {
+		t.Helper()
+		current := ctrl.getMachineSet(t, "machineset-looping")
+		current.Spec.Template.Spec.ProviderSpec = getGCPMachineSet("machineset-looping", image).Spec.Template.Spec.ProviderSpec
+		_, err := ctrl.machineClient.MachineV1beta1().MachineSets(MachineAPINamespace).Update(context.TODO(), current, v1.UpdateOptions{})
+		require.NoError(t, err)
+		require.NoError(t, ctrl.msIndexer.Update(current))
+	}
+	initialHotLoops := getHotLoops()
+
+	// The first update is below the hot loop limit
+	require.NoError(t, ctrl.syncAll("test"))
+	assert.Equal(t, testGCPStreamImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, "machineset-looping")))
+	assert.Equal(t, v1.ConditionFalse, ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateDegraded).Status)
+
+	// Once the update is reverted, the next update is refused as a hot loop
+	setBootImage(t, testGCPOldImage)
+	require.NoError(t, ctrl.syncAll("test"))
+	assert.Equal(t, testGCPOldImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, "machineset-looping")))
+	degraded := ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateDegraded)
+	assert.Equal(t, v1.ConditionTrue, degraded.Status)
+	assert.Equal(t, ReasonHotLoopDetected, degraded.Reason)
+	assert.Contains(t, degraded.Message, "refusing to reconcile machineset machineset-looping, hot loop detected")
+	assert.Equal(t, initialHotLoops+1, getHotLoops())
+
+	// The condition clears on the first pass after the machineset stops looping
+	setBootImage(t, testGCPStreamImage)
+	require.NoError(t, ctrl.syncAll("test"))
+	degraded = ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateDegraded)
+	assert.Equal(t, v1.ConditionFalse, degraded.Status)
+	assert.NotEqual(t, ReasonHotLoopDetected, degraded.Reason)
+	assert.Equal(t, initialHotLoops+1, getHotLoops())
+}
+
+func TestMachineResourceStatsMetrics(t *testing.T) {
+	failing := getGCPMachineSet("machineset-failing", testGCPOldImage)
+	failing.Annotations[BootImageSecretRefAnnotationKey] = "missing-secret"
+	ctrl := newTestController(t, osconfigv1.GCPPlatformType, []*machinev1beta1.MachineSet{
+		getGCPMachineSet("machineset-a", testGCPOldImage),
+		getGCPMachineSet("machineset-b", testGCPOldImage),
+		failing,
+	}, nil)
+
+	ctrl.syncMAPIMachineSets("test")
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(ctrlcommon.MCOBootImageMachineSetsTotal, ctrlcommon.MCOBootImageMachineSetsInProgress, ctrlcommon.MCOBootImageMachineSetsErrored)
+	expected := `
+# HELP mco_boot_image_machinesets_errored Number of MAPI MachineSets that failed to reconcile in the current boot image reconciliation
+# TYPE mco_boot_image_machinesets_errored gauge
+mco_boot_image_machinesets_errored 1
+# HELP mco_boot_image_machinesets_inprogress Number of MAPI MachineSets reconciled without error in the current boot image reconciliation
+# TYPE mco_boot_image_machinesets_inprogress gauge
+mco_boot_image_machinesets_inprogress 2
+# HELP mco_boot_image_machinesets_total Number of enrolled MAPI MachineSets reported by the boot image conditions
+# TYPE mco_boot_image_machinesets_total gauge
+mco_boot_image_machinesets_total 3
+`
+	require.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected),
+		"mco_boot_image_machinesets_total", "mco_boot_image_machinesets_inprogress", "mco_boot_image_machinesets_errored"))
+
+	// The metrics match the counts reported by the conditions
+	assert.Contains(t, ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateProgressing).Message, "Reconciled 2 of 3 MAPI MachineSets")
+	assert.Contains(t, ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateDegraded).Message, "1 Degraded MAPI MachineSets")
+}
@@ -0,0 +1,82 @@
+package bootimage
+
+import (
+	"slices"
+	"strings"
+
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	opv1 "github.com/openshift/api/operator/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+)
+
+// Annotations describing the last sync of a MAPI machineset. They are orphaned once the machineset is no
+// longer enrolled, e.g. after boot image management was disabled or the enrollment selector changed, as
+// they are then no longer kept up to date. The update provenance annotations remain accurate, and are kept.
+var machineSetSyncAnnotationKeys = []string{
+	BootImageSkipReasonAnnotationKey,
+	BootImageStatusAnnotationKey,
+}
+
+// getOrphanedAnnotations returns the sorted sync annotation keys present on the machineset.
+func (ctrl *Controller) getOrphanedAnnotations(machineSet *machinev1beta1.MachineSet) []string {
+	orphaned := []string{}
+	for _, key := range machineSetSyncAnnotationKeys {
+		if _, ok := machineSet.Annotations[ctrl.annotationKey(key)]; ok {
+			orphaned = append(orphaned, ctrl.annotationKey(key))
+		}
+	}
+	slices.Sort(orphaned)
+	return orphaned
+}
+
+// syncOrphanedMAPIMachineSetAnnotations reports the MAPI machinesets that carry sync annotations but are
+// not among the enrolled machinesets, and removes those annotations if RemoveOrphanedAnnotationsAnnotationKey
+// is set. Orphaned annotations are only ever reported in advisory-only mode. This is a best effort
+// cleanup; failures are logged and retried on the next sync, and do not degrade the controller.
+func (ctrl *Controller) syncOrphanedMAPIMachineSetAnnotations(mcop *opv1.MachineConfiguration, enrolled []*machinev1beta1.MachineSet) {
+	machineSets, err := ctrl.mapiMachineSetLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("failed to fetch MachineSet list while checking for orphaned boot image annotations: %v", err)
+		return
+	}
+
+	orphanedMachineSets := []string{}
+	for _, machineSet := range machineSets {
+		if slices.ContainsFunc(enrolled, func(ms *machinev1beta1.MachineSet) bool { return ms.Name == machineSet.Name }) {
+			continue
+		}
+		orphaned := ctrl.getOrphanedAnnotations(machineSet)
+		if len(orphaned) == 0 {
+			continue
+		}
+		if !ctrl.knobs.removeOrphanedAnnotations || ctrl.knobs.advisoryOnly {
+			klog.Warningf("machineset %s is not enrolled for boot image updates but carries orphaned annotation(s) %s", machineSet.Name, strings.Join(orphaned, ", "))
+			orphanedMachineSets = append(orphanedMachineSets, machineSet.Name)
+			continue
+		}
+		newMachineSet := machineSet.DeepCopy()
+		for _, key := range orphaned {
+			delete(newMachineSet.Annotations, key)
+		}
+		klog.Infof("Removing orphaned annotation(s) %s from machineset %s, which is not enrolled for boot image updates", strings.Join(orphaned, ", "), machineSet.Name)
+		if err := ctrl.patchMachineSet(machineSet, newMachineSet); err != nil {
+			klog.Errorf("failed to remove orphaned boot image annotations from machineset %s: %v", machineSet.Name, err)
+			orphanedMachineSets = append(orphanedMachineSets, machineSet.Name)
+		}
+	}
+
+	// Only report a change in the set of machinesets carrying orphaned annotations, as this is checked every sync
+	slices.Sort(orphanedMachineSets)
+	summary := strings.Join(orphanedMachineSets, ", ")
+	if summary == ctrl.lastOrphanedMachineSets {
+		return
+	}
+	ctrl.lastOrphanedMachineSets = summary
+	if len(orphanedMachineSets) > 0 {
+		ctrl.eventRecorder.Eventf(mcop, corev1.EventTypeWarning, "OrphanedBootImageAnnotations",
+			"%d MAPI machineset(s) not enrolled for boot image updates carry orphaned boot image annotations: %s; set %s to \"true\" to remove them",
+			len(orphanedMachineSets), summary, ctrl.annotationKey(RemoveOrphanedAnnotationsAnnotationKey))
+	}
+}
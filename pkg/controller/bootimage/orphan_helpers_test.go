@@ -0,0 +1,69 @@
+package bootimage
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	osconfigv1 "github.com/openshift/api/config/v1"
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	opv1 "github.com/openshift/api/operator/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestOrphanedAnnotations(t *testing.T) {
+	enrolled := getGCPMachineSet("machineset-enrolled", testGCPOldImage)
+	enrolled.Labels = map[string]string{"boot-images": "managed"}
+	orphaned := getGCPMachineSet("machineset-orphaned", testGCPOldImage)
+	orphaned.Annotations[BootImageSkipReasonAnnotationKey] = string(SkipReasonBudgetDeferred)
+	orphaned.Annotations[BootImageStatusAnnotationKey] = string(MachineSetBootImageStatusManaged)
+	orphaned.Annotations[BootImageUpdatedByVersionAnnotationKey] = "previous-version"
+	ctrl := newTestController(t, osconfigv1.GCPPlatformType, []*machinev1beta1.MachineSet{enrolled, orphaned, getGCPMachineSet("machineset-clean", testGCPOldImage)}, nil)
+
+	// Only the labeled machineset is enrolled
+	mcop := ctrl.getMachineConfiguration(t)
+	mcop.Status.ManagedBootImagesStatus.MachineManagers[0].Selection = opv1.MachineManagerSelector{
+		Mode:    opv1.Partial,
+		Partial: &opv1.PartialSelector{MachineResourceSelector: &v1.LabelSelector{MatchLabels: map[string]string{"boot-images": "managed"}}},
+	}
+	mcop, err := ctrl.mcopClient.OperatorV1().MachineConfigurations().UpdateStatus(context.TODO(), mcop, v1.UpdateOptions{})
+	require.NoError(t, err)
+	require.NoError(t, ctrl.mcopIndexer.Update(mcop))
+
+	getOrphanedEvents := func() []string {
+		events := []string{}
+		for len(ctrl.eventRecorder.Events) > 0 {
+			if event := <-ctrl.eventRecorder.Events; strings.Contains(event, "OrphanedBootImageAnnotations") {
+				events = append(events, event)
+			}
+		}
+		return events
+	}
+
+	t.Run("orphaned annotations are reported", func(t *testing.T) {
+		require.NoError(t, ctrl.syncAll("test"))
+		events := getOrphanedEvents()
+		require.Len(t, events, 1)
+		assert.Contains(t, events[0], "machineset-orphaned")
+		assert.NotContains(t, events[0], "machineset-clean")
+		assert.Equal(t, string(SkipReasonBudgetDeferred), ctrl.getMachineSet(t, "machineset-orphaned").Annotations[BootImageSkipReasonAnnotationKey])
+
+		// An unchanged set of orphaned machinesets is not reported again
+		require.NoError(t, ctrl.syncAll("test"))
+		assert.Empty(t, getOrphanedEvents())
+	})
+
+	t.Run("orphaned annotations are removed when enabled", func(t *testing.T) {
+		ctrl.setKnobs(t, map[string]string{RemoveOrphanedAnnotationsAnnotationKey: "true"})
+		require.NoError(t, ctrl.syncAll("test"))
+
+		annotations := ctrl.getMachineSet(t, "machineset-orphaned").Annotations
+		assert.NotContains(t, annotations, BootImageSkipReasonAnnotationKey)
+		assert.NotContains(t, annotations, BootImageStatusAnnotationKey)
+		assert.Equal(t, "previous-version", annotations[BootImageUpdatedByVersionAnnotationKey])
+		assert.Equal(t, testGCPOldImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, "machineset-orphaned")))
+		assert.Equal(t, testGCPStreamImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, "machineset-enrolled")))
+	})
+}
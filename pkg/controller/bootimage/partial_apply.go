@@ -0,0 +1,58 @@
+package bootimage
+
+import (
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// A boot image update is partially applied when the boot image field of a providerspec holds the target
+// image, but a field that must move along with it does not. This happens when a previous update was
+// interrupted, or was made by hand or by an older controller. As the boot image already matches, such a
+// providerspec would otherwise never be reconciled again; instead, the update is completed.
+
+// completePartialAWSAMIUpdate completes an update that set the AMI ID but left the ARN or filters of the
+// previous AMI in place. Only one of ID, ARN or Filters may be specified, so the stale fields are dropped.
+// Returns true if the providerspec was repaired.
+func completePartialAWSAMIUpdate(providerSpec *machinev1beta1.AWSMachineProviderConfig, machineSetName string) bool {
+	ami := providerSpec.AMI
+	if ami.ID == nil || (ami.ARN == nil && len(ami.Filters) == 0) {
+		return false
+	}
+	klog.Infof("machineset %s has a partially applied boot image update, AMI %s is set along with an ARN or filters; completing the update", machineSetName, *ami.ID)
+	providerSpec.AMI = machinev1beta1.AWSResourceReference{ID: ami.ID}
+	return true
+}
+
+// completePartialAzureImageUpdate completes an update that set the image resource ID but left the
+// marketplace fields of the previous image in place. Returns true if the providerspec was repaired.
+func completePartialAzureImageUpdate(providerSpec *machinev1beta1.AzureMachineProviderSpec, machineSetName string) bool {
+	image := providerSpec.Image
+	if image.ResourceID == "" || (image.Publisher == "" && image.Offer == "" && image.SKU == "" && image.Version == "") {
+		return false
+	}
+	klog.Infof("machineset %s has a partially applied boot image update, image %s is set along with marketplace fields; completing the update", machineSetName, image.ResourceID)
+	providerSpec.Image = machinev1beta1.Image{ResourceID: image.ResourceID}
+	return true
+}
+
+// completePartialStubIgnitionUpgrade ensures that the ignition stub of a machineset whose boot image is
+// already up to date was upgraded along with it. Newer boot images require the minimum acceptable stub
+// spec, so a stub that was left behind is upgraded now.
+// An empty secret name means the machineset does not reference a user data secret.
+func completePartialStubIgnitionUpgrade(userDataSecretName string, secretClient clientset.Interface) error {
+	if userDataSecretName == "" {
+		return nil
+	}
+	return upgradeStubIgnitionIfRequired(userDataSecretName, secretClient)
+}
+
+// getLocalUserDataSecretName returns the name of the referenced user data secret, or an empty string if
+// there is none.
+func getLocalUserDataSecretName(userDataSecret *corev1.LocalObjectReference) string {
+	if userDataSecret == nil {
+		return ""
+	}
+	return userDataSecret.Name
+}
@@ -0,0 +1,231 @@
+package bootimage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/coreos/stream-metadata-go/stream"
+	osconfigv1 "github.com/openshift/api/config/v1"
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	opv1 "github.com/openshift/api/operator/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestPartiallyAppliedUpdateRepaired(t *testing.T) {
+	const targetAMI = "ami-x86-64-new"
+	streamData := &stream.Stream{
+		Architectures: map[string]stream.Arch{
+			"x86_64": {
+				Images: stream.Images{
+					Aws: &stream.AwsImage{Regions: map[string]stream.SingleImage{"us-east-1": {Image: targetAMI}}},
+				},
+			},
+		},
+	}
+	getUserDataSecret := func(ignitionVersion string) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: v1.ObjectMeta{Name: "test-secret", Namespace: MachineAPINamespace},
+			Data: map[string][]byte{
+				ctrlcommon.UserDataKey: []byte(fmt.Sprintf(`{"ignition":{"version":%q}}`, ignitionVersion)),
+			},
+		}
+	}
+	getStubVersion := func(t *testing.T, client *fake.Clientset) string {
+		t.Helper()
+		secret, err := client.CoreV1().Secrets(MachineAPINamespace).Get(context.TODO(), "test-secret", v1.GetOptions{})
+		require.NoError(t, err)
+		userData := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal(secret.Data[ctrlcommon.UserDataKey], &userData))
+		version, _, err := unstructured.NestedString(userData, ctrlcommon.IgnFieldIgnition, ctrlcommon.IgnFieldVersion)
+		require.NoError(t, err)
+		return version
+	}
+
+	t.Run("AMI ID updated but stale filters left in place", func(t *testing.T) {
+		ami := targetAMI
+		providerSpec := &machinev1beta1.AWSMachineProviderConfig{
+			AMI: machinev1beta1.AWSResourceReference{
+				ID:      &ami,
+				Filters: []machinev1beta1.Filter{{Name: "name", Values: []string{"rhcos-old"}}},
+			},
+			Placement:      machinev1beta1.Placement{Region: "us-east-1"},
+			UserDataSecret: &corev1.LocalObjectReference{Name: "test-secret"},
+		}
+		client := fake.NewClientset(getUserDataSecret("3.4.0"))
+
+		patchRequired, reconcileSkipped, newProviderSpec, err := reconcileAWSProviderSpec(streamData, "x86_64", nil, providerSpec, "test-machineset", client)
+		require.NoError(t, err)
+		assert.False(t, reconcileSkipped)
+		require.True(t, patchRequired)
+		assert.Equal(t, machinev1beta1.AWSResourceReference{ID: &ami}, newProviderSpec.AMI)
+
+		// The repaired providerspec is consistent and needs no further patches
+		patchRequired, _, _, err = reconcileAWSProviderSpec(streamData, "x86_64", nil, newProviderSpec, "test-machineset", client)
+		require.NoError(t, err)
+		assert.False(t, patchRequired)
+	})
+
+	t.Run("boot image updated but ignition stub left behind", func(t *testing.T) {
+		ami := targetAMI
+		providerSpec := &machinev1beta1.AWSMachineProviderConfig{
+			AMI:            machinev1beta1.AWSResourceReference{ID: &ami},
+			Placement:      machinev1beta1.Placement{Region: "us-east-1"},
+			UserDataSecret: &corev1.LocalObjectReference{Name: "test-secret"},
+		}
+		client := fake.NewClientset(getUserDataSecret("2.2.0"))
+
+		patchRequired, _, _, err := reconcileAWSProviderSpec(streamData, "x86_64", nil, providerSpec, "test-machineset", client)
+		require.NoError(t, err)
+		assert.False(t, patchRequired)
+		assert.True(t, strings.HasPrefix(getStubVersion(t, client), ctrlcommon.MinimumAcceptableStubIgnitionSpec))
+	})
+
+	t.Run("secret-provided boot image", func(t *testing.T) {
+		const secretImage = "ami-from-secret"
+		awsImage := secretImage
+		arn := "arn:aws:ec2:us-east-1::image/ami-old"
+		awsProviderSpec := &machinev1beta1.AWSMachineProviderConfig{
+			AMI: machinev1beta1.AWSResourceReference{ID: &awsImage, ARN: &arn},
+		}
+		changed, _ := setAWSBootImage(awsProviderSpec, secretImage, "test-machineset")
+		assert.True(t, changed)
+		assert.Equal(t, machinev1beta1.AWSResourceReference{ID: &awsImage}, awsProviderSpec.AMI)
+		changed, _ = setAWSBootImage(awsProviderSpec, secretImage, "test-machineset")
+		assert.False(t, changed)
+
+		azureProviderSpec := &machinev1beta1.AzureMachineProviderSpec{
+			Image: machinev1beta1.Image{ResourceID: secretImage, Publisher: "azureopenshift", Offer: "aro4", SKU: "aro_418", Version: "418.94.20241201"},
+		}
+		changed, _ = setAzureBootImage(azureProviderSpec, secretImage, "test-machineset")
+		assert.True(t, changed)
+		assert.Equal(t, machinev1beta1.Image{ResourceID: secretImage}, azureProviderSpec.Image)
+		changed, _ = setAzureBootImage(azureProviderSpec, secretImage, "test-machineset")
+		assert.False(t, changed)
+	})
+}
+
+func TestPairedUserDataSecret(t *testing.T) {
+	const pairedImage = "projects/my-project/global/images/paired-rhcos"
+	const userDataContents = `{"ignition":{"version":"3.4.0","config":{"merge":[{"source":"https://api-int.example.com:22623/config/worker-v2"}]}}}`
+	getUserDataSecretName := func(t *testing.T, machineSet *machinev1beta1.MachineSet) string {
+		t.Helper()
+		providerSpec := new(machinev1beta1.GCPMachineProviderSpec)
+		require.NoError(t, unmarshalProviderSpec(machineSet, providerSpec))
+		return getLocalUserDataSecretName(providerSpec.UserDataSecret)
+	}
+	bootImageSecret := func(userDataSecret string) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: v1.ObjectMeta{Name: "paired-image", Namespace: MachineAPINamespace},
+			Data:       map[string][]byte{BootImageSecretKey: []byte(pairedImage), BootImageUserDataSecretKey: []byte(userDataSecret)},
+		}
+	}
+	userDataSecret := &corev1.Secret{
+		ObjectMeta: v1.ObjectMeta{Name: "worker-user-data-v2", Namespace: MachineAPINamespace},
+		Data:       map[string][]byte{ctrlcommon.UserDataKey: []byte(userDataContents)},
+	}
+
+	cases := []struct {
+		name                   string
+		currentImage           string
+		currentUserDataSecret  string
+		secrets                []*corev1.Secret
+		expectedPatches        int
+		expectedImage          string
+		expectedUserDataSecret string
+		expectedErrorText      string
+	}{
+		{
+			name:                   "image and user data secret are updated together",
+			currentImage:           testGCPOldImage,
+			secrets:                []*corev1.Secret{bootImageSecret("worker-user-data-v2"), userDataSecret},
+			expectedPatches:        1,
+			expectedImage:          pairedImage,
+			expectedUserDataSecret: "worker-user-data-v2",
+		},
+		{
+			name:                   "user data secret is updated on a machineset that already has the image",
+			currentImage:           pairedImage,
+			secrets:                []*corev1.Secret{bootImageSecret("worker-user-data-v2"), userDataSecret},
+			expectedPatches:        1,
+			expectedImage:          pairedImage,
+			expectedUserDataSecret: "worker-user-data-v2",
+		},
+		{
+			name:                   "machineset already paired is not patched",
+			currentImage:           pairedImage,
+			currentUserDataSecret:  "worker-user-data-v2",
+			secrets:                []*corev1.Secret{bootImageSecret("worker-user-data-v2"), userDataSecret},
+			expectedImage:          pairedImage,
+			expectedUserDataSecret: "worker-user-data-v2",
+		},
+		{
+			name:                   "missing user data secret degrades the machineset",
+			currentImage:           testGCPOldImage,
+			secrets:                []*corev1.Secret{bootImageSecret("worker-user-data-v2")},
+			expectedImage:          testGCPOldImage,
+			expectedUserDataSecret: "test-secret",
+			expectedErrorText:      "failed to fetch user data secret worker-user-data-v2 paired with boot image secret paired-image",
+		},
+		{
+			name:         "user data secret without user data degrades the machineset",
+			currentImage: testGCPOldImage,
+			secrets: []*corev1.Secret{bootImageSecret("worker-user-data-v2"), {
+				ObjectMeta: v1.ObjectMeta{Name: "worker-user-data-v2", Namespace: MachineAPINamespace},
+				Data:       map[string][]byte{"unrelated": []byte(userDataContents)},
+			}},
+			expectedImage:          testGCPOldImage,
+			expectedUserDataSecret: "test-secret",
+			expectedErrorText:      fmt.Sprintf("user data secret worker-user-data-v2 paired with boot image secret paired-image has no %q key", ctrlcommon.UserDataKey),
+		},
+		{
+			name:                   "empty user data secret name degrades the machineset",
+			currentImage:           testGCPOldImage,
+			secrets:                []*corev1.Secret{bootImageSecret(" ")},
+			expectedImage:          testGCPOldImage,
+			expectedUserDataSecret: "test-secret",
+			expectedErrorText:      fmt.Sprintf("has an empty %q key", BootImageUserDataSecretKey),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ms := getGCPMachineSet("machineset-a", tc.currentImage)
+			ms.Annotations[BootImageSecretRefAnnotationKey] = "paired-image"
+			if tc.currentUserDataSecret != "" {
+				providerSpec := new(machinev1beta1.GCPMachineProviderSpec)
+				require.NoError(t, unmarshalProviderSpec(ms, providerSpec))
+				providerSpec.UserDataSecret.Name = tc.currentUserDataSecret
+				require.NoError(t, marshalProviderSpec(ms, providerSpec))
+			}
+			ctrl := newTestController(t, osconfigv1.GCPPlatformType, []*machinev1beta1.MachineSet{ms}, tc.secrets)
+
+			require.NoError(t, ctrl.syncAll("test"))
+
+			assert.Equal(t, tc.expectedPatches, ctrl.countMachineSetPatches())
+			updated := ctrl.getMachineSet(t, "machineset-a")
+			assert.Equal(t, tc.expectedImage, getGCPMachineSetBootImage(t, updated))
+			assert.Equal(t, tc.expectedUserDataSecret, getUserDataSecretName(t, updated))
+			degraded := ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateDegraded)
+			if tc.expectedErrorText != "" {
+				assert.Equal(t, 1, ctrl.mapiStats.erroredCount)
+				assert.Equal(t, v1.ConditionTrue, degraded.Status)
+				assert.Contains(t, degraded.Message, tc.expectedErrorText)
+			} else {
+				assert.Equal(t, 0, ctrl.mapiStats.erroredCount)
+				assert.Equal(t, v1.ConditionFalse, degraded.Status)
+			}
+			// Secret contents are never surfaced
+			assert.NotContains(t, degraded.Message, userDataContents)
+			assert.NotContains(t, degraded.Message, "api-int.example.com")
+		})
+	}
+}
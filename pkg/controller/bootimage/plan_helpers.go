@@ -0,0 +1,209 @@
+package bootimage
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	osconfigv1 "github.com/openshift/api/config/v1"
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// Path of the debug endpoint, served by the metrics listener, that returns the boot image updates
+// planned for MAPI machinesets in advisory-only mode
+const BootImagePlanPath = "/debug/bootimage/plan"
+
+// Maximum number of field changes listed across all machinesets of a plan, so that the plan of a large
+// fleet stays bounded. Changes beyond it are only counted.
+const maxPlanChanges = 1000
+
+// plannedBootImageUpdate is a MAPI machineset boot image update that advisory-only mode held back.
+type plannedBootImageUpdate struct {
+	MachineSet string `json:"machineSet"`
+	OldImage   string `json:"oldImage"`
+	NewImage   string `json:"newImage"`
+	// Source is the source the new image was resolved from
+	Source BootImageSource `json:"source"`
+	// Changes are the fields of the machineset that the update would change, for change review tooling
+	Changes []plannedFieldChange `json:"changes"`
+	// ChangesOmitted is the number of changes left out of Changes once the plan reached maxPlanChanges
+	ChangesOmitted int `json:"changesOmitted,omitempty"`
+}
+
+// plannedFieldChange is a change to a single field of a machineset. Path is a JSON pointer into the
+// machineset; OldValue is omitted for an added field, and NewValue for a removed one.
+type plannedFieldChange struct {
+	Path     string      `json:"path"`
+	OldValue interface{} `json:"oldValue,omitempty"`
+	NewValue interface{} `json:"newValue,omitempty"`
+}
+
+// bootImagePlan is the JSON document served on BootImagePlanPath, describing the updates that the last
+// advisory-only pass would have applied.
+type bootImagePlan struct {
+	GeneratedAt string                   `json:"generatedAt"`
+	MachineSets []plannedBootImageUpdate `json:"machineSets"`
+}
+
+// recordPlannedBootImageUpdate adds the held back update of machineSet to the plan of the current pass.
+// Failing to describe the update only leaves it out of the plan, as the plan is informational.
+func (ctrl *Controller) recordPlannedBootImageUpdate(infra *osconfigv1.Infrastructure, imagePath []string, machineSet, newMachineSet *machinev1beta1.MachineSet) {
+	oldImage, err := getMachineSetBootImage(infra, imagePath, machineSet)
+	if err != nil {
+		klog.Warningf("Failed to read the current boot image of machineset %s for the boot image plan: %v", machineSet.Name, err)
+		return
+	}
+	newImage, err := getMachineSetBootImage(infra, imagePath, newMachineSet)
+	if err != nil {
+		klog.Warningf("Failed to read the planned boot image of machineset %s for the boot image plan: %v", machineSet.Name, err)
+		return
+	}
+	update := plannedBootImageUpdate{MachineSet: machineSet.Name, OldImage: oldImage, NewImage: newImage, Source: ctrl.mapiImageSources[machineSet.Name], Changes: []plannedFieldChange{}}
+	changes, err := getMachineSetFieldChanges(machineSet, newMachineSet)
+	if err != nil {
+		klog.Warningf("Failed to compute the planned changes of machineset %s for the boot image plan: %v", machineSet.Name, err)
+	}
+	remaining := maxPlanChanges
+	for _, planned := range ctrl.mapiPlan {
+		remaining -= len(planned.Changes)
+	}
+	if len(changes) > remaining {
+		update.ChangesOmitted = len(changes) - remaining
+		changes = changes[:remaining]
+	}
+	update.Changes = append(update.Changes, changes...)
+	ctrl.mapiPlan = append(ctrl.mapiPlan, update)
+	ctrl.reportPlannedBootImageUpdate(update)
+}
+
+// reportPlannedBootImageUpdate emits an event describing a held back update, once per target image of
+// the machineset.
+func (ctrl *Controller) reportPlannedBootImageUpdate(update plannedBootImageUpdate) {
+	if ctrl.reportedPlannedUpdates[update.MachineSet] == update.NewImage {
+		return
+	}
+	mcop, err := ctrl.mcopLister.Get(ctrlcommon.MCOOperatorKnobsObjectName)
+	if err != nil {
+		klog.Errorf("Failed to get MachineConfiguration to report the planned boot image update of machineset %s: %v", update.MachineSet, err)
+		return
+	}
+	ctrl.reportedPlannedUpdates[update.MachineSet] = update.NewImage
+	ctrl.eventRecorder.Eventf(mcop, corev1.EventTypeNormal, "BootImageUpdatePlanned",
+		"Advisory-only mode, machineset %s would be updated from boot image %s to %s (%d field change(s)); remove annotation %s to apply it",
+		update.MachineSet, update.OldImage, update.NewImage, len(update.Changes)+update.ChangesOmitted, ctrl.annotationKey(AdvisoryOnlyAnnotationKey))
+}
+
+// getMachineSetFieldChanges returns the changes between the JSON representations of the two machinesets,
+// sorted by path.
+func getMachineSetFieldChanges(machineSet, newMachineSet *machinev1beta1.MachineSet) ([]plannedFieldChange, error) {
+	oldValue, err := getJSONValue(machineSet)
+	if err != nil {
+		return nil, err
+	}
+	newValue, err := getJSONValue(newMachineSet)
+	if err != nil {
+		return nil, err
+	}
+	changes := []plannedFieldChange{}
+	diffJSONValues("", oldValue, newValue, &changes)
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, nil
+}
+
+// getJSONValue returns the machineset decoded as generic JSON.
+func getJSONValue(machineSet *machinev1beta1.MachineSet) (interface{}, error) {
+	data, err := json.Marshal(machineSet)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal machineset %s: %w", machineSet.Name, err)
+	}
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal machineset %s: %w", machineSet.Name, err)
+	}
+	return value, nil
+}
+
+// jsonPointerEscaper escapes a key for use as a JSON pointer reference token
+var jsonPointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// diffJSONValues appends the changes between two decoded JSON values under path to changes. Objects are
+// compared key by key and arrays of equal length element by element; any other difference replaces the
+// value as a whole.
+func diffJSONValues(path string, oldValue, newValue interface{}, changes *[]plannedFieldChange) {
+	oldObject, oldIsObject := oldValue.(map[string]interface{})
+	newObject, newIsObject := newValue.(map[string]interface{})
+	if oldIsObject && newIsObject {
+		for key, oldField := range oldObject {
+			if newField, ok := newObject[key]; ok {
+				diffJSONValues(path+"/"+jsonPointerEscaper.Replace(key), oldField, newField, changes)
+			} else {
+				*changes = append(*changes, plannedFieldChange{Path: path + "/" + jsonPointerEscaper.Replace(key), OldValue: oldField})
+			}
+		}
+		for key, newField := range newObject {
+			if _, ok := oldObject[key]; !ok {
+				*changes = append(*changes, plannedFieldChange{Path: path + "/" + jsonPointerEscaper.Replace(key), NewValue: newField})
+			}
+		}
+		return
+	}
+	oldArray, oldIsArray := oldValue.([]interface{})
+	newArray, newIsArray := newValue.([]interface{})
+	if oldIsArray && newIsArray && len(oldArray) == len(newArray) {
+		for i := range oldArray {
+			diffJSONValues(fmt.Sprintf("%s/%d", path, i), oldArray[i], newArray[i], changes)
+		}
+		return
+	}
+	if !reflect.DeepEqual(oldValue, newValue) {
+		*changes = append(*changes, plannedFieldChange{Path: path, OldValue: oldValue, NewValue: newValue})
+	}
+}
+
+// publishBootImagePlan makes the plan of the completed pass available on BootImagePlanPath. Outside of
+// advisory-only mode, no plan is served.
+func (ctrl *Controller) publishBootImagePlan() {
+	// Updates that are no longer planned are reported again once they are
+	maps.DeleteFunc(ctrl.reportedPlannedUpdates, func(name, _ string) bool {
+		return !slices.ContainsFunc(ctrl.mapiPlan, func(update plannedBootImageUpdate) bool { return update.MachineSet == name })
+	})
+	var plan *bootImagePlan
+	if ctrl.knobs.advisoryOnly {
+		plan = &bootImagePlan{GeneratedAt: time.Now().UTC().Format(time.RFC3339), MachineSets: []plannedBootImageUpdate{}}
+		plan.MachineSets = append(plan.MachineSets, ctrl.mapiPlan...)
+	}
+	ctrl.publishedPlanLock.Lock()
+	defer ctrl.publishedPlanLock.Unlock()
+	ctrl.publishedPlan = plan
+}
+
+// PlanHandler returns the read-only handler for BootImagePlanPath. It responds with 404 unless the
+// controller is in advisory-only mode and has completed a pass.
+func (ctrl *Controller) PlanHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+			return
+		}
+		ctrl.publishedPlanLock.Lock()
+		plan := ctrl.publishedPlan
+		ctrl.publishedPlanLock.Unlock()
+		if plan == nil {
+			http.Error(w, "no boot image plan available, plans are only computed in advisory-only mode", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(plan); err != nil {
+			klog.Errorf("Failed to write boot image plan: %v", err)
+		}
+	})
+}
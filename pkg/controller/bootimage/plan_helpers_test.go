@@ -0,0 +1,78 @@
+package bootimage
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	osconfigv1 "github.com/openshift/api/config/v1"
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBootImagePlanEndpoint(t *testing.T) {
+	machineSets := []*machinev1beta1.MachineSet{
+		getGCPMachineSet("machineset-a", testGCPOldImage),
+		getGCPMachineSet("machineset-b", testGCPOldImage),
+		getGCPMachineSet("machineset-current", testGCPStreamImage),
+	}
+	ctrl := newTestController(t, osconfigv1.GCPPlatformType, machineSets, nil)
+	getPlan := func(method string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		ctrl.PlanHandler().ServeHTTP(recorder, httptest.NewRequest(method, BootImagePlanPath, nil))
+		return recorder
+	}
+
+	// No plan is served before an advisory-only pass has completed
+	assert.Equal(t, http.StatusNotFound, getPlan(http.MethodGet).Code)
+
+	ctrl.setKnobs(t, map[string]string{AdvisoryOnlyAnnotationKey: "true"})
+	require.NoError(t, ctrl.syncAll("test"))
+
+	response := getPlan(http.MethodGet)
+	require.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "application/json", response.Header().Get("Content-Type"))
+	plan := bootImagePlan{}
+	require.NoError(t, json.Unmarshal(response.Body.Bytes(), &plan))
+	assert.NotEmpty(t, plan.GeneratedAt)
+	// Each planned update lists the changed fields as JSON pointers, with their old and new values
+	changes := []plannedFieldChange{{Path: "/spec/template/spec/providerSpec/value/disks/0/image", OldValue: testGCPOldImage, NewValue: testGCPStreamImage}}
+	assert.ElementsMatch(t, []plannedBootImageUpdate{
+		{MachineSet: "machineset-a", OldImage: testGCPOldImage, NewImage: testGCPStreamImage, Source: BootImageSourceStream, Changes: changes},
+		{MachineSet: "machineset-b", OldImage: testGCPOldImage, NewImage: testGCPStreamImage, Source: BootImageSourceStream, Changes: changes},
+	}, plan.MachineSets)
+	// The endpoint is read-only
+	assert.Equal(t, http.StatusMethodNotAllowed, getPlan(http.MethodPost).Code)
+	assert.Equal(t, 0, ctrl.countMachineSetPatches())
+
+	// Leaving advisory-only mode withdraws the plan
+	ctrl.setKnobs(t, nil)
+	require.NoError(t, ctrl.syncAll("test"))
+	assert.Equal(t, http.StatusNotFound, getPlan(http.MethodGet).Code)
+}
+
+func TestPlannedFieldChanges(t *testing.T) {
+	machineSet := getGCPMachineSet("machineset-a", testGCPOldImage)
+	machineSet.Annotations["example.com/removed"] = "value"
+	newMachineSet := getGCPMachineSet("machineset-a", testGCPStreamImage)
+	newMachineSet.Annotations["example.com/added"] = "value"
+
+	changes, err := getMachineSetFieldChanges(machineSet, newMachineSet)
+	require.NoError(t, err)
+	assert.Equal(t, []plannedFieldChange{
+		{Path: "/metadata/annotations/example.com~1added", NewValue: "value"},
+		{Path: "/metadata/annotations/example.com~1removed", OldValue: "value"},
+		{Path: "/spec/template/spec/providerSpec/value/disks/0/image", OldValue: testGCPOldImage, NewValue: testGCPStreamImage},
+	}, changes)
+
+	// Changes beyond maxPlanChanges are counted rather than listed
+	ctrl := newTestController(t, osconfigv1.GCPPlatformType, nil, nil)
+	ctrl.mapiPlan = []plannedBootImageUpdate{{MachineSet: "machineset-earlier", Changes: make([]plannedFieldChange, maxPlanChanges-1)}}
+	infra := &osconfigv1.Infrastructure{Status: osconfigv1.InfrastructureStatus{PlatformStatus: &osconfigv1.PlatformStatus{Type: osconfigv1.GCPPlatformType}}}
+	ctrl.recordPlannedBootImageUpdate(infra, nil, machineSet, newMachineSet)
+	require.Len(t, ctrl.mapiPlan, 2)
+	assert.Len(t, ctrl.mapiPlan[1].Changes, 1)
+	assert.Equal(t, 2, ctrl.mapiPlan[1].ChangesOmitted)
+}
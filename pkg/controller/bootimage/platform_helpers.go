@@ -4,8 +4,10 @@ import (
 	"context"
 	"fmt"
 	"reflect"
+	"regexp"
 	"strings"
 
+	archtranslater "github.com/coreos/stream-metadata-go/arch"
 	"github.com/coreos/stream-metadata-go/stream"
 	"github.com/coreos/stream-metadata-go/stream/rhcos"
 	corev1 "k8s.io/api/core/v1"
@@ -42,21 +44,50 @@ type publisherOffer struct {
 	publisher, offer string
 }
 
+// isNativelySupportedPlatform returns true if the controller can resolve and apply boot images for
+// MAPI machinesets on this platform without additional configuration.
+func isNativelySupportedPlatform(platform osconfigv1.PlatformType) bool {
+	switch platform {
+	case osconfigv1.AWSPlatformType, osconfigv1.AzurePlatformType, osconfigv1.GCPPlatformType, osconfigv1.VSpherePlatformType:
+		return true
+	default:
+		return false
+	}
+}
+
+// providerSpecKinds is the kind of the MAPI machine providerspec on each natively supported platform
+var providerSpecKinds = map[osconfigv1.PlatformType]string{
+	osconfigv1.AWSPlatformType:     "AWSMachineProviderConfig",
+	osconfigv1.AzurePlatformType:   "AzureMachineProviderSpec",
+	osconfigv1.GCPPlatformType:     "GCPMachineProviderSpec",
+	osconfigv1.VSpherePlatformType: "VSphereMachineProviderSpec",
+}
+
+// providerSpecAPIVersions are the apiVersions of the MAPI machine providerspec recognized on each natively
+// supported platform: the machine API group, and the provider specific group that predates it. Both decode
+// into the same types, and only changed fields are written back, so a machineset keeps its apiVersion.
+var providerSpecAPIVersions = map[osconfigv1.PlatformType][]string{
+	osconfigv1.AWSPlatformType:     {"machine.openshift.io/v1beta1", "awsproviderconfig.openshift.io/v1beta1"},
+	osconfigv1.AzurePlatformType:   {"machine.openshift.io/v1beta1", "azureproviderconfig.openshift.io/v1beta1"},
+	osconfigv1.GCPPlatformType:     {"machine.openshift.io/v1beta1", "gcpprovider.openshift.io/v1beta1"},
+	osconfigv1.VSpherePlatformType: {"machine.openshift.io/v1beta1", "vsphereprovider.openshift.io/v1beta1"},
+}
+
 // checkMachineSet calls the appropriate reconcile function based on the infra type.
 // Returns (patchRequired, reconcileSkipped, newMachineSet, error).
 // reconcileSkipped=true means the boot image could not be updated automatically (e.g.
 // custom or unknown image) and requires manual intervention; the condition is surfaced
 // via skew enforcement rather than returned as an error.
-func checkMachineSet(infra *osconfigv1.Infrastructure, machineSet *machinev1beta1.MachineSet, configMap *corev1.ConfigMap, arch string, secretClient clientset.Interface) (bool, bool, *machinev1beta1.MachineSet, error) {
+func checkMachineSet(infra *osconfigv1.Infrastructure, machineSet *machinev1beta1.MachineSet, configMap *corev1.ConfigMap, streamKey, arch string, secretClient clientset.Interface) (bool, bool, *machinev1beta1.MachineSet, error) {
 	switch infra.Status.PlatformStatus.Type {
 	case osconfigv1.AWSPlatformType:
-		return reconcilePlatform(machineSet, infra, configMap, arch, secretClient, reconcileAWSProviderSpec)
+		return reconcilePlatform(machineSet, infra, configMap, streamKey, arch, secretClient, reconcileAWSProviderSpec)
 	case osconfigv1.AzurePlatformType:
-		return reconcilePlatform(machineSet, infra, configMap, arch, secretClient, reconcileAzureProviderSpec)
+		return reconcilePlatform(machineSet, infra, configMap, streamKey, arch, secretClient, reconcileAzureProviderSpec)
 	case osconfigv1.GCPPlatformType:
-		return reconcilePlatform(machineSet, infra, configMap, arch, secretClient, reconcileGCPProviderSpec)
+		return reconcilePlatform(machineSet, infra, configMap, streamKey, arch, secretClient, reconcileGCPProviderSpec)
 	case osconfigv1.VSpherePlatformType:
-		return reconcilePlatform(machineSet, infra, configMap, arch, secretClient, reconcileVSphereProviderSpec)
+		return reconcilePlatform(machineSet, infra, configMap, streamKey, arch, secretClient, reconcileVSphereProviderSpec)
 	default:
 		klog.Infof("Skipping machineset %s, unsupported platform %s", machineSet.Name, infra.Status.PlatformStatus.Type)
 		return false, false, nil, nil
@@ -70,6 +101,7 @@ func reconcilePlatform[T any](
 	machineSet *machinev1beta1.MachineSet,
 	infra *osconfigv1.Infrastructure,
 	configMap *corev1.ConfigMap,
+	streamKey string,
 	arch string,
 	secretClient clientset.Interface,
 	reconcileProviderSpec func(*stream.Stream, string, *osconfigv1.Infrastructure, *T, string, clientset.Interface) (bool, bool, *T, error),
@@ -84,9 +116,12 @@ func reconcilePlatform[T any](
 
 	// Unmarshal the configmap into a stream object
 	streamData := new(stream.Stream)
-	if err := unmarshalStreamDataConfigMap(configMap, streamData); err != nil {
+	if err := unmarshalStreamDataConfigMap(configMap, streamKey, streamData); err != nil {
 		return false, false, nil, err
 	}
+	if _, ok := streamData.Architectures[arch]; !ok {
+		return false, false, nil, fmt.Errorf("architecture %s not found in stream data", arch)
+	}
 
 	// Reconcile the provider spec
 	patchRequired, reconcileSkipped, newProviderSpec, err := reconcileProviderSpec(streamData, arch, infra, providerSpec, machineSet.Name, secretClient)
@@ -99,11 +134,14 @@ func reconcilePlatform[T any](
 		return false, reconcileSkipped, nil, nil
 	}
 
-	// If patch is required, marshal the new providerspec into the machineset
+	// If patch is required, merge only the changed fields into the machineset's providerspec so that
+	// fields unknown to the typed providerspec are preserved
 	newMachineSet = machineSet.DeepCopy()
-	if err := marshalProviderSpec(newMachineSet, newProviderSpec); err != nil {
+	mergedProviderSpec, err := mergeProviderSpecChanges(machineSet.Spec.Template.Spec.ProviderSpec.Value.Raw, providerSpec, newProviderSpec)
+	if err != nil {
 		return false, false, nil, err
 	}
+	newMachineSet.Spec.Template.Spec.ProviderSpec.Value = mergedProviderSpec
 	return patchRequired, false, newMachineSet, nil
 }
 
@@ -125,14 +163,14 @@ func reconcileGCPProviderSpec(streamData *stream.Stream, arch string, _ *osconfi
 		if !disk.Boot {
 			continue
 		}
-		// Nothing to update on a match
-		if newBootImage == disk.Image {
+		// Nothing to update on a match, including a self-link to the same image
+		if gcpImagesEqual(newBootImage, disk.Image) {
 			continue
 		}
 		klog.Infof("New target boot image: %s", newBootImage)
 		klog.Infof("Current image: %s", disk.Image)
 		// If image does not start with "projects/rhcos-cloud/global/images", this is a custom boot image.
-		if !strings.HasPrefix(disk.Image, "projects/rhcos-cloud/global/images") {
+		if !strings.HasPrefix(normalizeGCPImage(disk.Image), "projects/rhcos-cloud/global/images") {
 			klog.Infof("current boot image %s is unknown, skipping update of MachineSet %s", disk.Image, machineSetName)
 			return false, true, nil, nil
 		}
@@ -140,11 +178,13 @@ func reconcileGCPProviderSpec(streamData *stream.Stream, arch string, _ *osconfi
 		newProviderSpec.Disks[idx].Image = newBootImage
 	}
 
-	if patchRequired {
-		// Ensure the ignition stub is the minimum acceptable spec required for boot image updates
-		if err := upgradeStubIgnitionIfRequired(providerSpec.UserDataSecret.Name, secretClient); err != nil {
-			return false, false, nil, err
-		}
+	if !patchRequired {
+		return false, false, nil, completePartialStubIgnitionUpgrade(getLocalUserDataSecretName(providerSpec.UserDataSecret), secretClient)
+	}
+
+	// Ensure the ignition stub is the minimum acceptable spec required for boot image updates
+	if err := upgradeStubIgnitionIfRequired(providerSpec.UserDataSecret.Name, secretClient); err != nil {
+		return false, false, nil, err
 	}
 
 	return patchRequired, false, newProviderSpec, nil
@@ -174,23 +214,38 @@ func reconcileAWSProviderSpec(streamData *stream.Stream, arch string, _ *osconfi
 	// This happens when the installer has copied an AMI at install-time
 	// Related bug: https://issues.redhat.com/browse/OCPBUGS-57506
 	if newProviderSpec.AMI.ID == nil {
+		// An ARN naming the target AMI is equivalent to its ID, and is left as is
+		if newProviderSpec.AMI.ARN != nil && len(newProviderSpec.AMI.Filters) == 0 && awsAMIsEqual(*newProviderSpec.AMI.ARN, newAMI) {
+			return false, false, nil, completePartialStubIgnitionUpgrade(getLocalUserDataSecretName(providerSpec.UserDataSecret), secretClient)
+		}
 		klog.Infof("current AMI.ID is undefined, skipping update of MachineSet %s", machineSetName)
 		return false, true, nil, nil
 	}
 
 	currentAMI := *newProviderSpec.AMI.ID
 
-	// If the current AMI matches target AMI, nothing to do here
-	if newAMI == currentAMI {
-		return false, false, nil, nil
+	// If the current AMI matches target AMI, only a partially applied update may need to be completed
+	if awsAMIsEqual(newAMI, currentAMI) {
+		if !completePartialAWSAMIUpdate(newProviderSpec, machineSetName) {
+			return false, false, nil, completePartialStubIgnitionUpgrade(getLocalUserDataSecretName(providerSpec.UserDataSecret), secretClient)
+		}
+		if err := upgradeStubIgnitionIfRequired(providerSpec.UserDataSecret.Name, secretClient); err != nil {
+			return false, false, nil, err
+		}
+		return true, false, newProviderSpec, nil
 	}
 
 	// Validate that we're allowed to update from the current AMI
-	if !AllowedAMIs.Has(currentAMI) {
+	if !AllowedAMIs.Has(normalizeAWSAMI(currentAMI)) {
 		klog.Infof("current AMI %s is unknown, skipping update of MachineSet %s", currentAMI, machineSetName)
 		return false, true, nil, nil
 	}
 
+	// Refuse to apply an AMI whose architecture cannot boot on the machineset's instance type
+	if instanceTypeArch := getAWSInstanceTypeArch(providerSpec.InstanceType); instanceTypeArch != "" && instanceTypeArch != arch {
+		return false, false, nil, fmt.Errorf("instance type %s of MachineSet %s requires %s, which is incompatible with the %s AMI %s", providerSpec.InstanceType, machineSetName, instanceTypeArch, arch, newAMI)
+	}
+
 	klog.Infof("Current image: %s: %s", region, currentAMI)
 	klog.Infof("New target boot image: %s: %s", region, newAMI)
 
@@ -208,6 +263,24 @@ func reconcileAWSProviderSpec(streamData *stream.Stream, arch string, _ *osconfi
 	return true, false, newProviderSpec, nil
 }
 
+// awsARMInstanceTypeFamily matches AWS Graviton instance type families, which have a "g" processor
+// attribute directly following the generation number (e.g. m6g, c7gn, r8gd, x2gd). The a1 family
+// predates this naming scheme.
+var awsARMInstanceTypeFamily = regexp.MustCompile(`^([a-z]+[0-9]+g[a-z-]*|a1)$`)
+
+// getAWSInstanceTypeArch returns the architecture, in the stream's naming, of the nodes provisioned
+// with the given AWS instance type. Returns an empty string if the instance type is not set.
+func getAWSInstanceTypeArch(instanceType string) string {
+	if instanceType == "" {
+		return ""
+	}
+	family, _, _ := strings.Cut(instanceType, ".")
+	if awsARMInstanceTypeFamily.MatchString(family) {
+		return archtranslater.RpmArch("arm64")
+	}
+	return archtranslater.RpmArch("amd64")
+}
+
 func reconcileVSphereProviderSpec(streamData *stream.Stream, arch string, infra *osconfigv1.Infrastructure, providerSpec *machinev1beta1.VSphereMachineProviderSpec, _ string, secretClient clientset.Interface) (bool, bool, *machinev1beta1.VSphereMachineProviderSpec, error) {
 
 	if infra.Spec.PlatformSpec.VSphere == nil {
@@ -326,7 +399,11 @@ func reconcileAzureProviderSpec(streamData *stream.Stream, arch string, _ *oscon
 	// If the current image matches, nothing to do here
 	// Q: Should we enhance this to do version comparisons?
 	if reflect.DeepEqual(currentImage, targetImage) {
-		return false, false, nil, nil
+		var userDataSecretName string
+		if providerSpec.UserDataSecret != nil {
+			userDataSecretName = providerSpec.UserDataSecret.Name
+		}
+		return false, false, nil, completePartialStubIgnitionUpgrade(userDataSecretName, secretClient)
 	}
 
 	klog.Infof("Current boot image version: %s", currentImage.Version)
@@ -0,0 +1,112 @@
+package bootimage
+
+import (
+	"testing"
+
+	"github.com/coreos/stream-metadata-go/stream"
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestReconcileAWSProviderSpec(t *testing.T) {
+	streamData := &stream.Stream{
+		Architectures: map[string]stream.Arch{
+			"x86_64": {
+				Images: stream.Images{
+					Aws: &stream.AwsImage{Regions: map[string]stream.SingleImage{"us-east-1": {Image: "ami-x86-64-new"}}},
+				},
+			},
+			"aarch64": {
+				Images: stream.Images{
+					Aws: &stream.AwsImage{Regions: map[string]stream.SingleImage{"us-east-1": {Image: "ami-aarch64-new"}}},
+				},
+			},
+		},
+	}
+	fakeClient := fake.NewClientset(&corev1.Secret{
+		ObjectMeta: v1.ObjectMeta{Name: "test-secret", Namespace: MachineAPINamespace},
+		Data: map[string][]byte{
+			ctrlcommon.UserDataKey: []byte(`{"ignition":{"version":"3.4.0"}}`),
+		},
+	})
+
+	tests := []struct {
+		name         string
+		arch         string
+		instanceType string
+		expectedAMI  string
+		expectError  bool
+	}{
+		{
+			name:         "x86_64 AMI on x86_64 instance type",
+			arch:         "x86_64",
+			instanceType: "m6i.xlarge",
+			expectedAMI:  "ami-x86-64-new",
+		},
+		{
+			name:         "aarch64 AMI on Graviton instance type",
+			arch:         "aarch64",
+			instanceType: "m6g.xlarge",
+			expectedAMI:  "ami-aarch64-new",
+		},
+		{
+			name:         "aarch64 AMI on Graviton instance type with additional attributes",
+			arch:         "aarch64",
+			instanceType: "c7gn.2xlarge",
+			expectedAMI:  "ami-aarch64-new",
+		},
+		{
+			name:         "x86_64 AMI on GPU instance type",
+			arch:         "x86_64",
+			instanceType: "g4dn.xlarge",
+			expectedAMI:  "ami-x86-64-new",
+		},
+		{
+			name:         "no instance type skips the check",
+			arch:         "aarch64",
+			instanceType: "",
+			expectedAMI:  "ami-aarch64-new",
+		},
+		{
+			name:         "aarch64 AMI on x86_64 instance type",
+			arch:         "aarch64",
+			instanceType: "m6i.xlarge",
+			expectError:  true,
+		},
+		{
+			name:         "x86_64 AMI on Graviton instance type",
+			arch:         "x86_64",
+			instanceType: "r8g.large",
+			expectError:  true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			currentAMI := "ami-000145e5a91e9ac22"
+			providerSpec := &machinev1beta1.AWSMachineProviderConfig{
+				AMI:            machinev1beta1.AWSResourceReference{ID: &currentAMI},
+				InstanceType:   tc.instanceType,
+				Placement:      machinev1beta1.Placement{Region: "us-east-1"},
+				UserDataSecret: &corev1.LocalObjectReference{Name: "test-secret"},
+			}
+
+			patchRequired, reconcileSkipped, newProviderSpec, err := reconcileAWSProviderSpec(streamData, tc.arch, nil, providerSpec, "test-machineset", fakeClient)
+			assert.False(t, reconcileSkipped)
+			if tc.expectError {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), "incompatible")
+				assert.False(t, patchRequired)
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, patchRequired)
+			assert.Equal(t, tc.expectedAMI, *newProviderSpec.AMI.ID)
+		})
+	}
+}
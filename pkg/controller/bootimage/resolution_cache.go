@@ -0,0 +1,110 @@
+package bootimage
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sync"
+	"time"
+
+	osconfigv1 "github.com/openshift/api/config/v1"
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	corev1 "k8s.io/api/core/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// imageResolutionCache remembers the boot image resolutions of MAPI machinesets that found nothing to
+// update, so that syncs within ImageResolutionCacheTTLAnnotationKey do not repeat external lookups such
+// as vCenter queries. It is invalidated whenever the boot images configmap changes, and is safe for
+// concurrent use as the configmap handlers run outside of the sync.
+type imageResolutionCache struct {
+	lock    sync.Mutex
+	entries map[string]imageResolution
+}
+
+// imageResolution is a cached boot image resolution.
+type imageResolution struct {
+	reconcileSkipped bool
+	resolvedAt       time.Time
+}
+
+func newImageResolutionCache() *imageResolutionCache {
+	return &imageResolutionCache{entries: map[string]imageResolution{}}
+}
+
+// getImageResolutionCacheKey returns the key of the boot image resolution of the machineset. Besides the
+// providerspec, the resolution depends on the stream data and, on vSphere, the failure domains of the
+// infrastructure, both of which are covered by their resource versions.
+func getImageResolutionCacheKey(infra *osconfigv1.Infrastructure, machineSet *machinev1beta1.MachineSet, configMap *corev1.ConfigMap, arch string) string {
+	var providerSpec []byte
+	if machineSet.Spec.Template.Spec.ProviderSpec.Value != nil {
+		providerSpec = machineSet.Spec.Template.Spec.ProviderSpec.Value.Raw
+	}
+	return fmt.Sprintf("%s/%s/%s/%s/%x", infra.Status.PlatformStatus.Type, infra.ResourceVersion, configMap.ResourceVersion, arch, sha256.Sum256(providerSpec))
+}
+
+// get returns the resolution cached under key, if it was resolved less than ttl ago.
+func (c *imageResolutionCache) get(key string, now time.Time, ttl time.Duration) (imageResolution, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	resolution, ok := c.entries[key]
+	if !ok || now.Sub(resolution.resolvedAt) >= ttl {
+		return imageResolution{}, false
+	}
+	return resolution, true
+}
+
+// add caches the resolution under key, and evicts the resolutions older than ttl.
+func (c *imageResolutionCache) add(key string, resolution imageResolution, ttl time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for k, cached := range c.entries {
+		if resolution.resolvedAt.Sub(cached.resolvedAt) >= ttl {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = resolution
+}
+
+// invalidate drops all cached resolutions.
+func (c *imageResolutionCache) invalidate() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	clear(c.entries)
+}
+
+// checkMachineSetCached calls checkMachineSet, unless the machineset's boot image was resolved within the
+// cache TTL and found up to date or unrecognized. Resolutions that require a patch are never cached, as
+// resolving prepares the update, e.g. by upgrading the ignition stub, and the machineset changes once
+// it is patched. Returns (patchRequired, reconcileSkipped, newMachineSet, error).
+func (ctrl *Controller) checkMachineSetCached(infra *osconfigv1.Infrastructure, machineSet *machinev1beta1.MachineSet, configMap *corev1.ConfigMap, arch string, secretClient clientset.Interface) (bool, bool, *machinev1beta1.MachineSet, error) {
+	ttl := ctrl.knobs.imageResolutionCacheTTL
+	if ttl == 0 {
+		return checkMachineSet(infra, machineSet, configMap, ctrl.streamConfigMapKey, arch, secretClient)
+	}
+	key := getImageResolutionCacheKey(infra, machineSet, configMap, arch)
+	if resolution, ok := ctrl.imageResolutionCache.get(key, ctrl.clock.Now(), ttl); ok {
+		klog.V(4).Infof("Reusing the boot image resolution of MAPI machineset %s from %s", machineSet.Name, resolution.resolvedAt.UTC().Format(time.RFC3339))
+		ctrlcommon.MCCBootImageResolutionCacheLookups.WithLabelValues("hit").Inc()
+		return false, resolution.reconcileSkipped, nil, nil
+	}
+	ctrlcommon.MCCBootImageResolutionCacheLookups.WithLabelValues("miss").Inc()
+	resolvedAt := ctrl.clock.Now()
+	patchRequired, reconcileSkipped, newMachineSet, err := checkMachineSet(infra, machineSet, configMap, ctrl.streamConfigMapKey, arch, secretClient)
+	if err == nil && !patchRequired {
+		ctrl.imageResolutionCache.add(key, imageResolution{reconcileSkipped: reconcileSkipped, resolvedAt: resolvedAt}, ttl)
+	}
+	return patchRequired, reconcileSkipped, newMachineSet, err
+}
+
+// isResolutionCached returns true if checkMachineSetCached would reuse a cached resolution of the
+// machineset's boot image rather than resolving it.
+func (ctrl *Controller) isResolutionCached(infra *osconfigv1.Infrastructure, machineSet *machinev1beta1.MachineSet, configMap *corev1.ConfigMap, arch string) bool {
+	ttl := ctrl.knobs.imageResolutionCacheTTL
+	if ttl == 0 {
+		return false
+	}
+	_, ok := ctrl.imageResolutionCache.get(getImageResolutionCacheKey(infra, machineSet, configMap, arch), ctrl.clock.Now(), ttl)
+	return ok
+}
@@ -0,0 +1,78 @@
+package bootimage
+
+import (
+	"testing"
+	"time"
+
+	osconfigv1 "github.com/openshift/api/config/v1"
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImageResolutionCache(t *testing.T) {
+	getLookups := func(result string) float64 {
+		return testutil.ToFloat64(ctrlcommon.MCCBootImageResolutionCacheLookups.WithLabelValues(result))
+	}
+	assertLookups := func(t *testing.T, hits, misses float64, sync func()) {
+		t.Helper()
+		initialHits, initialMisses := getLookups("hit"), getLookups("miss")
+		sync()
+		assert.Equal(t, hits, getLookups("hit")-initialHits, "hits")
+		assert.Equal(t, misses, getLookups("miss")-initialMisses, "misses")
+	}
+
+	t.Run("resolutions are reused within the TTL and invalidated by a configmap change", func(t *testing.T) {
+		ctrl := newTestController(t, osconfigv1.GCPPlatformType, []*machinev1beta1.MachineSet{getGCPMachineSet("machineset-a", testGCPStreamImage)}, nil)
+		ctrl.setKnobs(t, map[string]string{ImageResolutionCacheTTLAnnotationKey: "10m"})
+		sync := func() { require.NoError(t, ctrl.syncAll("test")) }
+
+		assertLookups(t, 0, 1, sync)
+		assertLookups(t, 1, 0, sync)
+		assertLookups(t, 1, 0, sync)
+
+		// The configmap handler invalidates the cache, even before the lister observes the change
+		oldConfigMap := getGCPBootImagesConfigMap()
+		newConfigMap := oldConfigMap.DeepCopy()
+		newConfigMap.ResourceVersion = "2"
+		ctrl.updateConfigMap(oldConfigMap, newConfigMap)
+		assertLookups(t, 0, 1, sync)
+		assertLookups(t, 1, 0, sync)
+		assert.Equal(t, 0, ctrl.countMachineSetPatches())
+	})
+
+	t.Run("resolutions expire after the TTL", func(t *testing.T) {
+		ctrl := newTestController(t, osconfigv1.GCPPlatformType, []*machinev1beta1.MachineSet{getGCPMachineSet("machineset-a", testGCPStreamImage)}, nil)
+		ctrl.setKnobs(t, map[string]string{ImageResolutionCacheTTLAnnotationKey: "10m"})
+		sync := func() { require.NoError(t, ctrl.syncAll("test")) }
+
+		assertLookups(t, 0, 1, sync)
+		for key, resolution := range ctrl.imageResolutionCache.entries {
+			resolution.resolvedAt = resolution.resolvedAt.Add(-10 * time.Minute)
+			ctrl.imageResolutionCache.entries[key] = resolution
+		}
+		assertLookups(t, 0, 1, sync)
+		assertLookups(t, 1, 0, sync)
+	})
+
+	t.Run("resolutions requiring a patch are not cached", func(t *testing.T) {
+		ctrl := newTestController(t, osconfigv1.GCPPlatformType, []*machinev1beta1.MachineSet{getGCPMachineSet("machineset-a", testGCPOldImage)}, nil)
+		ctrl.setKnobs(t, map[string]string{ImageResolutionCacheTTLAnnotationKey: "10m"})
+
+		assertLookups(t, 0, 1, func() { require.NoError(t, ctrl.syncAll("test")) })
+		assert.Empty(t, ctrl.imageResolutionCache.entries)
+		assert.Equal(t, 1, ctrl.countMachineSetPatches())
+	})
+
+	t.Run("the cache is disabled by default", func(t *testing.T) {
+		ctrl := newTestController(t, osconfigv1.GCPPlatformType, []*machinev1beta1.MachineSet{getGCPMachineSet("machineset-a", testGCPStreamImage)}, nil)
+
+		assertLookups(t, 0, 0, func() {
+			require.NoError(t, ctrl.syncAll("test"))
+			require.NoError(t, ctrl.syncAll("test"))
+		})
+		assert.Empty(t, ctrl.imageResolutionCache.entries)
+	})
+}
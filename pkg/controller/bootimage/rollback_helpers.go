@@ -0,0 +1,140 @@
+package bootimage
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+)
+
+const (
+	// Annotation written on a MAPI machineset, in the same patch as its boot image update while
+	// auto-rollback is enabled, holding the providerspec the machineset had before the update
+	BootImagePreviousProviderSpecAnnotationKey = "machineconfiguration.openshift.io/boot-image-previous-provider-spec"
+
+	// Annotation written on a MAPI machineset recording, in RFC 3339 format, when it was rolled back to its
+	// previous providerspec after repeated boot failures. Its boot image updates are frozen until the
+	// annotation is removed.
+	BootImageRolledBackAtAnnotationKey = "machineconfiguration.openshift.io/boot-image-rolled-back-at"
+)
+
+// rollbackFrozenError is returned by the sync of a MAPI machineset that was rolled back after repeated
+// boot failures, until BootImageRolledBackAtAnnotationKey is removed from it.
+type rollbackFrozenError struct {
+	name          string
+	rolledBackAt  string
+	annotationKey string
+}
+
+func (e *rollbackFrozenError) Error() string {
+	return fmt.Sprintf("boot image updates of machineset %s are frozen, it was rolled back to its previous boot image at %s after repeated boot failures. Please fix the boot images and remove annotation %s from the machineset to resume boot image updates", e.name, e.rolledBackAt, e.annotationKey)
+}
+
+// machineSetBoots describes how the machines created since the last boot image update of a machineset
+// are booting.
+type machineSetBoots struct {
+	// failed is the number of machines that failed, or did not reach Running within the boot timeout
+	failed int
+	// booted is set if any machine reached Running, proving the new boot image
+	booted bool
+	// booting is set if any machine is still within the boot timeout
+	booting bool
+}
+
+// getMachineSetBoots returns how the machines of the machineset created since its last boot image update,
+// as recorded by BootImageUpdatedAtAnnotationKey, are booting.
+func (ctrl *Controller) getMachineSetBoots(machineSet *machinev1beta1.MachineSet) (machineSetBoots, error) {
+	boots := machineSetBoots{}
+	updatedAt, err := time.Parse(time.RFC3339, machineSet.Annotations[ctrl.annotationKey(BootImageUpdatedAtAnnotationKey)])
+	if err != nil {
+		return boots, nil
+	}
+	machines, err := ctrl.mapiMachineLister.Machines(MachineAPINamespace).List(labels.Everything())
+	if err != nil {
+		return boots, fmt.Errorf("failed to list machines of machineset %s: %w", machineSet.Name, err)
+	}
+	timeout := ctrl.knobs.autoRollbackTimeout()
+	for _, machine := range machines {
+		if machine.DeletionTimestamp != nil || machine.CreationTimestamp.Time.Before(updatedAt) {
+			continue
+		}
+		if !slices.ContainsFunc(machine.GetOwnerReferences(), func(ref metav1.OwnerReference) bool {
+			return ref.Kind == "MachineSet" && ref.Name == machineSet.Name
+		}) {
+			continue
+		}
+		switch phase := ptr.Deref(machine.Status.Phase, ""); {
+		case phase == machinev1beta1.PhaseRunning:
+			boots.booted = true
+		case phase == machinev1beta1.PhaseFailed || ctrl.clock.Since(machine.CreationTimestamp.Time) > timeout:
+			boots.failed++
+		default:
+			boots.booting = true
+		}
+	}
+	return boots, nil
+}
+
+// rollbackOnBootFailures rolls the machineset back to the providerspec recorded before its last boot image
+// update if as many of the machines created since the update failed to boot as auto-rollback allows, and
+// none booted. The rolled back machineset is annotated with BootImageRolledBackAtAnnotationKey, which
+// freezes its boot image updates. Returns the time of the rollback, or an empty string if the machineset
+// was not rolled back.
+func (ctrl *Controller) rollbackOnBootFailures(machineSet *machinev1beta1.MachineSet) (string, error) {
+	previous, ok := machineSet.Annotations[ctrl.annotationKey(BootImagePreviousProviderSpecAnnotationKey)]
+	if !ok {
+		return "", nil
+	}
+	boots, err := ctrl.getMachineSetBoots(machineSet)
+	if err != nil {
+		return "", err
+	}
+	if boots.booting && !boots.booted {
+		// The machines still booting are checked again once the boot timeout has passed
+		ctrl.mapiAwaitingBoots = true
+	}
+	if boots.booted || boots.failed < ctrl.knobs.autoRollbackBootFailures {
+		return "", nil
+	}
+	if !json.Valid([]byte(previous)) {
+		return "", fmt.Errorf("cannot roll back machineset %s after %d boot failures, annotation %s does not hold a valid providerspec", machineSet.Name, boots.failed, ctrl.annotationKey(BootImagePreviousProviderSpecAnnotationKey))
+	}
+
+	klog.Warningf("%d machines of MAPI machineset %s failed to boot since its boot image update, rolling it back to its previous boot image", boots.failed, machineSet.Name)
+	newMachineSet := machineSet.DeepCopy()
+	newMachineSet.Spec.Template.Spec.ProviderSpec.Value = &runtime.RawExtension{Raw: []byte(previous)}
+	delete(newMachineSet.Annotations, ctrl.annotationKey(BootImagePreviousProviderSpecAnnotationKey))
+	rolledBackAt := ctrl.clock.Now().UTC().Format(time.RFC3339)
+	metav1.SetMetaDataAnnotation(&newMachineSet.ObjectMeta, ctrl.annotationKey(BootImageRolledBackAtAnnotationKey), rolledBackAt)
+	if err := ctrl.patchMachineSetRollback(machineSet, newMachineSet); err != nil {
+		return "", fmt.Errorf("failed to roll back machineset %s after %d boot failures: %w", machineSet.Name, boots.failed, err)
+	}
+	// The boot image reapplied once updates resume is not a hot loop
+	delete(ctrl.mapiBootImageState, machineSet.Name)
+	ctrl.updateHotLoopStateMetrics()
+	return rolledBackAt, nil
+}
+
+// updateMAPIMachine triggers a reconciliation when a machine owned by a machineset fails, so that
+// auto-rollback can act on boot failures without waiting for another event.
+func (ctrl *Controller) updateMAPIMachine(oldM, newM interface{}) {
+	oldMachine := oldM.(*machinev1beta1.Machine)
+	newMachine := newM.(*machinev1beta1.Machine)
+
+	if ptr.Deref(newMachine.Status.Phase, "") != machinev1beta1.PhaseFailed || ptr.Deref(oldMachine.Status.Phase, "") == machinev1beta1.PhaseFailed {
+		return
+	}
+	if !slices.ContainsFunc(newMachine.GetOwnerReferences(), func(ref metav1.OwnerReference) bool { return ref.Kind == "MachineSet" }) {
+		return
+	}
+
+	klog.V(4).Infof("MAPI machine %s failed, reconciling enrolled machine resources", newMachine.Name)
+	ctrl.enqueueEvent("MAPIMachineFailed")
+}
@@ -0,0 +1,186 @@
+package bootimage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	osconfigv1 "github.com/openshift/api/config/v1"
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	opv1 "github.com/openshift/api/operator/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestAutoRollbackOnBootFailures(t *testing.T) {
+	// A machine of machineset-a, created the given duration before the last sync
+	type testMachine struct {
+		name  string
+		phase string
+		age   time.Duration
+	}
+
+	cases := []struct {
+		name             string
+		annotations      map[string]string
+		updatedAgo       time.Duration
+		machines         []testMachine
+		expectRolledBack bool
+	}{
+		{
+			name:        "auto-rollback is opt-in",
+			annotations: map[string]string{},
+			machines:    []testMachine{{"failed-1", machinev1beta1.PhaseFailed, 0}, {"failed-2", machinev1beta1.PhaseFailed, 0}},
+		},
+		{
+			name:        "boot failures below the threshold do not roll back",
+			annotations: map[string]string{AutoRollbackBootFailuresAnnotationKey: "2"},
+			machines:    []testMachine{{"failed-1", machinev1beta1.PhaseFailed, 0}, {"provisioning", machinev1beta1.PhaseProvisioning, 0}},
+		},
+		{
+			name:             "repeated boot failures roll back",
+			annotations:      map[string]string{AutoRollbackBootFailuresAnnotationKey: "2"},
+			machines:         []testMachine{{"failed-1", machinev1beta1.PhaseFailed, 0}, {"failed-2", machinev1beta1.PhaseFailed, 0}},
+			expectRolledBack: true,
+		},
+		{
+			name:             "machines not running within the boot timeout are boot failures",
+			annotations:      map[string]string{AutoRollbackBootFailuresAnnotationKey: "2", AutoRollbackBootTimeoutAnnotationKey: "10m"},
+			updatedAgo:       time.Hour,
+			machines:         []testMachine{{"failed-1", machinev1beta1.PhaseFailed, 0}, {"stuck", machinev1beta1.PhaseProvisioned, 30 * time.Minute}},
+			expectRolledBack: true,
+		},
+		{
+			name:        "a machine that booted proves the new boot image",
+			annotations: map[string]string{AutoRollbackBootFailuresAnnotationKey: "2"},
+			machines:    []testMachine{{"failed-1", machinev1beta1.PhaseFailed, 0}, {"failed-2", machinev1beta1.PhaseFailed, 0}, {"running", machinev1beta1.PhaseRunning, 0}},
+		},
+		{
+			name:        "machines created before the update are not counted",
+			annotations: map[string]string{AutoRollbackBootFailuresAnnotationKey: "2"},
+			machines:    []testMachine{{"failed-1", machinev1beta1.PhaseFailed, time.Hour}, {"failed-2", machinev1beta1.PhaseFailed, time.Hour}},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			original := getGCPMachineSet("machineset-a", testGCPOldImage)
+			ctrl := newTestController(t, osconfigv1.GCPPlatformType, []*machinev1beta1.MachineSet{original}, nil)
+			ctrl.setKnobs(t, tc.annotations)
+			// Mimics the informer catching up with the writes of the controller, or of the admin
+			refresh := func(t *testing.T, update func(*machinev1beta1.MachineSet)) {
+				t.Helper()
+				machineSet := ctrl.getMachineSet(t, "machineset-a")
+				if update != nil {
+					update(machineSet)
+					var err error
+					machineSet, err = ctrl.machineClient.MachineV1beta1().MachineSets(MachineAPINamespace).Update(context.TODO(), machineSet, v1.UpdateOptions{})
+					require.NoError(t, err)
+				}
+				require.NoError(t, ctrl.msIndexer.Update(machineSet))
+			}
+
+			// The update records the previous providerspec while auto-rollback is enabled
+			require.NoError(t, ctrl.syncAll("test"))
+			updated := ctrl.getMachineSet(t, "machineset-a")
+			assert.Equal(t, testGCPStreamImage, getGCPMachineSetBootImage(t, updated))
+			if _, enabled := tc.annotations[AutoRollbackBootFailuresAnnotationKey]; enabled {
+				assert.Equal(t, string(original.Spec.Template.Spec.ProviderSpec.Value.Raw), updated.Annotations[BootImagePreviousProviderSpecAnnotationKey])
+				assert.True(t, ctrl.mapiAwaitingBoots)
+			} else {
+				assert.NotContains(t, updated.Annotations, BootImagePreviousProviderSpecAnnotationKey)
+			}
+			refresh(t, func(ms *machinev1beta1.MachineSet) {
+				if tc.updatedAgo > 0 {
+					ms.Annotations[BootImageUpdatedAtAnnotationKey] = time.Now().Add(-tc.updatedAgo).UTC().Format(time.RFC3339)
+				}
+			})
+
+			machines := []*machinev1beta1.Machine{}
+			for _, m := range tc.machines {
+				machine := getMachine(m.name, "machineset-a", m.phase)
+				machine.CreationTimestamp = v1.NewTime(time.Now().Add(-m.age))
+				require.NoError(t, ctrl.machineIndexer.Add(machine))
+				machines = append(machines, machine)
+			}
+			require.NoError(t, ctrl.syncAll("test"))
+
+			machineSet := ctrl.getMachineSet(t, "machineset-a")
+			degraded := ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateDegraded)
+			if !tc.expectRolledBack {
+				assert.Equal(t, testGCPStreamImage, getGCPMachineSetBootImage(t, machineSet))
+				assert.NotContains(t, machineSet.Annotations, BootImageRolledBackAtAnnotationKey)
+				assert.Equal(t, v1.ConditionFalse, degraded.Status)
+				return
+			}
+			assert.Equal(t, testGCPOldImage, getGCPMachineSetBootImage(t, machineSet))
+			assert.Contains(t, machineSet.Annotations, BootImageRolledBackAtAnnotationKey)
+			assert.NotContains(t, machineSet.Annotations, BootImagePreviousProviderSpecAnnotationKey)
+			assert.Equal(t, v1.ConditionTrue, degraded.Status)
+			assert.Equal(t, ReasonBootImageRolledBack, degraded.Reason)
+			assert.Contains(t, degraded.Message, "boot image updates of machineset machineset-a are frozen")
+
+			// Boot image updates stay frozen
+			refresh(t, nil)
+			require.NoError(t, ctrl.syncAll("test"))
+			assert.Equal(t, testGCPOldImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, "machineset-a")))
+			assert.Equal(t, v1.ConditionTrue, ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateDegraded).Status)
+
+			// Removing the rollback annotation resumes boot image updates
+			for _, machine := range machines {
+				require.NoError(t, ctrl.machineIndexer.Delete(machine))
+			}
+			refresh(t, func(ms *machinev1beta1.MachineSet) { delete(ms.Annotations, BootImageRolledBackAtAnnotationKey) })
+			require.NoError(t, ctrl.syncAll("test"))
+			assert.Equal(t, testGCPStreamImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, "machineset-a")))
+			assert.Equal(t, v1.ConditionFalse, ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateDegraded).Status)
+		})
+	}
+}
+
+func TestUpdateMAPIMachineEnqueuesFailures(t *testing.T) {
+	cases := []struct {
+		name          string
+		oldMachine    *machinev1beta1.Machine
+		newMachine    *machinev1beta1.Machine
+		expectEnqueue bool
+	}{
+		{
+			name:          "machine failed",
+			oldMachine:    getMachine("machine", "machineset-a", machinev1beta1.PhaseProvisioning),
+			newMachine:    getMachine("machine", "machineset-a", machinev1beta1.PhaseFailed),
+			expectEnqueue: true,
+		},
+		{
+			name:       "machine already failed",
+			oldMachine: getMachine("machine", "machineset-a", machinev1beta1.PhaseFailed),
+			newMachine: getMachine("machine", "machineset-a", machinev1beta1.PhaseFailed),
+		},
+		{
+			name:       "machine running",
+			oldMachine: getMachine("machine", "machineset-a", machinev1beta1.PhaseProvisioned),
+			newMachine: getMachine("machine", "machineset-a", machinev1beta1.PhaseRunning),
+		},
+		{
+			name:       "machine not owned by a machineset failed",
+			oldMachine: getMachine("machine", "", machinev1beta1.PhaseProvisioning),
+			newMachine: func() *machinev1beta1.Machine {
+				machine := getMachine("machine", "", machinev1beta1.PhaseFailed)
+				machine.OwnerReferences = nil
+				return machine
+			}(),
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := newTestController(t, osconfigv1.GCPPlatformType, nil, nil)
+			ctrl.updateMAPIMachine(tc.oldMachine, tc.newMachine)
+			if tc.expectEnqueue {
+				assert.Equal(t, 1, ctrl.queue.Len())
+			} else {
+				assert.Equal(t, 0, ctrl.queue.Len())
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package bootimage
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// Name of the configmap in the MCO namespace in which the controller persists the cursor of a
+	// budget-limited rollout, so that a restarted controller resumes the rollout where it left off
+	BootImageRolloutStateConfigMapName = "machine-config-boot-image-rollout-state"
+
+	// Key of the rollout state configmap holding the name of the last MAPI machineset updated by a
+	// budget-limited rollout
+	rolloutCursorKey = "cursor"
+)
+
+// loadRolloutCursor reads the persisted rollout cursor, at startup or the first time it is needed;
+// afterwards, the cursor held by the controller is authoritative. A cursor that is not a valid
+// machineset name is discarded, and the rollout state configmap is overwritten by the next pass.
+func (ctrl *Controller) loadRolloutCursor() {
+	if ctrl.rolloutCursorLoaded {
+		return
+	}
+	configMap, err := ctrl.mcoCmLister.ConfigMaps(ctrlcommon.MCONamespace).Get(BootImageRolloutStateConfigMapName)
+	if err != nil && !k8serrors.IsNotFound(err) {
+		klog.Warningf("Failed to read rollout state configmap %s, the rollout will start over: %v", BootImageRolloutStateConfigMapName, err)
+	}
+	if err == nil {
+		cursor := configMap.Data[rolloutCursorKey]
+		// The persisted value is kept as is, so that it differs from any cursor written later
+		ctrl.persistedRolloutCursor = cursor
+		if errs := validation.IsDNS1123Subdomain(cursor); cursor != "" && len(errs) > 0 {
+			klog.Warningf("Ignoring invalid rollout cursor in configmap %s, the rollout will start over: %s", BootImageRolloutStateConfigMapName, strings.Join(errs, ", "))
+			cursor = ""
+		}
+		ctrl.mapiRolloutCursor = cursor
+		if ctrl.mapiRolloutCursor != "" {
+			klog.Infof("Resuming budget-limited boot image rollout after MAPI machineset %s", ctrl.mapiRolloutCursor)
+		}
+	}
+	ctrl.rolloutCursorLoaded = true
+}
+
+// orderBudgetedRollout returns the machinesets in name order, starting with the first machineset after
+// the cursor, so that a budget-limited rollout keeps moving forward through the fleet.
+func orderBudgetedRollout(machineSets []*machinev1beta1.MachineSet, cursor string) []*machinev1beta1.MachineSet {
+	sorted := slices.SortedFunc(slices.Values(machineSets), func(a, b *machinev1beta1.MachineSet) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	start := slices.IndexFunc(sorted, func(ms *machinev1beta1.MachineSet) bool { return ms.Name > cursor })
+	if start <= 0 {
+		return sorted
+	}
+	return slices.Concat(sorted[start:], sorted[:start])
+}
+
+// persistRolloutCursor saves the cursor of the rollout at the end of a pass. The cursor is cleared once
+// a pass no longer uses up its budget, as the rollout is then complete.
+func (ctrl *Controller) persistRolloutCursor() {
+	if ctrl.mapiReconcileBudget == 0 || ctrl.mapiStats.updatedCount < ctrl.mapiReconcileBudget {
+		ctrl.mapiRolloutCursor = ""
+	}
+	if ctrl.mapiRolloutCursor == ctrl.persistedRolloutCursor {
+		return
+	}
+	if err := ctrl.writeRolloutStateConfigMap(ctrl.mapiRolloutCursor); err != nil {
+		// The rollout carries on; only a restart before the next successful write starts it over
+		klog.Errorf("Failed to persist boot image rollout cursor: %v", err)
+		return
+	}
+	ctrl.persistedRolloutCursor = ctrl.mapiRolloutCursor
+}
+
+// writeRolloutStateConfigMap creates or updates the rollout state configmap with the given cursor.
+func (ctrl *Controller) writeRolloutStateConfigMap(cursor string) error {
+	configMaps := ctrl.kubeClient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace)
+	configMap, err := configMaps.Get(context.TODO(), BootImageRolloutStateConfigMapName, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		configMap = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: BootImageRolloutStateConfigMapName, Namespace: ctrlcommon.MCONamespace},
+			Data:       map[string]string{rolloutCursorKey: cursor},
+		}
+		if _, err := configMaps.Create(context.TODO(), configMap, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create rollout state configmap %s: %w", BootImageRolloutStateConfigMapName, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get rollout state configmap %s: %w", BootImageRolloutStateConfigMapName, err)
+	}
+	configMap = configMap.DeepCopy()
+	if configMap.Data == nil {
+		configMap.Data = map[string]string{}
+	}
+	configMap.Data[rolloutCursorKey] = cursor
+	if _, err := configMaps.Update(context.TODO(), configMap, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to update rollout state configmap %s: %w", BootImageRolloutStateConfigMapName, err)
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package bootimage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	osconfigv1 "github.com/openshift/api/config/v1"
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+func TestBudgetedRolloutResumesAfterRestart(t *testing.T) {
+	budget := map[string]string{ReconcileBudgetPercentAnnotationKey: "34"}
+	machineSetNames := []string{"machineset-0", "machineset-1", "machineset-2"}
+	newRollout := func(t *testing.T, machineSets []*machinev1beta1.MachineSet) *testController {
+		t.Helper()
+		ctrl := newTestController(t, osconfigv1.GCPPlatformType, machineSets, nil)
+		ctrl.setKnobs(t, budget)
+		return ctrl
+	}
+	getCursor := func(t *testing.T, ctrl *testController) string {
+		t.Helper()
+		rolloutState, err := ctrl.kubeClient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Get(context.TODO(), BootImageRolloutStateConfigMapName, v1.GetOptions{})
+		require.NoError(t, err)
+		return rolloutState.Data[rolloutCursorKey]
+	}
+	getBootImages := func(t *testing.T, ctrl *testController) []string {
+		t.Helper()
+		images := []string{}
+		for _, name := range machineSetNames {
+			images = append(images, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, name)))
+		}
+		return images
+	}
+
+	// Budget-limited passes update the machinesets in name order, one per pass
+	machineSets := []*machinev1beta1.MachineSet{}
+	for _, name := range []string{"machineset-2", "machineset-0", "machineset-1"} {
+		machineSets = append(machineSets, getGCPMachineSet(name, testGCPOldImage))
+	}
+	ctrl := newRollout(t, machineSets)
+	require.NoError(t, ctrl.syncAll("test"))
+	assert.Equal(t, []string{testGCPStreamImage, testGCPOldImage, testGCPOldImage}, getBootImages(t, ctrl))
+	assert.Equal(t, "machineset-0", getCursor(t, ctrl))
+	require.NoError(t, ctrl.syncAll("test"))
+	assert.Equal(t, []string{testGCPStreamImage, testGCPStreamImage, testGCPOldImage}, getBootImages(t, ctrl))
+	assert.Equal(t, "machineset-1", getCursor(t, ctrl))
+
+	// Restarts the controller mid-rollout, with the boot image of machineset-0 reverted in the meantime
+	restart := func(t *testing.T, keepRolloutState bool) *testController {
+		t.Helper()
+		restartedMachineSets := []*machinev1beta1.MachineSet{}
+		for _, name := range machineSetNames {
+			ms := ctrl.getMachineSet(t, name)
+			ms.ResourceVersion = ""
+			restartedMachineSets = append(restartedMachineSets, ms)
+		}
+		restartedMachineSets[0].Spec.Template.Spec.ProviderSpec = getGCPMachineSet("machineset-0", testGCPOldImage).Spec.Template.Spec.ProviderSpec
+		restarted := newRollout(t, restartedMachineSets)
+		if keepRolloutState {
+			rolloutState, err := ctrl.kubeClient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Get(context.TODO(), BootImageRolloutStateConfigMapName, v1.GetOptions{})
+			require.NoError(t, err)
+			rolloutState.ResourceVersion = ""
+			_, err = restarted.kubeClient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Create(context.TODO(), rolloutState, v1.CreateOptions{})
+			require.NoError(t, err)
+			require.NoError(t, restarted.cmIndexer.Add(rolloutState))
+		}
+		return restarted
+	}
+
+	t.Run("persisted cursor resumes the rollout", func(t *testing.T) {
+		restarted := restart(t, true)
+		require.NoError(t, restarted.syncAll("test"))
+		assert.Equal(t, []string{testGCPOldImage, testGCPStreamImage, testGCPStreamImage}, getBootImages(t, restarted))
+		assert.Equal(t, "machineset-2", getCursor(t, restarted))
+
+		// The rollout then wraps around
+		require.NoError(t, restarted.syncAll("test"))
+		assert.Equal(t, []string{testGCPStreamImage, testGCPStreamImage, testGCPStreamImage}, getBootImages(t, restarted))
+		assert.Equal(t, "machineset-0", getCursor(t, restarted))
+
+		// The cursor is cleared once a pass is no longer limited by a budget
+		restarted.setKnobs(t, map[string]string{})
+		require.NoError(t, restarted.syncAll("test"))
+		assert.Empty(t, getCursor(t, restarted))
+	})
+
+	t.Run("rollout starts over without persisted state", func(t *testing.T) {
+		restarted := restart(t, false)
+		require.NoError(t, restarted.syncAll("test"))
+		assert.Equal(t, []string{testGCPStreamImage, testGCPStreamImage, testGCPOldImage}, getBootImages(t, restarted))
+	})
+}
+
+func TestOrderBudgetedRollout(t *testing.T) {
+	machineSets := []*machinev1beta1.MachineSet{}
+	for _, name := range []string{"machineset-c", "machineset-a", "machineset-b"} {
+		machineSets = append(machineSets, getGCPMachineSet(name, testGCPOldImage))
+	}
+	cases := []struct {
+		cursor   string
+		expected []string
+	}{
+		{cursor: "", expected: []string{"machineset-a", "machineset-b", "machineset-c"}},
+		{cursor: "machineset-a", expected: []string{"machineset-b", "machineset-c", "machineset-a"}},
+		{cursor: "machineset-c", expected: []string{"machineset-a", "machineset-b", "machineset-c"}},
+		// A cursor naming a machineset that is no longer enrolled resumes after where it would sort
+		{cursor: "machineset-aa", expected: []string{"machineset-b", "machineset-c", "machineset-a"}},
+	}
+	for _, tc := range cases {
+		names := []string{}
+		for _, ms := range orderBudgetedRollout(machineSets, tc.cursor) {
+			names = append(names, ms.Name)
+		}
+		assert.Equal(t, tc.expected, names, "cursor %q", tc.cursor)
+	}
+}
+
+func TestStartupSurvivesGarbageRolloutState(t *testing.T) {
+	machineSets := []*machinev1beta1.MachineSet{}
+	for _, name := range []string{"machineset-0", "machineset-1", "machineset-2"} {
+		machineSets = append(machineSets, getGCPMachineSet(name, testGCPOldImage))
+	}
+	ctrl := newTestController(t, osconfigv1.GCPPlatformType, machineSets, nil)
+	ctrl.setKnobs(t, map[string]string{ReconcileBudgetPercentAnnotationKey: "34"})
+	rolloutState := &corev1.ConfigMap{
+		ObjectMeta: v1.ObjectMeta{Name: BootImageRolloutStateConfigMapName, Namespace: ctrlcommon.MCONamespace},
+		Data:       map[string]string{rolloutCursorKey: "\x00{not a machineset name"},
+	}
+	_, err := ctrl.kubeClient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Create(context.TODO(), rolloutState, v1.CreateOptions{})
+	require.NoError(t, err)
+	require.NoError(t, ctrl.cmIndexer.Add(rolloutState))
+
+	// Starts and stops the controller, which loads the persisted state at startup
+	synced := func() bool { return true }
+	ctrl.mcoCmListerSynced, ctrl.mapiMachineSetListerSynced, ctrl.mapiMachineListerSynced = synced, synced, synced
+	ctrl.infraListerSynced, ctrl.mcopListerSynced, ctrl.clusterVersionListerSynced, ctrl.mapiSecretListerSynced = synced, synced, synced, synced
+	ctrl.nodeListerSynced = synced
+	ctrl.serveMachineConfigurationAPI()
+	stopCh := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		ctrl.Run(stopCh)
+		close(done)
+	}()
+	close(stopCh)
+	select {
+	case <-done:
+	case <-time.After(wait.ForeverTestTimeout):
+		t.Fatal("controller did not stop")
+	}
+	assert.True(t, ctrl.rolloutCursorLoaded)
+	assert.Empty(t, ctrl.mapiRolloutCursor)
+
+	// The rollout starts over, and the garbage is overwritten
+	require.NoError(t, ctrl.syncAll("test"))
+	assert.Equal(t, testGCPStreamImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, "machineset-0")))
+	rolloutState, err = ctrl.kubeClient.CoreV1().ConfigMaps(ctrlcommon.MCONamespace).Get(context.TODO(), BootImageRolloutStateConfigMapName, v1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "machineset-0", rolloutState.Data[rolloutCursorKey])
+}
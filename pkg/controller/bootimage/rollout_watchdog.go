@@ -0,0 +1,152 @@
+package bootimage
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	opv1 "github.com/openshift/api/operator/v1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	corev1 "k8s.io/api/core/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/klog/v2"
+)
+
+// stuckRolloutCheckInterval is the interval at which the watchdog checks for a stuck rollout
+const stuckRolloutCheckInterval = 30 * time.Second
+
+// rolloutProgress is the progress of the current rollout, as tracked by the sync for the stuck rollout
+// watchdog. It is guarded by Controller.rolloutLock, as the watchdog runs while a sync may be hung.
+type rolloutProgress struct {
+	// startedAt is the time at which the machine resources last became unfinished; zero while finished
+	startedAt time.Time
+	// pendingMAPIMachineSets are the MAPI machinesets not yet synced in the current pass
+	pendingMAPIMachineSets []string
+	// timeout is the configured StuckRolloutTimeoutAnnotationKey; 0 disables the watchdog
+	timeout time.Duration
+	// reported is set once the current rollout was reported as stuck
+	reported bool
+
+	// convergencePending is set by a change of the boot images configmap until machine resources have
+	// converged on it, and convergingSince is the time Progressing first went True after the change
+	convergencePending bool
+	convergingSince    time.Time
+}
+
+// trackRolloutProgress records whether all machine resources have finished syncing, and reports the
+// recovery of a rollout previously reported as stuck.
+func (ctrl *Controller) trackRolloutProgress(finished bool) {
+	ctrl.rolloutLock.Lock()
+	ctrl.rollout.timeout = ctrl.knobs.stuckRolloutTimeout
+	recovered := finished && ctrl.rollout.reported
+	if finished {
+		ctrl.rollout.startedAt = time.Time{}
+		ctrl.rollout.reported = false
+	} else if ctrl.rollout.startedAt.IsZero() {
+		ctrl.rollout.startedAt = ctrl.clock.Now()
+	}
+	if !finished && ctrl.rollout.convergencePending && ctrl.rollout.convergingSince.IsZero() {
+		ctrl.rollout.convergingSince = ctrl.clock.Now()
+	}
+	ctrl.rolloutLock.Unlock()
+
+	if recovered {
+		klog.Infof("Boot image rollout previously reported as stuck has finished")
+		if mcop, err := ctrl.mcopLister.Get(ctrlcommon.MCOOperatorKnobsObjectName); err == nil {
+			ctrl.eventRecorder.Event(mcop, corev1.EventTypeNormal, "BootImageRolloutRecovered", "Boot image rollout previously reported as stuck has finished")
+		}
+	}
+}
+
+// trackConfigMapRollout starts tracking the convergence of machine resources on a changed boot images
+// configmap. A change during a rollout restarts the tracking, as the rollout now converges on the new
+// content.
+func (ctrl *Controller) trackConfigMapRollout() {
+	ctrl.rolloutLock.Lock()
+	defer ctrl.rolloutLock.Unlock()
+	ctrl.rollout.convergencePending = true
+	ctrl.rollout.convergingSince = time.Time{}
+}
+
+// recordRolloutConvergence observes the convergence duration of a configmap driven rollout once all
+// machine resources have finished syncing without errors, and none are behind the configmap. A rollout
+// that never converges is not observed; the stuck rollout watchdog reports it instead.
+func (ctrl *Controller) recordRolloutConvergence(mcop *opv1.MachineConfiguration) {
+	allStats := []MachineResourceStats{ctrl.mapiStats, ctrl.cpmsStats, ctrl.capiMachineSetStats, ctrl.capiMachineDeploymentStats}
+	for _, stats := range allStats {
+		if !stats.isFinished() || stats.erroredCount > 0 || stats.behindCount() > 0 {
+			return
+		}
+	}
+
+	ctrl.rolloutLock.Lock()
+	convergingSince := ctrl.rollout.convergingSince
+	converged := ctrl.rollout.convergencePending && !convergingSince.IsZero()
+	if converged {
+		ctrl.rollout.convergencePending = false
+		ctrl.rollout.convergingSince = time.Time{}
+	}
+	ctrl.rolloutLock.Unlock()
+	if !converged {
+		return
+	}
+
+	duration := ctrl.clock.Since(convergingSince)
+	ctrlcommon.MCCBootImageRolloutConvergenceDuration.Observe(duration.Seconds())
+	klog.Infof("Machine resources converged on the boot images configmap in %v", duration.Round(time.Second))
+	ctrl.eventRecorder.Eventf(mcop, corev1.EventTypeNormal, "BootImageRolloutConverged", "Machine resources converged on the boot images configmap in %v", duration.Round(time.Second))
+}
+
+// setPendingMAPIMachineSets records the MAPI machinesets not yet synced in the current pass.
+func (ctrl *Controller) setPendingMAPIMachineSets(names []string) {
+	ctrl.rolloutLock.Lock()
+	defer ctrl.rolloutLock.Unlock()
+	ctrl.rollout.pendingMAPIMachineSets = append([]string{}, names...)
+}
+
+// runStuckRolloutWatchdog checks for a stuck rollout every stuckRolloutCheckInterval, until stopCh is
+// closed.
+func (ctrl *Controller) runStuckRolloutWatchdog(stopCh <-chan struct{}) {
+	defer utilruntime.HandleCrash()
+	ticker := ctrl.clock.NewTicker(stuckRolloutCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C():
+			ctrl.checkStuckRollout()
+		}
+	}
+}
+
+// checkStuckRollout raises a warning event, once per rollout, if machine resources have been syncing for
+// longer than StuckRolloutTimeoutAnnotationKey. It runs on its own goroutine so that a hung sync, e.g. a
+// vSphere template import that never completes, is reported as well.
+func (ctrl *Controller) checkStuckRollout() {
+	ctrl.rolloutLock.Lock()
+	progress := ctrl.rollout
+	stuck := progress.timeout > 0 && !progress.startedAt.IsZero() && !progress.reported && ctrl.clock.Since(progress.startedAt) >= progress.timeout
+	if stuck {
+		ctrl.rollout.reported = true
+	}
+	ctrl.rolloutLock.Unlock()
+	if !stuck {
+		return
+	}
+
+	pending := "none"
+	if len(progress.pendingMAPIMachineSets) > 0 {
+		pending = strings.Join(slices.Sorted(slices.Values(progress.pendingMAPIMachineSets)), ", ")
+	}
+	elapsed := ctrl.clock.Since(progress.startedAt).Round(time.Second)
+	klog.Warningf("Boot image rollout has been progressing for %v, longer than %v; MAPI machinesets not yet synced: %s", elapsed, progress.timeout, pending)
+	mcop, err := ctrl.mcopLister.Get(ctrlcommon.MCOOperatorKnobsObjectName)
+	if err != nil {
+		klog.Errorf("Failed to get MachineConfiguration to report a stuck boot image rollout: %v", err)
+		return
+	}
+	ctrl.eventRecorder.Eventf(mcop, corev1.EventTypeWarning, "BootImageRolloutStuck",
+		"Boot image rollout has been progressing for %v, longer than %v set by %s; MAPI machinesets not yet synced: %s",
+		elapsed, progress.timeout, ctrl.annotationKey(StuckRolloutTimeoutAnnotationKey), pending)
+}
@@ -0,0 +1,81 @@
+package bootimage
+
+import (
+	"testing"
+	"time"
+
+	osconfigv1 "github.com/openshift/api/config/v1"
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	opv1 "github.com/openshift/api/operator/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clienttesting "k8s.io/client-go/testing"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+func TestStuckRolloutWatchdog(t *testing.T) {
+	machineSets := []*machinev1beta1.MachineSet{
+		getGCPMachineSet("machineset-a", testGCPOldImage),
+		getGCPMachineSet("machineset-b", testGCPOldImage),
+	}
+	ctrl := newTestController(t, osconfigv1.GCPPlatformType, machineSets, nil)
+	fakeClock := clocktesting.NewFakeClock(time.Now())
+	ctrl.clock = fakeClock
+	ctrl.knobs = getBootImageKnobs(&opv1.MachineConfiguration{ObjectMeta: v1.ObjectMeta{
+		Annotations: map[string]string{StuckRolloutTimeoutAnnotationKey: "1h"},
+	}}, DefaultAnnotationKeyPrefix, nil)
+
+	// The first patch hangs until released, so the rollout never finishes on its own
+	patching := make(chan struct{})
+	release := make(chan struct{})
+	ctrl.machineClient.PrependReactor("patch", "machinesets", func(_ clienttesting.Action) (bool, runtime.Object, error) {
+		select {
+		case patching <- struct{}{}:
+			<-release
+		default:
+		}
+		return false, nil, nil
+	})
+	synced := make(chan struct{})
+	go func() {
+		defer close(synced)
+		ctrl.syncMAPIMachineSets("test")
+	}()
+	<-patching
+
+	// A rollout progressing for less than the timeout is not reported
+	ctrl.checkStuckRollout()
+	assert.Empty(t, ctrl.eventRecorder.Events)
+
+	fakeClock.Step(time.Hour)
+	ctrl.checkStuckRollout()
+	require.Len(t, ctrl.eventRecorder.Events, 1)
+	event := <-ctrl.eventRecorder.Events
+	assert.Contains(t, event, "Warning BootImageRolloutStuck")
+	assert.Contains(t, event, "MAPI machinesets not yet synced: machineset-a, machineset-b")
+
+	// The stuck rollout is only reported once
+	ctrl.checkStuckRollout()
+	assert.Empty(t, ctrl.eventRecorder.Events)
+
+	// Once the rollout finishes, its recovery is reported and the watchdog is rearmed
+	close(release)
+	<-synced
+	assert.Equal(t, 2, ctrl.mapiStats.updatedCount)
+	require.Len(t, ctrl.eventRecorder.Events, 1)
+	assert.Contains(t, <-ctrl.eventRecorder.Events, "Normal BootImageRolloutRecovered")
+	ctrl.checkStuckRollout()
+	assert.Empty(t, ctrl.eventRecorder.Events)
+	assert.True(t, ctrl.rollout.startedAt.IsZero())
+	assert.Empty(t, ctrl.rollout.pendingMAPIMachineSets)
+
+	// Without a timeout, a rollout is never reported as stuck
+	ctrl.knobs = bootImageKnobs{}
+	ctrl.mapiStats.totalCount++
+	ctrl.updateConditions("test", nil, opv1.MachineConfigurationBootImageUpdateProgressing)
+	ctrl.rollout.startedAt = fakeClock.Now().Add(-time.Hour)
+	ctrl.checkStuckRollout()
+	assert.Empty(t, ctrl.eventRecorder.Events)
+}
@@ -0,0 +1,304 @@
+package bootimage
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	osconfigv1 "github.com/openshift/api/config/v1"
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// BootImageSource identifies where the boot image applied to a machineset comes from.
+type BootImageSource string
+
+const (
+	// The Secret referenced by the machineset's BootImageSecretRefAnnotationKey annotation
+	BootImageSourceMachineSetOverride BootImageSource = "MachineSetOverride"
+	// The Secret referenced by the MachineConfiguration's ClusterBootImageSecretRefAnnotationKey annotation
+	BootImageSourceClusterImage BootImageSource = "ClusterImage"
+	// The stream data of the boot images configmap
+	BootImageSourceStream BootImageSource = "Stream"
+)
+
+// secretBootImage is a boot image read from a Secret.
+type secretBootImage struct {
+	image string
+	// userDataSecret is the user data secret paired with the image; empty if none
+	userDataSecret string
+}
+
+// resolveBootImageSource returns the source that the boot image of the machineset is taken from, along
+// with the boot image read from a Secret unless it comes from the stream. Sources take precedence in the order: the
+// machineset's own override, the cluster-wide image, the stream. A source that is set but cannot be
+// read is an error rather than falling through to the next source, so that a broken reference never
+// silently applies a different image.
+func (ctrl *Controller) resolveBootImageSource(machineSet *machinev1beta1.MachineSet) (BootImageSource, secretBootImage, error) {
+	secretRefKey := ctrl.annotationKey(BootImageSecretRefAnnotationKey)
+	if secretName, ok := machineSet.GetAnnotations()[secretRefKey]; ok && !ctrl.isBootImagePinExpired(machineSet) {
+		if secretName == "" {
+			return BootImageSourceMachineSetOverride, secretBootImage{}, fmt.Errorf("annotation %s on machineset %s is empty", secretRefKey, machineSet.Name)
+		}
+		bootImage, err := ctrl.getSecretBootImage(secretName, "machineset "+machineSet.Name)
+		return BootImageSourceMachineSetOverride, bootImage, err
+	}
+	if secretName := ctrl.knobs.clusterBootImageSecretRef; secretName != "" {
+		bootImage, err := ctrl.getSecretBootImage(secretName, "annotation "+ctrl.annotationKey(ClusterBootImageSecretRefAnnotationKey))
+		return BootImageSourceClusterImage, bootImage, err
+	}
+	return BootImageSourceStream, secretBootImage{}, nil
+}
+
+// isBootImagePinExpired returns true if the machineset's BootImageSecretRefExpiresAtAnnotationKey
+// timestamp has passed, reporting the expiry once. A malformed timestamp is logged and never expires.
+func (ctrl *Controller) isBootImagePinExpired(machineSet *machinev1beta1.MachineSet) bool {
+	expiresAtKey := ctrl.annotationKey(BootImageSecretRefExpiresAtAnnotationKey)
+	value, ok := machineSet.GetAnnotations()[expiresAtKey]
+	if !ok {
+		return false
+	}
+	expiresAt, err := time.Parse(time.RFC3339, strings.TrimSpace(value))
+	if err != nil {
+		klog.Warningf("Ignoring invalid value %q for annotation %s on machineset %s, expected an RFC 3339 timestamp", value, expiresAtKey, machineSet.Name)
+		return false
+	}
+	if ctrl.clock.Now().Before(expiresAt) {
+		return false
+	}
+	if ctrl.reportedPinExpirations[machineSet.Name] == value {
+		return true
+	}
+	ctrl.reportedPinExpirations[machineSet.Name] = value
+	klog.Infof("The boot image pin of machineset %s expired at %s, resuming normal boot image reconciliation", machineSet.Name, expiresAt.UTC().Format(time.RFC3339))
+	mcop, err := ctrl.mcopLister.Get(ctrlcommon.MCOOperatorKnobsObjectName)
+	if err != nil {
+		klog.Errorf("Failed to get MachineConfiguration to report the expired boot image pin of machineset %s: %v", machineSet.Name, err)
+		return true
+	}
+	ctrl.eventRecorder.Eventf(mcop, corev1.EventTypeNormal, "BootImagePinExpired",
+		"The boot image pin of machineset %s via annotation %s expired at %s, resuming normal boot image reconciliation; remove the annotations to unpin it",
+		machineSet.Name, ctrl.annotationKey(BootImageSecretRefAnnotationKey), expiresAt.UTC().Format(time.RFC3339))
+	return true
+}
+
+// getSecretBootImage returns the boot image held by the named Secret in the machine API namespace,
+// which is referenced by referrer, along with the user data secret paired with it. A paired user data
+// secret must exist and hold user data. The contents of the Secrets are never logged; errors only
+// reference the Secrets by name.
+func (ctrl *Controller) getSecretBootImage(secretName, referrer string) (secretBootImage, error) {
+	secret, err := ctrl.mapiSecretLister.Secrets(MachineAPINamespace).Get(secretName)
+	if err != nil {
+		return secretBootImage{}, fmt.Errorf("failed to fetch boot image secret %s referenced by %s: %w", secretName, referrer, err)
+	}
+	bootImage, ok := secret.Data[BootImageSecretKey]
+	if !ok || len(bootImage) == 0 {
+		return secretBootImage{}, fmt.Errorf("boot image secret %s referenced by %s has no %q key", secretName, referrer, BootImageSecretKey)
+	}
+	rawUserDataSecret, ok := secret.Data[BootImageUserDataSecretKey]
+	if !ok {
+		return secretBootImage{image: string(bootImage)}, nil
+	}
+	userDataSecretName := strings.TrimSpace(string(rawUserDataSecret))
+	if userDataSecretName == "" {
+		return secretBootImage{}, fmt.Errorf("boot image secret %s referenced by %s has an empty %q key", secretName, referrer, BootImageUserDataSecretKey)
+	}
+	userDataSecret, err := ctrl.mapiSecretLister.Secrets(MachineAPINamespace).Get(userDataSecretName)
+	if err != nil {
+		return secretBootImage{}, fmt.Errorf("failed to fetch user data secret %s paired with boot image secret %s: %w", userDataSecretName, secretName, err)
+	}
+	if len(userDataSecret.Data[ctrlcommon.UserDataKey]) == 0 {
+		return secretBootImage{}, fmt.Errorf("user data secret %s paired with boot image secret %s has no %q key", userDataSecretName, secretName, ctrlcommon.UserDataKey)
+	}
+	return secretBootImage{image: string(bootImage), userDataSecret: userDataSecretName}, nil
+}
+
+// checkMachineSetSecretBootImage calls the appropriate image setter based on the infra type, to apply
+// a boot image resolved from a referenced Secret. On platforms that are not natively supported, the
+// boot image is set at imagePath in the providerspec, if configured.
+// Returns (patchRequired, newMachineSet, error).
+func checkMachineSetSecretBootImage(infra *osconfigv1.Infrastructure, machineSet *machinev1beta1.MachineSet, bootImage secretBootImage, imagePath []string, secretClient clientset.Interface) (bool, *machinev1beta1.MachineSet, error) {
+	switch infra.Status.PlatformStatus.Type {
+	case osconfigv1.AWSPlatformType:
+		return reconcileProviderSpecBootImage(machineSet, bootImage, secretClient, setAWSBootImage, setAWSUserDataSecret)
+	case osconfigv1.AzurePlatformType:
+		return reconcileProviderSpecBootImage(machineSet, bootImage, secretClient, setAzureBootImage, setAzureUserDataSecret)
+	case osconfigv1.GCPPlatformType:
+		return reconcileProviderSpecBootImage(machineSet, bootImage, secretClient, setGCPBootImage, setGCPUserDataSecret)
+	case osconfigv1.VSpherePlatformType:
+		return reconcileProviderSpecBootImage(machineSet, bootImage, secretClient, setVSphereBootImage, setVSphereUserDataSecret)
+	default:
+		if imagePath != nil {
+			// Applying the image without its paired user data could leave new machines unable to boot
+			if bootImage.userDataSecret != "" {
+				return false, nil, fmt.Errorf("machineset %s cannot be paired with user data secret %s, as its location in the providerspec of platform %s is not known", machineSet.Name, bootImage.userDataSecret, infra.Status.PlatformStatus.Type)
+			}
+			return reconcileProviderSpecImagePath(machineSet, bootImage.image, imagePath)
+		}
+		klog.Infof("Skipping machineset %s, unsupported platform %s", machineSet.Name, infra.Status.PlatformStatus.Type)
+		return false, nil, nil
+	}
+}
+
+// reconcileProviderSpecImagePath sets the string field at imagePath in the machineset's providerspec to
+// bootImage. The field must already exist and hold a string. As the location of the user data secret
+// is not known for these platforms, the ignition stub is not upgraded.
+func reconcileProviderSpecImagePath(machineSet *machinev1beta1.MachineSet, bootImage string, imagePath []string) (bool, *machinev1beta1.MachineSet, error) {
+	providerSpec := map[string]interface{}{}
+	if err := unmarshalProviderSpec(machineSet, &providerSpec); err != nil {
+		return false, nil, err
+	}
+	currentImage, found, err := unstructured.NestedString(providerSpec, imagePath...)
+	if err != nil {
+		return false, nil, fmt.Errorf("providerspec image path %s of machineset %s is not a string field: %w", strings.Join(imagePath, "."), machineSet.Name, err)
+	}
+	if !found {
+		return false, nil, fmt.Errorf("providerspec image path %s not found in machineset %s", strings.Join(imagePath, "."), machineSet.Name)
+	}
+	if currentImage == bootImage {
+		return false, nil, nil
+	}
+	if err := unstructured.SetNestedField(providerSpec, bootImage, imagePath...); err != nil {
+		return false, nil, err
+	}
+	newMachineSet := machineSet.DeepCopy()
+	if err := marshalProviderSpec(newMachineSet, providerSpec); err != nil {
+		return false, nil, err
+	}
+	return true, newMachineSet, nil
+}
+
+// reconcileProviderSpecBootImage is a generic function that sets the boot image field of the machineset's
+// provider spec to bootImage. The setImage callback returns false if the field was already up to date and no
+// partially applied update had to be completed, and the user data secret name for ignition stub upgrades.
+// If the boot image is paired with a user data secret, the setUserDataSecret callback points the provider
+// spec at it, returning false if it already was.
+func reconcileProviderSpecBootImage[T any](
+	machineSet *machinev1beta1.MachineSet,
+	bootImage secretBootImage,
+	secretClient clientset.Interface,
+	setImage func(*T, string, string) (bool, string),
+	setUserDataSecret func(*T, string) bool,
+) (bool, *machinev1beta1.MachineSet, error) {
+	providerSpec := new(T)
+	if err := unmarshalProviderSpec(machineSet, providerSpec); err != nil {
+		return false, nil, err
+	}
+
+	original := new(T)
+	if err := unmarshalProviderSpec(machineSet, original); err != nil {
+		return false, nil, err
+	}
+	changed, userDataSecretName := setImage(providerSpec, bootImage.image, machineSet.Name)
+	if bootImage.userDataSecret != "" {
+		if setUserDataSecret(providerSpec, bootImage.userDataSecret) {
+			klog.Infof("Updating the user data secret of machineset %s from %q to %s, paired with its boot image", machineSet.Name, userDataSecretName, bootImage.userDataSecret)
+			changed = true
+		}
+		userDataSecretName = bootImage.userDataSecret
+	}
+	if !changed {
+		return false, nil, completePartialStubIgnitionUpgrade(userDataSecretName, secretClient)
+	}
+
+	// Ensure the ignition stub is the minimum acceptable spec required for boot image updates
+	if userDataSecretName != "" {
+		if err := upgradeStubIgnitionIfRequired(userDataSecretName, secretClient); err != nil {
+			return false, nil, err
+		}
+	}
+
+	newMachineSet := machineSet.DeepCopy()
+	mergedProviderSpec, err := mergeProviderSpecChanges(machineSet.Spec.Template.Spec.ProviderSpec.Value.Raw, original, providerSpec)
+	if err != nil {
+		return false, nil, err
+	}
+	newMachineSet.Spec.Template.Spec.ProviderSpec.Value = mergedProviderSpec
+	return true, newMachineSet, nil
+}
+
+func setAWSBootImage(providerSpec *machinev1beta1.AWSMachineProviderConfig, bootImage, machineSetName string) (bool, string) {
+	secretName := getLocalUserDataSecretName(providerSpec.UserDataSecret)
+	if providerSpec.AMI.ID != nil && awsAMIsEqual(*providerSpec.AMI.ID, bootImage) {
+		return completePartialAWSAMIUpdate(providerSpec, machineSetName), secretName
+	}
+	if providerSpec.AMI.ID == nil && providerSpec.AMI.ARN != nil && len(providerSpec.AMI.Filters) == 0 && awsAMIsEqual(*providerSpec.AMI.ARN, bootImage) {
+		return false, secretName
+	}
+	// Only one of ID, ARN or Filters in the AMI may be specified
+	providerSpec.AMI = machinev1beta1.AWSResourceReference{ID: &bootImage}
+	return true, secretName
+}
+
+func setAzureBootImage(providerSpec *machinev1beta1.AzureMachineProviderSpec, bootImage, machineSetName string) (bool, string) {
+	var secretName string
+	if providerSpec.UserDataSecret != nil {
+		secretName = providerSpec.UserDataSecret.Name
+	}
+	if providerSpec.Image.ResourceID == bootImage {
+		return completePartialAzureImageUpdate(providerSpec, machineSetName), secretName
+	}
+	providerSpec.Image = machinev1beta1.Image{ResourceID: bootImage}
+	return true, secretName
+}
+
+func setGCPBootImage(providerSpec *machinev1beta1.GCPMachineProviderSpec, bootImage, _ string) (bool, string) {
+	secretName := getLocalUserDataSecretName(providerSpec.UserDataSecret)
+	changed := false
+	for idx, disk := range providerSpec.Disks {
+		if disk.Boot && !gcpImagesEqual(disk.Image, bootImage) {
+			providerSpec.Disks[idx].Image = bootImage
+			changed = true
+		}
+	}
+	return changed, secretName
+}
+
+func setVSphereBootImage(providerSpec *machinev1beta1.VSphereMachineProviderSpec, bootImage, _ string) (bool, string) {
+	secretName := getLocalUserDataSecretName(providerSpec.UserDataSecret)
+	if providerSpec.Template == bootImage {
+		return false, secretName
+	}
+	providerSpec.Template = bootImage
+	return true, secretName
+}
+
+func setAWSUserDataSecret(providerSpec *machinev1beta1.AWSMachineProviderConfig, secretName string) bool {
+	if getLocalUserDataSecretName(providerSpec.UserDataSecret) == secretName {
+		return false
+	}
+	providerSpec.UserDataSecret = &corev1.LocalObjectReference{Name: secretName}
+	return true
+}
+
+func setAzureUserDataSecret(providerSpec *machinev1beta1.AzureMachineProviderSpec, secretName string) bool {
+	if providerSpec.UserDataSecret == nil {
+		providerSpec.UserDataSecret = &corev1.SecretReference{}
+	} else if providerSpec.UserDataSecret.Name == secretName {
+		return false
+	}
+	// The user data secret is always read from the machine API namespace
+	providerSpec.UserDataSecret.Name = secretName
+	providerSpec.UserDataSecret.Namespace = MachineAPINamespace
+	return true
+}
+
+func setGCPUserDataSecret(providerSpec *machinev1beta1.GCPMachineProviderSpec, secretName string) bool {
+	if getLocalUserDataSecretName(providerSpec.UserDataSecret) == secretName {
+		return false
+	}
+	providerSpec.UserDataSecret = &corev1.LocalObjectReference{Name: secretName}
+	return true
+}
+
+func setVSphereUserDataSecret(providerSpec *machinev1beta1.VSphereMachineProviderSpec, secretName string) bool {
+	if getLocalUserDataSecretName(providerSpec.UserDataSecret) == secretName {
+		return false
+	}
+	providerSpec.UserDataSecret = &corev1.LocalObjectReference{Name: secretName}
+	return true
+}
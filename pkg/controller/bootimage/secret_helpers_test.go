@@ -0,0 +1,247 @@
+package bootimage
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	osconfigv1 "github.com/openshift/api/config/v1"
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSyncMAPIMachineSetSecretBootImage(t *testing.T) {
+	const secretImage = "projects/my-project/global/images/private-rhcos"
+	bootImageSecret := &corev1.Secret{
+		ObjectMeta: v1.ObjectMeta{Name: "boot-image-secret", Namespace: MachineAPINamespace},
+		Data: map[string][]byte{
+			BootImageSecretKey: []byte(secretImage),
+		},
+	}
+	emptySecret := &corev1.Secret{
+		ObjectMeta: v1.ObjectMeta{Name: "empty-secret", Namespace: MachineAPINamespace},
+		Data:       map[string][]byte{},
+	}
+
+	cases := []struct {
+		name          string
+		secretRef     string
+		currentImage  string
+		expectedImage string
+		expectError   bool
+	}{
+		{
+			name:          "referenced secret present, image is applied",
+			secretRef:     "boot-image-secret",
+			currentImage:  testGCPOldImage,
+			expectedImage: secretImage,
+		},
+		{
+			name:          "referenced secret present, already up to date",
+			secretRef:     "boot-image-secret",
+			currentImage:  secretImage,
+			expectedImage: secretImage,
+		},
+		{
+			name:          "referenced secret missing, machineset is errored",
+			secretRef:     "missing-secret",
+			currentImage:  testGCPOldImage,
+			expectedImage: testGCPOldImage,
+			expectError:   true,
+		},
+		{
+			name:          "referenced secret lacks boot image key, machineset is errored",
+			secretRef:     "empty-secret",
+			currentImage:  testGCPOldImage,
+			expectedImage: testGCPOldImage,
+			expectError:   true,
+		},
+		{
+			name:          "no secret reference, stream image is applied",
+			currentImage:  testGCPOldImage,
+			expectedImage: testGCPStreamImage,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			machineSet := getGCPMachineSet("test-machineset", tc.currentImage)
+			if tc.secretRef != "" {
+				machineSet.Annotations[BootImageSecretRefAnnotationKey] = tc.secretRef
+			}
+			ctrl := newTestController(t, osconfigv1.GCPPlatformType, []*machinev1beta1.MachineSet{machineSet}, []*corev1.Secret{bootImageSecret, emptySecret})
+
+			_, _, err := ctrl.syncMAPIMachineSet(machineSet, getGCPBootImagesConfigMap())
+			if tc.expectError {
+				require.Error(t, err)
+				// The secret contents must never surface in errors
+				assert.NotContains(t, err.Error(), secretImage)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tc.expectedImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, machineSet.Name)))
+		})
+	}
+}
+
+func TestBootImageSourcePrecedence(t *testing.T) {
+	const machineSetImage = "projects/my-project/global/images/machineset-rhcos"
+	const clusterImage = "projects/my-project/global/images/cluster-rhcos"
+	secrets := []*corev1.Secret{
+		{
+			ObjectMeta: v1.ObjectMeta{Name: "machineset-image", Namespace: MachineAPINamespace},
+			Data:       map[string][]byte{BootImageSecretKey: []byte(machineSetImage)},
+		},
+		{
+			ObjectMeta: v1.ObjectMeta{Name: "cluster-image", Namespace: MachineAPINamespace},
+			Data:       map[string][]byte{BootImageSecretKey: []byte(clusterImage)},
+		},
+	}
+
+	cases := []struct {
+		name              string
+		machineSetRef     string
+		clusterRef        string
+		expectedSource    BootImageSource
+		expectedImage     string
+		expectedErrorText string
+	}{
+		{
+			name:           "stream only",
+			expectedSource: BootImageSourceStream,
+			expectedImage:  testGCPStreamImage,
+		},
+		{
+			name:           "cluster image over stream",
+			clusterRef:     "cluster-image",
+			expectedSource: BootImageSourceClusterImage,
+			expectedImage:  clusterImage,
+		},
+		{
+			name:           "machineset override over stream",
+			machineSetRef:  "machineset-image",
+			expectedSource: BootImageSourceMachineSetOverride,
+			expectedImage:  machineSetImage,
+		},
+		{
+			name:           "machineset override over cluster image",
+			machineSetRef:  "machineset-image",
+			clusterRef:     "cluster-image",
+			expectedSource: BootImageSourceMachineSetOverride,
+			expectedImage:  machineSetImage,
+		},
+		{
+			name:              "broken machineset override does not fall back to the cluster image",
+			machineSetRef:     "missing-secret",
+			clusterRef:        "cluster-image",
+			expectedSource:    BootImageSourceMachineSetOverride,
+			expectedImage:     testGCPOldImage,
+			expectedErrorText: "referenced by machineset machineset-a",
+		},
+		{
+			name:              "broken cluster image does not fall back to the stream",
+			clusterRef:        "missing-secret",
+			expectedSource:    BootImageSourceClusterImage,
+			expectedImage:     testGCPOldImage,
+			expectedErrorText: "referenced by annotation " + ClusterBootImageSecretRefAnnotationKey,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			machineSet := getGCPMachineSet("machineset-a", testGCPOldImage)
+			if tc.machineSetRef != "" {
+				machineSet.Annotations[BootImageSecretRefAnnotationKey] = tc.machineSetRef
+			}
+			ctrl := newTestController(t, osconfigv1.GCPPlatformType, []*machinev1beta1.MachineSet{machineSet}, secrets)
+			if tc.clusterRef != "" {
+				ctrl.setKnobs(t, map[string]string{ClusterBootImageSecretRefAnnotationKey: tc.clusterRef})
+			}
+
+			require.NoError(t, ctrl.syncAll("test"))
+
+			// The state exporter is not running, so the state of the pass is left in the queue
+			state := <-ctrl.stateExportQueue
+			require.Len(t, state.MachineSets, 1)
+			assert.Equal(t, tc.expectedSource, state.MachineSets[0].ImageSource)
+			if tc.expectedErrorText == "" {
+				assert.Empty(t, state.MachineSets[0].Error)
+			} else {
+				assert.Contains(t, state.MachineSets[0].Error, tc.expectedErrorText)
+			}
+			assert.Equal(t, tc.expectedImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, "machineset-a")))
+		})
+	}
+}
+
+func TestBootImagePinExpiration(t *testing.T) {
+	const secretImage = "projects/my-project/global/images/pinned-rhcos"
+	bootImageSecret := &corev1.Secret{
+		ObjectMeta: v1.ObjectMeta{Name: "boot-image-secret", Namespace: MachineAPINamespace},
+		Data:       map[string][]byte{BootImageSecretKey: []byte(secretImage)},
+	}
+	getPinExpiredEvents := func(ctrl *testController) []string {
+		events := []string{}
+		for len(ctrl.eventRecorder.Events) > 0 {
+			if event := <-ctrl.eventRecorder.Events; strings.Contains(event, "BootImagePinExpired") {
+				events = append(events, event)
+			}
+		}
+		return events
+	}
+
+	cases := []struct {
+		name                 string
+		expiresAt            string
+		expectedImage        string
+		expectedSource       BootImageSource
+		expectedExpiredEvent bool
+	}{
+		{
+			name:           "active pin is applied",
+			expiresAt:      time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+			expectedImage:  secretImage,
+			expectedSource: BootImageSourceMachineSetOverride,
+		},
+		{
+			name:                 "expired pin resumes stream-based reconciliation",
+			expiresAt:            time.Now().Add(-time.Hour).UTC().Format(time.RFC3339),
+			expectedImage:        testGCPStreamImage,
+			expectedSource:       BootImageSourceStream,
+			expectedExpiredEvent: true,
+		},
+		{
+			name:           "malformed expiry is ignored and the pin stays in effect",
+			expiresAt:      "next tuesday",
+			expectedImage:  secretImage,
+			expectedSource: BootImageSourceMachineSetOverride,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			machineSet := getGCPMachineSet("machineset-a", testGCPOldImage)
+			machineSet.Annotations[BootImageSecretRefAnnotationKey] = "boot-image-secret"
+			machineSet.Annotations[BootImageSecretRefExpiresAtAnnotationKey] = tc.expiresAt
+			ctrl := newTestController(t, osconfigv1.GCPPlatformType, []*machinev1beta1.MachineSet{machineSet}, []*corev1.Secret{bootImageSecret})
+
+			require.NoError(t, ctrl.syncAll("test"))
+			assert.Equal(t, tc.expectedImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, "machineset-a")))
+			assert.Equal(t, tc.expectedSource, ctrl.mapiImageSources["machineset-a"])
+			events := getPinExpiredEvents(ctrl)
+			if !tc.expectedExpiredEvent {
+				assert.Empty(t, events)
+				return
+			}
+			require.Len(t, events, 1)
+			assert.Contains(t, events[0], "machineset-a")
+
+			// The expiry is only reported once
+			require.NoError(t, ctrl.syncAll("test"))
+			assert.Empty(t, getPinExpiredEvents(ctrl))
+		})
+	}
+}
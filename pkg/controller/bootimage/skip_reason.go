@@ -0,0 +1,140 @@
+package bootimage
+
+import (
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// Annotation on a machineset recording why its boot image was not updated during the last sync.
+	// The annotation is removed once the machineset is reconciled.
+	BootImageSkipReasonAnnotationKey = "machineconfiguration.openshift.io/boot-image-skip-reason"
+
+	// Annotation on a MAPI machineset holding its MachineSetBootImageStatus as of the last sync. It is
+	// only written while enabled with MachineSetStatusAnnotationKey.
+	BootImageStatusAnnotationKey = "machineconfiguration.openshift.io/boot-image-status"
+)
+
+// MachineSetSkipReason enumerates the reasons a machineset's boot image was not updated.
+type MachineSetSkipReason string
+
+const (
+	// The machineset has an owner reference and may be managed by another workflow
+	SkipReasonOwnerReference MachineSetSkipReason = "OwnerReference"
+	// The machineset opted out of boot image updates with BootImageOptOutAnnotationKey. This is never
+	// recorded on the machineset, as it asked to be left alone.
+	SkipReasonOptedOut MachineSetSkipReason = "OptedOut"
+	// The machineset is labeled with an OS stream that is not supported by the controller
+	SkipReasonUnsupportedOSStream MachineSetSkipReason = "UnsupportedOSStream"
+	// The machineset provisions Windows nodes
+	SkipReasonWindows MachineSetSkipReason = "Windows"
+	// The machineset has no architecture annotation in a multi-arch cluster
+	SkipReasonMissingArchitecture MachineSetSkipReason = "MissingArchitecture"
+	// The cluster platform is not supported for boot image updates
+	SkipReasonUnsupportedPlatform MachineSetSkipReason = "UnsupportedPlatform"
+	// The machineset targets a zone outside of the MachineConfiguration zone restriction
+	SkipReasonZoneDeferred MachineSetSkipReason = "ZoneDeferred"
+	// Reconciliation is paused for the machineset's platform by the MachineConfiguration
+	SkipReasonPlatformPaused MachineSetSkipReason = "PlatformPaused"
+	// The per-pass reconcile budget was used up before the machineset was reached
+	SkipReasonBudgetDeferred MachineSetSkipReason = "BudgetDeferred"
+	// Too many MAPI machines were being replaced for the machineset to be updated
+	SkipReasonReplacementsInFlight MachineSetSkipReason = "ReplacementsInFlight"
+	// Too few of the cluster's nodes were Ready for the machineset to be updated
+	SkipReasonLowNodeReadiness MachineSetSkipReason = "LowNodeReadiness"
+	// The machineset's current boot image is a custom or unknown image
+	SkipReasonUnrecognizedBootImage MachineSetSkipReason = "UnrecognizedBootImage"
+	// Advisory-only mode cannot evaluate machinesets on the cluster platform, so their drift is unknown
+	SkipReasonAdvisoryUnsupportedPlatform MachineSetSkipReason = "AdvisoryUnsupportedPlatform"
+	// The machineset was modified while its boot image update was applied; a later pass retries it
+	SkipReasonConflictDeferred MachineSetSkipReason = "ConflictDeferred"
+	// The machineset's current boot image is a newer build than the boot images configmap advertises
+	SkipReasonAheadOfStream MachineSetSkipReason = "AheadOfStream"
+	// The pre-update webhook rejected the machineset's boot image update
+	SkipReasonPreUpdateWebhookRejected MachineSetSkipReason = "PreUpdateWebhookRejected"
+	// Another machineset was updated less than the soak interval ago
+	SkipReasonSoakDeferred MachineSetSkipReason = "SoakDeferred"
+	// The reconcile rate limit of the machineset's platform was reached
+	SkipReasonThrottled MachineSetSkipReason = "Throttled"
+	// The machineset is claimed by the MCO instance of another cluster. This is never recorded on the
+	// machineset, as it is managed by that instance.
+	SkipReasonOwnedByOtherInstance MachineSetSkipReason = "OwnedByOtherInstance"
+)
+
+// isSkipReasonRecorded returns true if the skip reason is recorded on the machineset. Machinesets
+// that may be managed by another workflow or MCO instance, or that opted out, are never written to when
+// they are skipped, and neither are machinesets whose cached copy is known to be out of date.
+func isSkipReasonRecorded(reason MachineSetSkipReason) bool {
+	switch reason {
+	case SkipReasonOwnerReference, SkipReasonOptedOut, SkipReasonOwnedByOtherInstance, SkipReasonConflictDeferred:
+		return false
+	}
+	return true
+}
+
+// MachineSetBootImageStatus summarizes the boot image state of a MAPI machineset for tooling that lists
+// machinesets, such as `oc get machineset` with custom columns.
+type MachineSetBootImageStatus string
+
+const (
+	// The machineset's boot image matches the boot images configmap
+	MachineSetBootImageStatusUpToDate MachineSetBootImageStatus = "UpToDate"
+	// The machineset's boot image update is pending, and will be applied by the controller without intervention
+	MachineSetBootImageStatusManaged MachineSetBootImageStatus = "Managed"
+	// The controller will not update the machineset's boot image until the machineset or the controller's
+	// configuration is changed; the skip reason annotation records why
+	MachineSetBootImageStatusFrozen MachineSetBootImageStatus = "Frozen"
+	// The last sync of the machineset failed
+	MachineSetBootImageStatusErrored MachineSetBootImageStatus = "Errored"
+)
+
+// getMachineSetBootImageStatus returns the boot image status of a machineset that was synced without
+// error and was not updated for the given reason, if any.
+func getMachineSetBootImageStatus(reason MachineSetSkipReason) MachineSetBootImageStatus {
+	switch reason {
+	case "":
+		return MachineSetBootImageStatusUpToDate
+	case SkipReasonZoneDeferred, SkipReasonBudgetDeferred, SkipReasonReplacementsInFlight, SkipReasonLowNodeReadiness, SkipReasonSoakDeferred, SkipReasonPreUpdateWebhookRejected, SkipReasonConflictDeferred, SkipReasonThrottled:
+		return MachineSetBootImageStatusManaged
+	default:
+		return MachineSetBootImageStatusFrozen
+	}
+}
+
+// setMAPIMachineSetSyncAnnotations records the skip reason and, if enabled, the boot image status on the
+// machineset. Empty values remove the respective annotation. The machineset is only patched if either
+// recorded value changes.
+func (ctrl *Controller) setMAPIMachineSetSyncAnnotations(machineSet *machinev1beta1.MachineSet, reason MachineSetSkipReason, status MachineSetBootImageStatus) error {
+	newMachineSet := machineSet.DeepCopy()
+	if !ctrl.applyMAPIMachineSetSyncAnnotations(newMachineSet, reason, status) {
+		return nil
+	}
+	klog.Infof("Recording boot image skip reason %q and status %q on machineset %s", reason, status, machineSet.Name)
+	return ctrl.patchMachineSet(machineSet, newMachineSet)
+}
+
+// applyMAPIMachineSetSyncAnnotations sets the skip reason and boot image status annotations on the
+// machineset, without patching it. Returns true if either annotation was changed.
+func (ctrl *Controller) applyMAPIMachineSetSyncAnnotations(machineSet *machinev1beta1.MachineSet, reason MachineSetSkipReason, status MachineSetBootImageStatus) bool {
+	reasonChanged := setOrRemoveAnnotation(machineSet, ctrl.annotationKey(BootImageSkipReasonAnnotationKey), string(reason))
+	statusChanged := setOrRemoveAnnotation(machineSet, ctrl.annotationKey(BootImageStatusAnnotationKey), string(status))
+	return reasonChanged || statusChanged
+}
+
+// setOrRemoveAnnotation sets the annotation on the machineset, removing it if the value is empty.
+// Returns true if the annotations were changed.
+func setOrRemoveAnnotation(machineSet *machinev1beta1.MachineSet, key, value string) bool {
+	current, ok := machineSet.Annotations[key]
+	if (!ok && value == "") || (ok && current == value) {
+		return false
+	}
+	if value == "" {
+		delete(machineSet.Annotations, key)
+		return true
+	}
+	if machineSet.Annotations == nil {
+		machineSet.Annotations = map[string]string{}
+	}
+	machineSet.Annotations[key] = value
+	return true
+}
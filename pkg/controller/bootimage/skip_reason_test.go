@@ -0,0 +1,380 @@
+package bootimage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	osconfigv1 "github.com/openshift/api/config/v1"
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	opv1 "github.com/openshift/api/operator/v1"
+	configlistersv1 "github.com/openshift/client-go/config/listers/config/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestSkipReasonAnnotation(t *testing.T) {
+	cases := []struct {
+		name           string
+		platform       osconfigv1.PlatformType
+		machineSet     func() *machinev1beta1.MachineSet
+		multiArch      bool
+		knobs          map[string]string
+		expectedReason MachineSetSkipReason
+		// The machineset must not be written to at all
+		expectUntouched bool
+	}{
+		{
+			name:     "owner reference is not recorded on the machineset",
+			platform: osconfigv1.GCPPlatformType,
+			machineSet: func() *machinev1beta1.MachineSet {
+				ms := getGCPMachineSet("test-machineset", testGCPOldImage)
+				ms.OwnerReferences = []v1.OwnerReference{{Kind: "MachineDeployment", Name: "owner"}}
+				return ms
+			},
+			expectUntouched: true,
+		},
+		{
+			name:     "unsupported OS stream",
+			platform: osconfigv1.GCPPlatformType,
+			machineSet: func() *machinev1beta1.MachineSet {
+				ms := getGCPMachineSet("test-machineset", testGCPOldImage)
+				ms.Labels = map[string]string{OSStreamLabelKey: "unsupported-stream"}
+				return ms
+			},
+			expectedReason: SkipReasonUnsupportedOSStream,
+		},
+		{
+			name:     "windows machineset",
+			platform: osconfigv1.GCPPlatformType,
+			machineSet: func() *machinev1beta1.MachineSet {
+				ms := getGCPMachineSet("test-machineset", testGCPOldImage)
+				ms.Spec.Template.Labels = map[string]string{OSLabelKey: "Windows"}
+				return ms
+			},
+			expectedReason: SkipReasonWindows,
+		},
+		{
+			name:     "missing architecture in a multi-arch cluster",
+			platform: osconfigv1.GCPPlatformType,
+			machineSet: func() *machinev1beta1.MachineSet {
+				ms := getGCPMachineSet("test-machineset", testGCPOldImage)
+				delete(ms.Annotations, MachineSetArchAnnotationKey)
+				return ms
+			},
+			multiArch:      true,
+			expectedReason: SkipReasonMissingArchitecture,
+		},
+		{
+			name:     "unsupported platform",
+			platform: osconfigv1.BareMetalPlatformType,
+			machineSet: func() *machinev1beta1.MachineSet {
+				return getGCPMachineSet("test-machineset", testGCPOldImage)
+			},
+			expectedReason: SkipReasonUnsupportedPlatform,
+		},
+		{
+			name:     "zone outside of restriction",
+			platform: osconfigv1.GCPPlatformType,
+			machineSet: func() *machinev1beta1.MachineSet {
+				return setGCPMachineSetZone(t, getGCPMachineSet("test-machineset", testGCPOldImage), "us-central1-a")
+			},
+			knobs:          map[string]string{ZonesAnnotationKey: "us-central1-b"},
+			expectedReason: SkipReasonZoneDeferred,
+		},
+		{
+			name:     "custom boot image",
+			platform: osconfigv1.GCPPlatformType,
+			machineSet: func() *machinev1beta1.MachineSet {
+				return getGCPMachineSet("test-machineset", "projects/custom/global/images/custom-image")
+			},
+			expectedReason: SkipReasonUnrecognizedBootImage,
+		},
+		{
+			name:     "updated machineset has no skip reason",
+			platform: osconfigv1.GCPPlatformType,
+			machineSet: func() *machinev1beta1.MachineSet {
+				return getGCPMachineSet("test-machineset", testGCPOldImage)
+			},
+		},
+		{
+			name:     "stale skip reason is cleared once the machineset is up to date",
+			platform: osconfigv1.GCPPlatformType,
+			machineSet: func() *machinev1beta1.MachineSet {
+				return withAnnotation(getGCPMachineSet("test-machineset", testGCPStreamImage), BootImageSkipReasonAnnotationKey, string(SkipReasonOwnerReference))
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := newTestController(t, tc.platform, []*machinev1beta1.MachineSet{tc.machineSet()}, nil)
+			ctrl.setKnobs(t, tc.knobs)
+			if tc.multiArch {
+				cvIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+				require.NoError(t, cvIndexer.Add(&osconfigv1.ClusterVersion{
+					ObjectMeta: v1.ObjectMeta{Name: "version"},
+					Status: osconfigv1.ClusterVersionStatus{
+						Desired: osconfigv1.Release{Architecture: osconfigv1.ClusterVersionArchitectureMulti},
+						History: []osconfigv1.UpdateHistory{{State: osconfigv1.CompletedUpdate, Version: "4.20.0"}},
+					},
+				}))
+				ctrl.clusterVersionLister = configlistersv1.NewClusterVersionLister(cvIndexer)
+			}
+
+			require.NoError(t, ctrl.syncAll("test"))
+
+			reason, ok := ctrl.getMachineSet(t, "test-machineset").Annotations[BootImageSkipReasonAnnotationKey]
+			if tc.expectedReason == "" {
+				assert.False(t, ok, "unexpected skip reason %q", reason)
+			} else {
+				assert.Equal(t, string(tc.expectedReason), reason)
+			}
+			if tc.expectUntouched {
+				for _, action := range ctrl.machineClient.Actions() {
+					assert.NotEqual(t, "patch", action.GetVerb(), "unexpected write to machineset")
+				}
+			}
+		})
+	}
+}
+
+func TestSkipReasonAnnotationPatchFailure(t *testing.T) {
+	ms := getGCPMachineSet("test-machineset", testGCPOldImage)
+	ms.Spec.Template.Labels = map[string]string{OSLabelKey: "Windows"}
+	ctrl := newTestController(t, osconfigv1.GCPPlatformType, []*machinev1beta1.MachineSet{ms}, nil)
+	ctrl.machineClient.PrependReactor("patch", "machinesets", func(clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("patch failed")
+	})
+
+	// Failing to record the skip reason leaves the machineset skipped, not errored
+	require.NoError(t, ctrl.syncAll("test"))
+	assert.Equal(t, 0, ctrl.mapiStats.erroredCount)
+	assert.Equal(t, v1.ConditionFalse, ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateDegraded).Status)
+}
+
+func TestMachineSetBootImageStatus(t *testing.T) {
+	failing := getGCPMachineSet("machineset-failing", testGCPOldImage)
+	failing.Annotations[BootImageSecretRefAnnotationKey] = "missing-secret"
+	owned := getGCPMachineSet("machineset-owned", testGCPOldImage)
+	owned.OwnerReferences = []v1.OwnerReference{{Kind: "MachineDeployment", Name: "owner"}}
+	machineSets := []*machinev1beta1.MachineSet{
+		getGCPMachineSet("machineset-outdated-a", testGCPOldImage),
+		getGCPMachineSet("machineset-outdated-b", testGCPOldImage),
+		getGCPMachineSet("machineset-current", testGCPStreamImage),
+		getGCPMachineSet("machineset-custom", "projects/custom/global/images/custom-image"),
+		failing,
+		owned,
+	}
+	ctrl := newTestController(t, osconfigv1.GCPPlatformType, machineSets, nil)
+	ctrl.setKnobs(t, map[string]string{
+		MachineSetStatusAnnotationKey: "true",
+		SoakIntervalAnnotationKey:     "1h",
+	})
+
+	require.NoError(t, ctrl.syncAll("test"))
+
+	getStatus := func(name string) string {
+		return ctrl.getMachineSet(t, name).Annotations[BootImageStatusAnnotationKey]
+	}
+	// The soak interval lets one outdated machineset be updated, the other is left pending
+	statuses := []string{getStatus("machineset-outdated-a"), getStatus("machineset-outdated-b")}
+	assert.ElementsMatch(t, []string{string(MachineSetBootImageStatusUpToDate), string(MachineSetBootImageStatusManaged)}, statuses)
+	assert.Equal(t, string(MachineSetBootImageStatusUpToDate), getStatus("machineset-current"))
+	assert.Equal(t, string(MachineSetBootImageStatusFrozen), getStatus("machineset-custom"))
+	assert.Equal(t, string(SkipReasonUnrecognizedBootImage), ctrl.getMachineSet(t, "machineset-custom").Annotations[BootImageSkipReasonAnnotationKey])
+	assert.Equal(t, string(MachineSetBootImageStatusErrored), getStatus("machineset-failing"))
+	// A machineset that may be managed by another workflow is not written to
+	assert.NotContains(t, ctrl.getMachineSet(t, "machineset-owned").Annotations, BootImageStatusAnnotationKey)
+	assert.NotContains(t, ctrl.getMachineSet(t, "machineset-owned").Annotations, BootImageSkipReasonAnnotationKey)
+
+	// The updated machineset has its boot image and status written in a single patch
+	for _, name := range []string{"machineset-outdated-a", "machineset-outdated-b"} {
+		if getStatus(name) != string(MachineSetBootImageStatusUpToDate) {
+			continue
+		}
+		patches := 0
+		for _, action := range ctrl.machineClient.Actions() {
+			if patch, ok := action.(clienttesting.PatchAction); ok && patch.GetName() == name {
+				patches++
+			}
+		}
+		assert.Equal(t, 1, patches, "machineset %s", name)
+	}
+
+	// Disabling the knob removes the status annotations on the next sync
+	for _, name := range []string{"machineset-outdated-a", "machineset-outdated-b", "machineset-current", "machineset-custom", "machineset-failing"} {
+		require.NoError(t, ctrl.msIndexer.Update(ctrl.getMachineSet(t, name)))
+	}
+	ctrl.setKnobs(t, nil)
+	require.NoError(t, ctrl.syncAll("test"))
+	for _, name := range []string{"machineset-current", "machineset-custom", "machineset-failing"} {
+		assert.NotContains(t, ctrl.getMachineSet(t, name).Annotations, BootImageStatusAnnotationKey, "machineset %s", name)
+	}
+}
+
+func TestMachineSetOwnershipLabel(t *testing.T) {
+	claimed := getGCPMachineSet("machineset-claimed", testGCPOldImage)
+	claimed.Labels = map[string]string{BootImageOwnerLabelKey: "test-cluster-abcde"}
+	otherOwned := getGCPMachineSet("machineset-other-owned", testGCPOldImage)
+	otherOwned.Labels = map[string]string{BootImageOwnerLabelKey: "other-cluster-fghij"}
+	machineSets := []*machinev1beta1.MachineSet{claimed, otherOwned, getGCPMachineSet("machineset-unclaimed", testGCPOldImage)}
+	ctrl := newTestController(t, osconfigv1.GCPPlatformType, machineSets, nil)
+	ctrl.setKnobs(t, map[string]string{MachineSetStatusAnnotationKey: "true"})
+
+	require.NoError(t, ctrl.syncAll("test"))
+
+	cases := []struct {
+		machineSet    string
+		expectedImage string
+	}{
+		{
+			machineSet:    "machineset-claimed",
+			expectedImage: testGCPStreamImage,
+		},
+		{
+			machineSet:    "machineset-unclaimed",
+			expectedImage: testGCPStreamImage,
+		},
+		{
+			machineSet:    "machineset-other-owned",
+			expectedImage: testGCPOldImage,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.machineSet, func(t *testing.T) {
+			assert.Equal(t, tc.expectedImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, tc.machineSet)))
+		})
+	}
+
+	// A machineset owned by another instance is never written to, not even to record a skip reason
+	for _, action := range ctrl.machineClient.Actions() {
+		if patch, ok := action.(clienttesting.PatchAction); ok {
+			assert.NotEqual(t, "machineset-other-owned", patch.GetName())
+		}
+	}
+	assert.Equal(t, 0, ctrl.mapiStats.skippedCount)
+	assert.Equal(t, 0, ctrl.mapiStats.erroredCount)
+}
+
+func TestMachineSetAheadOfStream(t *testing.T) {
+	const (
+		streamImage = "projects/rhcos-cloud/global/images/rhcos-9-6-20250402-0-gcp-x86-64"
+		aheadImage  = "projects/rhcos-cloud/global/images/rhcos-9-6-20250601-0-gcp-x86-64"
+		behindImage = "projects/rhcos-cloud/global/images/rhcos-418-94-202410090804-0-gcp-x86-64"
+	)
+	getAheadOfStreamEvents := func(ctrl *testController) []string {
+		events := []string{}
+		for len(ctrl.eventRecorder.Events) > 0 {
+			if event := <-ctrl.eventRecorder.Events; strings.Contains(event, "BootImageAheadOfStream") {
+				events = append(events, event)
+			}
+		}
+		return events
+	}
+
+	cases := []struct {
+		name          string
+		streamData    string
+		currentImage  string
+		expectedImage string
+		expectedAhead bool
+	}{
+		{
+			name:          "machineset ahead of the stream image is skipped",
+			streamData:    `{"stream":"rhcos-9.6","architectures":{"x86_64":{"images":{"gcp":{"project":"rhcos-cloud","name":"rhcos-9-6-20250402-0-gcp-x86-64"}}}}}`,
+			currentImage:  aheadImage,
+			expectedImage: aheadImage,
+			expectedAhead: true,
+		},
+		{
+			name:          "machineset ahead of the stream release is skipped",
+			streamData:    `{"stream":"rhcos-9.6","architectures":{"x86_64":{"artifacts":{"gcp":{"release":"9.6.20250402-0"}},"images":{"gcp":{"project":"rhcos-cloud","name":"rhcos-9-6-new"}}}}}`,
+			currentImage:  aheadImage,
+			expectedImage: aheadImage,
+			expectedAhead: true,
+		},
+		{
+			name:          "machineset behind the stream is updated",
+			streamData:    `{"stream":"rhcos-9.6","architectures":{"x86_64":{"images":{"gcp":{"project":"rhcos-cloud","name":"rhcos-9-6-20250402-0-gcp-x86-64"}}}}}`,
+			currentImage:  behindImage,
+			expectedImage: streamImage,
+		},
+		{
+			name:          "machineset with an unversioned image is updated",
+			streamData:    `{"stream":"rhcos-9.6","architectures":{"x86_64":{"images":{"gcp":{"project":"rhcos-cloud","name":"rhcos-9-6-20250402-0-gcp-x86-64"}}}}}`,
+			currentImage:  testGCPOldImage,
+			expectedImage: streamImage,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := newTestController(t, osconfigv1.GCPPlatformType, []*machinev1beta1.MachineSet{getGCPMachineSet("machineset-a", tc.currentImage)}, nil)
+			configMap := getGCPBootImagesConfigMap()
+			configMap.Data[StreamConfigMapKey] = tc.streamData
+			require.NoError(t, ctrl.cmIndexer.Update(configMap))
+
+			require.NoError(t, ctrl.syncAll("test"))
+			machineSet := ctrl.getMachineSet(t, "machineset-a")
+			assert.Equal(t, tc.expectedImage, getGCPMachineSetBootImage(t, machineSet))
+			events := getAheadOfStreamEvents(ctrl)
+			if !tc.expectedAhead {
+				assert.Empty(t, events)
+				assert.NotContains(t, machineSet.Annotations, BootImageSkipReasonAnnotationKey)
+				return
+			}
+			assert.Equal(t, string(SkipReasonAheadOfStream), machineSet.Annotations[BootImageSkipReasonAnnotationKey])
+			assert.Equal(t, 1, ctrl.mapiStats.skippedCount)
+			require.Len(t, events, 1)
+			assert.Contains(t, events[0], "machineset-a")
+
+			// The machineset is only reported once while it stays ahead
+			require.NoError(t, ctrl.syncAll("test"))
+			assert.Empty(t, getAheadOfStreamEvents(ctrl))
+			assert.Equal(t, aheadImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, "machineset-a")))
+		})
+	}
+}
+
+func TestMachineSetOptOut(t *testing.T) {
+	machineSets := []*machinev1beta1.MachineSet{
+		withAnnotation(getGCPMachineSet("machineset-pinned", testGCPOldImage), BootImageOptOutAnnotationKey, "true"),
+		getGCPMachineSet("machineset-a", testGCPOldImage),
+	}
+	ctrl := newTestController(t, osconfigv1.GCPPlatformType, machineSets, nil)
+
+	// The opted out machineset is counted as skipped, is never written to, and the pass still finishes
+	require.NoError(t, ctrl.syncAll("test"))
+	pinned := ctrl.getMachineSet(t, "machineset-pinned")
+	assert.Equal(t, testGCPOldImage, getGCPMachineSetBootImage(t, pinned))
+	assert.NotContains(t, pinned.Annotations, BootImageSkipReasonAnnotationKey)
+	assert.NotContains(t, pinned.Annotations, BootImageStatusAnnotationKey)
+	for _, action := range ctrl.machineClient.Actions() {
+		if patch, ok := action.(clienttesting.PatchAction); ok {
+			assert.NotEqual(t, "machineset-pinned", patch.GetName(), "unexpected write to opted out machineset")
+		}
+	}
+	assert.Equal(t, testGCPStreamImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, "machineset-a")))
+	assert.Equal(t, 2, ctrl.mapiStats.totalCount)
+	assert.Equal(t, 1, ctrl.mapiStats.skippedCount)
+	progressing := ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateProgressing)
+	assert.Equal(t, v1.ConditionFalse, progressing.Status)
+	assert.Contains(t, progressing.Message, "Reconciled 1 of 2 MAPI MachineSets (1 skipped)")
+
+	// Removing the annotation returns the machineset to normal reconciliation
+	delete(pinned.Annotations, BootImageOptOutAnnotationKey)
+	_, err := ctrl.machineClient.MachineV1beta1().MachineSets(MachineAPINamespace).Update(context.TODO(), pinned, v1.UpdateOptions{})
+	require.NoError(t, err)
+	require.NoError(t, ctrl.msIndexer.Update(pinned))
+	require.NoError(t, ctrl.syncAll("test"))
+	pinned = ctrl.getMachineSet(t, "machineset-pinned")
+	assert.Equal(t, testGCPStreamImage, getGCPMachineSetBootImage(t, pinned))
+	assert.NotContains(t, pinned.Annotations, BootImageSkipReasonAnnotationKey)
+	assert.Equal(t, 0, ctrl.mapiStats.skippedCount)
+}
@@ -0,0 +1,95 @@
+package bootimage
+
+import (
+	"time"
+
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/klog/v2"
+)
+
+// Number of reconcile states that may be waiting for the state exporter before new states are dropped
+const stateExportQueueLength = 16
+
+// MachineSetReconcileOutcome is the outcome of the sync of a single MAPI machineset.
+type MachineSetReconcileOutcome struct {
+	MachineSet string
+	SkipReason MachineSetSkipReason
+	Status     MachineSetBootImageStatus
+	// ImageSource is the source the boot image was resolved from; empty if the machineset was skipped
+	// before its boot image was resolved
+	ImageSource BootImageSource
+	// Error holds the sync error of the machineset, if any
+	Error string
+}
+
+// ReconcileState is the state of the boot image controller after a MAPI machineset sync pass.
+// Machinesets deferred by a targeted rollout are not evaluated, and have no outcome.
+type ReconcileState struct {
+	Reason      string
+	SyncedAt    time.Time
+	MachineSets []MachineSetReconcileOutcome
+}
+
+// StateExporter ships reconcile state to an external datastore, such as a fleet management system.
+// Export is called from a dedicated goroutine, in the order the states were produced; export is best
+// effort, so states are dropped rather than delaying syncs when the exporter falls behind.
+type StateExporter interface {
+	Export(state ReconcileState) error
+}
+
+// noopStateExporter is the default StateExporter, which discards all states.
+type noopStateExporter struct{}
+
+func (noopStateExporter) Export(ReconcileState) error {
+	return nil
+}
+
+// WithStateExporter returns an Option that replaces the default no-op state exporter.
+func WithStateExporter(exporter StateExporter) Option {
+	return func(ctrl *Controller) {
+		ctrl.stateExporter = exporter
+	}
+}
+
+// recordMachineSetOutcome adds the outcome of the sync of machineSetName to the state of the current pass,
+// and returns the resulting boot image status of the machineset.
+func (ctrl *Controller) recordMachineSetOutcome(machineSetName string, skipReason MachineSetSkipReason, err error) MachineSetBootImageStatus {
+	outcome := MachineSetReconcileOutcome{
+		MachineSet:  machineSetName,
+		SkipReason:  skipReason,
+		Status:      getMachineSetBootImageStatus(skipReason),
+		ImageSource: ctrl.mapiImageSources[machineSetName],
+	}
+	if err != nil {
+		outcome.Status = MachineSetBootImageStatusErrored
+		outcome.Error = err.Error()
+	}
+	ctrl.mapiOutcomes = append(ctrl.mapiOutcomes, outcome)
+	return outcome.Status
+}
+
+// exportReconcileState queues the state of the completed pass for the state exporter without blocking.
+func (ctrl *Controller) exportReconcileState(reason string) {
+	state := ReconcileState{Reason: reason, SyncedAt: ctrl.clock.Now().UTC(), MachineSets: ctrl.mapiOutcomes}
+	ctrl.mapiOutcomes = nil
+	select {
+	case ctrl.stateExportQueue <- state:
+	default:
+		klog.Warningf("Boot image reconcile state exporter is falling behind, dropping the state of sync %q", reason)
+	}
+}
+
+// runStateExporter passes queued reconcile states to the state exporter until stopCh is closed.
+func (ctrl *Controller) runStateExporter(stopCh <-chan struct{}) {
+	defer utilruntime.HandleCrash()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case state := <-ctrl.stateExportQueue:
+			if err := ctrl.stateExporter.Export(state); err != nil {
+				klog.Warningf("Failed to export boot image reconcile state of sync %q: %v", state.Reason, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package bootimage
+
+import (
+	"testing"
+	"time"
+
+	osconfigv1 "github.com/openshift/api/config/v1"
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// capturingStateExporter forwards exported reconcile states to a channel
+type capturingStateExporter struct {
+	states chan ReconcileState
+}
+
+func (e *capturingStateExporter) Export(state ReconcileState) error {
+	e.states <- state
+	return nil
+}
+
+func TestReconcileStateExport(t *testing.T) {
+	failing := getGCPMachineSet("machineset-failing", testGCPOldImage)
+	failing.Annotations[BootImageSecretRefAnnotationKey] = "missing-secret"
+	machineSets := []*machinev1beta1.MachineSet{
+		getGCPMachineSet("machineset-outdated", testGCPOldImage),
+		getGCPMachineSet("machineset-custom", "projects/custom/global/images/custom-image"),
+		failing,
+	}
+	ctrl := newTestController(t, osconfigv1.GCPPlatformType, machineSets, nil)
+
+	// Syncs do not wait for states to be exported; states are dropped once the queue is full
+	idleCtrl := newTestController(t, osconfigv1.GCPPlatformType, nil, nil)
+	for i := 0; i < stateExportQueueLength+1; i++ {
+		require.NoError(t, idleCtrl.syncAll("test"))
+	}
+	assert.Len(t, idleCtrl.stateExportQueue, stateExportQueueLength)
+
+	exporter := &capturingStateExporter{states: make(chan ReconcileState)}
+	WithStateExporter(exporter)(ctrl.Controller)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go ctrl.runStateExporter(stopCh)
+
+	require.NoError(t, ctrl.syncAll("exported"))
+	var state ReconcileState
+	select {
+	case state = <-exporter.states:
+	case <-time.After(wait.ForeverTestTimeout):
+		t.Fatal("timed out waiting for the reconcile state to be exported")
+	}
+
+	assert.Equal(t, "exported", state.Reason)
+	assert.False(t, state.SyncedAt.IsZero())
+	outcomes := map[string]MachineSetReconcileOutcome{}
+	for _, outcome := range state.MachineSets {
+		outcomes[outcome.MachineSet] = outcome
+	}
+	require.Len(t, outcomes, 3)
+	assert.Equal(t, MachineSetReconcileOutcome{MachineSet: "machineset-outdated", Status: MachineSetBootImageStatusUpToDate, ImageSource: BootImageSourceStream}, outcomes["machineset-outdated"])
+	assert.Equal(t, MachineSetReconcileOutcome{MachineSet: "machineset-custom", SkipReason: SkipReasonUnrecognizedBootImage, Status: MachineSetBootImageStatusFrozen, ImageSource: BootImageSourceStream}, outcomes["machineset-custom"])
+	assert.Equal(t, MachineSetBootImageStatusErrored, outcomes["machineset-failing"].Status)
+	assert.Equal(t, BootImageSourceMachineSetOverride, outcomes["machineset-failing"].ImageSource)
+	assert.Contains(t, outcomes["machineset-failing"].Error, "missing-secret")
+}
@@ -0,0 +1,123 @@
+package bootimage
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/coreos/stream-metadata-go/stream"
+	osconfigv1 "github.com/openshift/api/config/v1"
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	ctrlcommon "github.com/openshift/machine-config-operator/pkg/controller/common"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// rhcosBuildIDRegexp matches the build ID of an RHCOS release, e.g. "9.6.20250402-0" or
+// "418.94.202410090804-0", including its dashed form in image names such as
+// "rhcos-9-6-20250402-0-gcp-x86-64".
+var rhcosBuildIDRegexp = regexp.MustCompile(`(?:^|[^0-9])([0-9]+)[.-]([0-9]+)[.-]([0-9]{8,12})-([0-9]+)(?:[^0-9]|$)`)
+
+// rhcosBuildID is the build timestamp and serial parsed from an RHCOS build ID
+type rhcosBuildID struct {
+	timestamp string
+	serial    int
+}
+
+// parseRHCOSBuildID returns the build ID found in an image name or release, and whether one was found.
+func parseRHCOSBuildID(s string) (rhcosBuildID, bool) {
+	match := rhcosBuildIDRegexp.FindStringSubmatch(s)
+	if match == nil {
+		return rhcosBuildID{}, false
+	}
+	serial, err := strconv.Atoi(match[4])
+	if err != nil {
+		return rhcosBuildID{}, false
+	}
+	return rhcosBuildID{timestamp: match[3], serial: serial}, true
+}
+
+// isNewerThan returns true if the build is known to be newer than other. Builds are compared by date
+// first, as the timestamp precision differs between versioning schemes; builds of the same date are
+// only compared further if their timestamps have the same precision.
+func (b rhcosBuildID) isNewerThan(other rhcosBuildID) bool {
+	if date, otherDate := b.timestamp[:8], other.timestamp[:8]; date != otherDate {
+		return date > otherDate
+	}
+	if len(b.timestamp) != len(other.timestamp) {
+		return false
+	}
+	if b.timestamp != other.timestamp {
+		return b.timestamp > other.timestamp
+	}
+	return b.serial > other.serial
+}
+
+// getStreamArchRelease returns the release advertised for the architecture by the boot images configmap,
+// or an empty string if none or several releases are advertised.
+func getStreamArchRelease(configMap *corev1.ConfigMap, streamKey, arch string) string {
+	streamData := new(stream.Stream)
+	if err := unmarshalStreamDataConfigMap(configMap, streamKey, streamData); err != nil {
+		return ""
+	}
+	release := ""
+	for _, artifacts := range streamData.Architectures[arch].Artifacts {
+		if artifacts.Release == "" {
+			continue
+		}
+		if release != "" && release != artifacts.Release {
+			return ""
+		}
+		release = artifacts.Release
+	}
+	return release
+}
+
+// checkMachineSetAheadOfStream returns true if the boot image of the machineset is a newer RHCOS build
+// than the update would apply, e.g. after the machineset was manually updated ahead of the MCO, and
+// warns about it once per image. The target build is read from the image name, falling back to the
+// release advertised by the boot images configmap. Images whose name does not carry a build ID, such as
+// AWS AMIs, are never considered ahead.
+func (ctrl *Controller) checkMachineSetAheadOfStream(infra *osconfigv1.Infrastructure, imagePath []string, configMap *corev1.ConfigMap, arch string, machineSet, newMachineSet *machinev1beta1.MachineSet) (bool, error) {
+	currentImage, err := getMachineSetBootImage(infra, imagePath, machineSet)
+	if err != nil {
+		return false, fmt.Errorf("failed to read the boot image of machineset %s: %w", machineSet.Name, err)
+	}
+	targetImage, err := getMachineSetBootImage(infra, imagePath, newMachineSet)
+	if err != nil {
+		return false, fmt.Errorf("failed to read the target boot image of machineset %s: %w", machineSet.Name, err)
+	}
+	currentBuild, ok := parseRHCOSBuildID(currentImage)
+	if !ok {
+		delete(ctrl.reportedAheadOfStream, machineSet.Name)
+		return false, nil
+	}
+	targetVersion := targetImage
+	targetBuild, ok := parseRHCOSBuildID(targetImage)
+	if !ok {
+		targetVersion = getStreamArchRelease(configMap, ctrl.streamConfigMapKey, arch)
+		if targetBuild, ok = parseRHCOSBuildID(targetVersion); !ok {
+			delete(ctrl.reportedAheadOfStream, machineSet.Name)
+			return false, nil
+		}
+	}
+	if !currentBuild.isNewerThan(targetBuild) {
+		delete(ctrl.reportedAheadOfStream, machineSet.Name)
+		return false, nil
+	}
+
+	klog.Warningf("Boot image %s of MAPI machineset %s is newer than %s advertised by the boot images configmap, skipping update", currentImage, machineSet.Name, targetVersion)
+	if ctrl.reportedAheadOfStream[machineSet.Name] == currentImage {
+		return true, nil
+	}
+	ctrl.reportedAheadOfStream[machineSet.Name] = currentImage
+	mcop, err := ctrl.mcopLister.Get(ctrlcommon.MCOOperatorKnobsObjectName)
+	if err != nil {
+		klog.Errorf("Failed to get MachineConfiguration to report the boot image of machineset %s as ahead of the stream: %v", machineSet.Name, err)
+		return true, nil
+	}
+	ctrl.eventRecorder.Eventf(mcop, corev1.EventTypeWarning, "BootImageAheadOfStream",
+		"Boot image %s of machineset %s is newer than %s advertised by the boot images configmap; the machineset will not be updated until the stream catches up or its boot image is reset",
+		currentImage, machineSet.Name, targetVersion)
+	return true, nil
+}
@@ -0,0 +1,50 @@
+package bootimage
+
+import (
+	"time"
+
+	osconfigv1 "github.com/openshift/api/config/v1"
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	"golang.org/x/time/rate"
+	"k8s.io/klog/v2"
+)
+
+// allowPlatformReconcile takes a token from the rate limiter of the platform, returning false if its
+// rate limit was reached. Each platform has its own token bucket, holding up to a minute's worth of
+// updates, so that the platforms are throttled independently.
+func (ctrl *Controller) allowPlatformReconcile(platform osconfigv1.PlatformType) bool {
+	limit := ctrl.knobs.platformRateLimit(platform)
+	if limit <= 0 {
+		return true
+	}
+	if ctrl.platformRateLimiters == nil {
+		ctrl.platformRateLimiters = map[osconfigv1.PlatformType]*rate.Limiter{}
+	}
+	every := rate.Every(time.Minute / time.Duration(limit))
+	limiter, ok := ctrl.platformRateLimiters[platform]
+	if !ok {
+		limiter = rate.NewLimiter(every, limit)
+		ctrl.platformRateLimiters[platform] = limiter
+	} else if limiter.Limit() != every || limiter.Burst() != limit {
+		// The limit was changed through the MachineConfiguration
+		limiter.SetLimit(every)
+		limiter.SetBurst(limit)
+	}
+	return limiter.AllowN(ctrl.clock.Now(), 1)
+}
+
+// resolutionQueriesProvider returns true if resolving the boot image of a machineset on the platform
+// calls the provider's APIs; on vSphere, it queries vCenter and may import a template.
+func resolutionQueriesProvider(platform osconfigv1.PlatformType) bool {
+	return platform == osconfigv1.VSpherePlatformType
+}
+
+// deferThrottledMachineSet records a MAPI machineset as deferred by the rate limit of its platform, to be
+// picked up by a later pass.
+func (ctrl *Controller) deferThrottledMachineSet(platform osconfigv1.PlatformType, machineSet *machinev1beta1.MachineSet) (MachineSetSkipReason, bool, *machinev1beta1.MachineSet, error) {
+	klog.Infof("Update rate limit of %d machinesets per minute on platform %s was reached, deferring boot image update of MAPI machineset %s", ctrl.knobs.platformRateLimit(platform), platform, machineSet.Name)
+	ctrl.mapiStats.deferredCount++
+	ctrl.mapiStats.throttledCount++
+	ctrl.mapiUpdatesHeld = true
+	return SkipReasonThrottled, false, machineSet, nil
+}
@@ -0,0 +1,150 @@
+package bootimage
+
+import (
+	"fmt"
+	"testing"
+
+	osconfigv1 "github.com/openshift/api/config/v1"
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	opv1 "github.com/openshift/api/operator/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestReconcileBudgetDefersMachineSets(t *testing.T) {
+	cases := []struct {
+		name           string
+		annotations    map[string]string
+		expectUpdated  int
+		expectDeferred int
+	}{
+		{
+			name:          "no budget updates all machinesets",
+			annotations:   map[string]string{},
+			expectUpdated: 5,
+		},
+		{
+			name:           "budget limits the machinesets updated per pass",
+			annotations:    map[string]string{ReconcileBudgetPercentAnnotationKey: "40"},
+			expectUpdated:  2,
+			expectDeferred: 3,
+		},
+		{
+			name:           "small budget still updates one machineset",
+			annotations:    map[string]string{ReconcileBudgetPercentAnnotationKey: "1"},
+			expectUpdated:  1,
+			expectDeferred: 4,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			machineSets := []*machinev1beta1.MachineSet{}
+			for i := range 5 {
+				machineSets = append(machineSets, getGCPMachineSet(fmt.Sprintf("machineset-%d", i), testGCPOldImage))
+			}
+			ctrl := newTestController(t, osconfigv1.GCPPlatformType, machineSets, nil)
+			ctrl.setKnobs(t, tc.annotations)
+
+			require.NoError(t, ctrl.syncAll("test"))
+
+			assert.Equal(t, tc.expectUpdated, ctrl.countMachineSetPatches())
+			assert.Equal(t, tc.expectUpdated, ctrl.mapiStats.updatedCount)
+			assert.Equal(t, tc.expectDeferred, ctrl.mapiStats.deferredCount)
+			assert.Equal(t, tc.expectDeferred > 0, ctrl.mapiUpdatesHeld)
+
+			deferred := 0
+			for _, ms := range machineSets {
+				if ctrl.getMachineSet(t, ms.Name).Annotations[BootImageSkipReasonAnnotationKey] == string(SkipReasonBudgetDeferred) {
+					deferred++
+				}
+			}
+			assert.Equal(t, tc.expectDeferred, deferred)
+		})
+	}
+}
+
+func TestPlatformRateLimits(t *testing.T) {
+	t.Run("platforms are throttled independently", func(t *testing.T) {
+		ctrl := newTestController(t, osconfigv1.GCPPlatformType, nil, nil)
+		ctrl.knobs = getBootImageKnobs(&opv1.MachineConfiguration{ObjectMeta: v1.ObjectMeta{Annotations: map[string]string{
+			PlatformRateLimitsAnnotationKey: "AWS=1, GCP=2, VSphere=0, Azure=-1, BareMetal",
+		}}}, DefaultAnnotationKeyPrefix, nil)
+
+		assert.True(t, ctrl.allowPlatformReconcile(osconfigv1.AWSPlatformType))
+		assert.False(t, ctrl.allowPlatformReconcile(osconfigv1.AWSPlatformType))
+		// Exhausting the AWS limit leaves the other platforms unaffected
+		assert.True(t, ctrl.allowPlatformReconcile(osconfigv1.GCPPlatformType))
+		assert.True(t, ctrl.allowPlatformReconcile(osconfigv1.GCPPlatformType))
+		assert.False(t, ctrl.allowPlatformReconcile(osconfigv1.GCPPlatformType))
+		// Invalid entries are ignored, and platforms that are unlisted or have a limit of 0 are not throttled
+		for _, platform := range []osconfigv1.PlatformType{osconfigv1.AzurePlatformType, osconfigv1.VSpherePlatformType, osconfigv1.BareMetalPlatformType, osconfigv1.NutanixPlatformType} {
+			assert.Equal(t, 0, ctrl.knobs.platformRateLimit(platform))
+			for range 100 {
+				assert.True(t, ctrl.allowPlatformReconcile(platform))
+			}
+		}
+	})
+
+	t.Run("throttling is opt-in", func(t *testing.T) {
+		ctrl := newTestController(t, osconfigv1.GCPPlatformType, nil, nil)
+		ctrl.knobs = getBootImageKnobs(&opv1.MachineConfiguration{}, DefaultAnnotationKeyPrefix, nil)
+		for _, platform := range []osconfigv1.PlatformType{osconfigv1.AWSPlatformType, osconfigv1.AzurePlatformType, osconfigv1.GCPPlatformType, osconfigv1.VSpherePlatformType} {
+			assert.Equal(t, 0, ctrl.knobs.platformRateLimit(platform))
+		}
+	})
+
+	t.Run("up to date machinesets do not take a token", func(t *testing.T) {
+		machineSets := []*machinev1beta1.MachineSet{}
+		for i := range 5 {
+			machineSets = append(machineSets, getGCPMachineSet(fmt.Sprintf("machineset-%d", i), testGCPStreamImage))
+		}
+		ctrl := newTestController(t, osconfigv1.GCPPlatformType, machineSets, nil)
+		ctrl.setKnobs(t, map[string]string{PlatformRateLimitsAnnotationKey: "GCP=1"})
+
+		for range 2 {
+			require.NoError(t, ctrl.syncAll("test"))
+			assert.Equal(t, 0, ctrl.mapiStats.throttledCount)
+			assert.False(t, ctrl.mapiUpdatesHeld)
+			assert.Equal(t, v1.ConditionFalse, ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateProgressing).Status)
+		}
+		assert.True(t, ctrl.allowPlatformReconcile(osconfigv1.GCPPlatformType), "the token should still be available")
+	})
+
+	t.Run("throttled machinesets are deferred and retried next pass", func(t *testing.T) {
+		machineSets := []*machinev1beta1.MachineSet{
+			getGCPMachineSet("machineset-a", testGCPOldImage),
+			getGCPMachineSet("machineset-b", testGCPOldImage),
+			getGCPMachineSet("machineset-c", testGCPOldImage),
+		}
+		ctrl := newTestController(t, osconfigv1.GCPPlatformType, machineSets, nil)
+		ctrl.setKnobs(t, map[string]string{PlatformRateLimitsAnnotationKey: "GCP=2"})
+
+		require.NoError(t, ctrl.syncAll("test"))
+		assert.Equal(t, 2, ctrl.mapiStats.updatedCount)
+		assert.Equal(t, 1, ctrl.mapiStats.throttledCount)
+		assert.Equal(t, 1, ctrl.mapiStats.deferredCount)
+		assert.True(t, ctrl.mapiUpdatesHeld)
+		assert.Contains(t, ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateProgressing).Message, "(1 throttled)")
+
+		var throttled string
+		for _, machineSet := range machineSets {
+			current := ctrl.getMachineSet(t, machineSet.Name)
+			if current.Annotations[BootImageSkipReasonAnnotationKey] == string(SkipReasonThrottled) {
+				throttled = current.Name
+				assert.Equal(t, testGCPOldImage, getGCPMachineSetBootImage(t, current))
+			}
+			require.NoError(t, ctrl.msIndexer.Update(current))
+		}
+		require.NotEmpty(t, throttled)
+
+		// Simulate the token bucket refilling over time; only the throttled machineset takes a token
+		delete(ctrl.platformRateLimiters, osconfigv1.GCPPlatformType)
+		require.NoError(t, ctrl.syncAll("test"))
+		assert.Equal(t, 1, ctrl.mapiStats.updatedCount)
+		assert.Equal(t, 0, ctrl.mapiStats.throttledCount)
+		assert.Equal(t, testGCPStreamImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, throttled)))
+		assert.True(t, ctrl.allowPlatformReconcile(osconfigv1.GCPPlatformType), "a token should be left")
+	})
+}
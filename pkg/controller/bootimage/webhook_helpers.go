@@ -0,0 +1,159 @@
+package bootimage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	osconfigv1 "github.com/openshift/api/config/v1"
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// Timeout for a single call to a pre-update or post-update webhook
+	updateWebhookTimeout = 5 * time.Second
+
+	// Phases reported to the update webhooks
+	updateWebhookPhasePre  = "PreUpdate"
+	updateWebhookPhasePost = "PostUpdate"
+)
+
+// updateWebhookRequest is the JSON body sent to the pre-update and post-update webhooks.
+type updateWebhookRequest struct {
+	Phase      string `json:"phase"`
+	MachineSet string `json:"machineSet"`
+	OldImage   string `json:"oldImage"`
+	NewImage   string `json:"newImage"`
+}
+
+// parseUpdateWebhookURL validates the value of a webhook knob annotation, which must be an absolute
+// http or https URL.
+func parseUpdateWebhookURL(value string) (string, error) {
+	parsed, err := url.Parse(value)
+	if err != nil {
+		return "", err
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return "", fmt.Errorf("expected an absolute http or https URL")
+	}
+	return parsed.String(), nil
+}
+
+// newUpdateWebhookRequest describes the boot image change from machineSet to newMachineSet.
+func newUpdateWebhookRequest(phase string, infra *osconfigv1.Infrastructure, imagePath []string, machineSet, newMachineSet *machinev1beta1.MachineSet) (updateWebhookRequest, error) {
+	oldImage, err := getMachineSetBootImage(infra, imagePath, machineSet)
+	if err != nil {
+		return updateWebhookRequest{}, err
+	}
+	newImage, err := getMachineSetBootImage(infra, imagePath, newMachineSet)
+	if err != nil {
+		return updateWebhookRequest{}, err
+	}
+	return updateWebhookRequest{Phase: phase, MachineSet: machineSet.Name, OldImage: oldImage, NewImage: newImage}, nil
+}
+
+// callUpdateWebhook posts the request to the webhook. It returns (accepted, error): accepted is false if
+// the webhook answered with a non-2xx status, and an error is returned if the webhook could not be called.
+func (ctrl *Controller) callUpdateWebhook(webhookURL string, request updateWebhookRequest) (bool, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal %s webhook request: %w", request.Phase, err)
+	}
+	ctx, cancel := context.WithTimeout(context.TODO(), updateWebhookTimeout)
+	defer cancel()
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("failed to create %s webhook request: %w", request.Phase, err)
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+	response, err := ctrl.webhookClient.Do(httpRequest)
+	if err != nil {
+		return false, fmt.Errorf("failed to call %s webhook for machineset %s: %w", request.Phase, request.MachineSet, err)
+	}
+	defer response.Body.Close()
+	// Drain the body so that the connection can be reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, 4096))
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		klog.Infof("%s webhook responded with status %d for machineset %s", request.Phase, response.StatusCode, request.MachineSet)
+		return false, nil
+	}
+	return true, nil
+}
+
+// notifyPostUpdateWebhook calls the post-update webhook, if configured, for an applied boot image update.
+// The update has already taken place, so failures are only logged.
+func (ctrl *Controller) notifyPostUpdateWebhook(infra *osconfigv1.Infrastructure, imagePath []string, machineSet, newMachineSet *machinev1beta1.MachineSet) {
+	if ctrl.knobs.postUpdateWebhook == "" {
+		return
+	}
+	request, err := newUpdateWebhookRequest(updateWebhookPhasePost, infra, imagePath, machineSet, newMachineSet)
+	if err != nil {
+		klog.Warningf("Failed to describe boot image update of machineset %s for the post-update webhook: %v", machineSet.Name, err)
+		return
+	}
+	accepted, err := ctrl.callUpdateWebhook(ctrl.knobs.postUpdateWebhook, request)
+	if err != nil {
+		klog.Warningf("%v", err)
+		return
+	}
+	if !accepted {
+		klog.Warningf("Post-update webhook did not accept the notification for machineset %s", machineSet.Name)
+	}
+}
+
+// getMachineSetBootImage returns the boot image currently set in the machineset's providerspec. The image
+// is read from imagePath on platforms that are not natively supported.
+func getMachineSetBootImage(infra *osconfigv1.Infrastructure, imagePath []string, machineSet *machinev1beta1.MachineSet) (string, error) {
+	switch infra.Status.PlatformStatus.Type {
+	case osconfigv1.AWSPlatformType:
+		providerSpec := new(machinev1beta1.AWSMachineProviderConfig)
+		if err := unmarshalProviderSpec(machineSet, providerSpec); err != nil {
+			return "", err
+		}
+		if providerSpec.AMI.ID == nil {
+			return "", nil
+		}
+		return *providerSpec.AMI.ID, nil
+	case osconfigv1.AzurePlatformType:
+		providerSpec := new(machinev1beta1.AzureMachineProviderSpec)
+		if err := unmarshalProviderSpec(machineSet, providerSpec); err != nil {
+			return "", err
+		}
+		if providerSpec.Image.ResourceID != "" {
+			return providerSpec.Image.ResourceID, nil
+		}
+		image := providerSpec.Image
+		return fmt.Sprintf("%s:%s:%s:%s", image.Publisher, image.Offer, image.SKU, image.Version), nil
+	case osconfigv1.GCPPlatformType:
+		providerSpec := new(machinev1beta1.GCPMachineProviderSpec)
+		if err := unmarshalProviderSpec(machineSet, providerSpec); err != nil {
+			return "", err
+		}
+		for _, disk := range providerSpec.Disks {
+			if disk.Boot {
+				return disk.Image, nil
+			}
+		}
+		return "", nil
+	case osconfigv1.VSpherePlatformType:
+		providerSpec := new(machinev1beta1.VSphereMachineProviderSpec)
+		if err := unmarshalProviderSpec(machineSet, providerSpec); err != nil {
+			return "", err
+		}
+		return providerSpec.Template, nil
+	default:
+		providerSpec := map[string]interface{}{}
+		if err := unmarshalProviderSpec(machineSet, &providerSpec); err != nil {
+			return "", err
+		}
+		image, _, err := unstructured.NestedString(providerSpec, imagePath...)
+		return image, err
+	}
+}
@@ -0,0 +1,100 @@
+package bootimage
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	osconfigv1 "github.com/openshift/api/config/v1"
+	machinev1beta1 "github.com/openshift/api/machine/v1beta1"
+	opv1 "github.com/openshift/api/operator/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestUpdateWebhooks(t *testing.T) {
+	// Records the requests received by a mock webhook, rejecting those for the named machineset
+	newWebhook := func(t *testing.T, rejectMachineSet string) (*httptest.Server, *[]updateWebhookRequest) {
+		var mu sync.Mutex
+		requests := &[]updateWebhookRequest{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			request := updateWebhookRequest{}
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+			mu.Lock()
+			*requests = append(*requests, request)
+			mu.Unlock()
+			if request.MachineSet == rejectMachineSet {
+				w.WriteHeader(http.StatusForbidden)
+			}
+		}))
+		t.Cleanup(server.Close)
+		return server, requests
+	}
+
+	t.Run("pre-update rejection aborts only that machineset", func(t *testing.T) {
+		preHook, preRequests := newWebhook(t, "machineset-rejected")
+		postHook, postRequests := newWebhook(t, "")
+		machineSets := []*machinev1beta1.MachineSet{
+			getGCPMachineSet("machineset-accepted", testGCPOldImage),
+			getGCPMachineSet("machineset-rejected", testGCPOldImage),
+		}
+		ctrl := newTestController(t, osconfigv1.GCPPlatformType, machineSets, nil)
+		ctrl.setKnobs(t, map[string]string{
+			PreUpdateWebhookAnnotationKey:  preHook.URL,
+			PostUpdateWebhookAnnotationKey: postHook.URL,
+		})
+
+		require.NoError(t, ctrl.syncAll("test"))
+
+		assert.Equal(t, testGCPStreamImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, "machineset-accepted")))
+		rejected := ctrl.getMachineSet(t, "machineset-rejected")
+		assert.Equal(t, testGCPOldImage, getGCPMachineSetBootImage(t, rejected))
+		assert.Equal(t, string(SkipReasonPreUpdateWebhookRejected), rejected.Annotations[BootImageSkipReasonAnnotationKey])
+		assert.Equal(t, v1.ConditionFalse, ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateDegraded).Status)
+
+		assert.ElementsMatch(t, []updateWebhookRequest{
+			{Phase: updateWebhookPhasePre, MachineSet: "machineset-accepted", OldImage: testGCPOldImage, NewImage: testGCPStreamImage},
+			{Phase: updateWebhookPhasePre, MachineSet: "machineset-rejected", OldImage: testGCPOldImage, NewImage: testGCPStreamImage},
+		}, *preRequests)
+		assert.Equal(t, []updateWebhookRequest{
+			{Phase: updateWebhookPhasePost, MachineSet: "machineset-accepted", OldImage: testGCPOldImage, NewImage: testGCPStreamImage},
+		}, *postRequests)
+	})
+
+	t.Run("unreachable pre-update webhook fails only that machineset", func(t *testing.T) {
+		preHook, _ := newWebhook(t, "")
+		preHook.Close()
+		ctrl := newTestController(t, osconfigv1.GCPPlatformType, []*machinev1beta1.MachineSet{getGCPMachineSet("machineset-a", testGCPOldImage)}, nil)
+		ctrl.setKnobs(t, map[string]string{PreUpdateWebhookAnnotationKey: preHook.URL})
+
+		require.NoError(t, ctrl.syncAll("test"))
+
+		assert.Equal(t, 0, ctrl.countMachineSetPatches())
+		degraded := ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateDegraded)
+		assert.Equal(t, v1.ConditionTrue, degraded.Status)
+		assert.Contains(t, degraded.Message, "failed to call PreUpdate webhook for machineset machineset-a")
+	})
+
+	t.Run("failing post-update webhook does not affect the update", func(t *testing.T) {
+		postHook, _ := newWebhook(t, "machineset-a")
+		ctrl := newTestController(t, osconfigv1.GCPPlatformType, []*machinev1beta1.MachineSet{getGCPMachineSet("machineset-a", testGCPOldImage)}, nil)
+		ctrl.setKnobs(t, map[string]string{PostUpdateWebhookAnnotationKey: postHook.URL})
+
+		require.NoError(t, ctrl.syncAll("test"))
+
+		assert.Equal(t, testGCPStreamImage, getGCPMachineSetBootImage(t, ctrl.getMachineSet(t, "machineset-a")))
+		assert.Equal(t, v1.ConditionFalse, ctrl.getCondition(t, opv1.MachineConfigurationBootImageUpdateDegraded).Status)
+	})
+
+	t.Run("invalid webhook URLs are ignored", func(t *testing.T) {
+		knobs := getBootImageKnobs(&opv1.MachineConfiguration{ObjectMeta: v1.ObjectMeta{Annotations: map[string]string{
+			PreUpdateWebhookAnnotationKey:  "not a url",
+			PostUpdateWebhookAnnotationKey: "ftp://example.com/hook",
+		}}}, DefaultAnnotationKeyPrefix, nil)
+		assert.Empty(t, knobs.preUpdateWebhook)
+		assert.Empty(t, knobs.postUpdateWebhook)
+	})
+}
@@ -34,6 +34,111 @@ var (
 			Help: "Set to 1 when boot image skew enforcement mode is None, indicating scaling may not be successful as bootimages are out of date",
 		})
 
+	// MCCBootImageMachineSetCount is the number of MAPI MachineSets considered in the last boot image
+	// reconciliation, labeled by platform and architecture
+	MCCBootImageMachineSetCount = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mcc_boot_image_machineset_count",
+			Help: "Number of MAPI MachineSets considered in the last boot image reconciliation, by platform and architecture",
+		}, []string{"platform", "arch"})
+
+	// MCCBootImageMachineSetErrors is the number of MAPI MachineSets that failed to reconcile in the last
+	// boot image reconciliation, labeled by platform and architecture
+	MCCBootImageMachineSetErrors = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mcc_boot_image_machineset_errors",
+			Help: "Number of MAPI MachineSets that failed to reconcile in the last boot image reconciliation, by platform and architecture",
+		}, []string{"platform", "arch"})
+
+	// MCCBootImageMachineSetRoleCount is the number of MAPI MachineSets considered in the last boot image
+	// reconciliation, labeled by machine role (e.g. worker, infra) and boot image status
+	MCCBootImageMachineSetRoleCount = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mcc_boot_image_machineset_role_count",
+			Help: "Number of MAPI MachineSets considered in the last boot image reconciliation, by machine role and boot image status",
+		}, []string{"role", "status"})
+
+	// MCOBootImageMachineSetsTotal is the number of enrolled MAPI MachineSets reported by the boot image
+	// conditions
+	MCOBootImageMachineSetsTotal = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "mco_boot_image_machinesets_total",
+			Help: "Number of enrolled MAPI MachineSets reported by the boot image conditions",
+		})
+
+	// MCOBootImageMachineSetsInProgress is the number of MAPI MachineSets reconciled without error in the
+	// current boot image reconciliation, as reported by the Progressing condition
+	MCOBootImageMachineSetsInProgress = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "mco_boot_image_machinesets_inprogress",
+			Help: "Number of MAPI MachineSets reconciled without error in the current boot image reconciliation",
+		})
+
+	// MCOBootImageMachineSetsErrored is the number of MAPI MachineSets that failed to reconcile in the
+	// current boot image reconciliation, as reported by the Degraded condition
+	MCOBootImageMachineSetsErrored = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "mco_boot_image_machinesets_errored",
+			Help: "Number of MAPI MachineSets that failed to reconcile in the current boot image reconciliation",
+		})
+
+	// MCOBootImageSyncDuration is the wall-clock duration of the boot image syncs of MAPI MachineSets
+	MCOBootImageSyncDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "mco_boot_image_sync_duration_seconds",
+			Help:    "Wall-clock duration of the boot image syncs of MAPI MachineSets",
+			Buckets: prometheus.ExponentialBuckets(0.1, 2, 12),
+		})
+
+	// MCCBootImageRolloutConvergenceDuration is the time machine resources took to converge on a changed
+	// boot images configmap, from the first Progressing sync of the rollout
+	MCCBootImageRolloutConvergenceDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "mcc_boot_image_rollout_convergence_duration_seconds",
+			Help:    "Time machine resources took to converge on a changed boot images configmap",
+			Buckets: prometheus.ExponentialBuckets(10, 2, 12),
+		})
+
+	// MCCBootImageHotLoopStateEntries is the number of machine resources tracked by the boot image
+	// controller for hot loop detection, labeled by resource type
+	MCCBootImageHotLoopStateEntries = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mcc_boot_image_hot_loop_state_entries",
+			Help: "Number of machine resources tracked by the boot image controller for hot loop detection, by resource type",
+		}, []string{"resource"})
+
+	// MCCBootImageHotLoopFrozenResources is the number of machine resources whose boot image updates
+	// are frozen by hot loop protection, labeled by resource type
+	MCCBootImageHotLoopFrozenResources = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mcc_boot_image_hot_loop_frozen_resources",
+			Help: "Number of machine resources whose boot image updates are frozen by hot loop protection, by resource type",
+		}, []string{"resource"})
+
+	// MCOBootImageHotLoops is the number of boot image updates of MAPI MachineSets refused due to a
+	// detected hot loop, labeled by machineset
+	MCOBootImageHotLoops = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mco_boot_image_hot_loop_total",
+			Help: "Total number of boot image updates of MAPI MachineSets refused due to a detected hot loop, by machineset",
+		}, []string{"machineset"})
+
+	// MCCBootImagePatchConflicts is the number of boot image patches of machine resources that were
+	// rejected due to a conflicting write, labeled by resource type
+	MCCBootImagePatchConflicts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mcc_boot_image_patch_conflicts_total",
+			Help: "Total number of boot image patches of machine resources rejected due to a conflicting write, by resource type",
+		}, []string{"resource"})
+
+	// MCCBootImageResolutionCacheLookups is the number of lookups of the boot image resolution cache of
+	// MAPI MachineSets, labeled by result (hit or miss)
+	MCCBootImageResolutionCacheLookups = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mcc_boot_image_resolution_cache_lookups_total",
+			Help: "Total number of lookups of the boot image resolution cache of MAPI MachineSets, by result",
+		}, []string{"result"})
+
 	// MCCDrainErr logs failed drain
 	MCCDrainErr = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
@@ -104,6 +209,19 @@ func RegisterMCCMetrics() error {
 		MCCDegradedMachineCount,
 		MCCUnavailableMachineCount,
 		MCCBootImageSkewEnforcementNone,
+		MCCBootImageMachineSetCount,
+		MCCBootImageMachineSetErrors,
+		MCCBootImageMachineSetRoleCount,
+		MCOBootImageMachineSetsTotal,
+		MCOBootImageMachineSetsInProgress,
+		MCOBootImageMachineSetsErrored,
+		MCOBootImageSyncDuration,
+		MCCBootImageRolloutConvergenceDuration,
+		MCCBootImageHotLoopStateEntries,
+		MCCBootImageHotLoopFrozenResources,
+		MCOBootImageHotLoops,
+		MCCBootImagePatchConflicts,
+		MCCBootImageResolutionCacheLookups,
 	})
 
 	if err != nil {
@@ -135,6 +253,15 @@ func RegisterMetrics(metrics []prometheus.Collector) error {
 	return nil
 }
 
+// debugHandlers serves the debug endpoints registered by controllers, under /debug/ on the metrics listener
+var debugHandlers = http.NewServeMux()
+
+// RegisterDebugHandler registers a read-only debug endpoint to be served by the metrics listener. The
+// pattern must start with /debug/. Handlers may be registered before or after the listener is started.
+func RegisterDebugHandler(pattern string, handler http.Handler) {
+	debugHandlers.Handle(pattern, handler)
+}
+
 // StartMetricsListener is metrics listener via http on localhost
 func StartMetricsListener(addr string, stopCh <-chan struct{}, registerFunc func() error, tlsMinVersion string, tlsCipherSuites []string) {
 	if addr == "" {
@@ -154,6 +281,7 @@ func StartMetricsListener(addr string, stopCh <-chan struct{}, registerFunc func
 	klog.Infof("Starting metrics listener on %s with TLS min version: %s", addr, tlsMinVersion)
 	mux := http.NewServeMux()
 	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/debug/", debugHandlers)
 	s := http.Server{
 		TLSConfig:    tlsConfig,
 		TLSNextProto: make(map[string]func(*http.Server, *tls.Conn, http.Handler)),
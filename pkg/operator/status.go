@@ -96,6 +96,12 @@ func (optr *Operator) syncAvailableStatus(co *configv1.ClusterOperator) {
 		Reason:  asExpectedReason,
 	}
 
+	// A boot image rollout doesn't make the cluster progress towards a new version, so it is only
+	// reported in the availability message
+	if bootImageMessage := optr.getBootImageRolloutMessage(); bootImageMessage != "" {
+		coStatusCondition.Message = fmt.Sprintf("%s; %s", coStatusCondition.Message, bootImageMessage)
+	}
+
 	cov1helpers.SetStatusCondition(&co.Status.Conditions, coStatusCondition, clock.RealClock{})
 }
 
@@ -175,6 +181,8 @@ func (optr *Operator) updateClusterOperatorStatus(co *configv1.ClusterOperator,
 
 const (
 	asExpectedReason = "AsExpected"
+	// Appended to the ClusterOperator availability message while boot images are being updated
+	bootImageUpdateInProgressMessage = "boot image update in progress"
 )
 
 // This function clears a prior CO degrade condition set by a sync function. If the CO is not
@@ -638,6 +646,34 @@ func checkBootImageControllerReady(mcop *opv1.MachineConfiguration) (bool, error
 	return true, nil
 }
 
+// checkBootImageControllerDegraded returns an error if the boot image controller reports a degraded
+// condition in the MachineConfiguration status, so the ClusterOperator is degraded by the
+// MachineConfiguration sync.
+func checkBootImageControllerDegraded(mcop *opv1.MachineConfiguration) error {
+	if degradedCondition := meta.FindStatusCondition(mcop.Status.Conditions, opv1.MachineConfigurationBootImageUpdateDegraded); degradedCondition != nil && degradedCondition.Status == metav1.ConditionTrue {
+		return fmt.Errorf("bootimage update failed: %s", degradedCondition.Message)
+	}
+	return nil
+}
+
+// getBootImageRolloutMessage returns a short, stable summary of the boot image rollout reported by the
+// boot image controller in the MachineConfiguration status, or an empty string if no rollout is in
+// progress. The details of the rollout are left on the MachineConfiguration, so that the availability
+// message does not change with every machine resource that is reconciled.
+func (optr *Operator) getBootImageRolloutMessage() string {
+	if optr.mcopLister == nil {
+		return ""
+	}
+	mcop, err := optr.mcopLister.Get(ctrlcommon.MCOOperatorKnobsObjectName)
+	if err != nil {
+		return ""
+	}
+	if progressingCondition := meta.FindStatusCondition(mcop.Status.Conditions, opv1.MachineConfigurationBootImageUpdateProgressing); progressingCondition != nil && progressingCondition.Status == metav1.ConditionTrue {
+		return bootImageUpdateInProgressMessage
+	}
+	return ""
+}
+
 // checkBootImageSkewUpgradeableGuard checks if the boot image version is within acceptable limits.
 // It returns an error if there is no skew enforcement opinion specified. If one is specified,
 // it checks if boot image skew is within the expected limit.
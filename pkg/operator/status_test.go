@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 
 	corelisterv1 "k8s.io/client-go/listers/core/v1"
@@ -1329,3 +1330,112 @@ func TestCheckBootImageSkewUpgradeableGuard(t *testing.T) {
 		})
 	}
 }
+
+func TestSyncBootImageClusterOperatorStatus(t *testing.T) {
+	tests := []struct {
+		name                    string
+		mcopConditions          []metav1.Condition
+		coProgressing           configv1.ConditionStatus
+		coProgressingReason     string
+		expectedDegraded        configv1.ConditionStatus
+		expectedDegradedReason  string
+		expectedDegradedMessage string
+		expectedAvailableSuffix string
+	}{
+		{
+			name: "boot image controller degraded",
+			mcopConditions: []metav1.Condition{
+				{Type: opv1.MachineConfigurationBootImageUpdateProgressing, Status: metav1.ConditionFalse},
+				{Type: opv1.MachineConfigurationBootImageUpdateDegraded, Status: metav1.ConditionTrue, Message: "failed to patch machineset worker-a"},
+			},
+			coProgressing:           configv1.ConditionFalse,
+			expectedDegraded:        configv1.ConditionTrue,
+			expectedDegradedReason:  taskFailed("MachineConfiguration"),
+			expectedDegradedMessage: "failed to patch machineset worker-a",
+		},
+		{
+			name: "boot image rollout in progress",
+			mcopConditions: []metav1.Condition{
+				{Type: opv1.MachineConfigurationBootImageUpdateProgressing, Status: metav1.ConditionTrue, Message: "Reconciled 1 of 2 MAPI MachineSets"},
+				{Type: opv1.MachineConfigurationBootImageUpdateDegraded, Status: metav1.ConditionFalse},
+			},
+			coProgressing:           configv1.ConditionFalse,
+			expectedDegraded:        configv1.ConditionFalse,
+			expectedAvailableSuffix: "; " + bootImageUpdateInProgressMessage,
+		},
+		{
+			name: "boot image rollout in progress during an upgrade",
+			mcopConditions: []metav1.Condition{
+				{Type: opv1.MachineConfigurationBootImageUpdateProgressing, Status: metav1.ConditionTrue, Message: "Reconciled 1 of 2 MAPI MachineSets"},
+				{Type: opv1.MachineConfigurationBootImageUpdateDegraded, Status: metav1.ConditionFalse},
+			},
+			coProgressing:           configv1.ConditionTrue,
+			coProgressingReason:     "WorkingTowardsNewVersion",
+			expectedDegraded:        configv1.ConditionFalse,
+			expectedAvailableSuffix: "; " + bootImageUpdateInProgressMessage,
+		},
+		{
+			name: "boot image controller idle",
+			mcopConditions: []metav1.Condition{
+				{Type: opv1.MachineConfigurationBootImageUpdateProgressing, Status: metav1.ConditionFalse},
+				{Type: opv1.MachineConfigurationBootImageUpdateDegraded, Status: metav1.ConditionFalse},
+			},
+			coProgressing:    configv1.ConditionFalse,
+			expectedDegraded: configv1.ConditionFalse,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mcop := &opv1.MachineConfiguration{
+				ObjectMeta: metav1.ObjectMeta{Name: ctrlcommon.MCOOperatorKnobsObjectName},
+				Status:     opv1.MachineConfigurationStatus{Conditions: tc.mcopConditions},
+			}
+			mcopIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+			mcopIndexer.Add(mcop)
+			optr := &Operator{
+				eventRecorder: &record.FakeRecorder{},
+				mcopLister:    mcoplistersv1.NewMachineConfigurationLister(mcopIndexer),
+			}
+			optr.vStore = newVersionStore()
+			optr.vStore.Set("operator", "test-version")
+
+			co := &configv1.ClusterOperator{ObjectMeta: metav1.ObjectMeta{Name: "machine-config"}}
+			progressingCondition := configv1.ClusterOperatorStatusCondition{Type: configv1.OperatorProgressing, Status: tc.coProgressing, Reason: tc.coProgressingReason, Message: "Working towards test-version"}
+			cov1helpers.SetStatusCondition(&co.Status.Conditions, progressingCondition, clock.RealClock{})
+			cov1helpers.SetStatusCondition(&co.Status.Conditions, configv1.ClusterOperatorStatusCondition{Type: configv1.OperatorDegraded, Status: configv1.ConditionFalse}, clock.RealClock{})
+
+			// Mirror syncAll, which degrades the ClusterOperator on sync function errors before syncing
+			// its availability
+			err := checkBootImageControllerDegraded(mcop)
+			optr.syncDegradedStatus(co, syncError{task: "MachineConfiguration", err: err})
+			optr.syncAvailableStatus(co)
+
+			degraded := cov1helpers.FindStatusCondition(co.Status.Conditions, configv1.OperatorDegraded)
+			assert.Equal(t, tc.expectedDegraded, degraded.Status)
+			if tc.expectedDegraded == configv1.ConditionTrue {
+				assert.Equal(t, tc.expectedDegradedReason, degraded.Reason)
+				assert.Contains(t, degraded.Message, tc.expectedDegradedMessage)
+			}
+
+			available := cov1helpers.FindStatusCondition(co.Status.Conditions, configv1.OperatorAvailable)
+			assert.Equal(t, configv1.ConditionTrue, available.Status)
+			assert.Equal(t, asExpectedReason, available.Reason)
+			if tc.expectedAvailableSuffix != "" {
+				assert.True(t, strings.HasSuffix(available.Message, tc.expectedAvailableSuffix), "availability message %q", available.Message)
+				// The rollout details are left on the MachineConfiguration
+				assert.NotContains(t, available.Message, "MAPI MachineSets")
+			} else {
+				assert.NotContains(t, available.Message, bootImageUpdateInProgressMessage)
+			}
+
+			// A boot image rollout never touches the Progressing condition, nor its reason
+			if tc.expectedDegraded != configv1.ConditionTrue {
+				progressing := cov1helpers.FindStatusCondition(co.Status.Conditions, configv1.OperatorProgressing)
+				assert.Equal(t, progressingCondition.Status, progressing.Status)
+				assert.Equal(t, progressingCondition.Reason, progressing.Reason)
+				assert.Equal(t, progressingCondition.Message, progressing.Message)
+			}
+		})
+	}
+}
@@ -7,7 +7,6 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"encoding/pem"
-	"errors"
 	"fmt"
 	"net"
 	"net/url"
@@ -2420,13 +2419,7 @@ func (optr *Operator) syncMachineConfiguration(_ *renderConfig, _ *configv1.Clus
 	}
 
 	// Always check for conditions last, so MachineConfiguration Status updates are never blocked
-	for _, condition := range mcop.Status.Conditions {
-		if condition.Type == opv1.MachineConfigurationBootImageUpdateDegraded && condition.Status == metav1.ConditionTrue {
-			return errors.New("bootimage update failed: " + condition.Message)
-		}
-	}
-
-	return nil
+	return checkBootImageControllerDegraded(mcop)
 }
 
 // syncManagedBootImagesStatus populates the ManagedBootImagesStatus in the MachineConfiguration status